@@ -15,11 +15,16 @@ var ResourceOrder = [...]string{
 	"App",
 	"ConfigCenter",
 	"DeployPlatform",
+	"TemplateLibrary",
 	"DeployTemplate",
 	"ResourceRange",
 	"Orchestration",
 	"AppDeployment",
 	"AppInstance",
+	"Schedule",
+	"DeploymentRequest",
+	"Notifier",
+	"DeployPlan",
 	// "AppInstanceRun",
 	// "VirtualNetwork",
 	// "Subnet",
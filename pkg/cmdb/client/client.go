@@ -1,6 +1,8 @@
 package client
 
 import (
+	"bufio"
+	"encoding/json"
 	"fmt"
 	"gcmdb/global"
 	"gcmdb/pkg/cmdb"
@@ -38,7 +40,7 @@ func (c CMDBClient) CreateResource(r cmdb.Object) (map[string]any, error) {
 	url := c.getCreateResourceUrl(r)
 	RemoveResourceManageFields(resource)
 
-	resp, err = req.C().R().SetBody(resource).SetSuccessResult(&result).Post(url)
+	resp, err = c.authRequest().SetBody(resource).SetSuccessResult(&result).Post(url)
 
 	return result, c.fmtError(r, resp, err)
 }
@@ -55,7 +57,7 @@ func (c CMDBClient) UpdateResource(r cmdb.Object) (map[string]any, error) {
 	url := c.getURDResourceUrl(r, meta.Name, meta.Namespace)
 	RemoveResourceManageFields(resource)
 
-	resp, err = req.C().R().SetBody(resource).SetSuccessResult(&result).Post(url)
+	resp, err = c.authRequest().SetBody(resource).SetSuccessResult(&result).Post(url)
 
 	return result, c.fmtError(r, resp, err)
 }
@@ -130,31 +132,231 @@ func (c CMDBClient) RenderAppDeployment(name, namespace string, params map[strin
 	path := fmt.Sprintf("/appdeployments/%s/%s/render", namespace, name)
 	var result map[string]any
 	url := c.getCMDBAPIURL() + path
-	data := map[string]any{"params": map[string]any{}}
+	data := map[string]any{"params": renderParams(params)}
 	resp, err := req.C().R().SetBody(data).SetSuccessResult(&result).SetErrorResult(&result).Post(url)
 	return result, c.fmtError(&cmdb.AppDeployment{}, resp, err)
 }
 
+// 获取渲染后的 AppDeployment 及每个字段的来源
+func (c CMDBClient) TraceAppDeployment(name, namespace string, params map[string]any) (map[string]any, error) {
+	path := fmt.Sprintf("/appdeployments/%s/%s/render", namespace, name)
+	var result map[string]any
+	url := c.getCMDBAPIURL() + path
+	data := map[string]any{"params": renderParams(params), "trace": true}
+	resp, err := req.C().R().SetBody(data).SetSuccessResult(&result).SetErrorResult(&result).Post(url)
+	return result, c.fmtError(&cmdb.AppDeployment{}, resp, err)
+}
+
+// 获取 AppDeployment 合并 ResourceRange 时的冲突
+func (c CMDBClient) RenderConflicts(name, namespace string, params map[string]any) ([]any, error) {
+	path := fmt.Sprintf("/appdeployments/%s/%s/render", namespace, name)
+	var result map[string]any
+	url := c.getCMDBAPIURL() + path
+	data := map[string]any{"params": renderParams(params), "conflicts": true}
+	resp, err := req.C().R().SetBody(data).SetSuccessResult(&result).SetErrorResult(&result).Post(url)
+	if err = c.fmtError(&cmdb.AppDeployment{}, resp, err); err != nil {
+		return nil, err
+	}
+	conflicts, _ := result["conflicts"].([]any)
+	return conflicts, nil
+}
+
 // 获取渲染后的 AppDeployment 关联的 DeployTemplate
 func (c CMDBClient) RenderDeployTemplate(name, namespace string, params map[string]any) (map[string]any, error) {
 	path := fmt.Sprintf("/appdeployments/%s/%s/deploytemplate/render", namespace, name)
 	var result map[string]any
 	url := c.getCMDBAPIURL() + path
-	data := map[string]any{"params": map[string]any{}}
+	data := map[string]any{"params": renderParams(params)}
 	resp, err := req.C().R().SetBody(data).SetSuccessResult(&result).SetErrorResult(&result).Post(url)
 	return result, c.fmtError(&cmdb.DeployTemplate{}, resp, err)
 }
 
+// 获取 Kubernetes AppDeployment 的 Helm values
+func (c CMDBClient) RenderHelmValues(name, namespace string, params map[string]any) (map[string]any, error) {
+	path := fmt.Sprintf("/appdeployments/%s/%s/helmvalues/render", namespace, name)
+	var result map[string]any
+	url := c.getCMDBAPIURL() + path
+	data := map[string]any{"params": renderParams(params)}
+	resp, err := req.C().R().SetBody(data).SetSuccessResult(&result).SetErrorResult(&result).Post(url)
+	return result, c.fmtError(&cmdb.AppDeployment{}, resp, err)
+}
+
+// 获取 Kubernetes AppDeployment 的 Deployment/Service/ServiceMonitor 清单
+func (c CMDBClient) RenderManifests(name, namespace string, params map[string]any) ([]map[string]any, error) {
+	path := fmt.Sprintf("/appdeployments/%s/%s/manifests/render", namespace, name)
+	var result []map[string]any
+	var errResult map[string]any
+	url := c.getCMDBAPIURL() + path
+	data := map[string]any{"params": renderParams(params)}
+	resp, err := req.C().R().SetBody(data).SetSuccessResult(&result).SetErrorResult(&errResult).Post(url)
+	return result, c.fmtError(&cmdb.AppDeployment{}, resp, err)
+}
+
+// 获取 Docker AppDeployment 每个 HostNode 的 docker-compose 配置
+func (c CMDBClient) RenderDockerCompose(name, namespace string, params map[string]any) (map[string]map[string]any, error) {
+	path := fmt.Sprintf("/appdeployments/%s/%s/compose/render", namespace, name)
+	var result map[string]map[string]any
+	var errResult map[string]any
+	url := c.getCMDBAPIURL() + path
+	data := map[string]any{"params": renderParams(params)}
+	resp, err := req.C().R().SetBody(data).SetSuccessResult(&result).SetErrorResult(&errResult).Post(url)
+	return result, c.fmtError(&cmdb.AppDeployment{}, resp, err)
+}
+
 // 运行 AppDeployment 部署
 func (c CMDBClient) RunAppDeployment(action deployment.DeployAction, name, namespace string, params map[string]any) (map[string]any, error) {
 	path := fmt.Sprintf("/appdeployments/%s/%s/run/%s", namespace, name, action)
 	var result map[string]any
 	url := c.getCMDBAPIURL() + path
-	data := map[string]any{"params": map[string]any{}}
+	data := map[string]any{"params": renderParams(params)}
+	resp, err := c.authRequest().SetBody(data).SetSuccessResult(&result).SetErrorResult(&result).Post(url)
+	return result, c.fmtError(&cmdb.AppDeployment{}, resp, err)
+}
+
+// 将 AppDeployment 及其引用的 ResourceRange、DeployTemplate 复制到目标命名空间，dryRun 时仅返回变更
+func (c CMDBClient) PromoteAppDeployment(name, from, to string, overrides map[string]any, dryRun bool) (map[string]any, error) {
+	path := fmt.Sprintf("/appdeployments/%s/%s/promote", from, name)
+	var result map[string]any
+	url := c.getCMDBAPIURL() + path
+	data := map[string]any{"to": to, "overrides": overrides, "dryRun": dryRun}
+	resp, err := req.C().R().SetBody(data).SetSuccessResult(&result).SetErrorResult(&result).Post(url)
+	return result, c.fmtError(&cmdb.AppDeployment{}, resp, err)
+}
+
+// 按依赖顺序部署 selector 匹配的 AppDeployment，dryRun 时仅返回部署顺序
+func (c CMDBClient) RunDeployPlan(action deployment.DeployAction, namespace string, selector map[string]string, params map[string]any, dryRun bool) (map[string]any, error) {
+	path := fmt.Sprintf("/deployplans/%s/run/%s?dryRun=%t", namespace, action, dryRun)
+	var result map[string]any
+	url := c.getCMDBAPIURL() + path
+	data := map[string]any{"selector": selector, "params": renderParams(params)}
+	resp, err := c.authRequest().SetBody(data).SetSuccessResult(&result).SetErrorResult(&result).Post(url)
+	return result, c.fmtError(&cmdb.DeployPlan{}, resp, err)
+}
+
+// 审批通过 DeploymentRequest
+func (c CMDBClient) ApproveDeploymentRequest(name, namespace, comment string) (map[string]any, error) {
+	return c.decideDeploymentRequest("approve", name, namespace, comment)
+}
+
+// 拒绝 DeploymentRequest
+func (c CMDBClient) RejectDeploymentRequest(name, namespace, comment string) (map[string]any, error) {
+	return c.decideDeploymentRequest("reject", name, namespace, comment)
+}
+
+func (c CMDBClient) decideDeploymentRequest(decision, name, namespace, comment string) (map[string]any, error) {
+	path := fmt.Sprintf("/deploymentrequests/%s/%s/%s", namespace, name, decision)
+	var result map[string]any
+	url := c.getCMDBAPIURL() + path
+	data := map[string]any{"comment": comment}
+	resp, err := c.authRequest().SetBody(data).SetSuccessResult(&result).Post(url)
+	return result, c.fmtError(&cmdb.DeploymentRequest{}, resp, err)
+}
+
+// 查询 AppDeployment 的 Orchestration 与 DeployTemplate 声明的参数 schema
+func (c CMDBClient) GetParameterSchemas(name, namespace string) (map[string]any, error) {
+	path := fmt.Sprintf("/appdeployments/%s/%s/parameters", namespace, name)
+	var result map[string]any
+	url := c.getCMDBAPIURL() + path
+	resp, err := req.C().R().SetSuccessResult(&result).SetErrorResult(&result).Get(url)
+	return result, c.fmtError(&cmdb.AppDeployment{}, resp, err)
+}
+
+// 查询 AppDeployment 的部署锁
+func (c CMDBClient) GetDeployLock(name, namespace string) (map[string]any, error) {
+	path := fmt.Sprintf("/appdeployments/%s/%s/lock", namespace, name)
+	var result map[string]any
+	url := c.getCMDBAPIURL() + path
+	resp, err := req.C().R().SetSuccessResult(&result).SetErrorResult(&result).Get(url)
+	return result, c.fmtError(&cmdb.AppDeployment{}, resp, err)
+}
+
+// 强制释放 AppDeployment 的部署锁
+func (c CMDBClient) UnlockAppDeployment(name, namespace string) (map[string]any, error) {
+	path := fmt.Sprintf("/appdeployments/%s/%s/lock", namespace, name)
+	var result map[string]any
+	url := c.getCMDBAPIURL() + path
+	resp, err := req.C().R().SetSuccessResult(&result).SetErrorResult(&result).Delete(url)
+	return result, c.fmtError(&cmdb.AppDeployment{}, resp, err)
+}
+
+// 更新 AppInstance 的 flow run 状态
+func (c CMDBClient) UpdateAppInstanceStatus(name, namespace string, status cmdb.FlowRunStatus) (map[string]any, error) {
+	path := fmt.Sprintf("/appinstances/%s/%s/status", namespace, name)
+	var result map[string]any
+	url := c.getCMDBAPIURL() + path
+	data := map[string]any{"flowRunStatus": status}
 	resp, err := req.C().R().SetBody(data).SetSuccessResult(&result).SetErrorResult(&result).Post(url)
+	return result, c.fmtError(&cmdb.AppInstance{}, resp, err)
+}
+
+// 查询 AppDeployment 及其当前版本 AppInstance 的部署状态
+func (c CMDBClient) GetAppDeploymentStatus(name, namespace string) (map[string]any, error) {
+	path := fmt.Sprintf("/appdeployments/%s/%s/status", namespace, name)
+	var result map[string]any
+	url := c.getCMDBAPIURL() + path
+	resp, err := req.C().R().SetSuccessResult(&result).SetErrorResult(&result).Get(url)
+	return result, c.fmtError(&cmdb.AppDeployment{}, resp, err)
+}
+
+// 查询 AppDeployment 应用镜像的标签，sort 为 semver 或 date
+func (c CMDBClient) ListImageTags(name, namespace, sort string, page, pageSize int) (map[string]any, error) {
+	path := fmt.Sprintf("/appdeployments/%s/%s/tags", namespace, name)
+	var result map[string]any
+	url := c.getCMDBAPIURL() + path
+	query := map[string]string{
+		"sort":     sort,
+		"page":     strconv.Itoa(page),
+		"pageSize": strconv.Itoa(pageSize),
+	}
+	resp, err := req.C().R().SetQueryParams(query).SetSuccessResult(&result).SetErrorResult(&result).Get(url)
+	return result, c.fmtError(&cmdb.AppDeployment{}, resp, err)
+}
+
+// 查询 AppDeployment 当前版本的部署日志，指定 instance 时仅查询该 AppInstance 的日志
+func (c CMDBClient) ReadLogs(name, namespace, instance string) ([]map[string]any, error) {
+	path := fmt.Sprintf("/appdeployments/%s/%s/logs", namespace, name)
+	var result []map[string]any
+	var errResult map[string]any
+	url := c.getCMDBAPIURL() + path
+	query := map[string]string{"instance": instance}
+	resp, err := req.C().R().SetQueryParams(query).SetSuccessResult(&result).SetErrorResult(&errResult).Get(url)
 	return result, c.fmtError(&cmdb.AppDeployment{}, resp, err)
 }
 
+// 持续读取部署日志直到部署结束，每条日志回调 fn
+func (c CMDBClient) FollowLogs(name, namespace, instance string, fn func(map[string]any)) error {
+	path := fmt.Sprintf("/appdeployments/%s/%s/logs", namespace, name)
+	url := c.getCMDBAPIURL() + path
+	query := map[string]string{"instance": instance, "follow": "true"}
+	resp, err := req.C().SetTimeout(0).R().SetQueryParams(query).DisableAutoReadResponse().Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		resp.ToBytes()
+		return c.fmtError(&cmdb.AppDeployment{}, resp, nil)
+	}
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var log map[string]any
+		if err = json.Unmarshal(scanner.Bytes(), &log); err != nil {
+			return err
+		}
+		fn(log)
+	}
+	return scanner.Err()
+}
+
+// 渲染参数，服务端要求 params 不能为空
+func renderParams(params map[string]any) map[string]any {
+	if params == nil {
+		return map[string]any{}
+	}
+	return params
+}
+
 // 格式化错误信息
 func (c CMDBClient) fmtError(r cmdb.Object, resp *req.Response, err error) error {
 	if err != nil || resp == nil {
@@ -176,6 +378,7 @@ func (c CMDBClient) fmtError(r cmdb.Object, resp *req.Response, err error) error
 			if ok, _ := regexp.MatchString("already exist", resp.String()); ok {
 				return cmdb.ResourceAlreadyExistError{Path: uri, Kind: lkind, Name: name, Namespace: namespace, Message: resp.String()}
 			}
+			return cmdb.ServerError{Path: uri, StatusCode: resp.StatusCode, Message: resp.String()}
 		case 404:
 			return cmdb.ResourceNotFoundError{Path: uri, Kind: lkind, Name: name, Namespace: namespace, Message: resp.String()}
 		default:
@@ -223,6 +426,25 @@ func (c CMDBClient) getCMDBAPIURL() string {
 	return global.ClientSetting.CMDB_API_URL
 }
 
+func (c CMDBClient) getToken() string {
+	if c.Token != "" {
+		return c.Token
+	}
+	if global.ClientSetting != nil {
+		return global.ClientSetting.CMDB_TOKEN
+	}
+	return ""
+}
+
+// 携带访问令牌的请求，服务端据此认证部署发起人及审批人
+func (c CMDBClient) authRequest() *req.Request {
+	r := req.C().R()
+	if token := c.getToken(); token != "" {
+		r.SetBearerAuthToken(token)
+	}
+	return r
+}
+
 func LowerKind(r cmdb.Object) string {
 	return strings.ToLower(r.GetKind()) + "s"
 }
@@ -243,6 +465,11 @@ func RemoveResourceManageFields(r map[string]any) {
 	if kind == "AppDeployment" {
 		delete(r, "flow_run_id")
 		delete(r, "status")
+		delete(r, "deployRevision")
+		delete(r, "rollout")
+	}
+	if kind == "Schedule" || kind == "DeploymentRequest" || kind == "Notifier" || kind == "DeployPlan" {
+		delete(r, "status")
 	}
 }
 
@@ -12,9 +12,13 @@ import (
 	apiv1 "gcmdb/pkg/cmdb/server/apis/v1"
 	"gcmdb/pkg/cmdb/server/storage"
 	"io/fs"
+	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"slices"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/goccy/go-yaml"
 	"github.com/stretchr/testify/assert"
@@ -263,6 +267,8 @@ func TestCreateResource(t *testing.T) {
 		"../example/files/orchestration.yaml",
 		"../example/files/appdeployment.yaml",
 		"../example/files/appinstance.yaml",
+		"../example/files/schedule.yaml",
+		"../example/files/notifier.yaml",
 	}
 	ts, apiUrl := testServer()
 	defer ts.Close()
@@ -459,6 +465,7 @@ func TestDeleteResource(t *testing.T) {
 	}
 	// 优先级倒序
 	cases := []Case{
+		{cmdb.NewSchedule(), "go-app-nightly", "test"},
 		{cmdb.NewAppInstance(), "go-app--test--eh6hw", "test"},
 		{cmdb.NewAppDeployment(), "go-app", "test"},
 		{cmdb.NewOrchestration(), "test", ""},
@@ -529,3 +536,1808 @@ func TestRunAppDeployment(t *testing.T) {
 	out, _ := yaml.MarshalWithOptions(result, yaml.AutoInt(), yaml.UseLiteralStyleIfMultiline(true))
 	fmt.Println(string(out))
 }
+
+func TestRestartAppDeploymentHostNode(t *testing.T) {
+	clearDb()
+	defer clearDb()
+	TestCreateResource(t)
+	ts, apiUrl := testServer()
+	defer ts.Close()
+
+	namespace := "test"
+	name := "go-app"
+	cli := NewCMDBClient(apiUrl)
+	node, err := ParseResourceFromFile("../example/files/hostnode.yaml")
+	assert.NoError(t, err)
+	node.GetMeta().Name = "test-2"
+	_, err = cli.CreateResource(node)
+	assert.NoError(t, err)
+
+	selector := map[string]string{"appDeployment": name}
+	// 返回未完成实例所在的 HostNode，并将其置为完成
+	runningNodes := func() []string {
+		var nodes []string
+		insts, err := cli.ListResource(cmdb.NewAppInstance(), &ListOptions{Namespace: namespace, Selector: selector})
+		assert.NoError(t, err)
+		for _, inst := range insts {
+			if conversion.GetMapValueByPath(inst, "status.flowRunStatus") == string(cmdb.FlowRunCompleted) {
+				continue
+			}
+			nodes = append(nodes, conversion.GetMapValueByPath(inst, "spec.deployPlatform.docker.nodeName").(string))
+			instName := conversion.GetMapValueByPath(inst, "metadata.name").(string)
+			_, err = cli.UpdateAppInstanceStatus(instName, namespace, cmdb.FlowRunCompleted)
+			assert.NoError(t, err)
+		}
+		slices.Sort(nodes)
+		return nodes
+	}
+
+	_, err = cli.RunAppDeployment(deployment.DeployRelease, name, namespace, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"test", "test-2"}, runningNodes())
+
+	// 仅重启指定的 HostNode
+	_, err = cli.RunAppDeployment(deployment.DeployRestart, name, namespace, map[string]any{"hostnode": "test-2"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"test-2"}, runningNodes())
+
+	_, err = cli.RunAppDeployment(deployment.DeployRestart, name, namespace, map[string]any{"hostnode": "not-exist"})
+	assert.Error(t, err)
+}
+
+func TestUninstallAppDeployment(t *testing.T) {
+	clearDb()
+	defer clearDb()
+	TestCreateResource(t)
+	ts, apiUrl := testServer()
+	defer ts.Close()
+
+	namespace := "test"
+	name := "go-app"
+	cli := NewCMDBClient(apiUrl)
+	selector := map[string]string{"appDeployment": name}
+	setInstancesStatus := func(status cmdb.FlowRunStatus) {
+		insts, err := cli.ListResource(cmdb.NewAppInstance(), &ListOptions{Namespace: namespace, Selector: selector})
+		assert.NoError(t, err)
+		assert.Less(t, 0, len(insts))
+		for _, inst := range insts {
+			instName := conversion.GetMapValueByPath(inst, "metadata.name").(string)
+			_, err = cli.UpdateAppInstanceStatus(instName, namespace, status)
+			assert.NoError(t, err)
+		}
+	}
+
+	// 未部署时不允许卸载
+	_, err := cli.RunAppDeployment(deployment.DeployUninstall, name, namespace, nil)
+	assert.Error(t, err)
+
+	_, err = cli.RunAppDeployment(deployment.DeployRelease, name, namespace, nil)
+	assert.NoError(t, err)
+	setInstancesStatus(cmdb.FlowRunCompleted)
+	appDeploy, err := cli.ReadResource(cmdb.NewAppDeployment(), name, namespace, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, string(cmdb.AppDeploymentDeployed), appDeploy["status"])
+
+	result, err := cli.RunAppDeployment(deployment.DeployUninstall, name, namespace, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, string(cmdb.AppDeploymentUninstalling), result["status"])
+	insts, err := cli.ListResource(cmdb.NewAppInstance(), &ListOptions{Namespace: namespace, Selector: selector})
+	assert.NoError(t, err)
+	for _, inst := range insts {
+		assert.Equal(t, string(cmdb.AppInstanceTerminating), conversion.GetMapValueByPath(inst, "status.phase"))
+	}
+
+	setInstancesStatus(cmdb.FlowRunCompleted)
+	appDeploy, err = cli.ReadResource(cmdb.NewAppDeployment(), name, namespace, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, string(cmdb.AppDeploymentUninstalled), appDeploy["status"])
+	insts, err = cli.ListResource(cmdb.NewAppInstance(), &ListOptions{Namespace: namespace, Selector: selector})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(insts))
+}
+
+func TestUpdateAppInstanceStatusInvalid(t *testing.T) {
+	TestCreateResource(t)
+	ts, apiUrl := testServer()
+	defer ts.Close()
+
+	cli := NewCMDBClient(apiUrl)
+	_, err := cli.UpdateAppInstanceStatus("go-app--test--eh6hw", "test", "a-bad-status")
+	assert.IsType(t, cmdb.ResourceValidateError{}, err)
+	_, err = cli.UpdateAppInstanceStatus("a-not-exist-instance", "test", cmdb.FlowRunCompleted)
+	assert.IsType(t, cmdb.ResourceNotFoundError{}, err)
+}
+
+func TestRollingAppDeployment(t *testing.T) {
+	clearDb()
+	defer clearDb()
+	TestCreateResource(t)
+	ts, apiUrl := testServer()
+	defer ts.Close()
+
+	namespace := "test"
+	name := "go-app"
+	cli := NewCMDBClient(apiUrl)
+	for _, nodeName := range []string{"test-2", "test-3"} {
+		node, err := ParseResourceFromFile("../example/files/hostnode.yaml")
+		assert.NoError(t, err)
+		node.GetMeta().Name = nodeName
+		_, err = cli.CreateResource(node)
+		assert.NoError(t, err)
+	}
+	obj, err := ParseResourceFromFile("../example/files/appdeployment.yaml")
+	assert.NoError(t, err)
+	appDeploy := obj.(*cmdb.AppDeployment)
+	appDeploy.Spec.Strategy = &cmdb.DeployStrategy{
+		Type:          cmdb.DeployStrategyRolling,
+		RollingUpdate: &cmdb.RollingUpdateStrategy{BatchSize: 2, MaxUnavailable: 1},
+	}
+	_, err = cli.UpdateResource(appDeploy)
+	assert.NoError(t, err)
+	etcdClient, err := clientv3.New(clientv3.Config{
+		Endpoints: []string{global.ServerSetting.ETCD_SERVER_HOST + ":" + global.ServerSetting.ETCD_SERVER_PORT},
+	})
+	assert.NoError(t, err)
+	defer etcdClient.Close()
+	store := storage.New(etcdClient, global.StoragePathPrefix)
+
+	selector := map[string]string{"appDeployment": name}
+	instsOfBatch := func(batch int) []map[string]any {
+		var result []map[string]any
+		insts, err := cli.ListResource(cmdb.NewAppInstance(), &ListOptions{Namespace: namespace, Selector: selector})
+		assert.NoError(t, err)
+		for _, inst := range insts {
+			if conversion.GetMapValueByPath(inst, "status.batch") == float64(batch) {
+				result = append(result, inst)
+			}
+		}
+		return result
+	}
+	setBatchStatus := func(batch int, status cmdb.FlowRunStatus) {
+		for _, inst := range instsOfBatch(batch) {
+			instName := conversion.GetMapValueByPath(inst, "metadata.name").(string)
+			_, err := cli.UpdateAppInstanceStatus(instName, namespace, status)
+			assert.NoError(t, err)
+		}
+	}
+
+	_, err = cli.RunAppDeployment(deployment.DeployRelease, name, namespace, nil)
+	assert.NoError(t, err)
+	appDeployMap, err := cli.ReadResource(cmdb.NewAppDeployment(), name, namespace, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, float64(1), appDeployMap["deployRevision"])
+	for batch := 1; batch <= 3; batch++ {
+		insts := instsOfBatch(batch)
+		assert.Equal(t, 1, len(insts))
+		want := string(cmdb.FlowRunPending)
+		if batch == 1 {
+			want = string(cmdb.FlowRunRunning)
+		}
+		assert.Equal(t, want, conversion.GetMapValueByPath(insts[0], "status.flowRunStatus"))
+	}
+
+	// 第一批次完成后记录下一批次的发布时间，到期后由推进循环发布第二批次
+	setBatchStatus(1, cmdb.FlowRunCompleted)
+	appDeployMap, err = cli.ReadResource(cmdb.NewAppDeployment(), name, namespace, 0)
+	assert.NoError(t, err)
+	next, err := time.Parse(time.RFC3339, conversion.GetMapValueByPath(appDeployMap, "rollout.nextBatchTime").(string))
+	assert.NoError(t, err)
+	assert.NoError(t, deployment.AdvanceDueRollouts(store, next.Add(-time.Second)))
+	assert.Equal(t, string(cmdb.FlowRunPending), conversion.GetMapValueByPath(instsOfBatch(2)[0], "status.flowRunStatus"))
+	assert.NoError(t, deployment.AdvanceDueRollouts(store, next.Add(time.Second)))
+	assert.Equal(t, string(cmdb.FlowRunRunning), conversion.GetMapValueByPath(instsOfBatch(2)[0], "status.flowRunStatus"))
+	appDeployMap, err = cli.ReadResource(cmdb.NewAppDeployment(), name, namespace, 0)
+	assert.NoError(t, err)
+	assert.Nil(t, conversion.GetMapValueByPath(appDeployMap, "rollout.nextBatchTime"))
+
+	// 第二批次失败后中止发布
+	setBatchStatus(2, cmdb.FlowRunFailed)
+	appDeployMap, err = cli.ReadResource(cmdb.NewAppDeployment(), name, namespace, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, string(cmdb.AppDeploymentFailed), appDeployMap["status"])
+	assert.Equal(t, float64(2), conversion.GetMapValueByPath(appDeployMap, "rollout.batch"))
+	assert.NotEmpty(t, conversion.GetMapValueByPath(appDeployMap, "rollout.message"))
+	insts := instsOfBatch(3)
+	assert.Equal(t, string(cmdb.FlowRunCancelled), conversion.GetMapValueByPath(insts[0], "status.flowRunStatus"))
+}
+
+func TestCanaryAppDeployment(t *testing.T) {
+	clearDb()
+	defer clearDb()
+	TestCreateResource(t)
+	ts, apiUrl := testServer()
+	defer ts.Close()
+
+	etcdClient, err := clientv3.New(clientv3.Config{
+		Endpoints: []string{global.ServerSetting.ETCD_SERVER_HOST + ":" + global.ServerSetting.ETCD_SERVER_PORT},
+	})
+	assert.NoError(t, err)
+	defer etcdClient.Close()
+	store := storage.New(etcdClient, global.StoragePathPrefix)
+
+	namespace := "test"
+	name := "go-app"
+	cli := NewCMDBClient(apiUrl)
+	for _, nodeName := range []string{"test-2", "test-3"} {
+		node, err := ParseResourceFromFile("../example/files/hostnode.yaml")
+		assert.NoError(t, err)
+		node.GetMeta().Name = nodeName
+		_, err = cli.CreateResource(node)
+		assert.NoError(t, err)
+	}
+	obj, err := ParseResourceFromFile("../example/files/appdeployment.yaml")
+	assert.NoError(t, err)
+	appDeploy := obj.(*cmdb.AppDeployment)
+	appDeploy.Spec.Strategy = &cmdb.DeployStrategy{
+		Type:   cmdb.DeployStrategyCanary,
+		Canary: &cmdb.CanaryStrategy{Nodes: 1},
+	}
+	_, err = cli.UpdateResource(appDeploy)
+	assert.NoError(t, err)
+
+	selector := map[string]string{"appDeployment": name}
+	liveInsts := func() map[string]map[string]any {
+		insts, err := cli.ListResource(cmdb.NewAppInstance(), &ListOptions{Namespace: namespace, Selector: selector})
+		assert.NoError(t, err)
+		live := map[string]map[string]any{}
+		for _, inst := range insts {
+			live[conversion.GetMapValueByPath(inst, "spec.deployPlatform.docker.nodeName").(string)] = inst
+		}
+		return live
+	}
+	setStatus := func(inst map[string]any, status cmdb.FlowRunStatus) {
+		instName := conversion.GetMapValueByPath(inst, "metadata.name").(string)
+		_, err := cli.UpdateAppInstanceStatus(instName, namespace, status)
+		assert.NoError(t, err)
+	}
+	readAppDeploy := func() map[string]any {
+		appDeployMap, err := cli.ReadResource(cmdb.NewAppDeployment(), name, namespace, 0)
+		assert.NoError(t, err)
+		return appDeployMap
+	}
+	// 发布金丝雀，分析完成后暂停，返回金丝雀节点
+	releaseCanary := func() string {
+		_, err := cli.RunAppDeployment(deployment.DeployRelease, name, namespace, nil)
+		assert.NoError(t, err)
+		canary := ""
+		for node, inst := range liveInsts() {
+			if conversion.GetMapValueByPath(inst, "status.batch") == float64(1) {
+				assert.Equal(t, string(cmdb.FlowRunRunning), conversion.GetMapValueByPath(inst, "status.flowRunStatus"))
+				canary = node
+			} else {
+				assert.Equal(t, string(cmdb.FlowRunPending), conversion.GetMapValueByPath(inst, "status.flowRunStatus"))
+			}
+		}
+		assert.NotEmpty(t, canary)
+
+		// 分析未完成时不允许 promote
+		_, err = cli.RunAppDeployment(deployment.DeployPromote, name, namespace, nil)
+		assert.Error(t, err)
+		setStatus(liveInsts()[canary], cmdb.FlowRunCompleted)
+		// 分析时间已持久化，由推进循环到期后分析
+		assert.NotEmpty(t, conversion.GetMapValueByPath(readAppDeploy(), "rollout.analysisTime"))
+		assert.NotEqual(t, true, conversion.GetMapValueByPath(readAppDeploy(), "rollout.paused"))
+		assert.NoError(t, deployment.AdvanceDueRollouts(store, time.Now()))
+		assert.Equal(t, true, conversion.GetMapValueByPath(readAppDeploy(), "rollout.paused"))
+		assert.Equal(t, "passed", conversion.GetMapValueByPath(readAppDeploy(), "rollout.analysis"))
+		return canary
+	}
+
+	// promote 后发布剩余节点，并接管金丝雀发布持有的部署锁
+	releaseCanary()
+	_, err = cli.RunAppDeployment(deployment.DeployPromote, name, namespace, nil)
+	assert.NoError(t, err)
+	lock, err := cli.GetDeployLock(name, namespace)
+	assert.NoError(t, err)
+	assert.Contains(t, lock["owner"], string(deployment.DeployPromote))
+	live := liveInsts()
+	assert.Equal(t, 3, len(live))
+	for _, inst := range live {
+		if conversion.GetMapValueByPath(inst, "status.batch") == float64(2) {
+			assert.Equal(t, string(cmdb.FlowRunRunning), conversion.GetMapValueByPath(inst, "status.flowRunStatus"))
+			setStatus(inst, cmdb.FlowRunCompleted)
+		}
+	}
+	assert.Equal(t, string(cmdb.AppDeploymentDeployed), readAppDeploy()["status"])
+
+	// abort 后删除未发布的实例，金丝雀节点回滚到上一版本
+	canary := releaseCanary()
+	_, err = cli.RunAppDeployment(deployment.DeployAbort, name, namespace, nil)
+	assert.NoError(t, err)
+	appDeployMap := readAppDeploy()
+	assert.Equal(t, string(cmdb.AppDeploymentDeploying), appDeployMap["status"])
+	assert.Equal(t, float64(3), appDeployMap["deployRevision"])
+	assert.Equal(t, true, conversion.GetMapValueByPath(appDeployMap, "rollout.aborted"))
+	live = liveInsts()
+	for node, inst := range live {
+		revision := conversion.GetMapValueByPath(inst, "metadata.labels.appDeploymentRevision")
+		if node == canary {
+			assert.Equal(t, "3", revision)
+			assert.Equal(t, string(cmdb.FlowRunRunning), conversion.GetMapValueByPath(inst, "status.flowRunStatus"))
+			setStatus(inst, cmdb.FlowRunCompleted)
+		} else {
+			assert.Equal(t, "1", revision)
+		}
+	}
+	assert.Equal(t, string(cmdb.AppDeploymentDeployed), readAppDeploy()["status"])
+	_, err = cli.RunAppDeployment(deployment.DeployAbort, name, namespace, nil)
+	assert.Error(t, err)
+}
+
+func TestCanaryAnalysisFailed(t *testing.T) {
+	clearDb()
+	defer clearDb()
+	TestCreateResource(t)
+	ts, apiUrl := testServer()
+	defer ts.Close()
+	probe := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer probe.Close()
+	probeUrl, err := url.Parse(probe.URL)
+	assert.NoError(t, err)
+
+	etcdClient, err := clientv3.New(clientv3.Config{
+		Endpoints: []string{global.ServerSetting.ETCD_SERVER_HOST + ":" + global.ServerSetting.ETCD_SERVER_PORT},
+	})
+	assert.NoError(t, err)
+	defer etcdClient.Close()
+	store := storage.New(etcdClient, global.StoragePathPrefix)
+
+	namespace := "test"
+	name := "go-app"
+	cli := NewCMDBClient(apiUrl)
+	for _, nodeName := range []string{"test", "test-2"} {
+		node, err := ParseResourceFromFile("../example/files/hostnode.yaml")
+		assert.NoError(t, err)
+		node.GetMeta().Name = nodeName
+		node.(*cmdb.HostNode).Spec.Ip = probeUrl.Hostname()
+		if _, err = cli.CreateResource(node); err != nil {
+			_, err = cli.UpdateResource(node)
+		}
+		assert.NoError(t, err)
+	}
+	obj, err := ParseResourceFromFile("../example/files/appdeployment.yaml")
+	assert.NoError(t, err)
+	appDeploy := obj.(*cmdb.AppDeployment)
+	appDeploy.Spec.Template.Spec.Monitoring.Probe.HttpGet.Port = probeUrl.Port()
+	appDeploy.Spec.Strategy = &cmdb.DeployStrategy{
+		Type:   cmdb.DeployStrategyCanary,
+		Canary: &cmdb.CanaryStrategy{Nodes: 1, HealthCheck: true},
+	}
+	_, err = cli.UpdateResource(appDeploy)
+	assert.NoError(t, err)
+	readAppDeploy := func() map[string]any {
+		appDeployMap, err := cli.ReadResource(cmdb.NewAppDeployment(), name, namespace, 0)
+		assert.NoError(t, err)
+		return appDeployMap
+	}
+	listInsts := func() []map[string]any {
+		insts, err := cli.ListResource(cmdb.NewAppInstance(), &ListOptions{Namespace: namespace, Selector: map[string]string{"appDeployment": name}})
+		assert.NoError(t, err)
+		return insts
+	}
+
+	_, err = cli.RunAppDeployment(deployment.DeployRelease, name, namespace, nil)
+	assert.NoError(t, err)
+	for _, inst := range listInsts() {
+		if conversion.GetMapValueByPath(inst, "status.batch") == float64(1) {
+			_, err = cli.UpdateAppInstanceStatus(conversion.GetMapValueByPath(inst, "metadata.name").(string), namespace, cmdb.FlowRunCompleted)
+			assert.NoError(t, err)
+		}
+	}
+
+	// 分析失败后自动中止发布，不允许 promote
+	assert.NoError(t, deployment.AdvanceDueRollouts(store, time.Now()))
+	appDeployMap := readAppDeploy()
+	assert.Equal(t, string(cmdb.AppDeploymentFailed), appDeployMap["status"])
+	assert.Contains(t, conversion.GetMapValueByPath(appDeployMap, "rollout.analysis"), "failed")
+	assert.NotEqual(t, true, conversion.GetMapValueByPath(appDeployMap, "rollout.paused"))
+	_, err = cli.RunAppDeployment(deployment.DeployPromote, name, namespace, nil)
+	assert.Error(t, err)
+
+	// abort 删除已取消的实例并卸载金丝雀
+	_, err = cli.RunAppDeployment(deployment.DeployAbort, name, namespace, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, string(cmdb.AppDeploymentDeploying), readAppDeploy()["status"])
+	insts := listInsts()
+	assert.Equal(t, 1, len(insts))
+	_, err = cli.UpdateAppInstanceStatus(conversion.GetMapValueByPath(insts[0], "metadata.name").(string), namespace, cmdb.FlowRunCompleted)
+	assert.NoError(t, err)
+	assert.Equal(t, string(cmdb.AppDeploymentUninstalled), readAppDeploy()["status"])
+}
+
+func TestDeployLock(t *testing.T) {
+	clearDb()
+	defer clearDb()
+	TestCreateResource(t)
+	ts, apiUrl := testServer()
+	defer ts.Close()
+
+	etcdClient, err := clientv3.New(clientv3.Config{
+		Endpoints: []string{global.ServerSetting.ETCD_SERVER_HOST + ":" + global.ServerSetting.ETCD_SERVER_PORT},
+	})
+	assert.NoError(t, err)
+	defer etcdClient.Close()
+	store := storage.New(etcdClient, global.StoragePathPrefix)
+	lockTTL := deployment.LockTTL
+	deployment.LockTTL = 3
+	defer func() { deployment.LockTTL = lockTTL }()
+
+	namespace := "test"
+	name := "go-app"
+	cli := NewCMDBClient(apiUrl)
+	_, err = cli.GetDeployLock(name, namespace)
+	assert.IsType(t, cmdb.ResourceNotFoundError{}, err)
+
+	// 部署进行中持有部署锁
+	_, err = cli.RunAppDeployment(deployment.DeployRelease, name, namespace, nil)
+	assert.NoError(t, err)
+	lock, err := cli.GetDeployLock(name, namespace)
+	assert.NoError(t, err)
+	assert.Contains(t, lock["owner"], string(deployment.DeployRelease))
+	_, err = cli.RunAppDeployment(deployment.DeployRestart, name, namespace, nil)
+	assert.IsType(t, cmdb.ServerError{}, err)
+	assert.Equal(t, 409, err.(cmdb.ServerError).StatusCode)
+
+	// flow run 运行时间超过锁的有效期，由推进循环续期
+	for i := 0; i < 4; i++ {
+		time.Sleep(time.Second)
+		assert.NoError(t, deployment.AdvanceDueRollouts(store, time.Now()))
+	}
+	lock, err = cli.GetDeployLock(name, namespace)
+	assert.NoError(t, err)
+	assert.Contains(t, lock["owner"], string(deployment.DeployRelease))
+
+	// 强制释放后不再续期，允许重新部署
+	_, err = cli.UnlockAppDeployment(name, namespace)
+	assert.NoError(t, err)
+	assert.NoError(t, deployment.AdvanceDueRollouts(store, time.Now()))
+	_, err = cli.GetDeployLock(name, namespace)
+	assert.IsType(t, cmdb.ResourceNotFoundError{}, err)
+	_, err = cli.RunAppDeployment(deployment.DeployRelease, name, namespace, nil)
+	assert.NoError(t, err)
+
+	// 部署结束后释放部署锁
+	insts, err := cli.ListResource(cmdb.NewAppInstance(), &ListOptions{Namespace: namespace, Selector: map[string]string{"appDeployment": name}})
+	assert.NoError(t, err)
+	for _, inst := range insts {
+		instName := conversion.GetMapValueByPath(inst, "metadata.name").(string)
+		_, err = cli.UpdateAppInstanceStatus(instName, namespace, cmdb.FlowRunCompleted)
+		assert.NoError(t, err)
+	}
+	_, err = cli.GetDeployLock(name, namespace)
+	assert.IsType(t, cmdb.ResourceNotFoundError{}, err)
+}
+
+func TestRenderKubernetes(t *testing.T) {
+	clearDb()
+	defer clearDb()
+	TestCreateResource(t)
+	ts, apiUrl := testServer()
+	defer ts.Close()
+
+	namespace := "test"
+	name := "go-app"
+	cli := NewCMDBClient(apiUrl)
+	_, err := cli.RenderHelmValues(name, namespace, nil)
+	assert.Error(t, err)
+
+	obj, err := ParseResourceFromFile("../example/files/appdeployment.yaml")
+	assert.NoError(t, err)
+	appDeploy := obj.(*cmdb.AppDeployment)
+	appDeploy.Spec.Template.Spec.DeployPlatform.Kubernetes = &cmdb.DPKubernetes{
+		Name:              "test",
+		Namespace:         "prod",
+		ContainerRegistry: &cmdb.DPContainerRegistry{Name: "harbor-test", Project: "go-devops"},
+		Helm:              &cmdb.DPHelm{Name: "test", Release: "go-app", Chart: "common", ChartVersion: "1.0.0"},
+	}
+	_, err = cli.UpdateResource(appDeploy)
+	assert.NoError(t, err)
+
+	values, err := cli.RenderHelmValues(name, namespace, map[string]any{"image_tag": "v1.0.0"})
+	assert.NoError(t, err)
+	assert.Equal(t, "harbor.dev.com/go-devops/go-app", conversion.GetMapValueByPath(values, "image.repository"))
+	assert.Equal(t, "v1.0.0", conversion.GetMapValueByPath(values, "image.tag"))
+
+	manifests, err := cli.RenderManifests(name, namespace, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(manifests))
+	assert.Equal(t, "Deployment", manifests[0]["kind"])
+	assert.Equal(t, "Service", manifests[1]["kind"])
+	assert.Equal(t, "prod", conversion.GetMapValueByPath(manifests[0], "metadata.namespace"))
+}
+
+func TestRenderDockerCompose(t *testing.T) {
+	clearDb()
+	defer clearDb()
+	TestCreateResource(t)
+	ts, apiUrl := testServer()
+	defer ts.Close()
+
+	namespace := "test"
+	name := "go-app"
+	cli := NewCMDBClient(apiUrl)
+	composes, err := cli.RenderDockerCompose(name, namespace, map[string]any{"image_tag": "v1.0.0"})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(composes))
+	service := conversion.GetMapValueByPath(composes["test"], "services.go-app").(map[string]any)
+	assert.Equal(t, "harbor.dev.com/go-devops/go-app:v1.0.0", service["image"])
+	assert.Equal(t, "test", conversion.GetMapValueByPath(service, "environment.HOST_NODE_NAME"))
+
+	// 未指定 DeployTemplate 时使用内置的 docker-compose 模板
+	obj, err := ParseResourceFromFile("../example/files/resource_range.yaml")
+	assert.NoError(t, err)
+	rr := obj.(*cmdb.ResourceRange)
+	rr.DeployTemplate.Name = ""
+	_, err = cli.UpdateResource(rr)
+	assert.NoError(t, err)
+	deployTpl, err := cli.RenderDeployTemplate(name, namespace, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "docker-compose-default", conversion.GetMapValueByPath(deployTpl, "metadata.name"))
+	tplData := conversion.GetMapValueByPath(deployTpl, "data").(map[string]any)
+	assert.Contains(t, tplData["docker-compose.yml"], "go-app")
+
+	_, err = cli.RunAppDeployment(deployment.DeployRelease, name, namespace, nil)
+	assert.NoError(t, err)
+	insts, err := cli.ListResource(cmdb.NewAppInstance(), &ListOptions{Namespace: namespace, Selector: map[string]string{"appDeployment": name}})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(insts))
+	data := conversion.GetMapValueByPath(insts[0], "deployTemplate.data").(map[string]any)
+	assert.Contains(t, data["docker-compose.yml"], "HOST_NODE_NAME: test")
+
+	// nodeSelector 为空时会匹配所有 HostNode，不允许渲染
+	obj, err = ParseResourceFromFile("../example/files/appdeployment.yaml")
+	assert.NoError(t, err)
+	appDeploy := obj.(*cmdb.AppDeployment)
+	appDeploy.Spec.Template.Spec.NodeSelector = map[string]string{}
+	_, err = cli.UpdateResource(appDeploy)
+	assert.NoError(t, err)
+	_, err = cli.RenderDockerCompose(name, namespace, map[string]any{"image_tag": "v1.0.0"})
+	assert.ErrorContains(t, err, "spec.nodeSelector")
+}
+
+// 模拟 Prefect 日志查询接口
+func testPrefectServer(logs map[string][]map[string]any, mu *sync.Mutex) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var filter struct {
+			Logs struct {
+				FlowRunId struct {
+					Any []string `json:"any_"`
+				} `json:"flow_run_id"`
+			} `json:"logs"`
+			Offset int `json:"offset"`
+			Limit  int `json:"limit"`
+		}
+		if r.URL.Path != "/api/logs/filter" || json.NewDecoder(r.Body).Decode(&filter) != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		runLogs := logs[filter.Logs.FlowRunId.Any[0]]
+		start := min(filter.Offset, len(runLogs))
+		end := min(start+filter.Limit, len(runLogs))
+		json.NewEncoder(w).Encode(runLogs[start:end])
+	}))
+}
+
+func TestReadLogs(t *testing.T) {
+	clearDb()
+	defer clearDb()
+	TestCreateResource(t)
+	// follow 模式的持续时间超过请求超时时间
+	apiv1.RequestTimeout = 200 * time.Millisecond
+	defer func() { apiv1.RequestTimeout = 3 * time.Second }()
+	ts, apiUrl := testServer()
+	defer ts.Close()
+
+	namespace := "test"
+	name := "go-app"
+	cli := NewCMDBClient(apiUrl)
+	_, err := cli.ReadLogs(name, namespace, "")
+	assert.IsType(t, cmdb.ServerError{}, err)
+
+	var mu sync.Mutex
+	deployRunId := "6f1c5a1e-3b7d-4c1e-9a51-0c6f3e2d8b11"
+	instRunId := "0b8e2f7a-9c4d-4e3b-8f21-7d5a6c1e4f22"
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	newLog := func(runId string, i int) map[string]any {
+		return map[string]any{
+			"id":          fmt.Sprintf("%s-%d", runId, i),
+			"flow_run_id": runId,
+			"level":       20,
+			"message":     fmt.Sprintf("log %d", i),
+			"timestamp":   start.Add(time.Duration(i) * time.Second).Format(time.RFC3339),
+		}
+	}
+	prefectLogs := map[string][]map[string]any{}
+	// 超过单页条数，需要分页读取
+	for i := 0; i < 201; i++ {
+		prefectLogs[deployRunId] = append(prefectLogs[deployRunId], newLog(deployRunId, i*2))
+	}
+	prefectLogs[instRunId] = []map[string]any{newLog(instRunId, 1)}
+	prefect := testPrefectServer(prefectLogs, &mu)
+	defer prefect.Close()
+	deployment.PrefectApiUrl = prefect.URL + "/api"
+	deployment.LogPollInterval = 50 * time.Millisecond
+	defer func() {
+		deployment.PrefectApiUrl = ""
+		deployment.LogPollInterval = 2 * time.Second
+	}()
+
+	// 部署后设置 flow run id
+	_, err = cli.RunAppDeployment(deployment.DeployRelease, name, namespace, nil)
+	assert.NoError(t, err)
+	etcdClient, err := clientv3.New(clientv3.Config{
+		Endpoints: []string{global.ServerSetting.ETCD_SERVER_HOST + ":" + global.ServerSetting.ETCD_SERVER_PORT},
+	})
+	assert.NoError(t, err)
+	defer etcdClient.Close()
+	store := storage.New(etcdClient, global.StoragePathPrefix)
+	var obj cmdb.Object
+	assert.NoError(t, store.Get(context.Background(), "AppDeployment", name, namespace, storage.GetOptions{}, &obj))
+	obj.(*cmdb.AppDeployment).FlowRunId = deployRunId
+	assert.NoError(t, store.Update(context.Background(), obj, nil))
+	insts, err := cli.ListResource(cmdb.NewAppInstance(), &ListOptions{Namespace: namespace, Selector: map[string]string{"appDeployment": name}})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(insts))
+	instName := conversion.GetMapValueByPath(insts[0], "metadata.name").(string)
+	assert.NoError(t, store.Get(context.Background(), "AppInstance", instName, namespace, storage.GetOptions{}, &obj))
+	obj.(*cmdb.AppInstance).FlowRunId = instRunId
+	assert.NoError(t, store.Update(context.Background(), obj, nil))
+
+	logs, err := cli.ReadLogs(name, namespace, "")
+	assert.NoError(t, err)
+	assert.Equal(t, 202, len(logs))
+	assert.Equal(t, "log 0", logs[0]["message"])
+	assert.Equal(t, "log 1", logs[1]["message"])
+	assert.Equal(t, "log 400", logs[201]["message"])
+
+	logs, err = cli.ReadLogs(name, namespace, instName)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(logs))
+	assert.Equal(t, instRunId, logs[0]["flow_run_id"])
+	_, err = cli.ReadLogs(name, namespace, "not-exist")
+	assert.IsType(t, cmdb.ResourceNotFoundError{}, err)
+
+	// follow 模式持续输出新日志，不受请求超时限制，部署结束后退出
+	var followed []map[string]any
+	followStart := time.Now()
+	err = cli.FollowLogs(name, namespace, instName, func(log map[string]any) {
+		followed = append(followed, log)
+		if len(followed) == 1 {
+			time.Sleep(2 * apiv1.RequestTimeout)
+			mu.Lock()
+			prefectLogs[instRunId] = append(prefectLogs[instRunId], newLog(instRunId, 3))
+			mu.Unlock()
+		}
+		if len(followed) == 2 {
+			mu.Lock()
+			prefectLogs[instRunId] = append(prefectLogs[instRunId], newLog(instRunId, 5))
+			mu.Unlock()
+			_, err := cli.UpdateAppInstanceStatus(instName, namespace, cmdb.FlowRunCompleted)
+			assert.NoError(t, err)
+		}
+	})
+	assert.NoError(t, err)
+	assert.Greater(t, time.Since(followStart), 2*apiv1.RequestTimeout)
+	assert.Equal(t, 3, len(followed))
+	assert.Equal(t, "log 5", followed[2]["message"])
+	err = cli.FollowLogs("not-exist", namespace, "", func(map[string]any) {})
+	assert.IsType(t, cmdb.ResourceNotFoundError{}, err)
+}
+
+func TestListImageTags(t *testing.T) {
+	clearDb()
+	defer clearDb()
+	TestCreateResource(t)
+	ts, apiUrl := testServer()
+	defer ts.Close()
+
+	// 模拟使用 Basic 认证的镜像仓库
+	registry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, _, _ := r.BasicAuth(); user != "readonly" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/v2/go-devops/go-app/tags/list":
+			json.NewEncoder(w).Encode(map[string]any{"tags": []string{"v1.0.0", "latest", "v1.1.0", "v1.0.1"}})
+		case "/v2/go-devops/go-app/manifests/v1.1.0", "/v2/go-devops/go-app/manifests/v1.0.1":
+			json.NewEncoder(w).Encode(map[string]any{"config": map[string]any{"digest": "sha256:config"}})
+		case "/v2/go-devops/go-app/blobs/sha256:config":
+			json.NewEncoder(w).Encode(map[string]any{"created": "2026-01-01T00:00:00Z"})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer registry.Close()
+
+	namespace := "test"
+	name := "go-app"
+	cli := NewCMDBClient(apiUrl)
+	obj, err := ParseResourceFromFile("../example/files/container_registry.yaml")
+	assert.NoError(t, err)
+	obj.(*cmdb.ContainerRegistry).Spec.Url = registry.URL
+	_, err = cli.UpdateResource(obj)
+	assert.NoError(t, err)
+
+	result, err := cli.ListImageTags(name, namespace, "", 1, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, "harbor.dev.com/go-devops/go-app", result["image"])
+	assert.Equal(t, float64(4), result["total"])
+	tags := result["tags"].([]any)
+	assert.Equal(t, 2, len(tags))
+	assert.Equal(t, "v1.1.0", conversion.GetMapValueByPath(tags[0].(map[string]any), "name"))
+	assert.Equal(t, "2026-01-01T00:00:00Z", conversion.GetMapValueByPath(tags[1].(map[string]any), "created"))
+
+	_, err = cli.ListImageTags(name, namespace, "invalid", 1, 2)
+	assert.IsType(t, cmdb.ServerError{}, err)
+	assert.Equal(t, 400, err.(cmdb.ServerError).StatusCode)
+	// 按日期排序需要查询所有标签的创建时间
+	_, err = cli.ListImageTags(name, namespace, "date", 1, 2)
+	assert.Error(t, err)
+	_, err = cli.ListImageTags("not-exist", namespace, "", 1, 2)
+	assert.IsType(t, cmdb.ResourceNotFoundError{}, err)
+}
+
+func TestAppDeploymentStatus(t *testing.T) {
+	clearDb()
+	defer clearDb()
+	TestCreateResource(t)
+	ts, apiUrl := testServer()
+	defer ts.Close()
+
+	namespace := "test"
+	name := "go-app"
+	cli := NewCMDBClient(apiUrl)
+	node, err := ParseResourceFromFile("../example/files/hostnode.yaml")
+	assert.NoError(t, err)
+	node.GetMeta().Name = "test-2"
+	_, err = cli.CreateResource(node)
+	assert.NoError(t, err)
+
+	state, err := cli.GetAppDeploymentStatus(name, namespace)
+	assert.NoError(t, err)
+	assert.Equal(t, string(cmdb.AppDeploymentNoneDeployed), state["status"])
+	assert.Equal(t, true, state["done"])
+	assert.Equal(t, 0, len(state["instances"].([]any)))
+
+	_, err = cli.RunAppDeployment(deployment.DeployRelease, name, namespace, nil)
+	assert.NoError(t, err)
+	state, err = cli.GetAppDeploymentStatus(name, namespace)
+	assert.NoError(t, err)
+	assert.Equal(t, string(cmdb.AppDeploymentDeploying), state["status"])
+	assert.Equal(t, false, state["done"])
+	assert.Equal(t, float64(2), conversion.GetMapValueByPath(state, "counts.running"))
+	insts := state["instances"].([]any)
+	assert.Equal(t, 2, len(insts))
+	inst := insts[0].(map[string]any)
+	assert.Equal(t, float64(1), inst["revision"])
+	assert.NotEmpty(t, inst["createdAt"])
+	assert.NotEmpty(t, inst["lastRunAt"])
+
+	_, err = cli.UpdateAppInstanceStatus(inst["name"].(string), namespace, cmdb.FlowRunFailed)
+	assert.NoError(t, err)
+	state, err = cli.GetAppDeploymentStatus(name, namespace)
+	assert.NoError(t, err)
+	assert.Equal(t, string(cmdb.AppDeploymentFailed), state["status"])
+	assert.Equal(t, true, state["done"])
+	assert.Equal(t, float64(1), conversion.GetMapValueByPath(state, "counts.failed"))
+	assert.Contains(t, state["lastError"], inst["name"].(string)+"("+inst["target"].(string)+") flow run failed")
+
+	_, err = cli.GetAppDeploymentStatus("not-exist", namespace)
+	assert.IsType(t, cmdb.ResourceNotFoundError{}, err)
+}
+
+func TestGCAppInstances(t *testing.T) {
+	clearDb()
+	defer clearDb()
+	TestCreateResource(t)
+	ts, apiUrl := testServer()
+	defer ts.Close()
+
+	namespace := "test"
+	name := "go-app"
+	cli := NewCMDBClient(apiUrl)
+	obj, err := ParseResourceFromFile("../example/files/appdeployment.yaml")
+	assert.NoError(t, err)
+	limit := 1
+	obj.(*cmdb.AppDeployment).Spec.RevisionHistoryLimit = &limit
+	_, err = cli.UpdateResource(obj)
+	assert.NoError(t, err)
+
+	selector := map[string]string{"appDeployment": name}
+	revisions := func() []string {
+		insts, err := cli.ListResource(cmdb.NewAppInstance(), &ListOptions{Namespace: namespace, Selector: selector})
+		assert.NoError(t, err)
+		result := []string{}
+		for _, inst := range insts {
+			result = append(result, conversion.GetMapValueByPath(inst, "metadata.labels.appDeploymentRevision").(string))
+		}
+		slices.Sort(result)
+		return result
+	}
+	for i := 0; i < 3; i++ {
+		_, err = cli.RunAppDeployment(deployment.DeployRelease, name, namespace, nil)
+		assert.NoError(t, err)
+		insts, err := cli.ListResource(cmdb.NewAppInstance(), &ListOptions{Namespace: namespace, Selector: selector})
+		assert.NoError(t, err)
+		for _, inst := range insts {
+			if conversion.GetMapValueByPath(inst, "status.flowRunStatus") != string(cmdb.FlowRunRunning) {
+				continue
+			}
+			instName := conversion.GetMapValueByPath(inst, "metadata.name").(string)
+			_, err = cli.UpdateAppInstanceStatus(instName, namespace, cmdb.FlowRunCompleted)
+			assert.NoError(t, err)
+		}
+	}
+	// 异步回收后保留当前版本及 1 个历史版本
+	assert.Eventually(t, func() bool {
+		return slices.Equal([]string{"2", "3"}, revisions())
+	}, 5*time.Second, 50*time.Millisecond)
+}
+
+func TestSchedule(t *testing.T) {
+	clearDb()
+	defer clearDb()
+	TestCreateResource(t)
+	ts, apiUrl := testServer()
+	defer ts.Close()
+
+	namespace := "test"
+	name := "go-app-nightly"
+	cli := NewCMDBClient(apiUrl)
+	etcdClient, err := clientv3.New(clientv3.Config{
+		Endpoints: []string{global.ServerSetting.ETCD_SERVER_HOST + ":" + global.ServerSetting.ETCD_SERVER_PORT},
+	})
+	assert.NoError(t, err)
+	defer etcdClient.Close()
+	store := storage.New(etcdClient, global.StoragePathPrefix)
+	readSchedule := func() map[string]any {
+		result, err := cli.ReadResource(cmdb.NewSchedule(), name, namespace, 0)
+		assert.NoError(t, err)
+		return result
+	}
+
+	// 未到期，仅记录下次运行时间
+	now := time.Now()
+	assert.NoError(t, deployment.RunDueSchedules(store, now))
+	schedule := readSchedule()
+	assert.Nil(t, conversion.GetMapValueByPath(schedule, "status.lastResult"))
+	next, err := time.Parse(time.RFC3339, conversion.GetMapValueByPath(schedule, "status.nextScheduleTime").(string))
+	assert.NoError(t, err)
+	loc, _ := time.LoadLocation("Asia/Shanghai")
+	assert.Equal(t, 3, next.In(loc).Hour())
+
+	// 到期触发部署
+	assert.NoError(t, deployment.RunDueSchedules(store, next))
+	schedule = readSchedule()
+	assert.Equal(t, string(cmdb.ScheduleStarted), conversion.GetMapValueByPath(schedule, "status.lastResult"))
+	assert.Equal(t, next.Add(24*time.Hour).Format(time.RFC3339), conversion.GetMapValueByPath(schedule, "status.nextScheduleTime"))
+	appDeploy, err := cli.ReadResource(cmdb.NewAppDeployment(), "go-app", namespace, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, string(cmdb.AppDeploymentDeploying), appDeploy["status"])
+
+	// 部署进行中，Forbid 策略跳过
+	assert.NoError(t, deployment.RunDueSchedules(store, next.Add(24*time.Hour)))
+	schedule = readSchedule()
+	assert.Equal(t, string(cmdb.ScheduleSkipped), conversion.GetMapValueByPath(schedule, "status.lastResult"))
+
+	// 暂停后不再记录下次运行时间
+	obj, err := ParseResourceFromFile("../example/files/schedule.yaml")
+	assert.NoError(t, err)
+	obj.(*cmdb.Schedule).Spec.Suspend = true
+	_, err = cli.UpdateResource(obj)
+	assert.NoError(t, err)
+	assert.NoError(t, deployment.RunDueSchedules(store, next.Add(48*time.Hour)))
+	schedule = readSchedule()
+	assert.Nil(t, conversion.GetMapValueByPath(schedule, "status.nextScheduleTime"))
+	assert.Nil(t, conversion.GetMapValueByPath(schedule, "status.lastResult"))
+
+	// 引用的 AppDeployment 不可删除
+	err = cli.DeleteResource(cmdb.NewAppDeployment(), "go-app", namespace)
+	assert.Error(t, err)
+}
+
+func TestScheduleReplace(t *testing.T) {
+	clearDb()
+	defer clearDb()
+	TestCreateResource(t)
+	ts, apiUrl := testServer()
+	defer ts.Close()
+
+	namespace := "test"
+	name := "go-app-nightly"
+	cli := NewCMDBClient(apiUrl)
+	etcdClient, err := clientv3.New(clientv3.Config{
+		Endpoints: []string{global.ServerSetting.ETCD_SERVER_HOST + ":" + global.ServerSetting.ETCD_SERVER_PORT},
+	})
+	assert.NoError(t, err)
+	defer etcdClient.Close()
+	store := storage.New(etcdClient, global.StoragePathPrefix)
+
+	var cancelled []string
+	prefect := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]any
+		json.NewDecoder(r.Body).Decode(&body)
+		assert.Equal(t, "CANCELLING", conversion.GetMapValueByPath(body, "state.type"))
+		cancelled = append(cancelled, r.URL.Path)
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer prefect.Close()
+	deployment.PrefectApiUrl = prefect.URL + "/api"
+	defer func() { deployment.PrefectApiUrl = "" }()
+
+	obj, err := ParseResourceFromFile("../example/files/schedule.yaml")
+	assert.NoError(t, err)
+	obj.(*cmdb.Schedule).Spec.ConcurrencyPolicy = cmdb.ScheduleConcurrencyReplace
+	_, err = cli.UpdateResource(obj)
+	assert.NoError(t, err)
+	assert.NoError(t, deployment.RunDueSchedules(store, time.Now()))
+	schedule, err := cli.ReadResource(cmdb.NewSchedule(), name, namespace, 0)
+	assert.NoError(t, err)
+	next, err := time.Parse(time.RFC3339, conversion.GetMapValueByPath(schedule, "status.nextScheduleTime").(string))
+	assert.NoError(t, err)
+	assert.NoError(t, deployment.RunDueSchedules(store, next))
+
+	// 模拟运行中的 flow run
+	flowRunId := "0b6c3a5e-8a43-4d2b-9c1f-2f5e4c3b1a00"
+	selector := map[string]string{"appDeployment": "go-app", "appDeploymentRevision": "1"}
+	insts, err := cli.ListResource(cmdb.NewAppInstance(), &ListOptions{Namespace: namespace, Selector: selector})
+	assert.NoError(t, err)
+	assert.NotEmpty(t, insts)
+	for _, inst := range insts {
+		var obj cmdb.Object
+		assert.NoError(t, store.Get(context.Background(), "AppInstance", inst["metadata"].(map[string]any)["name"].(string), namespace, storage.GetOptions{}, &obj))
+		obj.(*cmdb.AppInstance).FlowRunId = flowRunId
+		assert.NoError(t, store.Update(context.Background(), obj, nil))
+	}
+
+	// 部署进行中，Replace 策略先取消再重新部署
+	assert.NoError(t, deployment.RunDueSchedules(store, next.Add(24*time.Hour)))
+	schedule, err = cli.ReadResource(cmdb.NewSchedule(), name, namespace, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, string(cmdb.ScheduleStarted), conversion.GetMapValueByPath(schedule, "status.lastResult"))
+	assert.Equal(t, []string{"/api/flow_runs/" + flowRunId + "/set_state"}, cancelled)
+	insts, err = cli.ListResource(cmdb.NewAppInstance(), &ListOptions{Namespace: namespace, Selector: selector})
+	assert.NoError(t, err)
+	for _, inst := range insts {
+		assert.Equal(t, string(cmdb.FlowRunCancelling), conversion.GetMapValueByPath(inst, "status.flowRunStatus"))
+	}
+	appDeploy, err := cli.ReadResource(cmdb.NewAppDeployment(), "go-app", namespace, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, string(cmdb.AppDeploymentDeploying), appDeploy["status"])
+	assert.Equal(t, float64(2), appDeploy["deployRevision"])
+}
+
+func TestCreateScheduleInvalidCron(t *testing.T) {
+	clearDb()
+	defer clearDb()
+	TestCreateResource(t)
+	ts, apiUrl := testServer()
+	defer ts.Close()
+
+	cli := NewCMDBClient(apiUrl)
+	obj, err := ParseResourceFromFile("../example/files/schedule.yaml")
+	assert.NoError(t, err)
+	obj.GetMeta().Name = "invalid"
+	obj.(*cmdb.Schedule).Spec.Schedule = "0 25 * * *"
+	_, err = cli.CreateResource(obj)
+	assert.IsType(t, cmdb.ResourceValidateError{}, err)
+}
+
+func TestDeploymentApproval(t *testing.T) {
+	clearDb()
+	defer clearDb()
+	TestCreateResource(t)
+	ts, apiUrl := testServer()
+	defer ts.Close()
+
+	namespace := "test"
+	name := "go-app"
+	obj, err := ParseResourceFromFile("../example/files/namespace.yaml")
+	assert.NoError(t, err)
+	apiv1.UserTokens = map[string]string{}
+	for _, user := range []string{"alice", "bob", "carol", "dave"} {
+		apiv1.UserTokens[user+"-token"] = user
+	}
+	defer func() { apiv1.UserTokens = map[string]string{} }()
+	clientOf := func(user string) *CMDBClient {
+		cli := NewCMDBClient(apiUrl)
+		cli.Token = user + "-token"
+		return cli
+	}
+	// 审批策略只能由认证的用户修改
+	required := true
+	obj.(*cmdb.Namespace).Spec.Approval = &cmdb.NamespaceApproval{Required: &required, Approvers: []string{"bob", "carol"}, MinApprovals: 2}
+	_, err = NewCMDBClient(apiUrl).UpdateResource(obj)
+	assert.Equal(t, 401, err.(cmdb.ServerError).StatusCode)
+	_, err = clientOf("alice").UpdateResource(obj)
+	assert.NoError(t, err)
+	obj.(*cmdb.Namespace).Spec.BizEnv = "dev"
+	obj.(*cmdb.Namespace).Spec.Approval = nil
+	_, err = NewCMDBClient(apiUrl).UpdateResource(obj)
+	assert.Equal(t, 401, err.(cmdb.ServerError).StatusCode)
+
+	// 匿名请求不可发起需要审批的部署
+	_, err = NewCMDBClient(apiUrl).RunAppDeployment(deployment.DeployRelease, name, namespace, map[string]any{"image_tag": "v1"})
+	assert.Equal(t, 401, err.(cmdb.ServerError).StatusCode)
+	appDeployStatus := func() any {
+		appDeploy, err := clientOf("alice").ReadResource(cmdb.NewAppDeployment(), name, namespace, 0)
+		assert.NoError(t, err)
+		return appDeploy["status"]
+	}
+
+	// 受保护的命名空间创建待审批的 DeploymentRequest
+	result, err := clientOf("alice").RunAppDeployment(deployment.DeployRelease, name, namespace, map[string]any{"image_tag": "v1"})
+	assert.NoError(t, err)
+	assert.Equal(t, "DeploymentRequest", result["kind"])
+	assert.Equal(t, "alice", conversion.GetMapValueByPath(result, "spec.requester"))
+	assert.Equal(t, string(cmdb.DeploymentRequestPending), conversion.GetMapValueByPath(result, "status.phase"))
+	assert.Equal(t, string(cmdb.AppDeploymentNoneDeployed), appDeployStatus())
+	requestName := conversion.GetMapValueByPath(result, "metadata.name").(string)
+
+	// 申请人及非审批人不可审批
+	for _, user := range []string{"alice", "dave"} {
+		_, err = clientOf(user).ApproveDeploymentRequest(requestName, namespace, "")
+		assert.IsType(t, cmdb.ServerError{}, err)
+		assert.Equal(t, 403, err.(cmdb.ServerError).StatusCode)
+	}
+	result, err = clientOf("bob").ApproveDeploymentRequest(requestName, namespace, "lgtm")
+	assert.NoError(t, err)
+	assert.Equal(t, string(cmdb.DeploymentRequestPending), conversion.GetMapValueByPath(result, "status.phase"))
+	_, err = clientOf("bob").ApproveDeploymentRequest(requestName, namespace, "")
+	assert.Error(t, err)
+	assert.Equal(t, string(cmdb.AppDeploymentNoneDeployed), appDeployStatus())
+
+	// 匿名及令牌无效的请求不可审批
+	_, err = NewCMDBClient(apiUrl).ApproveDeploymentRequest(requestName, namespace, "")
+	assert.Equal(t, 401, err.(cmdb.ServerError).StatusCode)
+	_, err = clientOf("mallory").ApproveDeploymentRequest(requestName, namespace, "")
+	assert.Equal(t, 401, err.(cmdb.ServerError).StatusCode)
+
+	// 审批状态不可通过通用接口修改
+	request := cmdb.NewDeploymentRequest()
+	readRequest := func() map[string]any {
+		result, err := clientOf("alice").ReadResource(cmdb.NewDeploymentRequest(), requestName, namespace, 0)
+		assert.NoError(t, err)
+		return result
+	}
+	data, err := json.Marshal(readRequest())
+	assert.NoError(t, err)
+	assert.NoError(t, json.Unmarshal(data, request))
+	request.Status.Phase = cmdb.DeploymentRequestApproved
+	_, err = clientOf("alice").UpdateResource(request)
+	assert.Equal(t, 403, err.(cmdb.ServerError).StatusCode)
+	// 申请内容在创建后不可修改
+	approvedRequest := *request
+	approvedRequest.Status.Phase = cmdb.DeploymentRequestPending
+	approvedRequest.Spec.Params = map[string]any{"image_tag": "v2"}
+	_, err = clientOf("alice").UpdateResource(&approvedRequest)
+	assert.Equal(t, 403, err.(cmdb.ServerError).StatusCode)
+	request.Metadata.Name = "forged"
+	request.Status = cmdb.DeploymentRequestStatus{}
+	request.Spec.Requester = ""
+	_, err = NewCMDBClient(apiUrl).CreateResource(request)
+	assert.Equal(t, 401, err.(cmdb.ServerError).StatusCode)
+	request.Spec.Requester = "alice"
+	_, err = clientOf("alice").CreateResource(request)
+	assert.NoError(t, err)
+	request.Metadata.Name = "forged-requester"
+	request.Spec.Requester = "bob"
+	_, err = clientOf("alice").CreateResource(request)
+	assert.Equal(t, 403, err.(cmdb.ServerError).StatusCode)
+
+	// 达到审批人数后以申请人身份在后台执行部署
+	result, err = clientOf("carol").ApproveDeploymentRequest(requestName, namespace, "")
+	assert.NoError(t, err)
+	assert.Equal(t, string(cmdb.DeploymentRequestApproved), conversion.GetMapValueByPath(result, "status.phase"))
+	assert.Equal(t, 2, len(conversion.GetMapValueByPath(result, "status.approvals").([]any)))
+	assert.Eventually(t, func() bool {
+		return conversion.GetMapValueByPath(readRequest(), "status.phase") == string(cmdb.DeploymentRequestDeployed)
+	}, 5*time.Second, 50*time.Millisecond)
+	assert.Equal(t, string(cmdb.AppDeploymentDeploying), appDeployStatus())
+	lock, err := clientOf("alice").GetDeployLock(name, namespace)
+	assert.NoError(t, err)
+	assert.Contains(t, lock["owner"], "alice@")
+	_, err = clientOf("carol").RejectDeploymentRequest(requestName, namespace, "")
+	assert.Error(t, err)
+
+	// 申请人撤销
+	result, err = clientOf("alice").RunAppDeployment(deployment.DeployRestart, name, namespace, nil)
+	assert.NoError(t, err)
+	requestName = conversion.GetMapValueByPath(result, "metadata.name").(string)
+	result, err = clientOf("alice").RejectDeploymentRequest(requestName, namespace, "cancel")
+	assert.NoError(t, err)
+	assert.Equal(t, string(cmdb.DeploymentRequestRejected), conversion.GetMapValueByPath(result, "status.phase"))
+
+	_, err = clientOf("bob").ApproveDeploymentRequest("not-exist", namespace, "")
+	assert.IsType(t, cmdb.ResourceNotFoundError{}, err)
+}
+
+func TestNotifier(t *testing.T) {
+	clearDb()
+	defer clearDb()
+	TestCreateResource(t)
+	ts, apiUrl := testServer()
+	defer ts.Close()
+	deployment.NotifyRetryInterval = 10 * time.Millisecond
+	defer func() {
+		deployment.NotifyRetryInterval = 5 * time.Second
+	}()
+
+	// 首次请求失败，重试后成功
+	var mu sync.Mutex
+	var bodies []map[string]any
+	requests := 0
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		requests++
+		if requests == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		var body map[string]any
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		assert.Equal(t, "token", r.Header.Get("X-Token"))
+		bodies = append(bodies, body)
+	}))
+	defer webhook.Close()
+
+	namespace := "test"
+	name := "go-app"
+	cli := NewCMDBClient(apiUrl)
+	obj, err := ParseResourceFromFile("../example/files/notifier.yaml")
+	assert.NoError(t, err)
+	// 其他用例中示例 Notifier 的投递可能仍在重试，使用新的 Notifier
+	assert.NoError(t, cli.DeleteResource(cmdb.NewNotifier(), "deploy-chat", ""))
+	notifier := obj.(*cmdb.Notifier)
+	notifier.Metadata.Name = "test-chat"
+	notifier.Spec.Webhook.Url = webhook.URL
+	notifier.Spec.Webhook.Headers = map[string]string{"X-Token": "token"}
+	notifier.Spec.Template = `{"text": "${ namespace }/${ appDeployment } ${ action } ${ type }", "status": "${ status }"}`
+	_, err = cli.CreateResource(notifier)
+	assert.NoError(t, err)
+	// 不匹配的 Notifier 不投递
+	notifier.Metadata.Name = "prod-chat"
+	notifier.Spec.Filter.Namespaces = []string{"prod"}
+	_, err = cli.CreateResource(notifier)
+	assert.NoError(t, err)
+
+	deliveries := func(name string) []any {
+		result, err := cli.ReadResource(cmdb.NewNotifier(), name, "", 0)
+		assert.NoError(t, err)
+		d, _ := conversion.GetMapValueByPath(result, "status.deliveries").([]any)
+		return d
+	}
+
+	_, err = cli.RunAppDeployment(deployment.DeployRelease, name, namespace, nil)
+	assert.NoError(t, err)
+	assert.Eventually(t, func() bool { return len(deliveries("test-chat")) == 1 }, 3*time.Second, 20*time.Millisecond)
+	delivery := deliveries("test-chat")[0].(map[string]any)
+	assert.Equal(t, "started", delivery["event"])
+	assert.Equal(t, true, delivery["succeeded"])
+	assert.Equal(t, float64(2), delivery["attempts"])
+
+	// 部署完成后通知结果
+	insts, err := cli.ListResource(cmdb.NewAppInstance(), &ListOptions{Namespace: namespace, Selector: map[string]string{"appDeployment": name}})
+	assert.NoError(t, err)
+	for _, inst := range insts {
+		if conversion.GetMapValueByPath(inst, "status.flowRunStatus") != string(cmdb.FlowRunRunning) {
+			continue
+		}
+		_, err = cli.UpdateAppInstanceStatus(conversion.GetMapValueByPath(inst, "metadata.name").(string), namespace, cmdb.FlowRunCompleted)
+		assert.NoError(t, err)
+	}
+	assert.Eventually(t, func() bool { return len(deliveries("test-chat")) == 2 }, 3*time.Second, 20*time.Millisecond)
+	assert.Equal(t, "succeeded", deliveries("test-chat")[0].(map[string]any)["event"])
+
+	mu.Lock()
+	assert.Equal(t, []map[string]any{
+		{"text": "test/go-app release started", "status": "deploying"},
+		{"text": "test/go-app  succeeded", "status": "deployed"},
+	}, bodies)
+	mu.Unlock()
+	assert.Empty(t, deliveries("prod-chat"))
+}
+
+func TestDeployPlan(t *testing.T) {
+	clearDb()
+	defer clearDb()
+	TestCreateResource(t)
+	ts, apiUrl := testServer()
+	defer ts.Close()
+
+	namespace := "test"
+	cli := NewCMDBClient(apiUrl)
+	selector := map[string]string{"tier": "shop"}
+	newAppDeployment := func(name string, dependsOn ...string) *cmdb.AppDeployment {
+		obj, err := ParseResourceFromFile("../example/files/appdeployment.yaml")
+		assert.NoError(t, err)
+		appDeploy := obj.(*cmdb.AppDeployment)
+		appDeploy.Metadata.Name = name
+		appDeploy.Metadata.Labels = selector
+		appDeploy.Spec.DependsOn = dependsOn
+		return appDeploy
+	}
+	for _, appDeploy := range []*cmdb.AppDeployment{
+		newAppDeployment("config"),
+		newAppDeployment("api", "config"),
+		newAppDeployment("web", "api"),
+		newAppDeployment("worker"),
+	} {
+		_, err := cli.CreateResource(appDeploy)
+		assert.NoError(t, err)
+	}
+	itemsOf := func(plan map[string]any) map[string]any {
+		items := map[string]any{}
+		for _, item := range conversion.GetMapValueByPath(plan, "status.items").([]any) {
+			item := item.(map[string]any)
+			items[item["appDeployment"].(string)] = item["phase"]
+		}
+		return items
+	}
+	setInstancesStatus := func(name string, status cmdb.FlowRunStatus) {
+		insts, err := cli.ListResource(cmdb.NewAppInstance(), &ListOptions{Namespace: namespace, Selector: map[string]string{"appDeployment": name}})
+		assert.NoError(t, err)
+		assert.Less(t, 0, len(insts))
+		for _, inst := range insts {
+			instName := conversion.GetMapValueByPath(inst, "metadata.name").(string)
+			_, err = cli.UpdateAppInstanceStatus(instName, namespace, status)
+			assert.NoError(t, err)
+		}
+	}
+
+	// dryRun 仅返回部署顺序
+	plan, err := cli.RunDeployPlan(deployment.DeployRelease, namespace, selector, nil, true)
+	assert.NoError(t, err)
+	order := []string{}
+	for _, item := range conversion.GetMapValueByPath(plan, "status.items").([]any) {
+		order = append(order, item.(map[string]any)["appDeployment"].(string))
+	}
+	assert.Equal(t, []string{"config", "worker", "api", "web"}, order)
+	plans, err := cli.ListResource(cmdb.NewDeployPlan(), &ListOptions{Namespace: namespace})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(plans))
+
+	// 先部署没有依赖的 AppDeployment，依赖成功后部署下游
+	plan, err = cli.RunDeployPlan(deployment.DeployRelease, namespace, selector, map[string]any{"image_tag": "v1"}, false)
+	assert.NoError(t, err)
+	planName := conversion.GetMapValueByPath(plan, "metadata.name").(string)
+	assert.Equal(t, map[string]any{"config": "deploying", "worker": "deploying", "api": "pending", "web": "pending"}, itemsOf(plan))
+	readPlan := func() map[string]any {
+		plan, err := cli.ReadResource(cmdb.NewDeployPlan(), planName, namespace, 0)
+		assert.NoError(t, err)
+		return plan
+	}
+	setInstancesStatus("config", cmdb.FlowRunCompleted)
+	assert.Equal(t, map[string]any{"config": "succeeded", "worker": "deploying", "api": "deploying", "web": "pending"}, itemsOf(readPlan()))
+
+	// 依赖失败时不再部署下游，不影响无关的 AppDeployment
+	setInstancesStatus("api", cmdb.FlowRunFailed)
+	plan = readPlan()
+	assert.Equal(t, map[string]any{"config": "succeeded", "worker": "deploying", "api": "failed", "web": "skipped"}, itemsOf(plan))
+	assert.Equal(t, string(cmdb.DeployPlanRunning), conversion.GetMapValueByPath(plan, "status.phase"))
+	setInstancesStatus("worker", cmdb.FlowRunCompleted)
+	plan = readPlan()
+	assert.Equal(t, "succeeded", itemsOf(plan)["worker"])
+	assert.Equal(t, string(cmdb.DeployPlanFailed), conversion.GetMapValueByPath(plan, "status.phase"))
+	web, err := cli.ReadResource(cmdb.NewAppDeployment(), "web", namespace, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, string(cmdb.AppDeploymentNoneDeployed), web["status"])
+
+	// 依赖存在环
+	config := newAppDeployment("config", "web")
+	_, err = cli.UpdateResource(config)
+	assert.NoError(t, err)
+	_, err = cli.RunDeployPlan(deployment.DeployRelease, namespace, selector, nil, true)
+	assert.IsType(t, cmdb.ResourceValidateError{}, err)
+
+	_, err = cli.RunDeployPlan(deployment.DeployRelease, namespace, map[string]string{"tier": "not-exist"}, nil, true)
+	assert.Error(t, err)
+	_, err = cli.RunDeployPlan(deployment.DeployPromote, namespace, selector, nil, true)
+	assert.Error(t, err)
+}
+
+func TestDeployPlanApproval(t *testing.T) {
+	clearDb()
+	defer clearDb()
+	TestCreateResource(t)
+	ts, apiUrl := testServer()
+	defer ts.Close()
+
+	namespace := "test"
+	apiv1.UserTokens = map[string]string{"alice-token": "alice", "bob-token": "bob"}
+	defer func() { apiv1.UserTokens = map[string]string{} }()
+	alice, bob := NewCMDBClient(apiUrl), NewCMDBClient(apiUrl)
+	alice.Token, bob.Token = "alice-token", "bob-token"
+	obj, err := ParseResourceFromFile("../example/files/namespace.yaml")
+	assert.NoError(t, err)
+	required := true
+	obj.(*cmdb.Namespace).Spec.Approval = &cmdb.NamespaceApproval{Required: &required}
+	_, err = alice.UpdateResource(obj)
+	assert.NoError(t, err)
+	selector := map[string]string{"tier": "shop"}
+	for _, name := range []string{"config", "api"} {
+		obj, err := ParseResourceFromFile("../example/files/appdeployment.yaml")
+		assert.NoError(t, err)
+		appDeploy := obj.(*cmdb.AppDeployment)
+		appDeploy.Metadata.Name = name
+		appDeploy.Metadata.Labels = selector
+		if name == "api" {
+			appDeploy.Spec.DependsOn = []string{"config"}
+		}
+		_, err = alice.CreateResource(appDeploy)
+		assert.NoError(t, err)
+	}
+	itemOf := func(plan map[string]any, name string) map[string]any {
+		for _, item := range conversion.GetMapValueByPath(plan, "status.items").([]any) {
+			if item := item.(map[string]any); item["appDeployment"] == name {
+				return item
+			}
+		}
+		return nil
+	}
+
+	_, err = NewCMDBClient(apiUrl).RunDeployPlan(deployment.DeployRelease, namespace, selector, nil, false)
+	assert.Equal(t, 401, err.(cmdb.ServerError).StatusCode)
+
+	// 计划项等待审批，计划保持运行
+	plan, err := alice.RunDeployPlan(deployment.DeployRelease, namespace, selector, map[string]any{"image_tag": "v1"}, false)
+	assert.NoError(t, err)
+	planName := conversion.GetMapValueByPath(plan, "metadata.name").(string)
+	readPlan := func() map[string]any {
+		plan, err := alice.ReadResource(cmdb.NewDeployPlan(), planName, namespace, 0)
+		assert.NoError(t, err)
+		return plan
+	}
+	assert.Equal(t, string(cmdb.DeployPlanRunning), conversion.GetMapValueByPath(plan, "status.phase"))
+	assert.Equal(t, string(cmdb.DeployPlanItemWaitingApproval), itemOf(plan, "config")["phase"])
+	assert.Equal(t, string(cmdb.DeployPlanItemPending), itemOf(plan, "api")["phase"])
+
+	// 审批通过后在计划内部署，完成后下游同样等待审批
+	_, err = bob.ApproveDeploymentRequest(itemOf(plan, "config")["request"].(string), namespace, "")
+	assert.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		return itemOf(readPlan(), "config")["phase"] == string(cmdb.DeployPlanItemDeploying)
+	}, 5*time.Second, 50*time.Millisecond)
+	insts, err := alice.ListResource(cmdb.NewAppInstance(), &ListOptions{Namespace: namespace, Selector: map[string]string{"appDeployment": "config"}})
+	assert.NoError(t, err)
+	for _, inst := range insts {
+		_, err = alice.UpdateAppInstanceStatus(conversion.GetMapValueByPath(inst, "metadata.name").(string), namespace, cmdb.FlowRunCompleted)
+		assert.NoError(t, err)
+	}
+	plan = readPlan()
+	assert.Equal(t, string(cmdb.DeployPlanItemSucceeded), itemOf(plan, "config")["phase"])
+	assert.Equal(t, string(cmdb.DeployPlanItemWaitingApproval), itemOf(plan, "api")["phase"])
+
+	// 拒绝后计划失败
+	_, err = bob.RejectDeploymentRequest(itemOf(plan, "api")["request"].(string), namespace, "")
+	assert.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		return conversion.GetMapValueByPath(readPlan(), "status.phase") == string(cmdb.DeployPlanFailed)
+	}, 5*time.Second, 50*time.Millisecond)
+	assert.Equal(t, string(cmdb.DeployPlanItemFailed), itemOf(readPlan(), "api")["phase"])
+}
+
+func TestPromoteAppDeployment(t *testing.T) {
+	clearDb()
+	defer clearDb()
+	TestCreateResource(t)
+	ts, apiUrl := testServer()
+	defer ts.Close()
+
+	name := "go-app"
+	cli := NewCMDBClient(apiUrl)
+	obj, err := ParseResourceFromFile("../example/files/namespace.yaml")
+	assert.NoError(t, err)
+	staging := obj.(*cmdb.Namespace)
+	staging.Metadata.Name = "staging"
+	staging.Spec.BizEnv = "staging"
+	staging.Spec.PromotionOverrides = map[string]any{"resourcerange.spec.env.ENV": "staging"}
+	_, err = cli.CreateResource(staging)
+	assert.NoError(t, err)
+	actionsOf := func(result map[string]any) map[string]any {
+		actions := map[string]any{}
+		for _, o := range result["objects"].([]any) {
+			o := o.(map[string]any)
+			actions[fmt.Sprintf("%v/%v", o["kind"], o["name"])] = o["action"]
+		}
+		return actions
+	}
+
+	// dryRun 仅返回变更
+	overrides := map[string]any{"appdeployment.spec.template.spec.env.ENV": "staging"}
+	result, err := cli.PromoteAppDeployment(name, "test", "staging", overrides, true)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]any{
+		"DeployTemplate/docker-compose-test": "create", "ResourceRange/test": "create", "AppDeployment/go-app": "create",
+	}, actionsOf(result))
+	_, err = cli.ReadResource(cmdb.NewAppDeployment(), name, "staging", 0)
+	assert.IsType(t, cmdb.ResourceNotFoundError{}, err)
+
+	_, err = cli.PromoteAppDeployment(name, "test", "staging", overrides, false)
+	assert.NoError(t, err)
+	appDeploy, err := cli.ReadResource(cmdb.NewAppDeployment(), name, "staging", 0)
+	assert.NoError(t, err)
+	assert.Equal(t, "staging", conversion.GetMapValueByPath(appDeploy, "spec.template.spec.env.ENV"))
+	assert.Equal(t, string(cmdb.AppDeploymentNoneDeployed), appDeploy["status"])
+	resourceRange, err := cli.ReadResource(cmdb.NewResourceRange(), "test", "staging", 0)
+	assert.NoError(t, err)
+	assert.Equal(t, "staging", conversion.GetMapValueByPath(resourceRange, "spec.env.ENV"))
+
+	// 再次 promote 仅更新有变更的资源，保留目标的部署状态
+	result, err = cli.PromoteAppDeployment(name, "test", "staging", overrides, true)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]any{
+		"DeployTemplate/docker-compose-test": "unchanged", "ResourceRange/test": "unchanged", "AppDeployment/go-app": "unchanged",
+	}, actionsOf(result))
+	_, err = cli.RunAppDeployment(deployment.DeployRelease, name, "staging", nil)
+	assert.NoError(t, err)
+	result, err = cli.PromoteAppDeployment(name, "test", "staging", nil, false)
+	assert.NoError(t, err)
+	assert.Equal(t, "update", actionsOf(result)["AppDeployment/go-app"])
+	appDeploy, err = cli.ReadResource(cmdb.NewAppDeployment(), name, "staging", 0)
+	assert.NoError(t, err)
+	assert.Equal(t, "test", conversion.GetMapValueByPath(appDeploy, "spec.template.spec.env.ENV"))
+	assert.Equal(t, string(cmdb.AppDeploymentDeploying), appDeploy["status"])
+
+	_, err = cli.PromoteAppDeployment(name, "test", "staging", map[string]any{"app.spec.project": "x"}, true)
+	assert.IsType(t, cmdb.ResourceValidateError{}, err)
+	_, err = cli.PromoteAppDeployment(name, "test", "staging", map[string]any{"resourcerange.spec.notExist": "x"}, true)
+	assert.IsType(t, cmdb.ResourceValidateError{}, err)
+	_, err = cli.PromoteAppDeployment(name, "test", "test", nil, true)
+	assert.IsType(t, cmdb.ResourceValidateError{}, err)
+	_, err = cli.PromoteAppDeployment(name, "test", "not-exist", nil, true)
+	assert.IsType(t, cmdb.ResourceNotFoundError{}, err)
+	_, err = cli.PromoteAppDeployment("not-exist", "test", "staging", nil, true)
+	assert.IsType(t, cmdb.ResourceNotFoundError{}, err)
+}
+
+func TestParameterSchema(t *testing.T) {
+	clearDb()
+	defer clearDb()
+	TestCreateResource(t)
+	ts, apiUrl := testServer()
+	defer ts.Close()
+
+	namespace := "test"
+	name := "go-app"
+	cli := NewCMDBClient(apiUrl)
+	obj, err := ParseResourceFromFile("../example/files/orchestration.yaml")
+	assert.NoError(t, err)
+	orch := obj.(*cmdb.Orchestration)
+	minimum := float64(1)
+	orch.Spec.ParameterSchema.Required = []string{"image_tag"}
+	orch.Spec.ParameterSchema.Properties["image_tag"].Pattern = `^v\d+`
+	orch.Spec.ParameterSchema.Properties["replicas"] = &cmdb.ParameterSchema{Type: "integer", Minimum: &minimum, Default: 2}
+	_, err = cli.UpdateResource(orch)
+	assert.NoError(t, err)
+
+	// schema 本身不合法
+	orch.Spec.ParameterSchema.Properties["replicas"].Type = "int"
+	_, err = cli.UpdateResource(orch)
+	assert.IsType(t, cmdb.ResourceValidateError{}, err)
+
+	schemas, err := cli.GetParameterSchemas(name, namespace)
+	assert.NoError(t, err)
+	assert.Equal(t, "integer", conversion.GetMapValueByPath(schemas, "orchestration.properties.replicas.type"))
+
+	// 缺少必填参数及类型错误时返回字段级错误
+	_, err = cli.RenderAppDeployment(name, namespace, map[string]any{"replicas": 0})
+	assert.IsType(t, cmdb.ResourceValidateError{}, err)
+	assert.Contains(t, err.Error(), `"field":"image_tag"`)
+	assert.Contains(t, err.Error(), `"field":"replicas"`)
+	_, err = cli.RunAppDeployment(deployment.DeployRelease, name, namespace, map[string]any{"image_tag": "latest"})
+	assert.IsType(t, cmdb.ResourceValidateError{}, err)
+	appDeploy, err := cli.ReadResource(cmdb.NewAppDeployment(), name, namespace, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, "none-deployed", appDeploy["status"])
+
+	_, err = cli.RenderAppDeployment(name, namespace, map[string]any{"image_tag": "v1.0.0"})
+	assert.NoError(t, err)
+
+	// 不允许未声明的参数时，服务端为每个节点设置的参数不参与校验
+	additional := false
+	orch.Spec.ParameterSchema.Properties["replicas"].Type = "integer"
+	orch.Spec.ParameterSchema.AdditionalProperties = &additional
+	_, err = cli.UpdateResource(orch)
+	assert.NoError(t, err)
+	_, err = cli.RenderAppDeployment(name, namespace, map[string]any{"image_tag": "v1.0.0", "unknown": 1})
+	assert.IsType(t, cmdb.ResourceValidateError{}, err)
+	_, err = cli.RunAppDeployment(deployment.DeployRelease, name, namespace, map[string]any{"image_tag": "v1.0.0"})
+	assert.NoError(t, err)
+	appDeploy, err = cli.ReadResource(cmdb.NewAppDeployment(), name, namespace, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, "deploying", appDeploy["status"])
+}
+
+func TestStrictTemplate(t *testing.T) {
+	clearDb()
+	defer clearDb()
+	TestCreateResource(t)
+	ts, apiUrl := testServer()
+	defer ts.Close()
+
+	namespace := "test"
+	name := "go-app"
+	cli := NewCMDBClient(apiUrl)
+	obj, err := ParseResourceFromFile("../example/files/deploy_template.yaml")
+	assert.NoError(t, err)
+	deployTpl := obj.(*cmdb.DeployTemplate)
+	deployTpl.Data = map[string]string{"app.env": "APP=${ spec.app }\nENV=${ spec.evn }\n"}
+	_, err = cli.UpdateResource(deployTpl)
+	assert.NoError(t, err)
+
+	// 非严格模式下未定义的变量渲染为空
+	result, err := cli.RenderDeployTemplate(name, namespace, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "APP=go-app\nENV=\n", result["data"].(map[string]any)["app.env"])
+
+	deployTpl.Spec.Strict = true
+	_, err = cli.UpdateResource(deployTpl)
+	assert.NoError(t, err)
+	_, err = cli.RenderDeployTemplate(name, namespace, nil)
+	assert.IsType(t, cmdb.ResourceValidateError{}, err)
+	var body map[string]any
+	assert.NoError(t, json.Unmarshal([]byte(err.(cmdb.ResourceValidateError).Message), &body))
+	assert.Equal(t, "deploytemplate/test/docker-compose-test", conversion.GetMapValueByPath(body, "template.template"))
+	assert.Equal(t, "spec.evn", conversion.GetMapValueByPath(body, "template.path"))
+	assert.NotZero(t, conversion.GetMapValueByPath(body, "template.line"))
+	assert.NotZero(t, conversion.GetMapValueByPath(body, "template.col"))
+
+	// 服务端开启严格模式时 AppDeployment 同样严格渲染
+	deployment.StrictTemplate = true
+	defer func() { deployment.StrictTemplate = false }()
+	_, err = cli.RenderAppDeployment(name, namespace, nil)
+	assert.Contains(t, err.Error(), `"path":"version"`)
+	params := map[string]any{"version": "v1"}
+	_, err = cli.RenderAppDeployment(name, namespace, params)
+	assert.NoError(t, err)
+	obj, err = ParseResourceFromFile("../example/files/appdeployment.yaml")
+	assert.NoError(t, err)
+	appDeploy := obj.(*cmdb.AppDeployment)
+	appDeploy.Spec.Template.Spec.Env["APP_NAME"] = "${ spec.ap }"
+	_, err = cli.UpdateResource(appDeploy)
+	assert.NoError(t, err)
+	_, err = cli.RenderAppDeployment(name, namespace, params)
+	assert.IsType(t, cmdb.ResourceValidateError{}, err)
+	assert.Contains(t, err.Error(), `"path":"spec.ap"`)
+}
+
+func TestTemplateFunctions(t *testing.T) {
+	clearDb()
+	defer clearDb()
+	TestCreateResource(t)
+	ts, apiUrl := testServer()
+	defer ts.Close()
+
+	namespace := "test"
+	name := "go-app"
+	cli := NewCMDBClient(apiUrl)
+	obj, err := ParseResourceFromFile("../example/files/deploy_template.yaml")
+	assert.NoError(t, err)
+	deployTpl := obj.(*cmdb.DeployTemplate)
+	renderData := func(data string) (string, error) {
+		deployTpl.Data = map[string]string{"app.env": data}
+		_, err := cli.UpdateResource(deployTpl)
+		assert.NoError(t, err)
+		result, err := cli.RenderDeployTemplate(name, namespace, nil)
+		if err != nil {
+			return "", err
+		}
+		return result["data"].(map[string]any)["app.env"].(string), nil
+	}
+
+	// 命名空间未允许时不可读取 Secret
+	_, err = renderData("KEY=${ secret('test', 'privateKey') }")
+	assert.IsType(t, cmdb.ServerError{}, err)
+	assert.Equal(t, 403, err.(cmdb.ServerError).StatusCode)
+
+	obj, err = ParseResourceFromFile("../example/files/namespace.yaml")
+	assert.NoError(t, err)
+	ns := obj.(*cmdb.Namespace)
+	ns.Spec.TemplateAccess = &cmdb.NamespaceTemplateAccess{Secrets: []string{"test"}}
+	_, err = cli.UpdateResource(ns)
+	assert.NoError(t, err)
+	data, err := renderData("KEY=${ secret('test', 'privateKey') | replace('\\n', '') }\n" +
+		"DC=${ lookup('Namespace', metadata.namespace).spec.datacenter }\n" +
+		"RR=${ lookup('ResourceRange', 'test').metadata.namespace }\n" +
+		"B64=${ 'abc' | b64encode }\n" +
+		"SUM=${ 'abc' | sha256 }\n" +
+		"JSON=${ {'a': 1} | to_json }\n")
+	assert.NoError(t, err)
+	assert.Equal(t, "KEY=this is a privateKey\nDC=test\nRR=test\nB64=YWJj\n"+
+		"SUM=ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad\nJSON={\"a\":1}\n", data)
+
+	_, err = renderData("KEY=${ secret('test', 'notExist') }")
+	assert.IsType(t, cmdb.ServerError{}, err)
+	assert.Contains(t, err.Error(), "has no key notExist")
+
+	// Secret 只能通过 secret() 读取，命名空间内的资源只能读取本命名空间的
+	for _, data := range []string{
+		"KEY=${ lookup('Secret', 'test') }",
+		"APP=${ lookup('AppDeployment', 'go-app', namespace='prod') }",
+		"DP=${ lookup('DeploymentRequest', 'test') }",
+	} {
+		_, err = renderData(data)
+		assert.IsType(t, cmdb.ServerError{}, err, data)
+		assert.Equal(t, 403, err.(cmdb.ServerError).StatusCode, data)
+	}
+	ns.Spec.TemplateAccess.Kinds = []string{"Secret"}
+	_, err = cli.UpdateResource(ns)
+	assert.IsType(t, cmdb.ResourceValidateError{}, err)
+}
+
+func TestTemplateLibrary(t *testing.T) {
+	clearDb()
+	defer clearDb()
+	TestCreateResource(t)
+	ts, apiUrl := testServer()
+	defer ts.Close()
+
+	namespace := "test"
+	name := "go-app"
+	cli := NewCMDBClient(apiUrl)
+	common := cmdb.NewTemplateLibrary()
+	common.Metadata.Name = "common"
+	common.Metadata.Namespace = namespace
+	common.Data = map[string]string{
+		"env":    "ENV=${ metadata.namespace }",
+		"macros": "{% macro kv(k, v) %}${ k }=${ v }{% endmacro %}",
+	}
+	_, err := cli.CreateResource(common)
+	assert.NoError(t, err)
+	lib := cmdb.NewTemplateLibrary()
+	lib.Metadata.Name = "app"
+	lib.Metadata.Namespace = namespace
+	lib.Spec.Libraries = []string{"common"}
+	lib.Data = map[string]string{"env": "{% include 'common/env' %} {% include 'app/name' %}", "name": "APP=go"}
+	_, err = cli.CreateResource(lib)
+	assert.NoError(t, err)
+
+	obj, err := ParseResourceFromFile("../example/files/deploy_template.yaml")
+	assert.NoError(t, err)
+	deployTpl := obj.(*cmdb.DeployTemplate)
+	renderData := func(data string) (string, error) {
+		deployTpl.Data = map[string]string{"app.env": data}
+		if _, err := cli.UpdateResource(deployTpl); err != nil {
+			return "", err
+		}
+		result, err := cli.RenderDeployTemplate(name, namespace, nil)
+		if err != nil {
+			return "", err
+		}
+		return result["data"].(map[string]any)["app.env"].(string), nil
+	}
+
+	// 未声明的 TemplateLibrary 不可引用
+	_, err = renderData("{% include 'app/env' %}")
+	assert.IsType(t, cmdb.ResourceValidateError{}, err)
+	_, err = renderData("{% include 'env' %}")
+	assert.IsType(t, cmdb.ResourceValidateError{}, err)
+
+	deployTpl.Spec.Libraries = []string{"app", "common"}
+	data, err := renderData("{% import 'common/macros' as m %}{% include 'app/env' %} ${ m.kv('PORT', 8080) }")
+	assert.NoError(t, err)
+	assert.Equal(t, "ENV=test APP=go PORT=8080", data)
+
+	_, err = renderData("{% include 'app/notExist' %}")
+	assert.IsType(t, cmdb.ResourceValidateError{}, err)
+	assert.Contains(t, err.Error(), "has no data notExist")
+
+	// 被引用的 TemplateLibrary 不可删除
+	err = cli.DeleteResource(cmdb.NewTemplateLibrary(), "common", namespace)
+	assert.IsType(t, cmdb.ResourceReferencedError{}, err)
+
+	// 循环引用
+	lib.Data["name"] = "{% include 'app/env' %}"
+	_, err = cli.UpdateResource(lib)
+	assert.NoError(t, err)
+	_, err = renderData("{% include 'app/env' %}")
+	assert.IsType(t, cmdb.ResourceValidateError{}, err)
+	assert.Contains(t, err.Error(), "template include cycle")
+}
+
+func TestTraceAppDeployment(t *testing.T) {
+	clearDb()
+	defer clearDb()
+	TestCreateResource(t)
+	ts, apiUrl := testServer()
+	defer ts.Close()
+
+	cli := NewCMDBClient(apiUrl)
+	result, err := cli.TraceAppDeployment("go-app", "test", map[string]any{"image_tag": "v1"})
+	assert.NoError(t, err)
+	assert.Equal(t, "go-app", result["appDeployment"].(map[string]any)["metadata"].(map[string]any)["name"])
+	fields := map[string]map[string]any{}
+	for _, f := range result["fields"].([]any) {
+		field := f.(map[string]any)
+		fields[field["path"].(string)] = field
+	}
+
+	// AppDeployment 的值优先，ResourceRange 的冲突值被丢弃
+	command := fields["spec.template.spec.command[0]"]
+	assert.Equal(t, "python", command["value"])
+	assert.Equal(t, "AppDeployment/test/go-app", command["source"])
+	assert.Equal(t, "spec.template.spec.command[0]", command["sourcePath"])
+	assert.Equal(t, []any{map[string]any{
+		"source": "ResourceRange/test/test", "sourcePath": "spec.command", "value": []any{"java"},
+	}}, command["dropped"])
+
+	// 来自 ResourceRange 的模板表达式，引用了 ContainerRegistry 的字段
+	registry := fields["spec.template.deployTemplate.values.image_registry"]
+	assert.Equal(t, "ResourceRange/test/test", registry["source"])
+	assert.Equal(t, "deployTemplate.values.image_registry", registry["sourcePath"])
+	assert.Equal(t, "${ spec.deployPlatform.docker.containerRegistry.spec.registry }", registry["expression"])
+	assert.Equal(t, []any{map[string]any{
+		"variable":   "spec.deployPlatform.docker.containerRegistry.spec.registry",
+		"source":     "ContainerRegistry/harbor-test",
+		"sourcePath": "spec.registry",
+	}}, registry["inputs"])
+
+	image := fields["spec.template.deployTemplate.values.image"]
+	assert.Contains(t, image["inputs"], map[string]any{"variable": "image_tag", "source": "params", "sourcePath": "image_tag"})
+
+	// 模板渲染出的 map，来源为上级字段的表达式
+	env := fields["spec.template.deployTemplate.values.env.ENV"]
+	assert.Equal(t, "test", env["value"])
+	assert.Equal(t, "${ spec.env }", env["expression"])
+}
+
+func TestMergeStrategies(t *testing.T) {
+	clearDb()
+	defer clearDb()
+	TestCreateResource(t)
+	ts, apiUrl := testServer()
+	defer ts.Close()
+
+	namespace := "test"
+	name := "go-app"
+	cli := NewCMDBClient(apiUrl)
+	conflicts, err := cli.RenderConflicts(name, namespace, nil)
+	assert.NoError(t, err)
+	assert.Contains(t, conflicts, map[string]any{
+		"path": "spec.command", "strategy": "keep", "kept": []any{"python"}, "dropped": []any{"java"},
+	})
+
+	obj, err := ParseResourceFromFile("../example/files/resource_range.yaml")
+	assert.NoError(t, err)
+	rr := obj.(*cmdb.ResourceRange)
+	rr.Metadata.Annotations = map[string]string{
+		"merge.cmdb/spec.command": "override",
+		"merge.cmdb/spec.args":    "append",
+	}
+	_, err = cli.UpdateResource(rr)
+	assert.NoError(t, err)
+	obj, err = ParseResourceFromFile("../example/files/appdeployment.yaml")
+	assert.NoError(t, err)
+	appDeploy := obj.(*cmdb.AppDeployment)
+	appDeploy.Metadata.Annotations = map[string]string{"merge.cmdb/spec.env.JAVA_OPTS": "delete"}
+	_, err = cli.UpdateResource(appDeploy)
+	assert.NoError(t, err)
+
+	result, err := cli.RenderAppDeployment(name, namespace, nil)
+	assert.NoError(t, err)
+	spec := result["spec"].(map[string]any)["template"].(map[string]any)["spec"].(map[string]any)
+	assert.Equal(t, []any{"java"}, spec["command"])
+	assert.Equal(t, []any{"autoapp.py", "test", "-XX:+HeapDumpBeforeFullGC", "-XX:+HeapDumpAfterFullGC"}, spec["args"])
+	assert.NotContains(t, spec["env"], "JAVA_OPTS")
+	assert.Contains(t, spec["env"], "EMPTY_ARG")
+	conflicts, err = cli.RenderConflicts(name, namespace, nil)
+	assert.NoError(t, err)
+	assert.Contains(t, conflicts, map[string]any{
+		"path": "spec.command", "strategy": "override", "kept": []any{"java"}, "dropped": []any{"python"},
+	})
+
+	// AppDeployment 的注解优先，合并策略不合法时报错
+	appDeploy.Metadata.Annotations["merge.cmdb/spec.command"] = "invalid"
+	_, err = cli.UpdateResource(appDeploy)
+	assert.NoError(t, err)
+	_, err = cli.RenderAppDeployment(name, namespace, nil)
+	assert.IsType(t, cmdb.ResourceValidateError{}, err)
+	assert.Contains(t, err.Error(), "invalid merge strategy")
+}
@@ -8,6 +8,8 @@ func NewCMDBClient(apiUrl string) *CMDBClient {
 
 type CMDBClient struct {
 	ApiUrl string
+	// 访问令牌，为空时使用配置的 CMDB_TOKEN
+	Token string
 }
 
 type ListOptions struct {
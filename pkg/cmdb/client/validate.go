@@ -0,0 +1,201 @@
+package client
+
+import (
+	"fmt"
+	"gcmdb/pkg/cmdb"
+	"gcmdb/pkg/cmdb/conversion"
+	"gcmdb/pkg/cmdb/runtime"
+	"maps"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+
+	"github.com/goccy/go-yaml"
+)
+
+// 离线校验的检查项
+const (
+	ValidateCheckParse     = "parse"
+	ValidateCheckSchema    = "schema"
+	ValidateCheckNaming    = "naming"
+	ValidateCheckReference = "reference"
+	ValidateCheckTemplate  = "template"
+)
+
+type ValidationError struct {
+	Check   string `json:"check"`
+	Message string `json:"message"`
+}
+
+// 文件中对象的校验结果，文件无法解析时 kind、name 为空
+type ValidationResult struct {
+	File      string            `json:"file"`
+	Kind      string            `json:"kind,omitempty"`
+	Name      string            `json:"name,omitempty"`
+	Namespace string            `json:"namespace,omitempty"`
+	Errors    []ValidationError `json:"errors"`
+}
+
+func (r *ValidationResult) addError(check, format string, args ...any) {
+	r.Errors = append(r.Errors, ValidationError{Check: check, Message: fmt.Sprintf(format, args...)})
+}
+
+// 查询文件集合之外的引用对象是否存在，为 nil 时引用须在文件集合内
+type ReferenceResolver func(kind, name, namespace string) (bool, error)
+
+// 待校验的资源文件，目录下仅包含 yaml、yml、json 文件
+func ValidationFiles(path string) ([]string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return []string{path}, nil
+	}
+	var files []string
+	err = filepath.Walk(path, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() && slices.Contains([]string{".yaml", ".yml", ".json"}, strings.ToLower(filepath.Ext(p))) {
+			files = append(files, p)
+		}
+		return nil
+	})
+	return files, err
+}
+
+// 离线校验资源文件：字段、命名规范、模板语法，以及引用的对象是否在文件集合内或由 resolver 查到
+func ValidateFiles(files []string, resolver ReferenceResolver) []ValidationResult {
+	results := make([]ValidationResult, len(files))
+	objs := make([]cmdb.Object, len(files))
+	for i, file := range files {
+		results[i] = ValidationResult{File: file, Errors: []ValidationError{}}
+		objs[i] = parseValidationFile(&results[i])
+	}
+	for i, obj := range objs {
+		if obj == nil {
+			continue
+		}
+		result := &results[i]
+		validateNaming(result, obj, objs[:i])
+		validateReferences(result, obj, objs, resolver)
+		validateTemplates(result, obj)
+	}
+	return results
+}
+
+func parseValidationFile(result *ValidationResult) cmdb.Object {
+	byts, err := os.ReadFile(result.File)
+	if err != nil {
+		result.addError(ValidateCheckParse, "%s", err.Error())
+		return nil
+	}
+	var m map[string]any
+	if err = yaml.Unmarshal(byts, &m); err != nil {
+		result.addError(ValidateCheckParse, "%s", err.Error())
+		return nil
+	}
+	if kind, ok := m["kind"].(string); !ok || kind == "" {
+		result.addError(ValidateCheckParse, "kind is required")
+		return nil
+	}
+	obj, err := conversion.DecodeObject(byts)
+	if err != nil {
+		result.addError(ValidateCheckParse, "%s", err.Error())
+		return nil
+	}
+	meta := obj.GetMeta()
+	result.Kind, result.Name, result.Namespace = obj.GetKind(), meta.Name, meta.Namespace
+	if err = runtime.ValidateObject(obj); err != nil {
+		for _, line := range strings.Split(err.Error(), "\n") {
+			result.addError(ValidateCheckSchema, "%s", line)
+		}
+	}
+	return obj
+}
+
+// apiVersion、命名空间与资源类型一致，且文件集合内没有重复的对象
+func validateNaming(result *ValidationResult, obj cmdb.Object, previous []cmdb.Object) {
+	meta := obj.GetMeta()
+	if v := reflect.Indirect(reflect.ValueOf(obj)).FieldByName("APIVersion").String(); v != cmdb.APIVersion {
+		result.addError(ValidateCheckNaming, "apiVersion must be %s, got %q", cmdb.APIVersion, v)
+	}
+	if meta.HasNamespace() && meta.Namespace == "" {
+		result.addError(ValidateCheckNaming, "%s is namespaced, metadata.namespace is required", obj.GetKind())
+	}
+	if !meta.HasNamespace() && meta.Namespace != "" {
+		result.addError(ValidateCheckNaming, "%s is not namespaced, metadata.namespace must be empty", obj.GetKind())
+	}
+	for _, o := range previous {
+		if o != nil && o.GetKind() == obj.GetKind() && o.GetMeta().Name == meta.Name && o.GetMeta().Namespace == meta.Namespace {
+			result.addError(ValidateCheckNaming, "duplicate %s %s", obj.GetKind(), objectKey(meta.Namespace, meta.Name))
+		}
+	}
+}
+
+// 引用的对象须在文件集合内，或由 resolver 查到
+func validateReferences(result *ValidationResult, obj cmdb.Object, objs []cmdb.Object, resolver ReferenceResolver) {
+	meta := obj.GetMeta()
+	for _, ref := range runtime.GetFieldValueByTag(reflect.ValueOf(obj), "", "reference") {
+		// 渲染时才确定的引用无法离线校验
+		if strings.Contains(ref.FieldValue, "${") {
+			continue
+		}
+		refObj, err := cmdb.NewResourceWithKind(ref.TagValue)
+		if err != nil {
+			result.addError(ValidateCheckReference, "%s", err.Error())
+			continue
+		}
+		namespace := ""
+		if refObj.GetMeta().HasNamespace() {
+			namespace = meta.Namespace
+		}
+		if slices.ContainsFunc(objs, func(o cmdb.Object) bool {
+			return o != nil && o.GetKind() == ref.TagValue && o.GetMeta().Name == ref.FieldValue && o.GetMeta().Namespace == namespace
+		}) {
+			continue
+		}
+		if resolver == nil {
+			result.addError(ValidateCheckReference, "%s: %s %s not found in files", ref.FieldPath, ref.TagValue, objectKey(namespace, ref.FieldValue))
+			continue
+		}
+		found, err := resolver(ref.TagValue, ref.FieldValue, namespace)
+		if err != nil {
+			result.addError(ValidateCheckReference, "%s: %s", ref.FieldPath, err.Error())
+		} else if !found {
+			result.addError(ValidateCheckReference, "%s: %s %s not found in files or on server", ref.FieldPath, ref.TagValue, objectKey(namespace, ref.FieldValue))
+		}
+	}
+}
+
+// 与渲染时一致，AppDeployment、ResourceRange、DeployTemplate 整体作为模板，TemplateLibrary 逐个片段解析
+func validateTemplates(result *ValidationResult, obj cmdb.Object) {
+	name := fmt.Sprintf("%s/%s", strings.ToLower(obj.GetKind()), objectKey(obj.GetMeta().Namespace, obj.GetMeta().Name))
+	switch o := obj.(type) {
+	case *cmdb.AppDeployment, *cmdb.ResourceRange, *cmdb.DeployTemplate:
+		byts, err := yaml.MarshalWithOptions(o, yaml.AutoInt(), yaml.UseLiteralStyleIfMultiline(true))
+		if err != nil {
+			result.addError(ValidateCheckTemplate, "%s", err.Error())
+			return
+		}
+		if err = runtime.ParseTemplate(name, string(byts)); err != nil {
+			result.addError(ValidateCheckTemplate, "%s", err.Error())
+		}
+	case *cmdb.TemplateLibrary:
+		for _, key := range slices.Sorted(maps.Keys(o.Data)) {
+			if err := runtime.ParseTemplate(o.Metadata.Name+"/"+key, o.Data[key]); err != nil {
+				result.addError(ValidateCheckTemplate, "%s", err.Error())
+			}
+		}
+	}
+}
+
+func objectKey(namespace, name string) string {
+	if namespace == "" {
+		return name
+	}
+	return namespace + "/" + name
+}
@@ -0,0 +1,89 @@
+package client
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeValidationFiles(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	for name, content := range files {
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644))
+	}
+	return dir
+}
+
+func TestValidateExampleFiles(t *testing.T) {
+	files, err := ValidationFiles("../example/files")
+	assert.NoError(t, err)
+	for _, r := range ValidateFiles(files, nil) {
+		assert.Empty(t, r.Errors, r.File)
+	}
+}
+
+func TestValidateFiles(t *testing.T) {
+	dir := writeValidationFiles(t, map[string]string{
+		"README.md": "not a resource",
+		"bad.yaml":  "kind: [",
+		"nokind.yaml": `apiVersion: v1alpha
+metadata:
+  name: test`,
+		"lib.yaml": `apiVersion: v1alpha
+kind: TemplateLibrary
+metadata:
+  name: lib
+  namespace: test
+data:
+  broken: "{% if a %}"`,
+		"tpl.yaml": `apiVersion: v1
+kind: DeployTemplate
+metadata:
+  name: tpl
+spec:
+  command: [docker-compose]
+  libraries: [lib]
+data:
+  a: "{% include 'lib/broken' %}"`,
+		"tpl2.yaml": `apiVersion: v1alpha
+kind: DeployTemplate
+metadata:
+  name: tpl
+spec:
+  command: [docker-compose]
+data:
+  a: "${ a "`,
+	})
+	files, err := ValidationFiles(dir)
+	assert.NoError(t, err)
+	assert.Len(t, files, 5)
+	results := map[string]ValidationResult{}
+	for _, r := range ValidateFiles(files, nil) {
+		results[filepath.Base(r.File)] = r
+	}
+	checks := func(name string) []string {
+		checks := []string{}
+		for _, e := range results[name].Errors {
+			checks = append(checks, e.Check)
+		}
+		return checks
+	}
+	assert.Equal(t, []string{ValidateCheckParse}, checks("bad.yaml"))
+	assert.Equal(t, []string{ValidateCheckParse}, checks("nokind.yaml"))
+	// 引用的 Namespace 不在文件集合内，片段存在语法错误
+	assert.Equal(t, []string{ValidateCheckReference, ValidateCheckTemplate}, checks("lib.yaml"))
+	// apiVersion 错误、缺少 namespace，因此引用的 TemplateLibrary 也不在同一命名空间；两个 DeployTemplate 重名
+	assert.Equal(t, []string{ValidateCheckNaming, ValidateCheckNaming, ValidateCheckReference}, checks("tpl.yaml"))
+	assert.Equal(t, []string{ValidateCheckNaming, ValidateCheckNaming, ValidateCheckTemplate}, checks("tpl2.yaml"))
+	assert.Equal(t, "DeployTemplate", results["tpl2.yaml"].Kind)
+
+	// 文件集合之外的引用由 resolver 查询
+	lib := filepath.Join(dir, "lib.yaml")
+	results2 := ValidateFiles([]string{lib}, func(kind, name, namespace string) (bool, error) {
+		return kind == "Namespace" && name == "test", nil
+	})
+	assert.Equal(t, ValidateCheckTemplate, results2[0].Errors[0].Check)
+	assert.Len(t, results2[0].Errors, 1)
+}
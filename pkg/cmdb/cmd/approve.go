@@ -0,0 +1,61 @@
+package cmd
+
+import (
+	"fmt"
+	"gcmdb/pkg/cmdb/client"
+	"gcmdb/pkg/cmdb/conversion"
+
+	"github.com/spf13/cobra"
+)
+
+var approveCmd = &cobra.Command{
+	Use:   "approve <deploymentrequest>",
+	Short: "Approve deploymentrequest",
+	Long:  "Approve deploymentrequest, the deployment runs once the required approvals are reached",
+	Args:  cobra.ExactArgs(1),
+	Run: func(c *cobra.Command, args []string) {
+		decideCmdHandle(c, args[0], true)
+	},
+}
+
+var rejectCmd = &cobra.Command{
+	Use:   "reject <deploymentrequest>",
+	Short: "Reject deploymentrequest",
+	Long:  "Reject deploymentrequest, the requester can reject own request to cancel it",
+	Args:  cobra.ExactArgs(1),
+	Run: func(c *cobra.Command, args []string) {
+		decideCmdHandle(c, args[0], false)
+	},
+}
+
+func init() {
+	for _, c := range []*cobra.Command{approveCmd, rejectCmd} {
+		c.Flags().StringP("comment", "m", "", "comment of the approval")
+		c.Flags().StringP("output", "o", "", "output format: yaml|json, print the deploymentrequest")
+		RootCmd.AddCommand(c)
+	}
+}
+
+func decideCmdHandle(c *cobra.Command, name string, approved bool) {
+	namespace, _ := c.Root().PersistentFlags().GetString("namespace")
+	if namespace == "" {
+		CheckError(fmt.Errorf("error: a namespace must be specified for DeploymentRequest"))
+	}
+	comment, _ := c.Flags().GetString("comment")
+	cli := client.DefaultCMDBClient
+	var result map[string]any
+	var err error
+	if approved {
+		result, err = cli.ApproveDeploymentRequest(name, namespace, comment)
+	} else {
+		result, err = cli.RejectDeploymentRequest(name, namespace, comment)
+	}
+	CheckError(err)
+	if output, _ := c.Flags().GetString("output"); output != "" {
+		outputResult(c, []map[string]any{result})
+		return
+	}
+	phase := conversion.GetMapValueByPath(result, "status.phase")
+	message := conversion.GetMapValueByPath(result, "status.message")
+	fmt.Printf("deploymentrequest %v %v: %v\n", name, phase, message)
+}
@@ -0,0 +1,21 @@
+package cmd
+
+import (
+	"testing"
+)
+
+func TestApproveNoNamespaced(t *testing.T) {
+	RootCmd.SetArgs([]string{"approve", "go-app-release-abcde"})
+	assertOsExit(t, Execute, 1)
+}
+
+func TestRejectNotFound(t *testing.T) {
+	ts := testServer()
+	defer ts.Close()
+
+	RootCmd.SetArgs([]string{"reject", "not-exist", "-n", "test", "-m", "not now"})
+	assertOsExit(t, Execute, 1)
+	if flag := RootCmd.PersistentFlags().Lookup("namespace"); flag != nil {
+		flag.Value.Set("")
+	}
+}
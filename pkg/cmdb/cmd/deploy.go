@@ -0,0 +1,232 @@
+package cmd
+
+import (
+	"fmt"
+	"gcmdb/pkg/cmdb"
+	"gcmdb/pkg/cmdb/client"
+	"gcmdb/pkg/cmdb/conversion"
+	"gcmdb/pkg/cmdb/deployment"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/olekukonko/tablewriter"
+	"github.com/spf13/cobra"
+)
+
+var deployCmd = &cobra.Command{
+	Use:   "deploy",
+	Short: "Deploy appdeployment",
+}
+
+var deployLockCmd = &cobra.Command{
+	Use:   "lock <name>",
+	Short: "Show the deploy lock of appdeployment",
+	Args:  cobra.ExactArgs(1),
+	Run: func(c *cobra.Command, args []string) {
+		deployLockCmdHandle(c, args[0])
+	},
+}
+
+var deployUnlockCmd = &cobra.Command{
+	Use:   "unlock <name>",
+	Short: "Release the deploy lock of appdeployment",
+	Long:  "Release the deploy lock of appdeployment, the lock is kept until the deployment finished or expired, --force is required to confirm releasing a held lock",
+	Args:  cobra.ExactArgs(1),
+	Run: func(c *cobra.Command, args []string) {
+		deployUnlockCmdHandle(c, args[0])
+	},
+}
+
+var deployReleaseCmd = &cobra.Command{
+	Use:   "release <name> | -l <selector>",
+	Short: "Release appdeployment",
+	Long:  "Release appdeployment, or release all appdeployments matched by --selector in the order of dependsOn",
+	Args:  cobra.MaximumNArgs(1),
+	Run: func(c *cobra.Command, args []string) {
+		deployCmdHandle(c, deployment.DeployRelease, args)
+	},
+}
+
+var deployRestartCmd = &cobra.Command{
+	Use:   "restart <name> | -l <selector>",
+	Short: "Restart appdeployment",
+	Long:  "Restart appdeployment, restart all hostnodes if --hostnode not specified, or restart all appdeployments matched by --selector in the order of dependsOn",
+	Args:  cobra.MaximumNArgs(1),
+	Run: func(c *cobra.Command, args []string) {
+		deployCmdHandle(c, deployment.DeployRestart, args)
+	},
+}
+
+func init() {
+	for _, c := range []*cobra.Command{deployReleaseCmd, deployRestartCmd} {
+		addParamsFlags(c.Flags())
+		c.Flags().StringP("output", "o", "", "output format: yaml|json, print the deploy result")
+		c.Flags().StringP("selector", "l", "", "label selector, deploy all matched appdeployments in the order of dependsOn")
+		c.Flags().Bool("dry-run", false, "Only print the deploy order of appdeployments matched by --selector")
+		c.Flags().BoolP("watch", "w", false, "Watch the deploy plan until all appdeployments finished")
+		c.Flags().Duration("timeout", 0, "The length of time to wait before ending watch, zero means never")
+		deployCmd.AddCommand(c)
+	}
+	deployRestartCmd.Flags().StringSlice("hostnode", []string{}, "restart the specified hostnodes only, e.g. --hostnode node1,node2")
+	deployUnlockCmd.Flags().Bool("force", false, "Confirm releasing the deploy lock held by others")
+	deployCmd.AddCommand(deployLockCmd)
+	deployCmd.AddCommand(deployUnlockCmd)
+	RootCmd.AddCommand(deployCmd)
+}
+
+func deployCmdHandle(c *cobra.Command, action deployment.DeployAction, args []string) {
+	selector, _ := c.Flags().GetString("selector")
+	switch {
+	case len(args) == 1 && selector == "":
+		deployRunCmdHandle(c, action, args[0])
+	case len(args) == 0 && selector != "":
+		deployPlanCmdHandle(c, action, selector)
+	default:
+		CheckError(fmt.Errorf("error: either <name> or --selector must be specified"))
+	}
+}
+
+func deployRunCmdHandle(c *cobra.Command, action deployment.DeployAction, name string) {
+	namespace := appDeploymentNamespace(c)
+	params := parseParamsFlags(c)
+	if hostNodes, _ := c.Flags().GetStringSlice("hostnode"); len(hostNodes) > 0 {
+		params["hostnode"] = strings.Join(hostNodes, ",")
+	}
+	cli := client.DefaultCMDBClient
+	result, err := cli.RunAppDeployment(action, name, namespace, params)
+	CheckError(err)
+	if output, _ := c.Flags().GetString("output"); output != "" {
+		outputResult(c, []map[string]any{result})
+		return
+	}
+	// 受保护的命名空间需审批后部署
+	if result["kind"] == "DeploymentRequest" {
+		requestName := conversion.GetMapValueByPath(result, "metadata.name")
+		fmt.Printf("appdeployment %v %v requires approval, deploymentrequest %v created.\n", name, action, requestName)
+		return
+	}
+	fmt.Printf("appdeployment %v %v started.\n", name, action)
+}
+
+// 按依赖顺序部署 selector 匹配的 AppDeployment
+func deployPlanCmdHandle(c *cobra.Command, action deployment.DeployAction, selector string) {
+	namespace := appDeploymentNamespace(c)
+	params := parseParamsFlags(c)
+	if hostNodes, _ := c.Flags().GetStringSlice("hostnode"); len(hostNodes) > 0 {
+		params["hostnode"] = strings.Join(hostNodes, ",")
+	}
+	dryRun, _ := c.Flags().GetBool("dry-run")
+	cli := client.DefaultCMDBClient
+	plan, err := cli.RunDeployPlan(action, namespace, conversion.ParseSelector(selector), params, dryRun)
+	CheckError(err)
+	if output, _ := c.Flags().GetString("output"); output != "" {
+		outputResult(c, []map[string]any{plan})
+		return
+	}
+	name := conversion.GetMapValueByPath(plan, "metadata.name")
+	if !dryRun {
+		fmt.Printf("deployplan %v created.\n", name)
+	}
+	printDeployPlan(plan)
+	if watch, _ := c.Flags().GetBool("watch"); watch && !dryRun {
+		watchDeployPlan(c, fmt.Sprint(name), namespace, plan)
+	}
+}
+
+// 等待部署计划结束，计划项状态变化时重新输出
+func watchDeployPlan(c *cobra.Command, name, namespace string, plan map[string]any) {
+	timeout, _ := c.Flags().GetDuration("timeout")
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+	cli := client.DefaultCMDBClient
+	last := fmt.Sprint(conversion.GetMapValueByPath(plan, "status.items"))
+	for {
+		switch conversion.GetMapValueByPath(plan, "status.phase") {
+		case string(cmdb.DeployPlanSucceeded):
+			fmt.Printf("deployplan %v succeeded.\n", name)
+			return
+		case string(cmdb.DeployPlanFailed):
+			CheckError(fmt.Errorf("error: deployplan %s failed", name))
+		}
+		if !deadline.IsZero() && time.Now().After(deadline) {
+			CheckError(fmt.Errorf("error: timed out waiting for deployplan %s", name))
+		}
+		time.Sleep(rolloutStatusInterval)
+		var err error
+		plan, err = cli.ReadResource(&cmdb.DeployPlan{}, name, namespace, 0)
+		CheckError(err)
+		if items := fmt.Sprint(conversion.GetMapValueByPath(plan, "status.items")); items != last {
+			fmt.Println()
+			printDeployPlan(plan)
+			last = items
+		}
+	}
+}
+
+func printDeployPlan(plan map[string]any) {
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"ORDER", "APPDEPLOYMENT", "AFTER", "PHASE", "MESSAGE"})
+	table.SetBorder(false)
+	table.SetColumnSeparator("")
+	table.SetHeaderLine(false)
+	table.SetAutoWrapText(false)
+	table.SetAlignment(tablewriter.ALIGN_LEFT)
+	table.SetHeaderAlignment(tablewriter.ALIGN_LEFT)
+	items, _ := conversion.GetMapValueByPath(plan, "status.items").([]any)
+	for i, item := range items {
+		item := item.(map[string]any)
+		after := []string{}
+		deps, _ := item["after"].([]any)
+		for _, dep := range deps {
+			after = append(after, fmt.Sprint(dep))
+		}
+		message := ""
+		if item["message"] != nil {
+			message = fmt.Sprint(item["message"])
+		}
+		table.Append([]string{strconv.Itoa(i + 1), fmt.Sprint(item["appDeployment"]), strings.Join(after, ","), fmt.Sprint(item["phase"]), message})
+	}
+	table.Render()
+}
+
+// 查询 AppDeployment 的部署锁，未加锁时返回 nil
+func readDeployLock(name, namespace string) map[string]any {
+	cli := client.DefaultCMDBClient
+	_, err := cli.ReadResource(cmdb.NewAppDeployment(), name, namespace, 0)
+	CheckError(err)
+	lock, err := cli.GetDeployLock(name, namespace)
+	if _, ok := err.(cmdb.ResourceNotFoundError); ok {
+		return nil
+	}
+	CheckError(err)
+	return lock
+}
+
+func deployLockCmdHandle(c *cobra.Command, name string) {
+	namespace := appDeploymentNamespace(c)
+	lock := readDeployLock(name, namespace)
+	if lock == nil {
+		fmt.Printf("appdeployment %v is not locked.\n", name)
+		return
+	}
+	fmt.Printf("appdeployment %v is locked by %v since %v, expires in %vs.\n", name, lock["owner"], lock["acquiredAt"], lock["expiresIn"])
+}
+
+func deployUnlockCmdHandle(c *cobra.Command, name string) {
+	namespace := appDeploymentNamespace(c)
+	lock := readDeployLock(name, namespace)
+	if lock == nil {
+		fmt.Printf("appdeployment %v is not locked.\n", name)
+		return
+	}
+	if force, _ := c.Flags().GetBool("force"); !force {
+		CheckError(fmt.Errorf("error: appdeployment %s is locked by %v, use --force to release it", name, lock["owner"]))
+	}
+	lock, err := client.DefaultCMDBClient.UnlockAppDeployment(name, namespace)
+	CheckError(err)
+	fmt.Printf("appdeployment %v unlocked, lock owner: %v.\n", name, lock["owner"])
+}
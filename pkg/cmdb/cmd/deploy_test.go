@@ -0,0 +1,80 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeployUnlockNoNamespaced(t *testing.T) {
+	RootCmd.SetArgs([]string{"deploy", "unlock", "go-app", "--force"})
+	assertOsExit(t, Execute, 1)
+}
+
+func TestDeployUnlockNotFound(t *testing.T) {
+	ts := testServer()
+	defer ts.Close()
+
+	RootCmd.SetArgs([]string{"deploy", "unlock", "not-exist", "-n", "test"})
+	assertOsExit(t, Execute, 1)
+	RootCmd.SetArgs([]string{"deploy", "unlock", "not-exist", "-n", "test", "--force"})
+	assertOsExit(t, Execute, 1)
+	if flag := RootCmd.PersistentFlags().Lookup("namespace"); flag != nil {
+		flag.Value.Set("")
+	}
+}
+
+func TestDeployLock(t *testing.T) {
+	ts := testServer()
+	defer ts.Close()
+	defer func() {
+		deployUnlockCmd.Flags().Lookup("force").Value.Set("false")
+		if flag := RootCmd.PersistentFlags().Lookup("namespace"); flag != nil {
+			flag.Value.Set("")
+		}
+	}()
+
+	RootCmd.SetArgs([]string{"deploy", "lock", "not-exist", "-n", "test"})
+	assertOsExit(t, Execute, 1)
+	// 未加锁时无需 --force
+	for _, args := range [][]string{
+		{"apply", "-f", "../example/files"},
+		{"deploy", "lock", "go-app", "-n", "test"},
+		{"deploy", "unlock", "go-app", "-n", "test"},
+	} {
+		RootCmd.SetArgs(args)
+		assert.NoError(t, RootCmd.Execute())
+	}
+}
+
+func TestDeployReleaseNoNamespaced(t *testing.T) {
+	RootCmd.SetArgs([]string{"deploy", "release", "go-app", "--set", "image_tag=v1"})
+	assertOsExit(t, Execute, 1)
+}
+
+func TestDeployRestartInvalidSet(t *testing.T) {
+	RootCmd.SetArgs([]string{"deploy", "restart", "go-app", "-n", "test", "--set", "image_tag", "--hostnode", "test"})
+	assertOsExit(t, Execute, 1)
+	if flag := RootCmd.PersistentFlags().Lookup("namespace"); flag != nil {
+		flag.Value.Set("")
+	}
+}
+
+func TestDeployReleaseSelector(t *testing.T) {
+	ts := testServer()
+	defer ts.Close()
+	defer func() {
+		deployReleaseCmd.Flags().Lookup("selector").Value.Set("")
+		if flag := RootCmd.PersistentFlags().Lookup("namespace"); flag != nil {
+			flag.Value.Set("")
+		}
+	}()
+
+	// 必须且只能指定 name 或 selector 之一
+	RootCmd.SetArgs([]string{"deploy", "release", "-n", "test"})
+	assertOsExit(t, Execute, 1)
+	RootCmd.SetArgs([]string{"deploy", "release", "go-app", "-n", "test", "-l", "tier=shop"})
+	assertOsExit(t, Execute, 1)
+	RootCmd.SetArgs([]string{"deploy", "release", "-n", "test", "-l", "tier=not-exist", "--dry-run"})
+	assertOsExit(t, Execute, 1)
+}
@@ -0,0 +1,127 @@
+package cmd
+
+import (
+	"fmt"
+	"gcmdb/pkg/cmdb/client"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/olekukonko/tablewriter"
+	"github.com/spf13/cobra"
+)
+
+var describeCmd = &cobra.Command{
+	Use:   "describe",
+	Short: "Show details of resources",
+}
+
+var describeAppDeploymentCmd = &cobra.Command{
+	Use:   "appdeployment <name>",
+	Short: "appdeployment",
+	Long:  "Show the deploy status of appdeployment and its current appinstances",
+	Args:  cobra.ExactArgs(1),
+	Run: func(c *cobra.Command, args []string) {
+		describeAppDeploymentCmdHandle(c, args[0])
+	},
+}
+
+func init() {
+	describeCmd.AddCommand(describeAppDeploymentCmd)
+	RootCmd.AddCommand(describeCmd)
+}
+
+func describeAppDeploymentCmdHandle(c *cobra.Command, name string) {
+	namespace := appDeploymentNamespace(c)
+	cli := client.DefaultCMDBClient
+	state, err := cli.GetAppDeploymentStatus(name, namespace)
+	CheckError(err)
+	outputAppDeploymentStatus(state)
+}
+
+func outputAppDeploymentStatus(state map[string]any) {
+	field := func(name string, value any) {
+		if value == nil || value == "" {
+			value = "<none>"
+		}
+		fmt.Printf("%-12s %v\n", name+":", value)
+	}
+	field("Name", state["name"])
+	field("Namespace", state["namespace"])
+	field("Status", state["status"])
+	field("Revision", state["deployRevision"])
+	field("Flow Run", state["flow_run_id"])
+	if rollout, ok := state["rollout"].(map[string]any); ok {
+		field("Rollout", formatRollout(rollout))
+	}
+	field("Last Error", state["lastError"])
+	field("Instances", formatCounts(state["counts"]))
+
+	instances, _ := state["instances"].([]any)
+	if len(instances) == 0 {
+		return
+	}
+	fmt.Println()
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"NAME", "TARGET", "REVISION", "BATCH", "FLOW_RUN_STATUS", "PHASE", "AGE", "LAST_RUN"})
+	table.SetBorder(false)
+	table.SetColumnSeparator("")
+	table.SetHeaderLine(false)
+	table.SetAlignment(tablewriter.ALIGN_LEFT)
+	table.SetHeaderAlignment(tablewriter.ALIGN_LEFT)
+	for _, inst := range instances {
+		inst := inst.(map[string]any)
+		batch := ""
+		if b, ok := inst["batch"]; ok {
+			batch = fmt.Sprint(b)
+		}
+		table.Append([]string{
+			fmt.Sprint(inst["name"]),
+			fmt.Sprint(inst["target"]),
+			fmt.Sprint(inst["revision"]),
+			batch,
+			fmt.Sprint(inst["flowRunStatus"]),
+			fmt.Sprint(inst["phase"]),
+			formatSince(inst["createdAt"]),
+			formatSince(inst["lastRunAt"]),
+		})
+	}
+	table.Render()
+}
+
+func formatRollout(rollout map[string]any) string {
+	s := fmt.Sprintf("batch %v/%v", rollout["batch"], rollout["batches"])
+	var flags []string
+	for _, flag := range []string{"canary", "paused", "aborted"} {
+		if rollout[flag] == true {
+			flags = append(flags, flag)
+		}
+	}
+	if len(flags) > 0 {
+		s += " (" + strings.Join(flags, ", ") + ")"
+	}
+	if msg, ok := rollout["message"].(string); ok && msg != "" {
+		s += ", " + msg
+	}
+	return s
+}
+
+// 按状态名称排序输出各状态的 AppInstance 数量
+func formatCounts(counts any) string {
+	m, _ := counts.(map[string]any)
+	var s []string
+	for _, status := range slices.Sorted(maps.Keys(m)) {
+		s = append(s, fmt.Sprintf("%s=%v", status, m[status]))
+	}
+	return strings.Join(s, " ")
+}
+
+func formatSince(t any) string {
+	parsed, err := time.Parse(time.RFC3339Nano, fmt.Sprint(t))
+	if err != nil {
+		return "<unknown>"
+	}
+	return HumanDuration(time.Since(parsed))
+}
@@ -0,0 +1,19 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDescribeNoNamespaced(t *testing.T) {
+	RootCmd.SetArgs([]string{"describe", "appdeployment", "go-app"})
+	assertOsExit(t, Execute, 1)
+}
+
+func TestFormatRollout(t *testing.T) {
+	rollout := map[string]any{"batch": 1, "batches": 2, "canary": true, "paused": true, "message": "waiting"}
+	assert.Equal(t, "batch 1/2 (canary, paused), waiting", formatRollout(rollout))
+	assert.Equal(t, "completed=1 running=2", formatCounts(map[string]any{"running": 2, "completed": 1}))
+	assert.Equal(t, "", formatCounts(nil))
+}
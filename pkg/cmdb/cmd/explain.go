@@ -0,0 +1,148 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"gcmdb/pkg/cmdb"
+	"gcmdb/pkg/cmdb/client"
+	"gcmdb/pkg/cmdb/conversion"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/olekukonko/tablewriter"
+	"github.com/spf13/cobra"
+)
+
+var explainCmd = &cobra.Command{
+	Use:   "explain",
+	Short: "Show the parameter schema of resources",
+}
+
+var explainOrchestrationCmd = &cobra.Command{
+	Use:   "orchestration <name>",
+	Short: "orchestration",
+	Long:  "Show the parameter schema declared by orchestration",
+	Args:  cobra.ExactArgs(1),
+	Run: func(c *cobra.Command, args []string) {
+		explainResourceCmdHandle(c, cmdb.NewOrchestration(), args[0], "")
+	},
+}
+
+var explainDeployTemplateCmd = &cobra.Command{
+	Use:   "deploytemplate <name>",
+	Short: "deploytemplate",
+	Long:  "Show the parameter schema declared by deploytemplate",
+	Args:  cobra.ExactArgs(1),
+	Run: func(c *cobra.Command, args []string) {
+		namespace, _ := c.Root().PersistentFlags().GetString("namespace")
+		if namespace == "" {
+			CheckError(fmt.Errorf("error: a namespace must be specified for DeployTemplate"))
+		}
+		explainResourceCmdHandle(c, cmdb.NewDeployTemplate(), args[0], namespace)
+	},
+}
+
+var explainAppDeploymentCmd = &cobra.Command{
+	Use:   "appdeployment <name>",
+	Short: "appdeployment",
+	Long:  "Show the deploy params schema of appdeployment, declared by its orchestration and deploytemplate",
+	Args:  cobra.ExactArgs(1),
+	Run: func(c *cobra.Command, args []string) {
+		explainAppDeploymentCmdHandle(c, args[0])
+	},
+}
+
+func init() {
+	for _, c := range []*cobra.Command{explainOrchestrationCmd, explainDeployTemplateCmd, explainAppDeploymentCmd} {
+		c.Flags().StringP("output", "o", "", "output format: yaml|json, print the schema")
+		explainCmd.AddCommand(c)
+	}
+	RootCmd.AddCommand(explainCmd)
+}
+
+func explainResourceCmdHandle(c *cobra.Command, r cmdb.Object, name, namespace string) {
+	cli := client.DefaultCMDBClient
+	result, err := cli.ReadResource(r, name, namespace, 0)
+	CheckError(err)
+	schemas := map[string]any{}
+	if schema := conversion.GetMapValueByPath(result, "spec.parameterSchema"); schema != nil {
+		schemas[strings.ToLower(r.GetKind())] = schema
+	}
+	outputSchemas(c, schemas)
+}
+
+func explainAppDeploymentCmdHandle(c *cobra.Command, name string) {
+	namespace := appDeploymentNamespace(c)
+	cli := client.DefaultCMDBClient
+	schemas, err := cli.GetParameterSchemas(name, namespace)
+	CheckError(err)
+	outputSchemas(c, schemas)
+}
+
+func outputSchemas(c *cobra.Command, schemas map[string]any) {
+	if output, _ := c.Flags().GetString("output"); output != "" {
+		outputResult(c, []map[string]any{schemas})
+		return
+	}
+	if len(schemas) == 0 {
+		fmt.Println("no parameter schema declared.")
+		return
+	}
+	for i, source := range slices.Sorted(maps.Keys(schemas)) {
+		if i > 0 {
+			fmt.Println()
+		}
+		schema, _ := schemas[source].(map[string]any)
+		fmt.Printf("%s:\n", strings.ToUpper(source[:1])+source[1:])
+		if desc, ok := schema["description"]; ok {
+			fmt.Printf("  %v\n", desc)
+		}
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader([]string{"PARAMETER", "TYPE", "REQUIRED", "DEFAULT", "DESCRIPTION"})
+		table.SetBorder(false)
+		table.SetColumnSeparator("")
+		table.SetHeaderLine(false)
+		table.SetAutoWrapText(false)
+		table.SetAlignment(tablewriter.ALIGN_LEFT)
+		table.SetHeaderAlignment(tablewriter.ALIGN_LEFT)
+		for _, row := range schemaRows(schema, "") {
+			table.Append(row)
+		}
+		table.Render()
+	}
+}
+
+// 将嵌套的属性展开为以 . 连接的参数名
+func schemaRows(schema map[string]any, prefix string) [][]string {
+	rows := [][]string{}
+	props, _ := schema["properties"].(map[string]any)
+	required, _ := schema["required"].([]any)
+	for _, name := range slices.Sorted(maps.Keys(props)) {
+		prop, _ := props[name].(map[string]any)
+		path := prefix + name
+		typ := fmt.Sprint(prop["type"])
+		if prop["type"] == nil {
+			typ = "any"
+		}
+		if enum, ok := prop["enum"].([]any); ok {
+			typ += fmt.Sprintf(" %v", enum)
+		}
+		def := ""
+		if prop["default"] != nil {
+			byts, _ := json.Marshal(prop["default"])
+			def = string(byts)
+		}
+		desc := ""
+		if prop["description"] != nil {
+			desc = fmt.Sprint(prop["description"])
+		}
+		rows = append(rows, []string{path, typ, fmt.Sprint(slices.Contains(required, any(name))), def, desc})
+		rows = append(rows, schemaRows(prop, path+".")...)
+		if items, ok := prop["items"].(map[string]any); ok {
+			rows = append(rows, schemaRows(items, path+"[].")...)
+		}
+	}
+	return rows
+}
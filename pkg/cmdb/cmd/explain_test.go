@@ -0,0 +1,21 @@
+package cmd
+
+import (
+	"testing"
+)
+
+func TestExplainNotFound(t *testing.T) {
+	ts := testServer()
+	defer ts.Close()
+
+	RootCmd.SetArgs([]string{"explain", "orchestration", "not-exist"})
+	assertOsExit(t, Execute, 1)
+	RootCmd.SetArgs([]string{"explain", "appdeployment", "not-exist", "-n", "test"})
+	assertOsExit(t, Execute, 1)
+	RootCmd.PersistentFlags().Lookup("namespace").Value.Set("")
+}
+
+func TestExplainDeployTemplateNoNamespace(t *testing.T) {
+	RootCmd.SetArgs([]string{"explain", "deploytemplate", "docker-compose-test"})
+	assertOsExit(t, Execute, 1)
+}
@@ -22,8 +22,12 @@ type customColumn struct {
 // 资源额外列
 var extraCustomColumn = map[string][]customColumn{
 	"app":               {{"PROJECT", "spec.project"}, {"SCM", "spec.scm.name"}},
+	"appinstance":       {{"FLOW_RUN_STATUS", "status.flowRunStatus"}, {"PHASE", "status.phase"}},
 	"appdeployment":     {{"STATUS", "status"}, {"FLOW_RUN_ID", "flow_run_id"}, {"PROJECT", "spec.template.spec.project"}, {"APP", "spec.template.spec.app"}},
 	"datacenter":        {{"PROVIDER", "spec.provider"}},
+	"deployplan":        {{"ACTION", "spec.action"}, {"PHASE", "status.phase"}},
+	"templatelibrary":   {{"DESCRIPTION", "spec.description"}},
+	"deploymentrequest": {{"APPDEPLOYMENT", "spec.appDeployment"}, {"ACTION", "spec.action"}, {"REQUESTER", "spec.requester"}, {"PHASE", "status.phase"}},
 	"project":           {{"NAME_IN_CHAIN", "spec.nameInChain"}},
 	"scm":               {{"DATACENTER", "spec.datacenter"}, {"URL", "spec.url"}, {"SERVICE", "spec.service"}},
 	"containerregistry": {{"TYPE", "spec.type"}, {"DATACENTER", "spec.datacenter"}, {"REGISTRY", "spec.registry"}},
@@ -32,8 +36,10 @@ var extraCustomColumn = map[string][]customColumn{
 	"helmrepository":    {{"DATACENTER", "spec.datacenter"}, {"URL", "spec.url"}},
 	"hostnode":          {{"DATACENTER", "spec.datacenter"}, {"ZONE", "spec.zone"}, {"HOSTNAME", "spec.hostname"}, {"IP", "spec.ip"}, {"PHASE", "status.phase"}},
 	"namespace":         {{"ENV", "spec.bizEnv"}, {"UNIT", "spec.bizUnit"}, {"DATACENTER", "spec.datacenter"}},
+	"notifier":          {{"URL", "spec.webhook.url"}},
 	"orchestration":     {{"PREFECT_DEPLOY", "spec.name"}},
 	"resourcerange":     {{"DEPLOY_TEMPLATE", "deployTemplate.name"}},
+	"schedule":          {{"SCHEDULE", "spec.schedule"}, {"APPDEPLOYMENT", "spec.appDeployment"}, {"ACTION", "spec.action"}, {"LAST_RESULT", "status.lastResult"}, {"NEXT_SCHEDULE", "status.nextScheduleTime"}},
 }
 
 var getCmd = &cobra.Command{
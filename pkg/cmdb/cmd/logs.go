@@ -0,0 +1,65 @@
+package cmd
+
+import (
+	"fmt"
+	"gcmdb/pkg/cmdb/client"
+
+	"github.com/spf13/cobra"
+)
+
+// Prefect(python logging) 日志级别
+var logLevelNames = map[int]string{
+	10: "DEBUG",
+	20: "INFO",
+	30: "WARNING",
+	40: "ERROR",
+	50: "CRITICAL",
+}
+
+var logsCmd = &cobra.Command{
+	Use:   "logs",
+	Short: "Print the deploy logs",
+}
+
+var logsAppDeploymentCmd = &cobra.Command{
+	Use:   "appdeployment <name>",
+	Short: "appdeployment",
+	Long:  "Print the flow run logs of the current appdeployment revision, or of an appinstance with --instance",
+	Args:  cobra.ExactArgs(1),
+	Run: func(c *cobra.Command, args []string) {
+		logsAppDeploymentCmdHandle(c, args[0])
+	},
+}
+
+func init() {
+	logsAppDeploymentCmd.Flags().BoolP("follow", "f", false, "Follow the logs until the deployment finished")
+	logsAppDeploymentCmd.Flags().String("instance", "", "Print the logs of the appinstance only")
+	logsCmd.AddCommand(logsAppDeploymentCmd)
+	RootCmd.AddCommand(logsCmd)
+}
+
+func logsAppDeploymentCmdHandle(c *cobra.Command, name string) {
+	namespace := appDeploymentNamespace(c)
+	follow, _ := c.Flags().GetBool("follow")
+	instance, _ := c.Flags().GetString("instance")
+	cli := client.DefaultCMDBClient
+	if follow {
+		CheckError(cli.FollowLogs(name, namespace, instance, printLog))
+		return
+	}
+	logs, err := cli.ReadLogs(name, namespace, instance)
+	CheckError(err)
+	for _, log := range logs {
+		printLog(log)
+	}
+}
+
+func printLog(log map[string]any) {
+	level := fmt.Sprint(log["level"])
+	if l, ok := log["level"].(float64); ok {
+		if name, ok := logLevelNames[int(l)]; ok {
+			level = name
+		}
+	}
+	fmt.Printf("%v %-8s %v\n", log["timestamp"], level, log["message"])
+}
@@ -0,0 +1,21 @@
+package cmd
+
+import (
+	"testing"
+)
+
+func TestLogsNoNamespaced(t *testing.T) {
+	RootCmd.SetArgs([]string{"logs", "appdeployment", "go-app", "-f"})
+	assertOsExit(t, Execute, 1)
+}
+
+func TestLogsNotConfigured(t *testing.T) {
+	ts := testServer()
+	defer ts.Close()
+
+	RootCmd.SetArgs([]string{"logs", "appdeployment", "go-app", "-n", "test", "--instance", "not-exist"})
+	assertOsExit(t, Execute, 1)
+	if flag := RootCmd.PersistentFlags().Lookup("namespace"); flag != nil {
+		flag.Value.Set("")
+	}
+}
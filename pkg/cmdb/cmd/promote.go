@@ -0,0 +1,127 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"gcmdb/pkg/cmdb/client"
+	"gcmdb/pkg/cmdb/deployment"
+	"os"
+	"strings"
+
+	"github.com/goccy/go-yaml"
+	"github.com/pmezard/go-difflib/difflib"
+	"github.com/spf13/cobra"
+)
+
+var promoteCmd = &cobra.Command{
+	Use:   "promote",
+	Short: "Promote resources to another namespace",
+}
+
+var promoteAppDeploymentCmd = &cobra.Command{
+	Use:   "appdeployment <name>",
+	Short: "appdeployment",
+	Long: "Copy appdeployment with its resourcerange and deploytemplate to another namespace, " +
+		"applying the promotionOverrides of the target namespace and --set overrides, show the diff and confirm before committing",
+	Args: cobra.ExactArgs(1),
+	Run: func(c *cobra.Command, args []string) {
+		promoteAppDeploymentCmdHandle(c, args[0])
+	},
+}
+
+func init() {
+	promoteAppDeploymentCmd.Flags().String("from", "", "source namespace, defaults to --namespace")
+	promoteAppDeploymentCmd.Flags().String("to", "", "target namespace")
+	promoteAppDeploymentCmd.Flags().StringArray("set", []string{}, "override a field of promoted resources, e.g. --set resourcerange.spec.env.ENV=prod (can specify multiple), "+
+		"true/false and plain numbers are converted, other values are kept as strings")
+	promoteAppDeploymentCmd.Flags().StringArray("set-string", []string{}, "override a field of promoted resources, values are always strings (can specify multiple)")
+	promoteAppDeploymentCmd.Flags().StringP("values", "f", "", "overrides in a yaml file, keys are <kind>.<path>")
+	promoteAppDeploymentCmd.Flags().Bool("dry-run", false, "Only show the diff")
+	promoteAppDeploymentCmd.Flags().BoolP("yes", "y", false, "Promote without confirmation")
+	promoteAppDeploymentCmd.MarkFlagRequired("to")
+	promoteCmd.AddCommand(promoteAppDeploymentCmd)
+	RootCmd.AddCommand(promoteCmd)
+}
+
+func promoteAppDeploymentCmdHandle(c *cobra.Command, name string) {
+	from, _ := c.Flags().GetString("from")
+	if from == "" {
+		from = appDeploymentNamespace(c)
+	}
+	to, _ := c.Flags().GetString("to")
+	dryRun, _ := c.Flags().GetBool("dry-run")
+	yes, _ := c.Flags().GetBool("yes")
+	overrides := parseOverridesFlags(c)
+	cli := client.DefaultCMDBClient
+	result, err := cli.PromoteAppDeployment(name, from, to, overrides, true)
+	CheckError(err)
+	if !printPromoteDiff(result) {
+		fmt.Printf("appdeployment %v in namespace %v is up to date.\n", name, to)
+		return
+	}
+	if dryRun {
+		return
+	}
+	if !yes && !confirm(c, fmt.Sprintf("Promote appdeployment %s from %s to %s?", name, from, to)) {
+		fmt.Println("promote cancelled.")
+		return
+	}
+	_, err = cli.PromoteAppDeployment(name, from, to, overrides, false)
+	CheckError(err)
+	fmt.Printf("appdeployment %v promoted from %v to %v.\n", name, from, to)
+}
+
+// 解析 --values 文件和 --set、--set-string 覆盖项，键不展开为嵌套结构，--set-string 优先，其次 --set
+func parseOverridesFlags(c *cobra.Command) map[string]any {
+	overrides := map[string]any{}
+	if file, _ := c.Flags().GetString("values"); file != "" {
+		byts, err := os.ReadFile(file)
+		CheckError(err)
+		CheckError(yaml.Unmarshal(byts, &overrides))
+		if overrides == nil {
+			overrides = map[string]any{}
+		}
+	}
+	parseSetFlags(c, func(key string, value any) {
+		overrides[key] = value
+	})
+	return overrides
+}
+
+// 输出每个资源的变更，返回是否有变更
+func printPromoteDiff(result map[string]any) bool {
+	changed := false
+	objects, _ := result["objects"].([]any)
+	for _, o := range objects {
+		o := o.(map[string]any)
+		title := fmt.Sprintf("%v/%v", strings.ToLower(fmt.Sprint(o["kind"])), o["name"])
+		if o["action"] == string(deployment.PromoteUnchanged) {
+			fmt.Printf("%s unchanged\n", title)
+			continue
+		}
+		changed = true
+		fmt.Printf("%s %v\n", title, o["action"])
+		var current []byte
+		if o["current"] != nil {
+			current, _ = yaml.MarshalWithOptions(o["current"], yaml.AutoInt())
+		}
+		promoted, _ := yaml.MarshalWithOptions(o["promoted"], yaml.AutoInt())
+		diff := difflib.UnifiedDiff{
+			A:        difflib.SplitLines(string(current)),
+			B:        difflib.SplitLines(string(promoted)),
+			FromFile: fmt.Sprintf("%v/%s", result["to"], title),
+			ToFile:   fmt.Sprintf("%v/%s", result["from"], title),
+			Context:  3,
+		}
+		text, _ := difflib.GetUnifiedDiffString(diff)
+		fmt.Println(text)
+	}
+	return changed
+}
+
+func confirm(c *cobra.Command, prompt string) bool {
+	fmt.Printf("%s [y/N]: ", prompt)
+	answer, _ := bufio.NewReader(c.InOrStdin()).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
@@ -0,0 +1,20 @@
+package cmd
+
+import (
+	"testing"
+)
+
+func TestPromoteAppDeploymentNoTarget(t *testing.T) {
+	RootCmd.SetArgs([]string{"promote", "appdeployment", "go-app", "--from", "test"})
+	assertOsExit(t, Execute, 1)
+}
+
+func TestPromoteAppDeploymentNotFound(t *testing.T) {
+	ts := testServer()
+	defer ts.Close()
+
+	RootCmd.SetArgs([]string{"promote", "appdeployment", "not-exist", "--from", "test", "--to", "prod", "--dry-run"})
+	assertOsExit(t, Execute, 1)
+	RootCmd.SetArgs([]string{"promote", "appdeployment", "not-exist", "--from", "test", "--to", "prod", "--set", "resourcerange"})
+	assertOsExit(t, Execute, 1)
+}
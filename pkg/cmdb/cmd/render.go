@@ -0,0 +1,222 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"gcmdb/pkg/cmdb/client"
+	"gcmdb/pkg/cmdb/runtime"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/goccy/go-yaml"
+	"github.com/olekukonko/tablewriter"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+var renderCmd = &cobra.Command{
+	Use:   "render",
+	Short: "Render resources",
+}
+
+var renderAppDeploymentCmd = &cobra.Command{
+	Use:   "appdeployment <name>",
+	Short: "appdeployment",
+	Long:  "Render appdeployment, or its kubernetes manifests, helm values or docker-compose files with --format",
+	Args:  cobra.ExactArgs(1),
+	Run: func(c *cobra.Command, args []string) {
+		renderAppDeploymentCmdHandle(c, args[0])
+	},
+}
+
+var renderDeployTemplateCmd = &cobra.Command{
+	Use:   "deploytemplate <name>",
+	Short: "deploytemplate",
+	Long:  "Render the deploytemplate of appdeployment",
+	Args:  cobra.ExactArgs(1),
+	Run: func(c *cobra.Command, args []string) {
+		renderDeployTemplateCmdHandle(c, args[0])
+	},
+}
+
+func init() {
+	renderCmd.PersistentFlags().String("format", "", "render format: k8s|helm-values|docker-compose")
+	renderCmd.PersistentFlags().StringP("output", "o", "yaml", "output format: yaml|json")
+	addParamsFlags(renderCmd.PersistentFlags())
+	renderAppDeploymentCmd.Flags().Bool("trace", false, "show the source of every field of the rendered appdeployment")
+	renderAppDeploymentCmd.Flags().Bool("conflicts", false, "show the conflicts of merging resourcerange into appdeployment")
+	renderCmd.AddCommand(renderAppDeploymentCmd)
+	renderCmd.AddCommand(renderDeployTemplateCmd)
+	RootCmd.AddCommand(renderCmd)
+}
+
+func renderAppDeploymentCmdHandle(c *cobra.Command, name string) {
+	namespace := appDeploymentNamespace(c)
+	params := parseParamsFlags(c)
+	format, _ := c.Flags().GetString("format")
+	cli := client.DefaultCMDBClient
+	if conflicts, _ := c.Flags().GetBool("conflicts"); conflicts {
+		if format != "" {
+			CheckError(fmt.Errorf("error: --conflicts can't be used with --format"))
+		}
+		result, err := cli.RenderConflicts(name, namespace, params)
+		CheckError(err)
+		printConflicts(result)
+		return
+	}
+	if trace, _ := c.Flags().GetBool("trace"); trace {
+		if format != "" {
+			CheckError(fmt.Errorf("error: --trace can't be used with --format"))
+		}
+		result, err := cli.TraceAppDeployment(name, namespace, params)
+		CheckError(err)
+		outputResult(c, []map[string]any{result})
+		return
+	}
+	switch format {
+	case "":
+		appDeploy, err := cli.RenderAppDeployment(name, namespace, params)
+		CheckError(err)
+		outputResult(c, []map[string]any{appDeploy})
+	case "k8s":
+		manifests, err := cli.RenderManifests(name, namespace, params)
+		CheckError(err)
+		outputResult(c, manifests)
+	case "helm-values":
+		values, err := cli.RenderHelmValues(name, namespace, params)
+		CheckError(err)
+		outputResult(c, []map[string]any{values})
+	case "docker-compose":
+		composes, err := cli.RenderDockerCompose(name, namespace, params)
+		CheckError(err)
+		if output, _ := c.Flags().GetString("output"); output == "json" {
+			outputFmtJson([]map[string]any{{"composes": composes}})
+			return
+		}
+		outputFmtCompose(composes)
+	default:
+		CheckError(fmt.Errorf("error: render format %q no support, must be k8s, helm-values or docker-compose", format))
+	}
+}
+
+func renderDeployTemplateCmdHandle(c *cobra.Command, name string) {
+	namespace := appDeploymentNamespace(c)
+	params := parseParamsFlags(c)
+	cli := client.DefaultCMDBClient
+	deployTpl, err := cli.RenderDeployTemplate(name, namespace, params)
+	CheckError(err)
+	outputResult(c, []map[string]any{deployTpl})
+}
+
+// AppDeployment 相关命令必须指定 namespace
+func appDeploymentNamespace(c *cobra.Command) string {
+	namespace, _ := c.Root().PersistentFlags().GetString("namespace")
+	if namespace == "" {
+		CheckError(fmt.Errorf("error: a namespace must be specified for AppDeployment"))
+	}
+	return namespace
+}
+
+func addParamsFlags(flags *pflag.FlagSet) {
+	flags.StringArray("set", []string{}, "set template params on the command line, e.g. --set image_tag=v1.0.0 (can specify multiple), "+
+		"true/false and plain numbers are converted, other values are kept as strings")
+	flags.StringArray("set-string", []string{}, "set template params on the command line, values are always strings (can specify multiple)")
+	flags.StringP("values", "f", "", "template params in a yaml file")
+}
+
+// 解析 --values 文件和 --set、--set-string 参数，--set-string 优先，其次 --set
+func parseParamsFlags(c *cobra.Command) map[string]any {
+	params := map[string]any{}
+	if file, _ := c.Flags().GetString("values"); file != "" {
+		byts, err := os.ReadFile(file)
+		CheckError(err)
+		CheckError(yaml.Unmarshal(byts, &params))
+		if params == nil {
+			params = map[string]any{}
+		}
+	}
+	parseSetFlags(c, func(key string, value any) {
+		runtime.RecSetItem(params, key, value)
+	})
+	return params
+}
+
+// 依次解析 --set 和 --set-string 参数，--set-string 的值不做类型转换
+func parseSetFlags(c *cobra.Command, setItem func(key string, value any)) {
+	for _, name := range []string{"set", "set-string"} {
+		sets, _ := c.Flags().GetStringArray(name)
+		for _, set := range sets {
+			key, raw, ok := strings.Cut(set, "=")
+			if !ok || key == "" {
+				CheckError(fmt.Errorf("error: invalid --%s %q, must be key=value", name, set))
+			}
+			if name == "set-string" {
+				setItem(key, raw)
+			} else {
+				setItem(key, parseParamValue(raw))
+			}
+		}
+	}
+}
+
+// 按 yaml 解析参数值，解析失败或转换会改变原文(如 1.10、0755、1e3)时作为字符串
+func parseParamValue(raw string) any {
+	var value any
+	if raw == "" || yaml.Unmarshal([]byte(raw), &value) != nil || value == nil {
+		return raw
+	}
+	switch value.(type) {
+	case bool, int64, uint64, float64:
+		if fmt.Sprint(value) != raw {
+			return raw
+		}
+	}
+	return value
+}
+
+func outputResult(c *cobra.Command, results []map[string]any) {
+	output, _ := c.Flags().GetString("output")
+	switch output {
+	case "json":
+		if len(results) == 1 {
+			byts, _ := json.MarshalIndent(results[0], "", "  ")
+			fmt.Printf("%v\n", string(byts))
+			return
+		}
+		outputFmtJson(results)
+	case "yaml":
+		outputFmtYaml(results)
+	default:
+		CheckError(fmt.Errorf("error: output format %q no support, must be yaml or json", output))
+	}
+}
+
+func printConflicts(conflicts []any) {
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"PATH", "STRATEGY", "KEPT", "DROPPED"})
+	table.SetBorder(false)
+	table.SetColumnSeparator("")
+	table.SetHeaderLine(false)
+	table.SetAutoWrapText(false)
+	table.SetAlignment(tablewriter.ALIGN_LEFT)
+	table.SetHeaderAlignment(tablewriter.ALIGN_LEFT)
+	for _, c := range conflicts {
+		c := c.(map[string]any)
+		kept, _ := json.Marshal(c["kept"])
+		dropped, _ := json.Marshal(c["dropped"])
+		table.Append([]string{fmt.Sprint(c["path"]), fmt.Sprint(c["strategy"]), string(kept), string(dropped)})
+	}
+	table.Render()
+}
+
+// 按 HostNode 名称顺序输出 docker-compose 文件
+func outputFmtCompose(composes map[string]map[string]any) {
+	var s []string
+	for _, node := range slices.Sorted(maps.Keys(composes)) {
+		byts, _ := yaml.MarshalWithOptions(composes[node], yaml.AutoInt())
+		s = append(s, fmt.Sprintf("# hostnode: %s\n%s", node, string(byts)))
+	}
+	fmt.Printf("%v", strings.Join(s, "---\n"))
+}
@@ -0,0 +1,66 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRenderNoNamespaced(t *testing.T) {
+	RootCmd.SetArgs([]string{"render", "appdeployment", "go-app", "--format", "k8s"})
+	assertOsExit(t, Execute, 1)
+}
+
+func TestRenderInvalidFormat(t *testing.T) {
+	RootCmd.SetArgs([]string{"render", "appdeployment", "go-app", "-n", "test", "--format", "invalid"})
+	assertOsExit(t, Execute, 1)
+	if flag := RootCmd.PersistentFlags().Lookup("namespace"); flag != nil {
+		flag.Value.Set("")
+	}
+}
+
+func TestRenderDeployTemplateNoNamespaced(t *testing.T) {
+	RootCmd.SetArgs([]string{"render", "deploytemplate", "go-app"})
+	assertOsExit(t, Execute, 1)
+}
+
+func TestParseParamValue(t *testing.T) {
+	assert.Equal(t, "v1.0.0", parseParamValue("v1.0.0"))
+	assert.Equal(t, uint64(3), parseParamValue("3"))
+	assert.Equal(t, true, parseParamValue("true"))
+	assert.Equal(t, "", parseParamValue(""))
+	assert.Equal(t, "~", parseParamValue("~"))
+	assert.Equal(t, int64(-3), parseParamValue("-3"))
+	assert.Equal(t, 1.5, parseParamValue("1.5"))
+	// 转换会改变原文的值保持为字符串
+	for _, raw := range []string{"1.10", "0755", "1e3", "on", "yes", "True", "+3", "0x1F"} {
+		assert.Equal(t, raw, parseParamValue(raw))
+	}
+}
+
+func TestParseParamsFlags(t *testing.T) {
+	c := &cobra.Command{}
+	addParamsFlags(c.Flags())
+	assert.NoError(t, c.ParseFlags([]string{"--set", "a.b=1.10", "--set", "replicas=3", "--set-string", "tag=3", "--set", "tag=4"}))
+	params := parseParamsFlags(c)
+	assert.Equal(t, map[string]any{"a": map[string]any{"b": "1.10"}, "replicas": uint64(3), "tag": "3"}, params)
+}
+
+func TestRenderTraceWithFormat(t *testing.T) {
+	RootCmd.SetArgs([]string{"render", "appdeployment", "go-app", "-n", "test", "--trace", "--format", "k8s"})
+	assertOsExit(t, Execute, 1)
+	renderAppDeploymentCmd.Flags().Lookup("trace").Value.Set("false")
+	if flag := RootCmd.PersistentFlags().Lookup("namespace"); flag != nil {
+		flag.Value.Set("")
+	}
+}
+
+func TestRenderConflictsWithFormat(t *testing.T) {
+	RootCmd.SetArgs([]string{"render", "appdeployment", "go-app", "-n", "test", "--conflicts", "--format", "k8s"})
+	assertOsExit(t, Execute, 1)
+	renderAppDeploymentCmd.Flags().Lookup("conflicts").Value.Set("false")
+	if flag := RootCmd.PersistentFlags().Lookup("namespace"); flag != nil {
+		flag.Value.Set("")
+	}
+}
@@ -0,0 +1,141 @@
+package cmd
+
+import (
+	"fmt"
+	"gcmdb/pkg/cmdb"
+	"gcmdb/pkg/cmdb/client"
+	"gcmdb/pkg/cmdb/deployment"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var rolloutCmd = &cobra.Command{
+	Use:   "rollout",
+	Short: "Manage the rollout of appdeployment",
+}
+
+// rollout 子命令对应的部署动作及完成后的提示
+var rolloutActions = []struct {
+	action deployment.DeployAction
+	done   string
+	long   string
+}{
+	{deployment.DeployPromote, "promoted", "Promote the canary of appdeployment, release the remaining appinstances"},
+	{deployment.DeployAbort, "aborted", "Abort the canary of appdeployment, roll canary appinstances back to the previous revision"},
+}
+
+// rollout status 轮询部署状态的间隔
+var rolloutStatusInterval = 2 * time.Second
+
+var rolloutStatusCmd = &cobra.Command{
+	Use:   "status <name>",
+	Short: "Show the rollout status of appdeployment",
+	Long:  "Show the rollout status of appdeployment, and watch it until the deployment finished by default",
+	Args:  cobra.ExactArgs(1),
+	Run: func(c *cobra.Command, args []string) {
+		rolloutStatusCmdHandle(c, args[0])
+	},
+}
+
+func InitRolloutCmd() {
+	for _, a := range rolloutActions {
+		rolloutCmd.AddCommand(newRolloutCmd(a.action, a.done, a.long))
+	}
+	rolloutStatusCmd.Flags().BoolP("watch", "w", true, "Watch the status until the deployment finished")
+	rolloutStatusCmd.Flags().Duration("timeout", 0, "The length of time to wait before ending watch, zero means never")
+	rolloutCmd.AddCommand(rolloutStatusCmd)
+	RootCmd.AddCommand(rolloutCmd)
+}
+
+func newRolloutCmd(action deployment.DeployAction, done, long string) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   fmt.Sprintf("%s <name>", action),
+		Short: fmt.Sprintf("%s canary of appdeployment", action),
+		Long:  long,
+		Args:  cobra.ExactArgs(1),
+		Run: func(c *cobra.Command, args []string) {
+			rolloutCmdHandle(c, action, done, args[0])
+		},
+	}
+	return cmd
+}
+
+func rolloutCmdHandle(c *cobra.Command, action deployment.DeployAction, done, name string) {
+	namespace, _ := c.Root().PersistentFlags().GetString("namespace")
+	if namespace == "" {
+		CheckError(fmt.Errorf("error: a namespace must be specified for AppDeployment"))
+	}
+	cli := client.DefaultCMDBClient
+	_, err := cli.RunAppDeployment(action, name, namespace, nil)
+	CheckError(err)
+	fmt.Printf("appdeployment %v canary %s.\n", name, done)
+}
+
+func rolloutStatusCmdHandle(c *cobra.Command, name string) {
+	namespace := appDeploymentNamespace(c)
+	watch, _ := c.Flags().GetBool("watch")
+	timeout, _ := c.Flags().GetDuration("timeout")
+	cli := client.DefaultCMDBClient
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+	last := ""
+	for {
+		state, err := cli.GetAppDeploymentStatus(name, namespace)
+		CheckError(err)
+		done, msg := rolloutStatusMessage(state)
+		if msg != last {
+			fmt.Println(msg)
+			last = msg
+		}
+		if done {
+			if state["status"] == string(cmdb.AppDeploymentFailed) {
+				CheckError(fmt.Errorf("error: appdeployment %s failed: %v", name, state["lastError"]))
+			}
+			return
+		}
+		if !watch {
+			return
+		}
+		if !deadline.IsZero() && time.Now().After(deadline) {
+			CheckError(fmt.Errorf("error: timed out waiting for appdeployment %s", name))
+		}
+		time.Sleep(rolloutStatusInterval)
+	}
+}
+
+// 部署是否结束及当前进度，金丝雀分析完成后等待人工确认，也视为结束
+func rolloutStatusMessage(state map[string]any) (bool, string) {
+	name := state["name"]
+	counts, _ := state["counts"].(map[string]any)
+	total := 0
+	for _, n := range counts {
+		total += int(n.(float64))
+	}
+	completed := 0
+	if n, ok := counts[string(cmdb.FlowRunCompleted)].(float64); ok {
+		completed = int(n)
+	}
+	switch state["status"] {
+	case string(cmdb.AppDeploymentDeployed):
+		return true, fmt.Sprintf("appdeployment %v successfully rolled out.", name)
+	case string(cmdb.AppDeploymentUninstalled):
+		return true, fmt.Sprintf("appdeployment %v uninstalled.", name)
+	case string(cmdb.AppDeploymentNoneDeployed):
+		return true, fmt.Sprintf("appdeployment %v has not been deployed.", name)
+	case string(cmdb.AppDeploymentFailed):
+		return true, fmt.Sprintf("appdeployment %v failed: %v", name, state["lastError"])
+	case string(cmdb.AppDeploymentUninstalling):
+		return false, fmt.Sprintf("Waiting for appdeployment %v uninstall to finish: %d of %d appinstances terminated...", name, completed, total)
+	}
+	msg := fmt.Sprintf("Waiting for appdeployment %v rollout to finish: %d of %d appinstances completed", name, completed, total)
+	if rollout, ok := state["rollout"].(map[string]any); ok {
+		msg += ", " + formatRollout(rollout)
+		if rollout["paused"] == true {
+			return true, msg
+		}
+	}
+	return false, msg + "..."
+}
@@ -0,0 +1,49 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRolloutNoNamespaced(t *testing.T) {
+	RootCmd.SetArgs([]string{"rollout", "promote", "go-app"})
+	assertOsExit(t, Execute, 1)
+}
+
+func TestRolloutNotFound(t *testing.T) {
+	ts := testServer()
+	defer ts.Close()
+
+	RootCmd.SetArgs([]string{"rollout", "abort", "not-exist", "-n", "test"})
+	assertOsExit(t, Execute, 1)
+	if flag := RootCmd.PersistentFlags().Lookup("namespace"); flag != nil {
+		flag.Value.Set("")
+	}
+}
+
+func TestRolloutStatusMessage(t *testing.T) {
+	state := map[string]any{
+		"name":   "go-app",
+		"status": "deploying",
+		"counts": map[string]any{"completed": float64(1), "running": float64(1)},
+	}
+	done, msg := rolloutStatusMessage(state)
+	assert.False(t, done)
+	assert.Equal(t, "Waiting for appdeployment go-app rollout to finish: 1 of 2 appinstances completed...", msg)
+
+	// 金丝雀暂停等待人工确认
+	state["rollout"] = map[string]any{"batch": float64(1), "batches": float64(2), "canary": true, "paused": true}
+	done, _ = rolloutStatusMessage(state)
+	assert.True(t, done)
+
+	state["status"] = "deployed"
+	done, msg = rolloutStatusMessage(state)
+	assert.True(t, done)
+	assert.Equal(t, "appdeployment go-app successfully rolled out.", msg)
+}
+
+func TestRolloutStatusNoNamespaced(t *testing.T) {
+	RootCmd.SetArgs([]string{"rollout", "status", "go-app"})
+	assertOsExit(t, Execute, 1)
+}
@@ -39,4 +39,5 @@ func init() {
 	}
 	InitMutilGetCmd(objects)
 	InitMutilDeleteCmd(objects)
+	InitRolloutCmd()
 }
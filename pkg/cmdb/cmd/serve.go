@@ -1,6 +1,7 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
 	apiv1 "gcmdb/pkg/cmdb/server/apis/v1"
 	"net/http"
@@ -38,6 +39,7 @@ func serveStart(port int16) {
 	addr := fmt.Sprintf(":%s", strconv.Itoa(int(port)))
 	fmt.Printf("serve address: %s\n", addr)
 	server = &http.Server{Addr: addr, Handler: apiv1.NewRouter(nil)}
+	apiv1.StartScheduler(context.Background())
 	if err := server.ListenAndServe(); err != nil {
 		panic(err)
 	}
@@ -0,0 +1,67 @@
+package cmd
+
+import (
+	"fmt"
+	"gcmdb/pkg/cmdb/client"
+	"os"
+	"time"
+
+	"github.com/olekukonko/tablewriter"
+	"github.com/spf13/cobra"
+)
+
+var getTagsCmd = &cobra.Command{
+	Use:   "tags",
+	Short: "Get image tags",
+}
+
+var getTagsAppDeploymentCmd = &cobra.Command{
+	Use:   "appdeployment <name>",
+	Short: "appdeployment",
+	Long:  "Get image tags of appdeployment from the container registry",
+	Args:  cobra.ExactArgs(1),
+	Run: func(c *cobra.Command, args []string) {
+		getTagsAppDeploymentCmdHandle(c, args[0])
+	},
+}
+
+func init() {
+	getTagsAppDeploymentCmd.Flags().StringP("output", "o", "simple", "output format: simple|yaml|json")
+	getTagsAppDeploymentCmd.Flags().String("sort", "semver", "sort tags by semver or date, newest first")
+	getTagsAppDeploymentCmd.Flags().IntP("page", "p", 1, "page number")
+	getTagsAppDeploymentCmd.Flags().IntP("limit", "s", 20, "limit size, 0 is no limit")
+	getTagsCmd.AddCommand(getTagsAppDeploymentCmd)
+	getCmd.AddCommand(getTagsCmd)
+}
+
+func getTagsAppDeploymentCmdHandle(c *cobra.Command, name string) {
+	namespace := appDeploymentNamespace(c)
+	sort, _ := c.Flags().GetString("sort")
+	page, _ := c.Flags().GetInt("page")
+	limit, _ := c.Flags().GetInt("limit")
+	cli := client.DefaultCMDBClient
+	result, err := cli.ListImageTags(name, namespace, sort, page, limit)
+	CheckError(err)
+	if output, _ := c.Flags().GetString("output"); output != "simple" {
+		outputResult(c, []map[string]any{result})
+		return
+	}
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"TAG", "IMAGE", "CREATED"})
+	table.SetBorder(false)
+	table.SetColumnSeparator("")
+	table.SetHeaderLine(false)
+	table.SetAlignment(tablewriter.ALIGN_LEFT)
+	table.SetHeaderAlignment(tablewriter.ALIGN_LEFT)
+	tags, _ := result["tags"].([]any)
+	for _, tag := range tags {
+		tag := tag.(map[string]any)
+		created := "<unknown>"
+		if t, err := time.Parse(time.RFC3339Nano, fmt.Sprint(tag["created"])); err == nil {
+			created = HumanDuration(time.Since(t)) + " ago"
+		}
+		table.Append([]string{fmt.Sprint(tag["name"]), fmt.Sprintf("%v:%v", result["image"], tag["name"]), created})
+	}
+	table.Render()
+	fmt.Printf("\nShowing %d of %v tags.\n", len(tags), result["total"])
+}
@@ -0,0 +1,10 @@
+package cmd
+
+import (
+	"testing"
+)
+
+func TestGetTagsNoNamespaced(t *testing.T) {
+	RootCmd.SetArgs([]string{"get", "tags", "appdeployment", "go-app"})
+	assertOsExit(t, Execute, 1)
+}
@@ -0,0 +1,58 @@
+package cmd
+
+import (
+	"fmt"
+	"gcmdb/pkg/cmdb/deployment"
+
+	"github.com/spf13/cobra"
+)
+
+var templateCmd = &cobra.Command{
+	Use:   "template",
+	Short: "Template tools",
+}
+
+var templateTestCmd = &cobra.Command{
+	Use:   "test <dir>",
+	Short: "Render local objects and compare with golden files",
+	Long: "Load the objects in <dir> into an in-process store, render every appdeployment and its deploytemplate, " +
+		"and compare with <dir>/golden/<namespace>/<name>.<kind>.yaml, params in <dir>/params.yaml are overridden by --values, --set and --set-string",
+	Args: cobra.ExactArgs(1),
+	Run: func(c *cobra.Command, args []string) {
+		templateTestCmdHandle(c, args[0])
+	},
+}
+
+func init() {
+	addParamsFlags(templateTestCmd.Flags())
+	templateTestCmd.Flags().Bool("update", false, "write the rendered results to the golden files")
+	templateCmd.AddCommand(templateTestCmd)
+	RootCmd.AddCommand(templateCmd)
+}
+
+func templateTestCmdHandle(c *cobra.Command, dir string) {
+	params := parseParamsFlags(c)
+	update, _ := c.Flags().GetBool("update")
+	results, err := deployment.RunTemplateTests(dir, params, update)
+	CheckError(err)
+	if len(results) == 0 {
+		CheckError(fmt.Errorf("error: no appdeployment found in %s", dir))
+	}
+	failed := 0
+	for _, r := range results {
+		fmt.Printf("%-7s %s %s (%s)\n", r.Status, r.Kind, r.Name, r.Golden)
+		if r.Error != "" {
+			fmt.Printf("        %s\n", r.Error)
+		}
+		if r.Diff != "" {
+			fmt.Println(r.Diff)
+		}
+		if r.Status == deployment.TemplateTestFail || r.Status == deployment.TemplateTestError {
+			failed++
+		}
+	}
+	fmt.Printf("%d tests, %d failed\n", len(results), failed)
+	if failed > 0 {
+		CheckError(fmt.Errorf("error: %d template tests failed", failed))
+	}
+}
@@ -0,0 +1,50 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/pflag"
+	"github.com/stretchr/testify/assert"
+)
+
+func resetTemplateTestFlags() {
+	templateTestCmd.Flags().Lookup("update").Value.Set("false")
+	templateTestCmd.Flags().Lookup("values").Value.Set("")
+	templateTestCmd.Flags().Lookup("set").Value.(pflag.SliceValue).Replace([]string{})
+}
+
+func TestTemplateTest(t *testing.T) {
+	defer resetTemplateTestFlags()
+	dir := t.TempDir()
+	files, err := filepath.Glob("../example/files/*.yaml")
+	assert.NoError(t, err)
+	for _, file := range files {
+		byts, err := os.ReadFile(file)
+		assert.NoError(t, err)
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, filepath.Base(file)), byts, 0o644))
+	}
+
+	// 缺少 golden 文件
+	RootCmd.SetArgs([]string{"template", "test", dir})
+	assertOsExit(t, Execute, 1)
+	RootCmd.SetArgs([]string{"template", "test", dir, "--update"})
+	assert.NoError(t, RootCmd.Execute())
+	resetTemplateTestFlags()
+	RootCmd.SetArgs([]string{"template", "test", dir})
+	assert.NoError(t, RootCmd.Execute())
+	RootCmd.SetArgs([]string{"template", "test", dir, "--set", "app_instance_name=x"})
+	assertOsExit(t, Execute, 1)
+}
+
+func TestTemplateTestInvalid(t *testing.T) {
+	defer resetTemplateTestFlags()
+	for _, args := range [][]string{
+		{"template", "test", "notExist"},
+		{"template", "test", t.TempDir()},
+	} {
+		RootCmd.SetArgs(args)
+		assertOsExit(t, Execute, 1, args)
+	}
+}
@@ -0,0 +1,135 @@
+package cmd
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"gcmdb/pkg/cmdb"
+	"gcmdb/pkg/cmdb/client"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var validateCmd = &cobra.Command{
+	Use:   "validate",
+	Short: "Validate resource files offline",
+	Long: "Validate resource files offline: fields, naming, template syntax and references, " +
+		"references must be in the files or on the server with --server",
+	Args: cobra.NoArgs,
+	Run: func(c *cobra.Command, args []string) {
+		validateCmdHandle(c)
+	},
+}
+
+func init() {
+	validateCmd.Flags().StringP("filename", "f", "", "File or directory name")
+	validateCmd.Flags().StringP("output", "o", "text", "output format: text|json|junit")
+	validateCmd.Flags().Bool("server", false, "resolve references not in the files against the server")
+	RootCmd.AddCommand(validateCmd)
+}
+
+type junitTestSuites struct {
+	XMLName xml.Name         `xml:"testsuites"`
+	Suites  []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name     string          `xml:"name,attr"`
+	Tests    int             `xml:"tests,attr"`
+	Failures int             `xml:"failures,attr"`
+	Cases    []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	ClassName string         `xml:"classname,attr"`
+	Name      string         `xml:"name,attr"`
+	Failures  []junitFailure `xml:"failure"`
+}
+
+type junitFailure struct {
+	Type    string `xml:"type,attr"`
+	Message string `xml:"message,attr"`
+}
+
+func validateCmdHandle(c *cobra.Command) {
+	filePath, _ := c.Flags().GetString("filename")
+	output, _ := c.Flags().GetString("output")
+	server, _ := c.Flags().GetBool("server")
+	if filePath == "" {
+		CheckError(fmt.Errorf("error: must specify -f"))
+	}
+	if output != "text" && output != "json" && output != "junit" {
+		CheckError(fmt.Errorf("error: output format %q no support, must be text, json or junit", output))
+	}
+	files, err := client.ValidationFiles(filePath)
+	CheckError(err)
+	var resolver client.ReferenceResolver
+	if server {
+		resolver = serverReferenceResolver
+	}
+	results := client.ValidateFiles(files, resolver)
+	errCount := 0
+	for _, r := range results {
+		errCount += len(r.Errors)
+	}
+	switch output {
+	case "json":
+		byts, _ := json.MarshalIndent(results, "", "  ")
+		fmt.Println(string(byts))
+	case "junit":
+		fmt.Println(validationJUnit(results))
+	default:
+		for _, r := range results {
+			for _, e := range r.Errors {
+				fmt.Printf("%s: %s: [%s] %s\n", r.File, validationObject(r), e.Check, e.Message)
+			}
+		}
+		fmt.Printf("%d files validated, %d errors\n", len(results), errCount)
+	}
+	if errCount > 0 {
+		CheckError(fmt.Errorf("error: validation failed with %d errors", errCount))
+	}
+}
+
+func serverReferenceResolver(kind, name, namespace string) (bool, error) {
+	obj, err := cmdb.NewResourceWithKind(kind)
+	if err != nil {
+		return false, err
+	}
+	_, err = client.DefaultCMDBClient.ReadResource(obj, name, namespace, 0)
+	switch err.(type) {
+	case nil:
+		return true, nil
+	case cmdb.ResourceNotFoundError:
+		return false, nil
+	}
+	return false, err
+}
+
+func validationObject(r client.ValidationResult) string {
+	if r.Kind == "" {
+		return "-"
+	}
+	if r.Namespace == "" {
+		return fmt.Sprintf("%s/%s", strings.ToLower(r.Kind), r.Name)
+	}
+	return fmt.Sprintf("%s/%s/%s", strings.ToLower(r.Kind), r.Namespace, r.Name)
+}
+
+// 每个文件一个测试用例，每个错误一个 failure
+func validationJUnit(results []client.ValidationResult) string {
+	suite := junitTestSuite{Name: "cmctl validate", Tests: len(results), Cases: []junitTestCase{}}
+	for _, r := range results {
+		tc := junitTestCase{ClassName: r.File, Name: validationObject(r)}
+		for _, e := range r.Errors {
+			tc.Failures = append(tc.Failures, junitFailure{Type: e.Check, Message: e.Message})
+		}
+		if len(tc.Failures) > 0 {
+			suite.Failures++
+		}
+		suite.Cases = append(suite.Cases, tc)
+	}
+	byts, _ := xml.MarshalIndent(junitTestSuites{Suites: []junitTestSuite{suite}}, "", "  ")
+	return xml.Header + string(byts)
+}
@@ -0,0 +1,80 @@
+package cmd
+
+import (
+	"gcmdb/pkg/cmdb/client"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func resetValidateFlags() {
+	validateCmd.Flags().Lookup("filename").Value.Set("")
+	validateCmd.Flags().Lookup("output").Value.Set("text")
+	validateCmd.Flags().Lookup("server").Value.Set("false")
+}
+
+func TestValidate(t *testing.T) {
+	defer resetValidateFlags()
+	for _, args := range [][]string{
+		{"validate", "-f", "../example/files"},
+		{"validate", "-f", "../example/files", "-o", "json"},
+		{"validate", "-f", "../example/files", "-o", "junit"},
+	} {
+		RootCmd.SetArgs(args)
+		assert.NoError(t, RootCmd.Execute())
+	}
+}
+
+func TestValidateServer(t *testing.T) {
+	defer resetValidateFlags()
+	ts := testServer()
+	defer ts.Close()
+	for _, args := range [][]string{
+		{"apply", "-f", "../example/files/secret.yaml"},
+		{"apply", "-f", "../example/files/datacenter.yaml"},
+	} {
+		RootCmd.SetArgs(args)
+		assert.NoError(t, RootCmd.Execute())
+	}
+	// Datacenter 不在文件集合内
+	RootCmd.SetArgs([]string{"validate", "-f", "../example/files/zone.yaml"})
+	assertOsExit(t, Execute, 1)
+	RootCmd.SetArgs([]string{"validate", "-f", "../example/files/zone.yaml", "--server"})
+	assert.NoError(t, RootCmd.Execute())
+}
+
+func TestValidateInvalid(t *testing.T) {
+	defer resetValidateFlags()
+	dir := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "bad.yaml"), []byte("kind: ["), 0o644))
+	for _, args := range [][]string{
+		{"validate"},
+		{"validate", "-f", dir},
+		{"validate", "-f", dir, "-o", "junit"},
+		{"validate", "-f", "../example/files", "-o", "xml"},
+		{"validate", "-f", "notExist"},
+	} {
+		RootCmd.SetArgs(args)
+		assertOsExit(t, Execute, 1, args)
+	}
+}
+
+func TestValidationJUnit(t *testing.T) {
+	results := []client.ValidationResult{
+		{File: "a.yaml", Kind: "Zone", Name: "test", Errors: []client.ValidationError{}},
+		{File: "b.yaml", Kind: "DeployTemplate", Name: "tpl", Namespace: "test", Errors: []client.ValidationError{
+			{Check: "template", Message: "unexpected <"},
+		}},
+	}
+	assert.Equal(t, `<?xml version="1.0" encoding="UTF-8"?>
+<testsuites>
+  <testsuite name="cmctl validate" tests="2" failures="1">
+    <testcase classname="a.yaml" name="zone/test"></testcase>
+    <testcase classname="b.yaml" name="deploytemplate/test/tpl">
+      <failure type="template" message="unexpected &lt;"></failure>
+    </testcase>
+  </testsuite>
+</testsuites>`, validationJUnit(results))
+}
@@ -0,0 +1,250 @@
+package deployment
+
+import (
+	"context"
+	"fmt"
+	"gcmdb/pkg/cmdb"
+	"gcmdb/pkg/cmdb/server/storage"
+	"log"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+)
+
+// 未配置审批策略时需要审批的业务环境
+var ProtectedBizEnvs = []string{"prod", "production"}
+
+var defaultApprovalActions = []string{string(DeployRelease), string(DeployRestart), string(DeployUninstall)}
+
+// 审批操作锁的有效期(秒)，避免并发审批丢失
+const approvalLockTTL int64 = 60
+
+// 需要审批，已创建 DeploymentRequest
+type ApprovalRequiredError struct {
+	Request *cmdb.DeploymentRequest
+}
+
+func (e ApprovalRequiredError) Error() string {
+	return fmt.Sprintf("appDeployment %s/%s %s requires approval, deploymentRequest %s created.",
+		e.Request.Metadata.Namespace, e.Request.Spec.AppDeployment, e.Request.Spec.Action, e.Request.Metadata.Name)
+}
+
+// 无权审批或申请已结束
+type ApprovalDeniedError struct {
+	Message string
+}
+
+func (e ApprovalDeniedError) Error() string {
+	return e.Message
+}
+
+// 命名空间的审批策略，不需要审批时返回 nil
+func namespaceApproval(db *storage.Store, namespace string) (*cmdb.NamespaceApproval, error) {
+	var obj cmdb.Object
+	if err := db.Get(context.Background(), "Namespace", namespace, "", storage.GetOptions{}, &obj); err != nil {
+		if storage.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return NamespaceApprovalPolicy(obj.(*cmdb.Namespace)), nil
+}
+
+// 按 approval 及 bizEnv 计算命名空间生效的审批策略，不需要审批时返回 nil
+func NamespaceApprovalPolicy(ns *cmdb.Namespace) *cmdb.NamespaceApproval {
+	approval := ns.Spec.Approval
+	required := slices.Contains(ProtectedBizEnvs, strings.ToLower(ns.Spec.BizEnv))
+	if approval != nil && approval.Required != nil {
+		required = *approval.Required
+	}
+	if !required {
+		return nil
+	}
+	policy := &cmdb.NamespaceApproval{MinApprovals: 1, Actions: defaultApprovalActions}
+	if approval != nil {
+		policy.Approvers = approval.Approvers
+		if approval.MinApprovals > 0 {
+			policy.MinApprovals = approval.MinApprovals
+		}
+		if len(approval.Actions) > 0 {
+			policy.Actions = approval.Actions
+		}
+	}
+	return policy
+}
+
+// 命名空间的部署操作是否需要审批
+func ApprovalRequired(db *storage.Store, namespace, action string) (bool, error) {
+	policy, err := namespaceApproval(db, namespace)
+	if err != nil {
+		return false, err
+	}
+	return policy != nil && slices.Contains(policy.Actions, action), nil
+}
+
+// 受保护命名空间的部署需先创建 DeploymentRequest，审批通过后再执行
+func (c *DeployController) checkApproval() error {
+	if c.approved {
+		return nil
+	}
+	required, err := ApprovalRequired(c.store, c.namespace, string(c.action))
+	if err != nil || !required {
+		return err
+	}
+	// 匿名申请无法与审批人区分
+	if c.requester == "" {
+		return ApprovalDeniedError{Message: fmt.Sprintf("appDeployment %s/%s %s requires approval, requester is unknown.", c.namespace, c.name, c.action)}
+	}
+	if _, err = getAppDeployment(c.store, c.name, c.namespace); err != nil {
+		return err
+	}
+	request := cmdb.NewDeploymentRequest()
+	request.Metadata.Name = truncNameLeft63(fmt.Sprintf("%s-%s-%s", c.name, c.action, randomString(5)))
+	request.Metadata.Namespace = c.namespace
+	request.Metadata.Labels = map[string]string{"appDeployment": c.name}
+	request.Spec = cmdb.DeploymentRequestSpec{
+		AppDeployment: c.name,
+		Action:        string(c.action),
+		Params:        maps.Clone(c.params),
+		Requester:     c.requester,
+	}
+	request.Status.Phase = cmdb.DeploymentRequestPending
+	var out cmdb.Object
+	if err = c.store.Create(context.Background(), request, &out); err != nil {
+		return err
+	}
+	return ApprovalRequiredError{Request: out.(*cmdb.DeploymentRequest)}
+}
+
+func getDeploymentRequest(db *storage.Store, name, namespace string) (*cmdb.DeploymentRequest, error) {
+	var obj cmdb.Object
+	if err := db.Get(context.Background(), "DeploymentRequest", name, namespace, storage.GetOptions{}, &obj); err != nil {
+		return nil, err
+	}
+	return obj.(*cmdb.DeploymentRequest), nil
+}
+
+// 审批通过 DeploymentRequest，达到审批人数后在后台以申请人身份执行部署
+func ApproveDeploymentRequest(db *storage.Store, name, namespace, user, comment string) (*cmdb.DeploymentRequest, error) {
+	return decideDeploymentRequest(db, name, namespace, user, comment, true)
+}
+
+// 拒绝 DeploymentRequest，申请人可拒绝自己的申请以撤销
+func RejectDeploymentRequest(db *storage.Store, name, namespace, user, comment string) (*cmdb.DeploymentRequest, error) {
+	return decideDeploymentRequest(db, name, namespace, user, comment, false)
+}
+
+func decideDeploymentRequest(db *storage.Store, name, namespace, user, comment string, approved bool) (*cmdb.DeploymentRequest, error) {
+	lockName := fmt.Sprintf("deploymentrequests/%s/%s", namespace, name)
+	if _, err := db.AcquireLock(context.Background(), lockName, LockOwner, approvalLockTTL); err != nil {
+		return nil, err
+	}
+	defer db.ReleaseLock(context.Background(), lockName)
+
+	request, err := getDeploymentRequest(db, name, namespace)
+	if err != nil {
+		return nil, err
+	}
+	policy, err := namespaceApproval(db, namespace)
+	if err != nil {
+		return nil, err
+	}
+	if err = checkApprover(request, policy, user, approved); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	request.Status.Approvals = append(request.Status.Approvals, cmdb.DeploymentApproval{
+		User: user, Approved: approved, Comment: comment, Time: &now,
+	})
+	minApprovals := 1
+	if policy != nil {
+		minApprovals = policy.MinApprovals
+	}
+	switch {
+	case !approved:
+		request.Status.Phase = cmdb.DeploymentRequestRejected
+		request.Status.Message = fmt.Sprintf("rejected by %s.", user)
+	case countApprovals(request) >= minApprovals:
+		request.Status.Phase = cmdb.DeploymentRequestApproved
+		request.Status.Message = fmt.Sprintf("appDeployment %s %s starting.", request.Spec.AppDeployment, request.Spec.Action)
+	default:
+		request.Status.Message = fmt.Sprintf("approved %d/%d.", countApprovals(request), minApprovals)
+	}
+	if err = db.Update(context.Background(), request, nil); err != nil {
+		return nil, err
+	}
+	// 部署可能超过请求超时时间，不在审批请求中执行
+	switch request.Status.Phase {
+	case cmdb.DeploymentRequestApproved:
+		go runApprovedRequest(db, request)
+	case cmdb.DeploymentRequestRejected:
+		go advanceDeployPlans(db, request.Spec.AppDeployment, namespace)
+	}
+	return request, nil
+}
+
+// 以申请人身份执行审批通过的部署，记录部署结果
+func runApprovedRequest(db *storage.Store, request *cmdb.DeploymentRequest) {
+	name, namespace := request.Metadata.Name, request.Metadata.Namespace
+	c := NewDeployController(db, DeployAction(request.Spec.Action), request.Spec.AppDeployment, namespace, request.Spec.Params).
+		WithRequester(request.Spec.Requester)
+	c.approved = true
+	phase := cmdb.DeploymentRequestDeployed
+	message := fmt.Sprintf("appDeployment %s %s started.", request.Spec.AppDeployment, request.Spec.Action)
+	if _, err := c.Run(); err != nil {
+		phase, message = cmdb.DeploymentRequestFailed, err.Error()
+	}
+	latest, err := getDeploymentRequest(db, name, namespace)
+	if err != nil {
+		log.Printf("deploymentRequest %s/%s: %s", namespace, name, err)
+		return
+	}
+	latest.Status.Phase = phase
+	latest.Status.Message = message
+	if err = db.Update(context.Background(), latest, nil); err != nil {
+		log.Printf("deploymentRequest %s/%s: %s", namespace, name, err)
+		return
+	}
+	// 继续等待本次审批的部署计划
+	advanceDeployPlans(db, request.Spec.AppDeployment, namespace)
+}
+
+func checkApprover(request *cmdb.DeploymentRequest, policy *cmdb.NamespaceApproval, user string, approved bool) error {
+	if request.Status.Phase != cmdb.DeploymentRequestPending {
+		return ApprovalDeniedError{Message: fmt.Sprintf("deploymentRequest %s is %s.", request.Metadata.Name, request.Status.Phase)}
+	}
+	if user == "" {
+		return ApprovalDeniedError{Message: "approver is unknown."}
+	}
+	// 匿名申请无法确认审批人不是申请人，只能拒绝
+	if request.Spec.Requester == "" && approved {
+		return ApprovalDeniedError{Message: fmt.Sprintf("deploymentRequest %s has no requester and can't be approved.", request.Metadata.Name)}
+	}
+	// 申请人只能撤销自己的申请
+	if user == request.Spec.Requester {
+		if approved {
+			return ApprovalDeniedError{Message: fmt.Sprintf("requester %s can't approve own deploymentRequest.", user)}
+		}
+		return nil
+	}
+	if policy != nil && len(policy.Approvers) > 0 && !slices.Contains(policy.Approvers, user) {
+		return ApprovalDeniedError{Message: fmt.Sprintf("%s is not an approver of namespace %s.", user, request.Metadata.Namespace)}
+	}
+	for _, a := range request.Status.Approvals {
+		if a.User == user {
+			return ApprovalDeniedError{Message: fmt.Sprintf("%s has already approved deploymentRequest %s.", user, request.Metadata.Name)}
+		}
+	}
+	return nil
+}
+
+func countApprovals(request *cmdb.DeploymentRequest) int {
+	count := 0
+	for _, a := range request.Status.Approvals {
+		if a.Approved {
+			count++
+		}
+	}
+	return count
+}
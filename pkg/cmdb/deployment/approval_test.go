@@ -0,0 +1,40 @@
+package deployment
+
+import (
+	"gcmdb/pkg/cmdb"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckApprover(t *testing.T) {
+	request := cmdb.NewDeploymentRequest()
+	request.Metadata.Name = "go-app-release-abcde"
+	request.Spec.Requester = "alice"
+	request.Status.Phase = cmdb.DeploymentRequestPending
+	request.Status.Approvals = []cmdb.DeploymentApproval{{User: "bob", Approved: true}}
+	policy := &cmdb.NamespaceApproval{Approvers: []string{"bob", "carol"}, MinApprovals: 2}
+
+	assert.NoError(t, checkApprover(request, policy, "carol", true))
+	// 申请人可撤销，不可审批
+	assert.NoError(t, checkApprover(request, policy, "alice", false))
+	assert.IsType(t, ApprovalDeniedError{}, checkApprover(request, policy, "alice", true))
+	assert.IsType(t, ApprovalDeniedError{}, checkApprover(request, policy, "dave", true))
+	assert.IsType(t, ApprovalDeniedError{}, checkApprover(request, policy, "bob", true))
+	assert.IsType(t, ApprovalDeniedError{}, checkApprover(request, policy, "", true))
+	// 未指定审批人时除申请人外均可审批
+	assert.NoError(t, checkApprover(request, nil, "dave", true))
+	// 匿名申请只能拒绝
+	request.Spec.Requester = ""
+	assert.IsType(t, ApprovalDeniedError{}, checkApprover(request, policy, "carol", true))
+	assert.NoError(t, checkApprover(request, policy, "carol", false))
+
+	request.Status.Phase = cmdb.DeploymentRequestRejected
+	assert.IsType(t, ApprovalDeniedError{}, checkApprover(request, policy, "carol", true))
+}
+
+func TestCountApprovals(t *testing.T) {
+	request := cmdb.NewDeploymentRequest()
+	request.Status.Approvals = []cmdb.DeploymentApproval{{User: "bob", Approved: true}, {User: "carol"}, {User: "dave", Approved: true}}
+	assert.Equal(t, 2, countApprovals(request))
+}
@@ -0,0 +1,407 @@
+package deployment
+
+import (
+	"context"
+	"fmt"
+	"gcmdb/pkg/cmdb"
+	"gcmdb/pkg/cmdb/conversion"
+	"gcmdb/pkg/cmdb/server/storage"
+	"maps"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Kubernetes 金丝雀 helm release 的后缀
+const canaryReleaseSuffix = "-canary"
+
+// 金丝雀分析通过的结果，其他结果均视为失败
+const canaryAnalysisPassed = "passed"
+
+// 获取金丝雀发布策略，未启用时返回 nil
+func canaryStrategy(appDeploy *cmdb.AppDeployment) *cmdb.CanaryStrategy {
+	strategy := appDeploy.Spec.Strategy
+	if strategy == nil || strategy.Type != cmdb.DeployStrategyCanary {
+		return nil
+	}
+	if strategy.Canary == nil {
+		return &cmdb.CanaryStrategy{}
+	}
+	return strategy.Canary
+}
+
+// 金丝雀节点数量，nodes 优先，其次按 percentage 向上取整，至少 1 个
+func canaryNodeCount(strategy *cmdb.CanaryStrategy, total int) int {
+	n := 1
+	if strategy.Nodes > 0 {
+		n = strategy.Nodes
+	} else if strategy.Percentage > 0 {
+		n = (total*strategy.Percentage + 99) / 100
+	}
+	return min(max(n, 1), total)
+}
+
+// 判断 AppInstance 是否为 Kubernetes 金丝雀 release
+func isCanaryRelease(inst *cmdb.AppInstance) bool {
+	dp := inst.Spec.DeployPlatform
+	if dp == nil || dp.Kubernetes == nil || dp.Kubernetes.Helm == nil {
+		return false
+	}
+	return strings.HasSuffix(dp.Kubernetes.Helm.Release, canaryReleaseSuffix)
+}
+
+// 规划金丝雀发布，金丝雀为第 1 批次，其余为第 2 批次
+func (c *DeployController) planCanary(insts *[]cmdb.AppInstance) error {
+	strategy := canaryStrategy(c.appDeploy)
+	typ, err := c.platformType()
+	if err != nil {
+		return err
+	}
+	if strategy.HealthCheck {
+		if typ != DPDocker {
+			errMsg := fmt.Sprintf("appDeployment %s/%s canary health check only support %s.", c.namespace, c.name, DPDocker)
+			return fmt.Errorf("%s", errMsg)
+		}
+		if probe := c.appDeploy.Spec.Template.Spec.Monitoring; probe == nil || probe.Probe == nil {
+			errMsg := fmt.Sprintf("appDeployment %s/%s health check require spec.monitoring.probe.", c.namespace, c.name)
+			return fmt.Errorf("%s", errMsg)
+		}
+	}
+	switch typ {
+	case DPDocker:
+		if err = c.selectCanaryNodes(*insts, strategy); err != nil {
+			return err
+		}
+	case DPKubernetes:
+		if err = c.addCanaryRelease(insts); err != nil {
+			return err
+		}
+	}
+	batches := 1
+	for _, inst := range *insts {
+		batches = max(batches, inst.Status.Batch)
+	}
+	c.rollout = &cmdb.AppDeploymentRollout{Batch: 1, Batches: batches, Canary: true}
+	return nil
+}
+
+// 选择金丝雀节点，nodeSelector 优先，否则按顺序选择前 n 个节点
+func (c *DeployController) selectCanaryNodes(insts []cmdb.AppInstance, strategy *cmdb.CanaryStrategy) error {
+	canaries := map[string]bool{}
+	if len(strategy.NodeSelector) > 0 {
+		var objs []cmdb.Object
+		listOpts := storage.ListOptions{LabelSelector: strategy.NodeSelector}
+		if err := c.store.GetList(context.Background(), "HostNode", "", listOpts, &objs); err != nil {
+			return err
+		}
+		for _, o := range objs {
+			canaries[o.GetMeta().Name] = true
+		}
+	} else {
+		for _, inst := range insts[:canaryNodeCount(strategy, len(insts))] {
+			canaries[appInstanceTarget(&inst)] = true
+		}
+	}
+	matched := 0
+	for i := range insts {
+		insts[i].Status.Batch = 2
+		if canaries[appInstanceTarget(&insts[i])] {
+			insts[i].Status.Batch = 1
+			matched++
+		}
+	}
+	if matched == 0 {
+		errMsg := fmt.Sprintf("未匹配到金丝雀节点(nodeSelector:%v)", strategy.NodeSelector)
+		return fmt.Errorf("%s", errMsg)
+	}
+	return nil
+}
+
+// 为 Kubernetes 部署增加独立的金丝雀 helm release
+func (c *DeployController) addCanaryRelease(insts *[]cmdb.AppInstance) error {
+	if len(*insts) != 1 {
+		errMsg := fmt.Sprintf("appDeployment %s/%s canary strategy require exactly one kubernetes appInstance.", c.namespace, c.name)
+		return fmt.Errorf("%s", errMsg)
+	}
+	main := (*insts)[0]
+	if dp := main.Spec.DeployPlatform; dp == nil || dp.Kubernetes == nil || dp.Kubernetes.Helm == nil || dp.Kubernetes.Helm.Release == "" {
+		errMsg := fmt.Sprintf("appDeployment %s/%s canary strategy require spec.deployPlatform.kubernetes.helm.release.", c.namespace, c.name)
+		return fmt.Errorf("%s", errMsg)
+	}
+	canary, err := copyAppInstance(&main)
+	if err != nil {
+		return err
+	}
+	canary.Metadata.Name = c.genKubenertesInstanceName()
+	canary.Spec.DeployPlatform.Kubernetes.Helm.Release += canaryReleaseSuffix
+	canary.Status.Batch = 1
+	main.Status.Batch = 2
+	*insts = []cmdb.AppInstance{*canary, main}
+	return nil
+}
+
+// 金丝雀批次完成后按分析窗口记录分析时间，由 RunRollouts 到期后分析
+func scheduleCanaryAnalysis(db *storage.Store, appDeploy *cmdb.AppDeployment, now time.Time) error {
+	rollout := appDeploy.Rollout
+	if rollout.AnalysisTime != nil {
+		return nil
+	}
+	strategy := canaryStrategy(appDeploy)
+	if strategy == nil {
+		strategy = &cmdb.CanaryStrategy{}
+	}
+	analysisTime := now.Add(time.Duration(strategy.AnalysisSeconds) * time.Second)
+	rollout.AnalysisTime = &analysisTime
+	rollout.Message = fmt.Sprintf("canary batch completed, analysis at %s", analysisTime.Format(time.RFC3339))
+	return db.Update(context.Background(), appDeploy, nil)
+}
+
+// 判断金丝雀分析是否到期
+func canaryAnalysisDue(appDeploy *cmdb.AppDeployment, now time.Time) bool {
+	rollout := appDeploy.Rollout
+	if appDeploy.Status != cmdb.AppDeploymentDeploying || rollout == nil || !rollout.Canary || rollout.Aborted ||
+		rollout.Paused || rollout.Batch != 1 || rollout.Analysis != "" {
+		return false
+	}
+	return rollout.AnalysisTime != nil && !rollout.AnalysisTime.After(now)
+}
+
+// 分析到期的金丝雀，通过时暂停等待 promote 或 abort，失败时中止发布
+func analyzeDueCanary(db *storage.Store, appDeploy *cmdb.AppDeployment, now time.Time) error {
+	name := appDeploy.Metadata.Name
+	namespace := appDeploy.Metadata.Namespace
+	lockName := rolloutLockName(name, namespace)
+	if _, err := db.AcquireLock(context.Background(), lockName, LockOwner, rolloutLockTTL); err != nil {
+		// 其他服务端正在分析
+		if storage.IsLocked(err) {
+			return nil
+		}
+		return err
+	}
+	defer db.ReleaseLock(context.Background(), lockName)
+	// 加锁后重新读取，确认未被分析，且期间未被中止或重新部署
+	latest, err := getAppDeployment(db, name, namespace)
+	if err != nil {
+		return err
+	}
+	if !canaryAnalysisDue(latest, now) || latest.DeployRevision != appDeploy.DeployRevision {
+		return nil
+	}
+	insts, err := liveAppInstances(db, name, namespace)
+	if err != nil {
+		return err
+	}
+	return pauseCanary(db, latest, canaryAnalysis(latest, currentAppInstances(latest, insts)))
+}
+
+// 探测金丝雀实例，返回分析结果
+func canaryAnalysis(appDeploy *cmdb.AppDeployment, insts []cmdb.AppInstance) string {
+	strategy := canaryStrategy(appDeploy)
+	if strategy == nil || !strategy.HealthCheck {
+		return canaryAnalysisPassed
+	}
+	for _, inst := range insts {
+		if inst.Status.Batch != 1 {
+			continue
+		}
+		if err := waitAppInstanceHealthy(&inst, 0); err != nil {
+			return "failed: " + err.Error()
+		}
+	}
+	return canaryAnalysisPassed
+}
+
+// 记录金丝雀分析结果，通过时暂停发布，失败时中止发布并等待 abort 回滚
+func pauseCanary(db *storage.Store, appDeploy *cmdb.AppDeployment, analysis string) error {
+	latest, err := getAppDeployment(db, appDeploy.Metadata.Name, appDeploy.Metadata.Namespace)
+	if err != nil {
+		return err
+	}
+	rollout := latest.Rollout
+	if latest.Status != cmdb.AppDeploymentDeploying || rollout == nil || !rollout.Canary || rollout.Aborted ||
+		latest.DeployRevision != appDeploy.DeployRevision || rollout.Batch != 1 {
+		return nil
+	}
+	rollout.Analysis = analysis
+	if analysis != canaryAnalysisPassed {
+		if err = db.Update(context.Background(), latest, nil); err != nil {
+			return err
+		}
+		_, err = haltRollout(db, latest.Metadata.Name, latest.Metadata.Namespace, "canary analysis "+analysis+", abort to roll back")
+		return err
+	}
+	rollout.Paused = true
+	rollout.Message = "canary analysis " + analysis + ", waiting for promote or abort"
+	return db.Update(context.Background(), latest, nil)
+}
+
+// 判断金丝雀是否因分析失败而中止，此时仅允许 abort 回滚金丝雀节点
+func canaryAnalysisFailed(appDeploy *cmdb.AppDeployment) bool {
+	rollout := appDeploy.Rollout
+	return appDeploy.Status == cmdb.AppDeploymentFailed && rollout != nil && rollout.Canary && !rollout.Aborted &&
+		rollout.Batch == 1 && rollout.Analysis != "" && rollout.Analysis != canaryAnalysisPassed
+}
+
+// 推广金丝雀，发布剩余的 AppInstance 并卸载 Kubernetes 金丝雀 release
+func (c *DeployController) runPromote() (*cmdb.AppDeployment, error) {
+	var insts []cmdb.AppInstance
+	var err error
+	if c.appDeploy, err = getAppDeployment(c.store, c.name, c.namespace); err != nil {
+		return nil, err
+	}
+	if insts, err = liveAppInstances(c.store, c.name, c.namespace); err != nil {
+		return nil, err
+	}
+	c.flowRunId = c.appDeploy.FlowRunId
+	c.revision = c.appDeploy.DeployRevision
+	rollout := *c.appDeploy.Rollout
+	rollout.Batch = 2
+	rollout.Paused = false
+	rollout.AnalysisTime = nil
+	rollout.Message = "canary promoted"
+	c.rollout = &rollout
+
+	next := []cmdb.AppInstance{}
+	for _, inst := range currentAppInstances(c.appDeploy, insts) {
+		if inst.Status.Batch == 2 {
+			next = append(next, inst)
+		} else if isCanaryRelease(&inst) {
+			if err = terminateAppInstance(c.store, &inst, c.flowRunId); err != nil {
+				return nil, err
+			}
+		}
+	}
+	c.newAppInstances = &next
+	if err = c.runPrefectDeployment(); err != nil {
+		return nil, err
+	}
+	if err = c.setAppInstanceStatus(); err != nil {
+		return nil, err
+	}
+	if c.appDeploy, err = getAppDeployment(c.store, c.name, c.namespace); err != nil {
+		return nil, err
+	}
+	c.appDeploy.Rollout = c.rollout
+	if err = c.store.Update(context.Background(), c.appDeploy, nil); err != nil {
+		return nil, err
+	}
+	return ReconcileAppDeployment(c.store, c.name, c.namespace)
+}
+
+// 中止金丝雀，删除未发布的 AppInstance，金丝雀节点回滚到上一版本，无上一版本时卸载
+func (c *DeployController) runAbort() (*cmdb.AppDeployment, error) {
+	var all, insts []cmdb.AppInstance
+	var err error
+	if c.appDeploy, err = getAppDeployment(c.store, c.name, c.namespace); err != nil {
+		return nil, err
+	}
+	if all, err = listAppInstances(c.store, c.name, c.namespace); err != nil {
+		return nil, err
+	}
+	if insts, err = liveAppInstances(c.store, c.name, c.namespace); err != nil {
+		return nil, err
+	}
+	if c.revision, err = c.nextRevision(); err != nil {
+		return nil, err
+	}
+	c.flowRunId = c.appDeploy.FlowRunId
+	revision := strconv.FormatInt(c.revision, 10)
+
+	rollbacks := []cmdb.AppInstance{}
+	for _, inst := range currentAppInstances(c.appDeploy, insts) {
+		if inst.Status.FlowRunStatus == cmdb.FlowRunPending || inst.Status.FlowRunStatus == cmdb.FlowRunCancelled {
+			// 未发布及分析失败后取消的 AppInstance 直接删除
+			if err = c.store.Delete(context.Background(), "AppInstance", inst.Metadata.Name, c.namespace); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		prev := previousAppInstance(all, &inst)
+		if prev == nil || isCanaryRelease(&inst) {
+			inst.Metadata.Labels[revisionLabel] = revision
+			if err = terminateAppInstance(c.store, &inst, c.flowRunId); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		rollback, err := copyAppInstance(prev)
+		if err != nil {
+			return nil, err
+		}
+		rollback.Metadata = *cmdb.NewResourceMeta(true)
+		rollback.Metadata.Name = truncNameLeft63(fmt.Sprintf("%s--%s--%s", prev.Spec.App, appInstanceTarget(prev), randomString(5)))
+		rollback.Metadata.Namespace = c.namespace
+		rollback.Metadata.Labels = maps.Clone(prev.Metadata.Labels)
+		rollback.Metadata.Labels[revisionLabel] = revision
+		rollback.Status = cmdb.AppInstanceStatus{FlowRunStatus: cmdb.FlowRunPending}
+		var out cmdb.Object
+		if err = c.store.Create(context.Background(), rollback, &out); err != nil {
+			return nil, err
+		}
+		if out, ok := out.(*cmdb.AppInstance); ok {
+			rollbacks = append(rollbacks, *out)
+		}
+	}
+	c.newAppInstances = &rollbacks
+	if err = c.runPrefectDeployment(); err != nil {
+		return nil, err
+	}
+	if err = c.setAppInstanceStatus(); err != nil {
+		return nil, err
+	}
+	if c.appDeploy, err = getAppDeployment(c.store, c.name, c.namespace); err != nil {
+		return nil, err
+	}
+	// 分析失败中止后 abort，重新进入部署中以跟踪回滚
+	c.appDeploy.Status = cmdb.AppDeploymentDeploying
+	c.appDeploy.DeployRevision = c.revision
+	c.appDeploy.Rollout = &cmdb.AppDeploymentRollout{
+		Canary:   true,
+		Aborted:  true,
+		Analysis: c.appDeploy.Rollout.Analysis,
+		Message:  "canary aborted",
+	}
+	if err = c.store.Update(context.Background(), c.appDeploy, nil); err != nil {
+		return nil, err
+	}
+	return ReconcileAppDeployment(c.store, c.name, c.namespace)
+}
+
+// 获取部署目标上一版本已完成发布的 AppInstance
+func previousAppInstance(insts []cmdb.AppInstance, current *cmdb.AppInstance) *cmdb.AppInstance {
+	var prev *cmdb.AppInstance
+	target := appInstanceTarget(current)
+	revision := appInstanceRevision(current)
+	for i := range insts {
+		inst := &insts[i]
+		if appInstanceTarget(inst) != target || appInstanceRevision(inst) >= revision {
+			continue
+		}
+		if inst.Status.Phase == cmdb.AppInstanceTerminating || inst.Status.FlowRunStatus != cmdb.FlowRunCompleted {
+			continue
+		}
+		prev = inst
+	}
+	return prev
+}
+
+// 标记 AppInstance 为卸载中，由编排器执行卸载
+func terminateAppInstance(db *storage.Store, inst *cmdb.AppInstance, flowRunId string) error {
+	inst.Status.Phase = cmdb.AppInstanceTerminating
+	inst.Status.FlowRunStatus = cmdb.FlowRunRunning
+	inst.FlowRunId = flowRunId
+	return db.Update(context.Background(), inst, nil)
+}
+
+// 深拷贝 AppInstance
+func copyAppInstance(inst *cmdb.AppInstance) (*cmdb.AppInstance, error) {
+	var instDict map[string]any
+	if err := conversion.StructToMap(inst, &instDict); err != nil {
+		return nil, err
+	}
+	obj, err := mapToObject(instDict)
+	if err != nil {
+		return nil, err
+	}
+	return obj.(*cmdb.AppInstance), nil
+}
@@ -0,0 +1,68 @@
+package deployment
+
+import (
+	"gcmdb/pkg/cmdb"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCanaryNodeCount(t *testing.T) {
+	tests := []struct {
+		strategy cmdb.CanaryStrategy
+		total    int
+		want     int
+	}{
+		{cmdb.CanaryStrategy{}, 5, 1},
+		{cmdb.CanaryStrategy{Nodes: 2}, 5, 2},
+		{cmdb.CanaryStrategy{Nodes: 8}, 5, 5},
+		{cmdb.CanaryStrategy{Percentage: 30}, 5, 2},
+		{cmdb.CanaryStrategy{Percentage: 10}, 5, 1},
+		{cmdb.CanaryStrategy{Nodes: 3, Percentage: 10}, 5, 3},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, canaryNodeCount(&tt.strategy, tt.total))
+	}
+}
+
+func TestCanaryStrategy(t *testing.T) {
+	appDeploy := cmdb.NewAppDeployment()
+	assert.Nil(t, canaryStrategy(appDeploy))
+	appDeploy.Spec.Strategy = &cmdb.DeployStrategy{Type: cmdb.DeployStrategyRolling}
+	assert.Nil(t, canaryStrategy(appDeploy))
+	appDeploy.Spec.Strategy = &cmdb.DeployStrategy{Type: cmdb.DeployStrategyCanary}
+	assert.Equal(t, &cmdb.CanaryStrategy{}, canaryStrategy(appDeploy))
+}
+
+func TestIsCanaryRelease(t *testing.T) {
+	inst := cmdb.NewAppInstance()
+	assert.False(t, isCanaryRelease(inst))
+	inst.Spec.DeployPlatform = &cmdb.ResourceRangeDeployPlatform{
+		Kubernetes: &cmdb.DPKubernetes{Name: "k8s", Namespace: "prod", Helm: &cmdb.DPHelm{Release: "go-app"}},
+	}
+	assert.False(t, isCanaryRelease(inst))
+	inst.Spec.DeployPlatform.Kubernetes.Helm.Release = "go-app" + canaryReleaseSuffix
+	assert.True(t, isCanaryRelease(inst))
+}
+
+func TestPreviousAppInstance(t *testing.T) {
+	newInst := func(name string, revision int64, status cmdb.FlowRunStatus) cmdb.AppInstance {
+		inst := cmdb.NewAppInstance()
+		inst.Metadata.Name = name
+		inst.Metadata.Labels[revisionLabel] = strconv.FormatInt(revision, 10)
+		inst.Spec.DeployPlatform = &cmdb.ResourceRangeDeployPlatform{
+			Docker: &cmdb.DeployPlatformDocker{NodeName: "node-1"},
+		}
+		inst.Status.FlowRunStatus = status
+		return *inst
+	}
+	insts := []cmdb.AppInstance{
+		newInst("rev-1", 1, cmdb.FlowRunCompleted),
+		newInst("rev-2", 2, cmdb.FlowRunFailed),
+		newInst("rev-3", 3, cmdb.FlowRunRunning),
+	}
+	prev := previousAppInstance(insts, &insts[2])
+	assert.Equal(t, "rev-1", prev.Metadata.Name)
+	assert.Nil(t, previousAppInstance(insts, &insts[0]))
+}
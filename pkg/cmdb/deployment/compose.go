@@ -0,0 +1,208 @@
+package deployment
+
+import (
+	"context"
+	"fmt"
+	"gcmdb/pkg/cmdb"
+	"gcmdb/pkg/cmdb/server/storage"
+	"strings"
+
+	"github.com/goccy/go-yaml"
+)
+
+const (
+	// 内置 DeployTemplate 中 docker-compose 文件的键名
+	composeFileName = "docker-compose.yml"
+	// 未指定 DeployTemplate 时使用的内置 DeployTemplate 名称
+	defaultDeployTemplateName = "docker-compose-default"
+)
+
+// Docker 工作负载信息，由渲染后的 AppDeployment 生成
+type dockerWorkload struct {
+	image  string
+	tag    string
+	labels map[string]string
+	spec   cmdb.ResourceRangeSpec
+}
+
+// 为匹配 nodeSelector 的每个 HostNode 生成 docker-compose 配置
+func RenderDockerCompose(db *storage.Store, name, namespace string, params map[string]any) (map[string]map[string]any, error) {
+	var objs []cmdb.Object
+	appDeploy, err := ResolveAppDeployment(db, name, namespace, params)
+	if err != nil {
+		return nil, err
+	}
+	w, err := newDockerWorkload(db, appDeploy, params)
+	if err != nil {
+		return nil, err
+	}
+	nodeSelector, err := dockerNodeSelector(appDeploy)
+	if err != nil {
+		return nil, err
+	}
+	listOpts := storage.ListOptions{LabelSelector: nodeSelector}
+	if err = db.GetList(context.Background(), "HostNode", "", listOpts, &objs); err != nil {
+		return nil, err
+	}
+	result := map[string]map[string]any{}
+	for _, o := range objs {
+		if hostNode, ok := o.(*cmdb.HostNode); ok {
+			result[hostNode.Metadata.Name] = w.composeFile(hostNode.Metadata.Name, hostNode.Spec.Ip)
+		}
+	}
+	return result, nil
+}
+
+func newDockerWorkload(db *storage.Store, appDeploy *cmdb.AppDeployment, params map[string]any) (*dockerWorkload, error) {
+	var err error
+	spec := appDeploy.Spec.Template.Spec
+	if spec.DeployPlatform == nil || spec.DeployPlatform.Docker == nil {
+		errMsg := fmt.Sprintf("appDeployment %s/%s deploy platform is not %s.", appDeploy.Metadata.Namespace, appDeploy.Metadata.Name, DPDocker)
+		return nil, fmt.Errorf("%s", errMsg)
+	}
+	w := &dockerWorkload{
+		tag:    imageTag(params),
+		labels: appDeploy.Spec.Template.Metadata.Labels,
+		spec:   spec,
+	}
+	if w.image, err = containerImage(db, spec.DeployPlatform.Docker.ContainerRegistry, spec.App); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// 生成内置 DeployTemplate，data 为当前 HostNode 的 docker-compose 文件
+func defaultDeployTemplate(db *storage.Store, appDeploy *cmdb.AppDeployment, params map[string]any) (*cmdb.DeployTemplate, error) {
+	w, err := newDockerWorkload(db, appDeploy, params)
+	if err != nil {
+		return nil, err
+	}
+	nodeName, _ := params["host_node_name"].(string)
+	nodeIp, _ := params["host_node_ip"].(string)
+	compose, err := yaml.MarshalWithOptions(w.composeFile(nodeName, nodeIp), yaml.AutoInt())
+	if err != nil {
+		return nil, err
+	}
+	deployTpl := cmdb.NewDeployTemplate()
+	deployTpl.Metadata.Name = defaultDeployTemplateName
+	deployTpl.Metadata.Namespace = appDeploy.Metadata.Namespace
+	deployTpl.Spec = cmdb.DeployTemplateSpec{
+		Command:    []string{"docker-compose"},
+		DeployArgs: fmt.Sprintf("--file %s up -d --wait", composeFileName),
+	}
+	deployTpl.Data = map[string]string{composeFileName: string(compose)}
+	return deployTpl, nil
+}
+
+func (w *dockerWorkload) ports() []any {
+	ports := []any{}
+	add := func(p *cmdb.ServicePort) {
+		if p == nil || p.Port == 0 {
+			return
+		}
+		port := fmt.Sprintf("%d:%d", p.Port, p.Port)
+		if p.Protocol != "" && !strings.EqualFold(p.Protocol, "tcp") {
+			port += "/" + strings.ToLower(p.Protocol)
+		}
+		ports = append(ports, port)
+	}
+	if w.spec.Ports != nil {
+		add(w.spec.Ports.Http)
+		add(w.spec.Ports.Metrics)
+	}
+	if len(ports) == 0 && w.spec.Monitoring != nil && w.spec.Monitoring.Probe != nil && w.spec.Monitoring.Probe.HttpGet.Port != "" {
+		port := w.spec.Monitoring.Probe.HttpGet.Port
+		ports = append(ports, port+":"+port)
+	}
+	return ports
+}
+
+func (w *dockerWorkload) resources() map[string]any {
+	resources := map[string]any{}
+	if w.spec.Resources == nil {
+		return resources
+	}
+	limit := func(l *cmdb.ResourceLimit) map[string]any {
+		m := map[string]any{}
+		if l == nil {
+			return m
+		}
+		if l.Cpu != "" {
+			m["cpus"] = l.Cpu
+		}
+		if l.Memory != "" {
+			m["memory"] = l.Memory
+		}
+		return m
+	}
+	if l := limit(w.spec.Resources.Limit); len(l) > 0 {
+		resources["limits"] = l
+	}
+	if r := limit(w.spec.Resources.Request); len(r) > 0 {
+		resources["reservations"] = r
+	}
+	return resources
+}
+
+// 容器内的健康检查，镜像中不一定有 curl 等探测工具，仅在配置 monitoring.probe.command 时生成
+func (w *dockerWorkload) healthcheck() map[string]any {
+	m := w.spec.Monitoring
+	if m == nil || m.Probe == nil || len(m.Probe.Command) == 0 {
+		return nil
+	}
+	test := []any{"CMD"}
+	for _, arg := range m.Probe.Command {
+		test = append(test, arg)
+	}
+	return map[string]any{
+		"test":         test,
+		"interval":     "5s",
+		"timeout":      "1s",
+		"retries":      10,
+		"start_period": "10s",
+	}
+}
+
+// 生成 HostNode 上的 docker-compose 配置
+func (w *dockerWorkload) composeFile(nodeName, nodeIp string) map[string]any {
+	env := map[string]any{}
+	for k, v := range w.spec.Env {
+		env[k] = v
+	}
+	labels := map[string]any{}
+	for k, v := range w.labels {
+		labels[k] = v
+	}
+	if nodeName != "" {
+		env["HOST_NODE_NAME"] = nodeName
+		labels["app.cmdb/host-node"] = nodeName
+	}
+	if nodeIp != "" {
+		env["HOST_NODE_IP"] = nodeIp
+	}
+	service := map[string]any{
+		"image":          w.image + ":" + w.tag,
+		"container_name": w.spec.App,
+		"restart":        "always",
+		"environment":    env,
+		"labels":         labels,
+	}
+	if len(w.spec.Command) > 0 {
+		service["entrypoint"] = w.spec.Command
+	}
+	if len(w.spec.Args) > 0 {
+		service["command"] = w.spec.Args
+	}
+	if ports := w.ports(); len(ports) > 0 {
+		service["ports"] = ports
+	}
+	if resources := w.resources(); len(resources) > 0 {
+		service["deploy"] = map[string]any{"resources": resources}
+	}
+	if healthcheck := w.healthcheck(); healthcheck != nil {
+		service["healthcheck"] = healthcheck
+	}
+	return map[string]any{
+		"services": map[string]any{w.spec.App: service},
+	}
+}
@@ -0,0 +1,53 @@
+package deployment
+
+import (
+	"gcmdb/pkg/cmdb"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestComposeFile(t *testing.T) {
+	w := &dockerWorkload{
+		image:  "harbor.dev.com/go-devops/go-app",
+		tag:    "v1.0.0",
+		labels: map[string]string{"project": "go-devops"},
+		spec: cmdb.ResourceRangeSpec{
+			App:     "go-app",
+			Command: []string{"python"},
+			Args:    []string{"autoapp.py"},
+			Env:     map[string]string{"ENV": "test"},
+			Resources: &cmdb.ResourceRangeResources{
+				Limit:   &cmdb.ResourceLimit{Cpu: "1.0", Memory: "2G"},
+				Request: &cmdb.ResourceLimit{Memory: "1G"},
+			},
+			Monitoring: &cmdb.ResourceRangeMonitoring{
+				Probe: &cmdb.MonitoringProbe{HttpGet: cmdb.MonitoringProbeHttpGet{Path: "/actuator/health", Port: "8000"}},
+			},
+		},
+	}
+	compose := w.composeFile("node-1", "10.0.0.1")
+	service := compose["services"].(map[string]any)["go-app"].(map[string]any)
+	assert.Equal(t, "harbor.dev.com/go-devops/go-app:v1.0.0", service["image"])
+	assert.Equal(t, []string{"python"}, service["entrypoint"])
+	assert.Equal(t, []string{"autoapp.py"}, service["command"])
+	assert.Equal(t, map[string]any{"ENV": "test", "HOST_NODE_NAME": "node-1", "HOST_NODE_IP": "10.0.0.1"}, service["environment"])
+	assert.Equal(t, "node-1", service["labels"].(map[string]any)["app.cmdb/host-node"])
+	assert.Equal(t, []any{"8000:8000"}, service["ports"])
+	assert.Equal(t, map[string]any{"resources": map[string]any{
+		"limits":       map[string]any{"cpus": "1.0", "memory": "2G"},
+		"reservations": map[string]any{"memory": "1G"},
+	}}, service["deploy"])
+	// 未配置探测命令时不生成 healthcheck，镜像中不一定有 curl
+	assert.Nil(t, service["healthcheck"])
+	w.spec.Monitoring.Probe.Command = []string{"wget", "-q", "-O-", "http://localhost:8000/actuator/health"}
+	healthcheck := w.healthcheck()
+	assert.Equal(t, []any{"CMD", "wget", "-q", "-O-", "http://localhost:8000/actuator/health"}, healthcheck["test"])
+
+	// 显式端口优先于探针端口
+	w.spec.Ports = &cmdb.ResourceRangePorts{
+		Http:    &cmdb.ServicePort{Port: 8080},
+		Metrics: &cmdb.ServicePort{Port: 9090, Protocol: "UDP"},
+	}
+	assert.Equal(t, []any{"8080:8080", "9090:9090/udp"}, w.ports())
+}
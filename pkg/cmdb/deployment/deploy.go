@@ -9,6 +9,7 @@ import (
 	"maps"
 	"math/rand"
 	"slices"
+	"strconv"
 	"strings"
 	"time"
 	"unicode"
@@ -17,8 +18,11 @@ import (
 type DeployAction string
 
 const (
-	DeployRelease DeployAction = "release"
-	DeployRestart DeployAction = "restart"
+	DeployRelease   DeployAction = "release"
+	DeployRestart   DeployAction = "restart"
+	DeployUninstall DeployAction = "uninstall"
+	DeployPromote   DeployAction = "promote"
+	DeployAbort     DeployAction = "abort"
 )
 
 type DeployPlatformType string
@@ -38,13 +42,21 @@ type DeployController struct {
 	name string
 	// AppDeployment Namespace
 	namespace string
-	// release | restart
+	// release | restart | uninstall | promote | abort
 	action          DeployAction
 	params          map[string]any
 	appDeploy       *cmdb.AppDeployment
 	newAppInstances *[]cmdb.AppInstance
 	// newAppInstanceRuns []
 	flowRunId string
+	// 本次部署的版本号
+	revision int64
+	// 分批发布进度，nil 表示一次性发布所有 AppInstance
+	rollout *cmdb.AppDeploymentRollout
+	// 部署发起人
+	requester string
+	// 已审批通过，不再检查审批策略
+	approved bool
 }
 
 func NewDeployController(db *storage.Store, action DeployAction, name, namespace string, params map[string]any) *DeployController {
@@ -58,12 +70,60 @@ func NewDeployController(db *storage.Store, action DeployAction, name, namespace
 	return c
 }
 
+// 设置部署发起人
+func (c *DeployController) WithRequester(user string) *DeployController {
+	c.requester = user
+	return c
+}
+
 func (c *DeployController) Run() (*cmdb.AppDeployment, error) {
-	// TODO: 运行 AppDeployment 部署
-	var err error
 	if err := c.preCheck(); err != nil {
 		return nil, err
 	}
+	if err := c.checkApproval(); err != nil {
+		return nil, err
+	}
+	// 部署锁由状态回调续期，部署结束或 lease 过期后释放
+	if err := c.acquireLock(); err != nil {
+		return nil, err
+	}
+	var result *cmdb.AppDeployment
+	var err error
+	switch c.action {
+	case DeployPromote:
+		result, err = c.runPromote()
+	case DeployAbort:
+		result, err = c.runAbort()
+	default:
+		result, err = c.run()
+	}
+	if err != nil {
+		if c.action == DeployPromote || c.action == DeployAbort {
+			// 金丝雀发布仍在进行中时保留部署锁
+			if appDeploy, getErr := getAppDeployment(c.store, c.name, c.namespace); getErr == nil {
+				syncDeployLock(c.store, appDeploy)
+			}
+		} else {
+			ReleaseDeployLock(c.store, c.name, c.namespace)
+		}
+		return c.notify(nil, err)
+	}
+	appDeploy, err := getAppDeployment(c.store, c.name, c.namespace)
+	if err != nil {
+		return nil, err
+	}
+	if err = syncDeployLock(c.store, appDeploy); err != nil {
+		return nil, err
+	}
+	return c.notify(result, nil)
+}
+
+func (c *DeployController) run() (*cmdb.AppDeployment, error) {
+	// TODO: 运行 AppDeployment 部署
+	var err error
+	if c.action == DeployUninstall {
+		return c.runUninstall()
+	}
 	// 解析 AppDeployment
 	if c.appDeploy, err = ResolveAppDeployment(c.store, c.name, c.namespace, c.params); err != nil {
 		return nil, err
@@ -88,28 +148,85 @@ func (c *DeployController) Run() (*cmdb.AppDeployment, error) {
 
 func (c *DeployController) preCheck() error {
 	// 预检查
-	// 检查 AppDeployment 是否在运行中
+	switch c.action {
+	case DeployRelease, DeployRestart, DeployUninstall, DeployPromote, DeployAbort:
+	default:
+		errMsg := fmt.Sprintf("deploy action %s no support.", c.action)
+		return fmt.Errorf("%s", errMsg)
+	}
 	var appDeploy cmdb.Object
 	if err := c.store.Get(context.Background(), "AppDeployment", c.name, c.namespace, storage.GetOptions{}, &appDeploy); err != nil {
 		return err
 	}
 	if appDeploy, ok := appDeploy.(*cmdb.AppDeployment); ok {
 		status := appDeploy.Status
-		if status == cmdb.AppDeploymentDeploying {
-			errMsg := "another operation (install/upgrade/rollback/uninstall) is in progress"
-			return fmt.Errorf("%s", errMsg)
-		} else if c.action == DeployRestart {
+		if c.action == DeployPromote || c.action == DeployAbort {
+			return c.preCheckCanary(appDeploy)
+		}
+		// 进行中的部署由部署锁互斥，状态可能因服务中断而残留
+		if c.action == DeployRestart || c.action == DeployUninstall {
 			switch status {
 			case cmdb.AppDeploymentNoneDeployed, cmdb.AppDeploymentUninstalled:
 				errMsg := fmt.Sprintf("appDeployment %s/%s status %s can't be %s.", c.namespace, c.name, status, c.action)
 				return fmt.Errorf("%s", errMsg)
 			}
 		}
+		// 参数不合法时不创建审批申请
+		if c.action == DeployRelease || c.action == DeployRestart {
+			if err := validateDeployParams(c.store, appDeploy, c.params); err != nil {
+				return err
+			}
+		}
 	}
 	// TODO: 检查 Prefect Deployment 是否存在
 	return nil
 }
 
+// promote 和 abort 仅适用于进行中的金丝雀发布，分析失败中止的金丝雀仅允许 abort
+func (c *DeployController) preCheckCanary(appDeploy *cmdb.AppDeployment) error {
+	rollout := appDeploy.Rollout
+	if c.action == DeployAbort && canaryAnalysisFailed(appDeploy) {
+		return nil
+	}
+	if appDeploy.Status != cmdb.AppDeploymentDeploying || rollout == nil || !rollout.Canary || rollout.Aborted || rollout.Batch != 1 {
+		errMsg := fmt.Sprintf("appDeployment %s/%s has no canary in progress, can't be %s.", c.namespace, c.name, c.action)
+		return fmt.Errorf("%s", errMsg)
+	}
+	if c.action == DeployPromote && !rollout.Paused {
+		errMsg := fmt.Sprintf("appDeployment %s/%s canary analysis not finished, can't be %s.", c.namespace, c.name, c.action)
+		return fmt.Errorf("%s", errMsg)
+	}
+	if c.action == DeployPromote && rollout.Analysis != canaryAnalysisPassed {
+		errMsg := fmt.Sprintf("appDeployment %s/%s canary analysis %s, can't be %s.", c.namespace, c.name, rollout.Analysis, c.action)
+		return fmt.Errorf("%s", errMsg)
+	}
+	return nil
+}
+
+// 卸载 AppDeployment，为每个存活的 AppInstance 生成卸载任务
+func (c *DeployController) runUninstall() (*cmdb.AppDeployment, error) {
+	var insts []cmdb.AppInstance
+	var err error
+	if insts, err = liveAppInstances(c.store, c.name, c.namespace); err != nil {
+		return nil, err
+	}
+	if len(insts) == 0 {
+		// 没有存活的实例，无需卸载
+		return setAppDeploymentStatus(c.store, c.name, c.namespace, cmdb.AppDeploymentUninstalled)
+	}
+	c.newAppInstances = &insts
+	if err = c.runPrefectDeployment(); err != nil {
+		return nil, err
+	}
+	if err = c.setAppDeploymentStartStatus(); err != nil {
+		return nil, err
+	}
+	if err = c.setAppInstanceStatus(); err != nil {
+		return nil, err
+	}
+	return getAppDeployment(c.store, c.name, c.namespace)
+}
+
 func (c *DeployController) createNewAppInstances() error {
 	// 根据 AppDeployment 创建 AppInstance
 	// TODO: 同时创建 AppInstanceRun
@@ -117,8 +234,15 @@ func (c *DeployController) createNewAppInstances() error {
 	if err != nil {
 		return err
 	}
+	if c.revision, err = c.nextRevision(); err != nil {
+		return err
+	}
+	if err = c.planRollout(insts); err != nil {
+		return err
+	}
 	newAppInstances := []cmdb.AppInstance{}
 	for _, inst := range *insts {
+		inst.Metadata.Labels[revisionLabel] = strconv.FormatInt(c.revision, 10)
 		var out cmdb.Object
 		if err = c.store.Create(context.Background(), &inst, &out); err != nil {
 			return err
@@ -144,7 +268,13 @@ func (c *DeployController) setAppDeploymentStartStatus() error {
 	}
 	if appDeploy, ok := appDeploy.(*cmdb.AppDeployment); ok {
 		appDeploy.Status = cmdb.AppDeploymentDeploying
+		if c.action == DeployUninstall {
+			appDeploy.Status = cmdb.AppDeploymentUninstalling
+		} else {
+			appDeploy.DeployRevision = c.revision
+		}
 		appDeploy.FlowRunId = c.flowRunId
+		appDeploy.Rollout = c.rollout
 	}
 	if err := c.store.Update(context.Background(), appDeploy, nil); err != nil {
 		return err
@@ -160,7 +290,14 @@ func (c *DeployController) setAppInstanceStatus() error {
 			return err
 		}
 		if appDeploy, ok := instInDB.(*cmdb.AppInstance); ok {
+			if c.rollout != nil && appDeploy.Status.Batch != c.rollout.Batch {
+				// 非当前批次的 AppInstance 等待发布
+				continue
+			}
 			appDeploy.Status.FlowRunStatus = cmdb.FlowRunRunning
+			if c.action == DeployUninstall {
+				appDeploy.Status.Phase = cmdb.AppInstanceTerminating
+			}
 			appDeploy.FlowRunId = c.flowRunId
 		}
 		if err := c.store.Update(context.Background(), instInDB, nil); err != nil {
@@ -225,7 +362,7 @@ func (c *DeployController) genKubenertesAppInstance() (*[]cmdb.AppInstance, erro
 		},
 		"spec":           spec,
 		"deployTemplate": deployTemplate,
-		"status":         "pending",
+		"status":         map[string]any{"flowRunStatus": "pending"},
 	}
 	obj, err := mapToObject(appInstDict)
 	if err != nil {
@@ -244,10 +381,9 @@ func (c *DeployController) genDockerAppInstance() (*[]cmdb.AppInstance, error) {
 	var deployTemplateResolved *cmdb.DeployTemplate
 	var spec, deployTemplate map[string]any
 	var err error
-	nodeSelector := c.appDeploy.Spec.Template.Spec.NodeSelector
-	if nodeSelector == nil {
-		errMsg := "spec.nodeSelector 字段不允许为空"
-		return nil, fmt.Errorf("%s", errMsg)
+	nodeSelector, err := dockerNodeSelector(c.appDeploy)
+	if err != nil {
+		return nil, err
 	}
 	labels := c.appDeploy.Metadata.Labels
 	namespace := c.namespace
@@ -269,6 +405,10 @@ func (c *DeployController) genDockerAppInstance() (*[]cmdb.AppInstance, error) {
 		}
 	}
 	c.filterRestartHostNode(&hostNodes)
+	if len(hostNodes) == 0 {
+		errMsg := fmt.Sprintf("未找到指定的节点(hostnode:%v)", c.params["hostnode"])
+		return nil, fmt.Errorf("%s", errMsg)
+	}
 	for _, hostNode := range hostNodes {
 		nodeName := hostNode.Metadata.Name
 		nodeIp := hostNode.Spec.Ip
@@ -317,6 +457,33 @@ func (c *DeployController) genDockerAppInstance() (*[]cmdb.AppInstance, error) {
 	return &appInstances, nil
 }
 
+// Docker 部署的节点选择器，为空时会匹配所有 HostNode，因此不允许为空
+func dockerNodeSelector(appDeploy *cmdb.AppDeployment) (map[string]string, error) {
+	nodeSelector := appDeploy.Spec.Template.Spec.NodeSelector
+	if len(nodeSelector) == 0 {
+		errMsg := "spec.nodeSelector 字段不允许为空"
+		return nil, fmt.Errorf("%s", errMsg)
+	}
+	return nodeSelector, nil
+}
+
+// 计算本次部署的版本号
+func (c *DeployController) nextRevision() (int64, error) {
+	appDeploy, err := getAppDeployment(c.store, c.name, c.namespace)
+	if err != nil {
+		return 0, err
+	}
+	insts, err := listAppInstances(c.store, c.name, c.namespace)
+	if err != nil {
+		return 0, err
+	}
+	revision := appDeploy.DeployRevision
+	for _, inst := range insts {
+		revision = max(revision, appInstanceRevision(&inst))
+	}
+	return revision + 1, nil
+}
+
 func (c *DeployController) genKubenertesInstanceName() string {
 	randomStr := randomString(5)
 	k8sCluster := c.appDeploy.Spec.Template.Spec.DeployPlatform.Kubernetes.Name
@@ -333,16 +500,12 @@ func (c *DeployController) genDockerInstanceName(hostNodeName string) string {
 	return truncNameLeft63(name)
 }
 
+// 指定 hostnode 参数时，仅保留指定的 HostNode
 func (c *DeployController) filterRestartHostNode(hostNodes *[]cmdb.HostNode) {
 	if specifyHostnode, ok := c.params["hostnode"]; ok {
-		nodes := strings.Split(specifyHostnode.(string), ",")
+		nodes := strings.Split(fmt.Sprint(specifyHostnode), ",")
 		*hostNodes = slices.DeleteFunc(*hostNodes, func(e cmdb.HostNode) bool {
-			for _, h := range nodes {
-				if e.Metadata.Name == h {
-					return true
-				}
-			}
-			return false
+			return !slices.Contains(nodes, e.Metadata.Name)
 		})
 	}
 }
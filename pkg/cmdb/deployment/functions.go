@@ -0,0 +1,132 @@
+package deployment
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"gcmdb/pkg/cmdb"
+	"gcmdb/pkg/cmdb/conversion"
+	"gcmdb/pkg/cmdb/server/storage"
+	"slices"
+
+	"github.com/nikolalohinski/gonja/v2/exec"
+)
+
+// 命名空间未设置 templateAccess.kinds 时，模板可通过 lookup 读取的资源类型，
+// 不包括含认证信息的 HelmRepository、ContainerRegistry、ConfigCenter、DeployPlatform，需在 kinds 中显式允许
+var DefaultTemplateLookupKinds = []string{
+	"Project", "Datacenter", "Zone", "Namespace", "HostNode",
+	"App", "ResourceRange", "Orchestration", "AppDeployment",
+}
+
+// 模板读取了命名空间不允许读取的资源
+type TemplateAccessError struct {
+	Message string
+}
+
+func (e TemplateAccessError) Error() string {
+	return e.Message
+}
+
+// 渲染模板时可用的 secret、lookup 函数，仅可读取命名空间 templateAccess 允许的资源
+type templateFuncs struct {
+	db        *storage.Store
+	namespace string
+	kinds     []string
+	secrets   []string
+	// 首个被拒绝的访问，渲染失败时代替 gonja 的错误返回
+	denied error
+}
+
+func newTemplateFuncs(db *storage.Store, namespace string) (*templateFuncs, error) {
+	f := &templateFuncs{db: db, namespace: namespace, kinds: DefaultTemplateLookupKinds}
+	var obj cmdb.Object
+	if err := db.Get(context.Background(), "Namespace", namespace, "", storage.GetOptions{}, &obj); err != nil {
+		if storage.IsNotFound(err) {
+			return f, nil
+		}
+		return nil, err
+	}
+	if access := obj.(*cmdb.Namespace).Spec.TemplateAccess; access != nil {
+		if len(access.Kinds) > 0 {
+			f.kinds = access.Kinds
+		}
+		f.secrets = access.Secrets
+	}
+	return f, nil
+}
+
+func (f *templateFuncs) functions() map[string]any {
+	return map[string]any{"secret": f.secret, "lookup": f.lookup}
+}
+
+// 渲染失败时优先返回访问被拒绝的错误
+func (f *templateFuncs) renderErr(err error) error {
+	if f.denied != nil {
+		return f.denied
+	}
+	return err
+}
+
+func (f *templateFuncs) deny(format string, args ...any) error {
+	err := TemplateAccessError{Message: fmt.Sprintf(format, args...)}
+	if f.denied == nil {
+		f.denied = err
+	}
+	return err
+}
+
+// secret('name', 'key') 返回 Secret.Data 中解码后的值
+func (f *templateFuncs) secret(name, key string) (string, error) {
+	if !slices.Contains(f.secrets, name) {
+		return "", f.deny("secret %s is not allowed in templates of namespace %s.", name, f.namespace)
+	}
+	var obj cmdb.Object
+	if err := f.db.Get(context.Background(), "Secret", name, "", storage.GetOptions{}, &obj); err != nil {
+		return "", err
+	}
+	value, ok := obj.(*cmdb.Secret).Data[key]
+	if !ok {
+		errMsg := fmt.Sprintf("secret %s has no key %s.", name, key)
+		return "", fmt.Errorf("%s", errMsg)
+	}
+	byts, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return "", err
+	}
+	return string(byts), nil
+}
+
+// lookup('kind', 'name'[, 'namespace']) 返回资源详情，命名空间内的资源仅可读取本命名空间的
+func (f *templateFuncs) lookup(_ *exec.Evaluator, params *exec.VarArgs) (map[string]any, error) {
+	var kind, name, namespace string
+	if err := params.Take(
+		exec.PositionalArgument("kind", nil, exec.StringArgument(&kind)),
+		exec.PositionalArgument("name", nil, exec.StringArgument(&name)),
+		exec.KeywordArgument("namespace", exec.AsValue(""), exec.StringArgument(&namespace)),
+	); err != nil {
+		return nil, exec.ErrInvalidCall(err)
+	}
+	if !slices.Contains(f.kinds, kind) {
+		return nil, f.deny("lookup of kind %s is not allowed in templates of namespace %s.", kind, f.namespace)
+	}
+	obj, err := cmdb.NewResourceWithKind(kind)
+	if err != nil {
+		return nil, err
+	}
+	if !obj.GetMeta().HasNamespace() {
+		namespace = ""
+	} else if namespace == "" {
+		namespace = f.namespace
+	} else if namespace != f.namespace {
+		return nil, f.deny("lookup of %s in namespace %s is not allowed in templates of namespace %s.", kind, namespace, f.namespace)
+	}
+	if err = f.db.Get(context.Background(), kind, name, namespace, storage.GetOptions{}, &obj); err != nil {
+		return nil, err
+	}
+	result := map[string]any{}
+	if err = conversion.StructToMap(obj, &result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
@@ -0,0 +1,33 @@
+package deployment
+
+import (
+	"gcmdb/pkg/cmdb/runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTemplateFuncsDenied(t *testing.T) {
+	for _, tpl := range []string{
+		"${ secret('db', 'password') }",
+		"${ lookup('Secret', 'db') }",
+		"${ lookup('AppDeployment', 'go-app', namespace='prod') }",
+		"${ lookup('Project', 'devops') }",
+		"${ lookup('ContainerRegistry', 'harbor') }",
+	} {
+		f := &templateFuncs{namespace: "test", kinds: []string{"AppDeployment"}, secrets: []string{"other"}}
+		_, err := runtime.RenderTemplateWithOptions(tpl, nil, runtime.RenderOptions{Functions: f.functions()})
+		assert.Error(t, err, tpl)
+		assert.IsType(t, TemplateAccessError{}, f.renderErr(err), tpl)
+	}
+
+	// 含认证信息的类型默认不可读取
+	f := &templateFuncs{namespace: "test", kinds: DefaultTemplateLookupKinds}
+	for _, kind := range []string{"ContainerRegistry", "HelmRepository", "ConfigCenter", "DeployPlatform"} {
+		_, err := runtime.RenderTemplateWithOptions("${ lookup('"+kind+"', 'x') }", nil, runtime.RenderOptions{Functions: f.functions()})
+		assert.IsType(t, TemplateAccessError{}, f.renderErr(err), kind)
+		f.denied = nil
+	}
+	_, err := runtime.RenderTemplateWithOptions("${ lookup('Project') }", nil, runtime.RenderOptions{Functions: f.functions()})
+	assert.ErrorContains(t, f.renderErr(err), "missing required 2nd positional argument 'name'")
+}
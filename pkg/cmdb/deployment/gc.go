@@ -0,0 +1,206 @@
+package deployment
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"gcmdb/pkg/cmdb"
+	"gcmdb/pkg/cmdb/server/storage"
+	"log"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/imroc/req/v3"
+)
+
+// 未设置 revisionHistoryLimit 时保留的历史版本数
+const defaultRevisionHistoryLimit = 10
+
+func revisionHistoryLimit(appDeploy *cmdb.AppDeployment) int {
+	if limit := appDeploy.Spec.RevisionHistoryLimit; limit != nil {
+		return max(*limit, 0)
+	}
+	return defaultRevisionHistoryLimit
+}
+
+// 正在回收的 AppDeployment，值表示回收期间是否有新的回收请求
+var gcPending sync.Map
+
+// 异步回收 AppInstance，删除 flow run 较慢，不阻塞状态回调。
+// 回收进行中时再次请求，结束后重新回收一次；失败时记录日志，下次部署结束时重试
+func gcAppInstancesAsync(db *storage.Store, name, namespace string) {
+	key := namespace + "/" + name
+	if _, loaded := gcPending.LoadOrStore(key, false); loaded {
+		gcPending.Store(key, true)
+		return
+	}
+	go func() {
+		for {
+			if _, err := GCAppInstances(db, name, namespace); err != nil {
+				log.Printf("appDeployment %s gc: %s", key, err)
+			}
+			if gcPending.CompareAndDelete(key, false) {
+				return
+			}
+			gcPending.Store(key, false)
+		}
+	}()
+}
+
+// 回收超出历史版本数的 AppInstance 及其不再被引用的 flow run，返回回收的 AppInstance 名称。
+// 存活、当前版本及 flow run 未结束的 AppInstance 不会被回收
+func GCAppInstances(db *storage.Store, name, namespace string) ([]string, error) {
+	appDeploy, err := getAppDeployment(db, name, namespace)
+	if err != nil {
+		return nil, err
+	}
+	insts, err := listAppInstances(db, name, namespace)
+	if err != nil {
+		return nil, err
+	}
+	live, err := liveAppInstances(db, name, namespace)
+	if err != nil {
+		return nil, err
+	}
+	superseded := supersededAppInstances(appDeploy, insts, live)
+	// 先删除 flow run，失败时保留 AppInstance 以便下次重试
+	for _, id := range orphanFlowRuns(appDeploy, insts, superseded) {
+		if err = deleteFlowRun(id); err != nil {
+			return nil, err
+		}
+	}
+	deleted := []string{}
+	for _, inst := range superseded {
+		if err = db.Delete(context.Background(), "AppInstance", inst.Metadata.Name, namespace); err != nil && !storage.IsNotFound(err) {
+			return deleted, err
+		}
+		deleted = append(deleted, inst.Metadata.Name)
+	}
+	return deleted, nil
+}
+
+// AppInstance 所属的发布历史
+type appInstanceHistory struct {
+	// 带版本标签的 AppInstance 为版本号，无标签的为 flow run id
+	key     string
+	labeled bool
+	// 带标签时为版本号，无标签时为最新创建的 AppInstance 的 etcd create revision
+	order int64
+}
+
+// 版本标签引入之前创建的 AppInstance 没有版本标签，按 flow run 区分每次发布，
+// 视为早于所有带标签的版本，各占一个历史版本
+func historyOf(inst *cmdb.AppInstance) appInstanceHistory {
+	if _, ok := inst.Metadata.Labels[revisionLabel]; ok {
+		revision := appInstanceRevision(inst)
+		return appInstanceHistory{key: strconv.FormatInt(revision, 10), labeled: true, order: revision}
+	}
+	key := inst.FlowRunId
+	if key == "" {
+		key = inst.Metadata.Name
+	}
+	return appInstanceHistory{key: "flowrun/" + key, order: inst.Metadata.CreateRevision}
+}
+
+// 是否为 AppDeployment 当前版本，无版本标签时按当前 flow run 判断
+func currentHistory(appDeploy *cmdb.AppDeployment, inst *cmdb.AppInstance) bool {
+	if history := historyOf(inst); history.labeled {
+		return appInstanceRevision(inst) == appDeploy.DeployRevision
+	}
+	return appDeploy.FlowRunId != "" && inst.FlowRunId == appDeploy.FlowRunId
+}
+
+// 超出历史版本数且已不再存活的 AppInstance
+func supersededAppInstances(appDeploy *cmdb.AppDeployment, insts, live []cmdb.AppInstance) []cmdb.AppInstance {
+	liveNames := []string{}
+	for _, inst := range live {
+		liveNames = append(liveNames, inst.Metadata.Name)
+	}
+	// 历史版本按从新到旧排列，保留前 limit 个
+	histories := map[string]appInstanceHistory{}
+	for _, inst := range insts {
+		if currentHistory(appDeploy, &inst) {
+			continue
+		}
+		history := historyOf(&inst)
+		if prev, ok := histories[history.key]; !ok || history.order > prev.order {
+			histories[history.key] = history
+		}
+	}
+	ordered := slices.Collect(maps.Values(histories))
+	slices.SortFunc(ordered, func(a, b appInstanceHistory) int {
+		if a.labeled != b.labeled {
+			if a.labeled {
+				return -1
+			}
+			return 1
+		}
+		return cmp.Compare(b.order, a.order)
+	})
+	kept := []string{}
+	for _, history := range ordered[:min(revisionHistoryLimit(appDeploy), len(ordered))] {
+		kept = append(kept, history.key)
+	}
+
+	superseded := []cmdb.AppInstance{}
+	for _, inst := range insts {
+		if currentHistory(appDeploy, &inst) || slices.Contains(kept, historyOf(&inst).key) ||
+			slices.Contains(liveNames, inst.Metadata.Name) || flowRunActive(inst.Status.FlowRunStatus) {
+			continue
+		}
+		superseded = append(superseded, inst)
+	}
+	return superseded
+}
+
+// 回收 AppInstance 后不再被 AppDeployment 及其余 AppInstance 引用的 flow run
+func orphanFlowRuns(appDeploy *cmdb.AppDeployment, insts, superseded []cmdb.AppInstance) []string {
+	deleted := []string{}
+	for _, inst := range superseded {
+		deleted = append(deleted, inst.Metadata.Name)
+	}
+	referenced := []string{appDeploy.FlowRunId}
+	for _, inst := range insts {
+		if !slices.Contains(deleted, inst.Metadata.Name) {
+			referenced = append(referenced, inst.FlowRunId)
+		}
+	}
+	orphans := []string{}
+	for _, inst := range superseded {
+		if inst.FlowRunId != "" && !slices.Contains(referenced, inst.FlowRunId) && !slices.Contains(orphans, inst.FlowRunId) {
+			orphans = append(orphans, inst.FlowRunId)
+		}
+	}
+	return orphans
+}
+
+// 删除 Prefect 中的 flow run 及其日志，未配置 Prefect API 时跳过
+func deleteFlowRun(flowRunId string) error {
+	if PrefectApiUrl == "" {
+		return nil
+	}
+	url := strings.TrimSuffix(PrefectApiUrl, "/") + "/flow_runs/" + flowRunId
+	resp, err := req.C().SetTimeout(10 * time.Second).R().Delete(url)
+	if err != nil {
+		return err
+	}
+	if resp.IsErrorState() && resp.StatusCode != http.StatusNotFound {
+		errMsg := fmt.Sprintf("delete flow run %s failed, orchestrator response code %d: %s", flowRunId, resp.StatusCode, resp.String())
+		return fmt.Errorf("%s", errMsg)
+	}
+	return nil
+}
+
+// flow run 是否正在运行，历史版本中未发布的 pending AppInstance 不会再运行
+func flowRunActive(status cmdb.FlowRunStatus) bool {
+	switch status {
+	case cmdb.FlowRunRunning, cmdb.FlowRunPaused, cmdb.FlowRunCancelling:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,144 @@
+package deployment
+
+import (
+	"gcmdb/pkg/cmdb"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRevisionHistoryLimit(t *testing.T) {
+	appDeploy := cmdb.NewAppDeployment()
+	assert.Equal(t, defaultRevisionHistoryLimit, revisionHistoryLimit(appDeploy))
+	limit := 0
+	appDeploy.Spec.RevisionHistoryLimit = &limit
+	assert.Equal(t, 0, revisionHistoryLimit(appDeploy))
+}
+
+func TestSupersededAppInstances(t *testing.T) {
+	newInst := func(name string, revision int, status cmdb.FlowRunStatus) cmdb.AppInstance {
+		inst := cmdb.NewAppInstance()
+		inst.Metadata.Name = name
+		inst.Metadata.Labels = map[string]string{revisionLabel: strconv.Itoa(revision)}
+		inst.Status.FlowRunStatus = status
+		return *inst
+	}
+	insts := []cmdb.AppInstance{
+		newInst("rev0", 0, cmdb.FlowRunCompleted),
+		newInst("rev1", 1, cmdb.FlowRunCompleted),
+		newInst("rev1-running", 1, cmdb.FlowRunRunning),
+		newInst("rev1-pending", 1, cmdb.FlowRunPending),
+		newInst("rev2-live", 2, cmdb.FlowRunCompleted),
+		newInst("rev3", 3, cmdb.FlowRunFailed),
+		newInst("rev4", 4, cmdb.FlowRunCompleted),
+	}
+	live := []cmdb.AppInstance{insts[4], insts[6]}
+	appDeploy := cmdb.NewAppDeployment()
+	appDeploy.DeployRevision = 4
+	limit := 1
+	appDeploy.Spec.RevisionHistoryLimit = &limit
+
+	names := func(insts []cmdb.AppInstance) []string {
+		result := []string{}
+		for _, inst := range insts {
+			result = append(result, inst.Metadata.Name)
+		}
+		return result
+	}
+	assert.Equal(t, []string{"rev0", "rev1", "rev1-pending"}, names(supersededAppInstances(appDeploy, insts, live)))
+	limit = 0
+	assert.Equal(t, []string{"rev0", "rev1", "rev1-pending", "rev3"}, names(supersededAppInstances(appDeploy, insts, live)))
+	limit = 10
+	assert.Equal(t, []string{}, names(supersededAppInstances(appDeploy, insts, live)))
+}
+
+func TestSupersededLegacyAppInstances(t *testing.T) {
+	newInst := func(name, flowRunId string, createRevision int64, labels map[string]string) cmdb.AppInstance {
+		inst := cmdb.NewAppInstance()
+		inst.Metadata.Name = name
+		inst.Metadata.CreateRevision = createRevision
+		inst.Metadata.Labels = labels
+		inst.FlowRunId = flowRunId
+		inst.Status.FlowRunStatus = cmdb.FlowRunCompleted
+		return *inst
+	}
+	// 无版本标签的 AppInstance 按 flow run 各占一个历史版本，早于带标签的版本
+	insts := []cmdb.AppInstance{
+		newInst("legacy-a1", "flow-a", 10, nil),
+		newInst("legacy-a2", "flow-a", 11, nil),
+		newInst("legacy-b", "flow-b", 20, nil),
+		newInst("legacy-c", "flow-c", 30, map[string]string{}),
+		newInst("rev1", "flow-1", 40, map[string]string{revisionLabel: "1"}),
+		newInst("rev2", "flow-2", 50, map[string]string{revisionLabel: "2"}),
+	}
+	appDeploy := cmdb.NewAppDeployment()
+	appDeploy.DeployRevision = 2
+	appDeploy.FlowRunId = "flow-2"
+	limit := 3
+	appDeploy.Spec.RevisionHistoryLimit = &limit
+	names := func(insts []cmdb.AppInstance) []string {
+		result := []string{}
+		for _, inst := range insts {
+			result = append(result, inst.Metadata.Name)
+		}
+		return result
+	}
+	superseded := supersededAppInstances(appDeploy, insts, nil)
+	assert.Equal(t, []string{"legacy-a1", "legacy-a2"}, names(superseded))
+	assert.Equal(t, []string{"flow-a"}, orphanFlowRuns(appDeploy, insts, superseded))
+
+	// 尚未按版本发布过时，当前 flow run 的 AppInstance 为当前版本
+	appDeploy.DeployRevision = 0
+	appDeploy.FlowRunId = "flow-c"
+	limit = 0
+	insts = insts[:4]
+	superseded = supersededAppInstances(appDeploy, insts, nil)
+	assert.Equal(t, []string{"legacy-a1", "legacy-a2", "legacy-b"}, names(superseded))
+	assert.Equal(t, []string{"flow-a", "flow-b"}, orphanFlowRuns(appDeploy, insts, superseded))
+}
+
+func TestOrphanFlowRuns(t *testing.T) {
+	newInst := func(name, flowRunId string) cmdb.AppInstance {
+		inst := cmdb.NewAppInstance()
+		inst.Metadata.Name = name
+		inst.FlowRunId = flowRunId
+		return *inst
+	}
+	insts := []cmdb.AppInstance{newInst("a", "flow-1"), newInst("b", "flow-1"), newInst("c", "flow-2"), newInst("d", ""), newInst("e", "flow-3")}
+	appDeploy := cmdb.NewAppDeployment()
+	appDeploy.FlowRunId = "flow-3"
+	// flow-1 仍被 b 引用，flow-3 为当前 flow run
+	assert.Equal(t, []string{"flow-2"}, orphanFlowRuns(appDeploy, insts, []cmdb.AppInstance{insts[0], insts[2], insts[3], insts[4]}))
+	assert.Equal(t, []string{"flow-1", "flow-2"}, orphanFlowRuns(appDeploy, insts, insts[:4]))
+}
+
+func TestDeleteFlowRun(t *testing.T) {
+	var deleted []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodDelete, r.Method)
+		switch r.URL.Path {
+		case "/api/flow_runs/flow-1":
+			deleted = append(deleted, "flow-1")
+			w.WriteHeader(http.StatusNoContent)
+		case "/api/flow_runs/flow-2":
+			w.WriteHeader(http.StatusNotFound)
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer ts.Close()
+
+	// 未配置 Prefect API 时跳过
+	assert.NoError(t, deleteFlowRun("flow-1"))
+	assert.Empty(t, deleted)
+
+	PrefectApiUrl = ts.URL + "/api/"
+	defer func() { PrefectApiUrl = "" }()
+	assert.NoError(t, deleteFlowRun("flow-1"))
+	assert.Equal(t, []string{"flow-1"}, deleted)
+	assert.NoError(t, deleteFlowRun("flow-2"))
+	assert.Error(t, deleteFlowRun("flow-3"))
+}
@@ -0,0 +1,96 @@
+package deployment
+
+import (
+	"context"
+	"fmt"
+	"gcmdb/pkg/cmdb"
+	"gcmdb/pkg/cmdb/server/storage"
+	"os"
+)
+
+// 部署锁的有效期(秒)，部署进行中由状态回调及分批发布推进循环续期，flow run 运行时间可超过有效期
+var LockTTL int64 = 600
+
+// 部署锁的持有者标识
+var LockOwner = defaultLockOwner()
+
+func defaultLockOwner() string {
+	hostname, _ := os.Hostname()
+	return fmt.Sprintf("%s/%d", hostname, os.Getpid())
+}
+
+func deployLockName(name, namespace string) string {
+	return fmt.Sprintf("appdeployments/%s/%s", namespace, name)
+}
+
+// 查询 AppDeployment 的部署锁
+func GetDeployLock(db *storage.Store, name, namespace string) (*storage.Lock, error) {
+	return db.GetLock(context.Background(), deployLockName(name, namespace))
+}
+
+// 强制释放 AppDeployment 的部署锁
+func ReleaseDeployLock(db *storage.Store, name, namespace string) (*storage.Lock, error) {
+	return db.ReleaseLock(context.Background(), deployLockName(name, namespace))
+}
+
+// 部署锁的持有者，记录发起人、服务端及操作
+func (c *DeployController) lockOwner() string {
+	owner := fmt.Sprintf("%s/%s", LockOwner, c.action)
+	if c.requester != "" {
+		owner = c.requester + "@" + owner
+	}
+	return owner
+}
+
+// 获取部署锁，promote 和 abort 接管进行中的金丝雀发布持有的锁
+func (c *DeployController) acquireLock() error {
+	lockName := deployLockName(c.name, c.namespace)
+	if c.action == DeployPromote || c.action == DeployAbort {
+		lock, err := c.store.GetLock(context.Background(), lockName)
+		if err == nil {
+			_, err = c.store.TakeOverLock(context.Background(), lockName, c.lockOwner(), lock.LeaseID, LockTTL)
+			return err
+		}
+		// 锁已过期或分析失败中止后已释放
+		if !storage.IsNotFound(err) {
+			return err
+		}
+	}
+	_, err := c.store.AcquireLock(context.Background(), lockName, c.lockOwner(), LockTTL)
+	return err
+}
+
+// 部署或卸载进行中且存在运行中的 flow run，此时部署锁由推进循环续期，
+// 强制释放的锁不会被重新获取，编排器异常导致状态残留时可通过强制释放恢复
+func flowRunsActive(db *storage.Store, appDeploy *cmdb.AppDeployment) (bool, error) {
+	if appDeploy.Status != cmdb.AppDeploymentDeploying && appDeploy.Status != cmdb.AppDeploymentUninstalling {
+		return false, nil
+	}
+	insts, err := liveAppInstances(db, appDeploy.Metadata.Name, appDeploy.Metadata.Namespace)
+	if err != nil {
+		return false, err
+	}
+	for _, inst := range insts {
+		if flowRunActive(inst.Status.FlowRunStatus) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// 部署进行中续期部署锁，结束后释放
+func syncDeployLock(db *storage.Store, appDeploy *cmdb.AppDeployment) error {
+	var err error
+	lockName := deployLockName(appDeploy.Metadata.Name, appDeploy.Metadata.Namespace)
+	switch appDeploy.Status {
+	case cmdb.AppDeploymentDeploying, cmdb.AppDeploymentUninstalling:
+		err = db.RefreshLock(context.Background(), lockName)
+	default:
+		_, err = db.ReleaseLock(context.Background(), lockName)
+	}
+	// 锁已过期或已被强制释放
+	if storage.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
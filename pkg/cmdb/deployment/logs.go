@@ -0,0 +1,152 @@
+package deployment
+
+import (
+	"context"
+	"fmt"
+	"gcmdb/pkg/cmdb"
+	"gcmdb/pkg/cmdb/server/storage"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/imroc/req/v3"
+)
+
+// Prefect API 地址，如 http://127.0.0.1:4200/api
+var PrefectApiUrl string
+
+// follow 模式下轮询日志的间隔
+var LogPollInterval = 2 * time.Second
+
+// 每次向 Prefect 查询的日志条数
+const logPageLimit = 200
+
+// flow run 日志
+type FlowRunLog struct {
+	Id        string    `json:"id"`
+	FlowRunId string    `json:"flow_run_id"`
+	TaskRunId string    `json:"task_run_id,omitempty"`
+	Name      string    `json:"name"`
+	Level     int       `json:"level"`
+	Message   string    `json:"message"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+type LogOptions struct {
+	// 仅查询指定 AppInstance 的日志，为空时查询 AppDeployment 当前版本的日志
+	Instance string
+	// 持续输出新日志，直到部署结束
+	Follow bool
+}
+
+// 查询 AppDeployment 或 AppInstance 关联的 flow run 日志，follow 模式下持续输出新日志直到部署结束或 ctx 取消。
+// 首次查询的结果总会调用 write，之后仅在有新日志时调用
+func StreamLogs(ctx context.Context, db *storage.Store, name, namespace string, opts LogOptions, write func([]FlowRunLog) error) error {
+	if PrefectApiUrl == "" {
+		return fmt.Errorf("%s", "orchestrator api url is not configured, can't read logs.")
+	}
+	// 每个 flow run 已读取的日志条数
+	offsets := map[string]int{}
+	for first := true; ; first = false {
+		flowRunIds, running, err := logFlowRuns(db, name, namespace, opts.Instance)
+		if err != nil {
+			return err
+		}
+		logs := []FlowRunLog{}
+		for _, id := range flowRunIds {
+			runLogs, err := readFlowRunLogs(ctx, id, offsets[id])
+			if err != nil {
+				return err
+			}
+			offsets[id] += len(runLogs)
+			logs = append(logs, runLogs...)
+		}
+		slices.SortStableFunc(logs, func(a, b FlowRunLog) int {
+			return a.Timestamp.Compare(b.Timestamp)
+		})
+		if first || len(logs) > 0 {
+			if err = write(logs); err != nil {
+				return err
+			}
+		}
+		if !opts.Follow || !running {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(LogPollInterval):
+		}
+	}
+}
+
+// 日志对应的 flow run 及部署是否仍在进行
+func logFlowRuns(db *storage.Store, name, namespace, instance string) ([]string, bool, error) {
+	if instance != "" {
+		var obj cmdb.Object
+		if err := db.Get(context.Background(), "AppInstance", instance, namespace, storage.GetOptions{}, &obj); err != nil {
+			return nil, false, err
+		}
+		inst := obj.(*cmdb.AppInstance)
+		if name != "" && inst.Metadata.Labels["appDeployment"] != name {
+			return nil, false, storage.NewKeyNotFoundError(fmt.Sprintf("appinstance %s/%s of appdeployment %s", namespace, instance, name), 0)
+		}
+		switch inst.Status.FlowRunStatus {
+		case cmdb.FlowRunPending, cmdb.FlowRunRunning, cmdb.FlowRunPaused, cmdb.FlowRunCancelling:
+			return compactFlowRunIds(inst.FlowRunId), true, nil
+		}
+		return compactFlowRunIds(inst.FlowRunId), false, nil
+	}
+	appDeploy, err := getAppDeployment(db, name, namespace)
+	if err != nil {
+		return nil, false, err
+	}
+	insts, err := listAppInstances(db, name, namespace)
+	if err != nil {
+		return nil, false, err
+	}
+	flowRunIds := []string{appDeploy.FlowRunId}
+	for _, inst := range currentAppInstances(appDeploy, insts) {
+		flowRunIds = append(flowRunIds, inst.FlowRunId)
+	}
+	running := appDeploy.Status == cmdb.AppDeploymentDeploying || appDeploy.Status == cmdb.AppDeploymentUninstalling
+	return compactFlowRunIds(flowRunIds...), running, nil
+}
+
+// 去除空值和重复的 flow run id
+func compactFlowRunIds(ids ...string) []string {
+	result := []string{}
+	for _, id := range ids {
+		if id != "" && !slices.Contains(result, id) {
+			result = append(result, id)
+		}
+	}
+	return result
+}
+
+// 分页读取 flow run 从 offset 开始的所有日志
+func readFlowRunLogs(ctx context.Context, flowRunId string, offset int) ([]FlowRunLog, error) {
+	url := strings.TrimSuffix(PrefectApiUrl, "/") + "/logs/filter"
+	var logs []FlowRunLog
+	for {
+		var page []FlowRunLog
+		body := map[string]any{
+			"logs":   map[string]any{"flow_run_id": map[string]any{"any_": []string{flowRunId}}},
+			"sort":   "TIMESTAMP_ASC",
+			"offset": offset + len(logs),
+			"limit":  logPageLimit,
+		}
+		resp, err := req.C().R().SetContext(ctx).SetBody(body).SetSuccessResult(&page).Post(url)
+		if err != nil {
+			return nil, err
+		}
+		if resp.IsErrorState() {
+			errMsg := fmt.Sprintf("read flow run %s logs failed, orchestrator response code %d: %s", flowRunId, resp.StatusCode, resp.String())
+			return nil, fmt.Errorf("%s", errMsg)
+		}
+		logs = append(logs, page...)
+		if len(page) < logPageLimit {
+			return logs, nil
+		}
+	}
+}
@@ -0,0 +1,18 @@
+package deployment
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompactFlowRunIds(t *testing.T) {
+	assert.Equal(t, []string{}, compactFlowRunIds())
+	assert.Equal(t, []string{"a", "b"}, compactFlowRunIds("", "a", "b", "a", ""))
+}
+
+func TestStreamLogsNotConfigured(t *testing.T) {
+	err := StreamLogs(context.Background(), nil, "go-app", "test", LogOptions{}, func([]FlowRunLog) error { return nil })
+	assert.Error(t, err)
+}
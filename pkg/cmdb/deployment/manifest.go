@@ -0,0 +1,308 @@
+package deployment
+
+import (
+	"context"
+	"fmt"
+	"gcmdb/pkg/cmdb"
+	"gcmdb/pkg/cmdb/server/storage"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// 未指定 image_tag 参数时使用的镜像标签
+const defaultImageTag = "latest"
+
+// Kubernetes 工作负载信息，由渲染后的 AppDeployment 生成
+type kubernetesWorkload struct {
+	name      string
+	namespace string
+	image     string
+	tag       string
+	labels    map[string]string
+	spec      cmdb.ResourceRangeSpec
+}
+
+// 生成 Helm values
+func RenderHelmValues(db *storage.Store, name, namespace string, params map[string]any) (map[string]any, error) {
+	w, err := resolveKubernetesWorkload(db, name, namespace, params)
+	if err != nil {
+		return nil, err
+	}
+	return w.helmValues(), nil
+}
+
+// 生成 Deployment/Service/ServiceMonitor 清单
+func RenderKubernetesManifests(db *storage.Store, name, namespace string, params map[string]any) ([]map[string]any, error) {
+	w, err := resolveKubernetesWorkload(db, name, namespace, params)
+	if err != nil {
+		return nil, err
+	}
+	return w.manifests(), nil
+}
+
+func resolveKubernetesWorkload(db *storage.Store, name, namespace string, params map[string]any) (*kubernetesWorkload, error) {
+	appDeploy, err := ResolveAppDeployment(db, name, namespace, params)
+	if err != nil {
+		return nil, err
+	}
+	spec := appDeploy.Spec.Template.Spec
+	if spec.DeployPlatform == nil || spec.DeployPlatform.Kubernetes == nil {
+		errMsg := fmt.Sprintf("appDeployment %s/%s deploy platform is not %s.", namespace, name, DPKubernetes)
+		return nil, fmt.Errorf("%s", errMsg)
+	}
+	k8s := spec.DeployPlatform.Kubernetes
+	w := &kubernetesWorkload{
+		name:      spec.App,
+		namespace: k8s.Namespace,
+		tag:       imageTag(params),
+		labels:    appDeploy.Spec.Template.Metadata.Labels,
+		spec:      spec,
+	}
+	if k8s.Helm != nil && k8s.Helm.Release != "" {
+		w.name = k8s.Helm.Release
+	}
+	if w.image, err = containerImage(db, k8s.ContainerRegistry, spec.App); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// 镜像标签，来自 image_tag 参数
+func imageTag(params map[string]any) string {
+	if tag, ok := params["image_tag"]; ok && fmt.Sprint(tag) != "" {
+		return fmt.Sprint(tag)
+	}
+	return defaultImageTag
+}
+
+// 根据 ContainerRegistry 生成镜像地址(不含标签)
+func containerImage(db *storage.Store, registry *cmdb.DPContainerRegistry, app string) (string, error) {
+	if registry == nil || registry.Name == "" {
+		return app, nil
+	}
+	var obj cmdb.Object
+	if err := db.Get(context.Background(), "ContainerRegistry", registry.Name, "", storage.GetOptions{}, &obj); err != nil {
+		return "", err
+	}
+	parts := []string{obj.(*cmdb.ContainerRegistry).Spec.Registry}
+	if registry.Project != "" {
+		parts = append(parts, registry.Project)
+	}
+	return strings.Join(append(parts, app), "/"), nil
+}
+
+func (w *kubernetesWorkload) selectorLabels() map[string]any {
+	return map[string]any{
+		"app.kubernetes.io/name":     w.spec.App,
+		"app.kubernetes.io/instance": w.name,
+	}
+}
+
+func (w *kubernetesWorkload) podLabels() map[string]any {
+	labels := map[string]any{}
+	for k, v := range w.labels {
+		labels[k] = v
+	}
+	maps.Copy(labels, w.selectorLabels())
+	return labels
+}
+
+// 环境变量按名称排序，保证输出稳定
+func (w *kubernetesWorkload) env() []any {
+	env := []any{}
+	for _, k := range slices.Sorted(maps.Keys(w.spec.Env)) {
+		env = append(env, map[string]any{"name": k, "value": w.spec.Env[k]})
+	}
+	return env
+}
+
+func (w *kubernetesWorkload) resources() map[string]any {
+	resources := map[string]any{}
+	if w.spec.Resources == nil {
+		return resources
+	}
+	limit := func(l *cmdb.ResourceLimit) map[string]any {
+		m := map[string]any{}
+		if l == nil {
+			return m
+		}
+		if l.Cpu != "" {
+			m["cpu"] = l.Cpu
+		}
+		if l.Memory != "" {
+			m["memory"] = l.Memory
+		}
+		return m
+	}
+	if l := limit(w.spec.Resources.Limit); len(l) > 0 {
+		resources["limits"] = l
+	}
+	if r := limit(w.spec.Resources.Request); len(r) > 0 {
+		resources["requests"] = r
+	}
+	return resources
+}
+
+// 容器端口，http 端口未设置时使用探针端口
+func (w *kubernetesWorkload) ports() []any {
+	ports := []any{}
+	add := func(name string, p *cmdb.ServicePort) {
+		if p == nil || p.Port == 0 {
+			return
+		}
+		protocol := "TCP"
+		if p.Protocol != "" {
+			protocol = strings.ToUpper(p.Protocol)
+		}
+		ports = append(ports, map[string]any{"name": name, "containerPort": p.Port, "protocol": protocol})
+	}
+	if w.spec.Ports != nil {
+		add("http", w.spec.Ports.Http)
+		add("metrics", w.spec.Ports.Metrics)
+	}
+	if len(ports) == 0 && w.probe() != nil {
+		if port, err := strconv.Atoi(w.spec.Monitoring.Probe.HttpGet.Port); err == nil {
+			add("http", &cmdb.ServicePort{Port: port})
+		}
+	}
+	return ports
+}
+
+func (w *kubernetesWorkload) probe() map[string]any {
+	m := w.spec.Monitoring
+	if m == nil || m.Probe == nil || m.Probe.HttpGet.Port == "" {
+		return nil
+	}
+	return map[string]any{
+		"httpGet": map[string]any{
+			"path": "/" + strings.TrimPrefix(m.Probe.HttpGet.Path, "/"),
+			"port": m.Probe.HttpGet.Port,
+		},
+	}
+}
+
+func (w *kubernetesWorkload) metrics() *cmdb.MonitoringMetrics {
+	if w.spec.Monitoring == nil {
+		return nil
+	}
+	return w.spec.Monitoring.Metrics
+}
+
+func (w *kubernetesWorkload) helmValues() map[string]any {
+	values := map[string]any{
+		"nameOverride":     w.spec.App,
+		"fullnameOverride": w.name,
+		"image": map[string]any{
+			"repository": w.image,
+			"tag":        w.tag,
+			"pullPolicy": "IfNotPresent",
+		},
+		"command":      w.spec.Command,
+		"args":         w.spec.Args,
+		"env":          w.env(),
+		"resources":    w.resources(),
+		"nodeSelector": w.spec.NodeSelector,
+		"podLabels":    w.labels,
+		"ports":        w.ports(),
+		"service":      map[string]any{"enabled": len(w.ports()) > 0},
+	}
+	if probe := w.probe(); probe != nil {
+		values["livenessProbe"] = probe
+		values["readinessProbe"] = probe
+	}
+	if metrics := w.metrics(); metrics != nil {
+		values["metrics"] = map[string]any{
+			"enabled": metrics.Scraped,
+			"path":    "/" + strings.TrimPrefix(metrics.Path, "/"),
+			"port":    metrics.Port,
+		}
+	}
+	return values
+}
+
+func (w *kubernetesWorkload) metadata() map[string]any {
+	meta := map[string]any{"name": w.name, "labels": w.podLabels()}
+	if w.namespace != "" {
+		meta["namespace"] = w.namespace
+	}
+	return meta
+}
+
+func (w *kubernetesWorkload) manifests() []map[string]any {
+	container := map[string]any{
+		"name":            w.spec.App,
+		"image":           w.image + ":" + w.tag,
+		"imagePullPolicy": "IfNotPresent",
+		"env":             w.env(),
+		"resources":       w.resources(),
+		"ports":           w.ports(),
+	}
+	if len(w.spec.Command) > 0 {
+		container["command"] = w.spec.Command
+	}
+	if len(w.spec.Args) > 0 {
+		container["args"] = w.spec.Args
+	}
+	if probe := w.probe(); probe != nil {
+		container["livenessProbe"] = probe
+		container["readinessProbe"] = probe
+	}
+	podSpec := map[string]any{"containers": []any{container}}
+	if len(w.spec.NodeSelector) > 0 {
+		podSpec["nodeSelector"] = w.spec.NodeSelector
+	}
+	manifests := []map[string]any{{
+		"apiVersion": "apps/v1",
+		"kind":       "Deployment",
+		"metadata":   w.metadata(),
+		"spec": map[string]any{
+			"replicas": 1,
+			"selector": map[string]any{"matchLabels": w.selectorLabels()},
+			"template": map[string]any{
+				"metadata": map[string]any{"labels": w.podLabels()},
+				"spec":     podSpec,
+			},
+		},
+	}}
+
+	servicePorts := []any{}
+	for _, p := range w.ports() {
+		p := p.(map[string]any)
+		servicePorts = append(servicePorts, map[string]any{
+			"name":       p["name"],
+			"port":       p["containerPort"],
+			"targetPort": p["name"],
+			"protocol":   p["protocol"],
+		})
+	}
+	if len(servicePorts) > 0 {
+		manifests = append(manifests, map[string]any{
+			"apiVersion": "v1",
+			"kind":       "Service",
+			"metadata":   w.metadata(),
+			"spec": map[string]any{
+				"selector": w.selectorLabels(),
+				"ports":    servicePorts,
+			},
+		})
+	}
+
+	if metrics := w.metrics(); metrics != nil && metrics.Scraped && len(servicePorts) > 0 {
+		endpoint := map[string]any{"path": "/" + strings.TrimPrefix(metrics.Path, "/"), "port": "http"}
+		if w.spec.Ports != nil && w.spec.Ports.Metrics != nil && w.spec.Ports.Metrics.Port != 0 {
+			endpoint["port"] = "metrics"
+		}
+		manifests = append(manifests, map[string]any{
+			"apiVersion": "monitoring.coreos.com/v1",
+			"kind":       "ServiceMonitor",
+			"metadata":   w.metadata(),
+			"spec": map[string]any{
+				"selector":  map[string]any{"matchLabels": w.selectorLabels()},
+				"endpoints": []any{endpoint},
+			},
+		})
+	}
+	return manifests
+}
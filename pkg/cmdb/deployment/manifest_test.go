@@ -0,0 +1,75 @@
+package deployment
+
+import (
+	"gcmdb/pkg/cmdb"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testKubernetesWorkload() *kubernetesWorkload {
+	return &kubernetesWorkload{
+		name:      "go-app-release",
+		namespace: "prod",
+		image:     "harbor.dev.com/go-devops/go-app",
+		tag:       "v1.0.0",
+		labels:    map[string]string{"project": "go-devops"},
+		spec: cmdb.ResourceRangeSpec{
+			App:          "go-app",
+			Command:      []string{"python"},
+			Args:         []string{"autoapp.py"},
+			Env:          map[string]string{"ZONE": "A", "ENV": "test"},
+			NodeSelector: map[string]string{"zone": "a"},
+			Resources: &cmdb.ResourceRangeResources{
+				Limit: &cmdb.ResourceLimit{Cpu: "1", Memory: "2G"},
+			},
+			Monitoring: &cmdb.ResourceRangeMonitoring{
+				Metrics: &cmdb.MonitoringMetrics{Path: "metrics", Port: "1234", Scraped: true},
+				Probe:   &cmdb.MonitoringProbe{HttpGet: cmdb.MonitoringProbeHttpGet{Path: "actuator/health", Port: "8000"}},
+			},
+		},
+	}
+}
+
+func TestHelmValues(t *testing.T) {
+	values := testKubernetesWorkload().helmValues()
+	assert.Equal(t, "go-app-release", values["fullnameOverride"])
+	assert.Equal(t, map[string]any{
+		"repository": "harbor.dev.com/go-devops/go-app",
+		"tag":        "v1.0.0",
+		"pullPolicy": "IfNotPresent",
+	}, values["image"])
+	assert.Equal(t, []any{
+		map[string]any{"name": "ENV", "value": "test"},
+		map[string]any{"name": "ZONE", "value": "A"},
+	}, values["env"])
+	assert.Equal(t, map[string]any{"limits": map[string]any{"cpu": "1", "memory": "2G"}}, values["resources"])
+	assert.Equal(t, []any{
+		map[string]any{"name": "http", "containerPort": 8000, "protocol": "TCP"},
+	}, values["ports"])
+	probe := map[string]any{"httpGet": map[string]any{"path": "/actuator/health", "port": "8000"}}
+	assert.Equal(t, probe, values["livenessProbe"])
+	assert.Equal(t, true, values["metrics"].(map[string]any)["enabled"])
+}
+
+func TestKubernetesManifests(t *testing.T) {
+	w := testKubernetesWorkload()
+	manifests := w.manifests()
+	var kinds []any
+	for _, m := range manifests {
+		kinds = append(kinds, m["kind"])
+	}
+	assert.Equal(t, []any{"Deployment", "Service", "ServiceMonitor"}, kinds)
+
+	deploy := manifests[0]
+	assert.Equal(t, "prod", deploy["metadata"].(map[string]any)["namespace"])
+	podSpec := deploy["spec"].(map[string]any)["template"].(map[string]any)["spec"].(map[string]any)
+	container := podSpec["containers"].([]any)[0].(map[string]any)
+	assert.Equal(t, "harbor.dev.com/go-devops/go-app:v1.0.0", container["image"])
+	assert.Equal(t, map[string]string{"zone": "a"}, podSpec["nodeSelector"])
+
+	// 未采集指标且无端口时只生成 Deployment
+	w.spec.Monitoring = nil
+	manifests = w.manifests()
+	assert.Equal(t, 1, len(manifests))
+}
@@ -0,0 +1,255 @@
+package deployment
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"gcmdb/pkg/cmdb"
+	"gcmdb/pkg/cmdb/runtime"
+	"gcmdb/pkg/cmdb/server/storage"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/imroc/req/v3"
+)
+
+type DeployEventType string
+
+const (
+	DeployEventStarted   DeployEventType = "started"
+	DeployEventSucceeded DeployEventType = "succeeded"
+	DeployEventFailed    DeployEventType = "failed"
+)
+
+// 投递失败后的重试间隔，按重试次数递增
+var NotifyRetryInterval = 5 * time.Second
+
+// 未设置 retries 时的重试次数
+const defaultNotifierRetries = 3
+
+// Notifier 保留的投递记录数
+const notifierDeliveryLimit = 20
+
+// 串行更新投递记录，避免并发投递互相覆盖
+var deliveryMu sync.Mutex
+
+// 部署事件，同时作为 Notifier 模板的变量
+type DeployEvent struct {
+	Type          DeployEventType `json:"type"`
+	AppDeployment string          `json:"appDeployment"`
+	Namespace     string          `json:"namespace"`
+	// 部署结束的事件由状态同步产生，不含 action
+	Action   DeployAction              `json:"action,omitempty"`
+	Status   cmdb.AppDeploymentStuatus `json:"status"`
+	Revision int64                     `json:"revision"`
+	Labels   map[string]string         `json:"labels"`
+	Message  string                    `json:"message,omitempty"`
+	Time     time.Time                 `json:"time"`
+}
+
+// 发起部署后通知部署开始或失败，返回原结果
+func (c *DeployController) notify(result *cmdb.AppDeployment, err error) (*cmdb.AppDeployment, error) {
+	if err != nil {
+		emitDeployEvent(c.store, DeployEventFailed, c.action, c.name, c.namespace, err.Error())
+	} else {
+		emitDeployEvent(c.store, DeployEventStarted, c.action, c.name, c.namespace, "")
+	}
+	return result, err
+}
+
+// 部署或卸载结束时通知结果
+func notifyDeployFinished(db *storage.Store, prev cmdb.AppDeploymentStuatus, appDeploy *cmdb.AppDeployment) {
+	if prev != cmdb.AppDeploymentDeploying && prev != cmdb.AppDeploymentUninstalling {
+		return
+	}
+	var eventType DeployEventType
+	switch appDeploy.Status {
+	case cmdb.AppDeploymentDeployed, cmdb.AppDeploymentUninstalled:
+		eventType = DeployEventSucceeded
+	case cmdb.AppDeploymentFailed:
+		eventType = DeployEventFailed
+	default:
+		return
+	}
+	message := ""
+	if appDeploy.Rollout != nil {
+		message = appDeploy.Rollout.Message
+	}
+	emitDeployEvent(db, eventType, "", appDeploy.Metadata.Name, appDeploy.Metadata.Namespace, message)
+}
+
+// 向所有匹配的 Notifier 异步投递事件，投递失败不影响部署
+func emitDeployEvent(db *storage.Store, eventType DeployEventType, action DeployAction, name, namespace, message string) {
+	event := DeployEvent{
+		Type:          eventType,
+		AppDeployment: name,
+		Namespace:     namespace,
+		Action:        action,
+		Message:       message,
+		Time:          time.Now(),
+	}
+	if appDeploy, err := getAppDeployment(db, name, namespace); err == nil {
+		event.Status = appDeploy.Status
+		event.Revision = appDeploy.DeployRevision
+		event.Labels = appDeploy.Metadata.Labels
+	}
+	var objs []cmdb.Object
+	if err := db.GetList(context.Background(), "Notifier", "", storage.ListOptions{}, &objs); err != nil {
+		log.Printf("notifier: list notifiers: %s", err)
+		return
+	}
+	for _, o := range objs {
+		if n, ok := o.(*cmdb.Notifier); ok && notifierMatches(n, &event) {
+			go deliverDeployEvent(db, n, event)
+		}
+	}
+}
+
+func notifierMatches(n *cmdb.Notifier, event *DeployEvent) bool {
+	filter := n.Spec.Filter
+	if n.Spec.Suspend {
+		return false
+	}
+	if len(filter.Namespaces) > 0 && !slices.Contains(filter.Namespaces, event.Namespace) {
+		return false
+	}
+	if len(filter.Events) > 0 && !slices.Contains(filter.Events, string(event.Type)) {
+		return false
+	}
+	for k, v := range filter.Selector {
+		if event.Labels[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// 渲染请求体，未配置模板时为事件 JSON
+func renderNotifierBody(n *cmdb.Notifier, event *DeployEvent) (string, error) {
+	byts, err := json.Marshal(event)
+	if err != nil {
+		return "", err
+	}
+	if n.Spec.Template == "" {
+		return string(byts), nil
+	}
+	// 保留整数，避免 revision 渲染为浮点数
+	var vars map[string]any
+	decoder := json.NewDecoder(bytes.NewReader(byts))
+	decoder.UseNumber()
+	if err = decoder.Decode(&vars); err != nil {
+		return "", err
+	}
+	return runtime.RenderTemplate(n.Spec.Template, vars)
+}
+
+func deliverDeployEvent(db *storage.Store, n *cmdb.Notifier, event DeployEvent) {
+	now := time.Now()
+	delivery := cmdb.NotifierDelivery{
+		Event:         string(event.Type),
+		AppDeployment: event.AppDeployment,
+		Namespace:     event.Namespace,
+		Time:          &now,
+	}
+	body, err := renderNotifierBody(n, &event)
+	if err != nil {
+		delivery.Error = err.Error()
+	} else {
+		for delivery.Attempts = 1; ; delivery.Attempts++ {
+			delivery.StatusCode, err = postWebhook(&n.Spec.Webhook, body)
+			if err == nil {
+				delivery.Succeeded = true
+				delivery.Error = ""
+				break
+			}
+			delivery.Error = err.Error()
+			if delivery.Attempts > notifierRetries(n) {
+				break
+			}
+			time.Sleep(time.Duration(delivery.Attempts) * NotifyRetryInterval)
+		}
+	}
+	if err = recordDelivery(db, n.Metadata.Name, delivery); err != nil {
+		log.Printf("notifier %s: record delivery: %s", n.Metadata.Name, err)
+	}
+}
+
+func notifierRetries(n *cmdb.Notifier) int {
+	if retries := n.Spec.Retries; retries != nil {
+		return max(*retries, 0)
+	}
+	return defaultNotifierRetries
+}
+
+// 允许的 Webhook 协议及请求方法
+var (
+	webhookSchemes = []string{"http", "https"}
+	webhookMethods = []string{http.MethodPost, http.MethodPut}
+)
+
+// 错误及投递记录中保留的响应体长度
+const webhookResponseLimit = 512
+
+func postWebhook(webhook *cmdb.NotifierWebhook, body string) (int, error) {
+	method := webhook.Method
+	if method == "" {
+		method = http.MethodPost
+	}
+	if !slices.Contains(webhookMethods, method) {
+		errMsg := fmt.Sprintf("webhook method %q not allowed, must be one of %v", method, webhookMethods)
+		return 0, fmt.Errorf("%s", errMsg)
+	}
+	if u, err := url.Parse(webhook.Url); err != nil || !slices.Contains(webhookSchemes, u.Scheme) {
+		errMsg := fmt.Sprintf("webhook url %q not allowed, scheme must be one of %v", webhook.Url, webhookSchemes)
+		return 0, fmt.Errorf("%s", errMsg)
+	}
+	timeout := time.Duration(webhook.Timeout) * time.Second
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	// 不跟随重定向，重定向视为投递失败
+	client := req.C().SetTimeout(timeout).SetRedirectPolicy(req.NoRedirectPolicy()).DisableAutoReadResponse()
+	resp, err := client.R().
+		SetHeader("Content-Type", "application/json").
+		SetHeaders(webhook.Headers).
+		SetBodyString(body).
+		Send(method, webhook.Url)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if !resp.IsSuccessState() {
+		errMsg := fmt.Sprintf("webhook %s response code %d: %s", webhook.Url, resp.StatusCode, readLimited(resp.Body, webhookResponseLimit))
+		return resp.StatusCode, fmt.Errorf("%s", errMsg)
+	}
+	return resp.StatusCode, nil
+}
+
+// 读取至多 limit 字节，超出部分截断
+func readLimited(r io.Reader, limit int) string {
+	byts, _ := io.ReadAll(io.LimitReader(r, int64(limit)+1))
+	if len(byts) > limit {
+		return string(byts[:limit]) + "...(truncated)"
+	}
+	return string(byts)
+}
+
+// 记录投递结果，仅保留最近的记录
+func recordDelivery(db *storage.Store, name string, delivery cmdb.NotifierDelivery) error {
+	deliveryMu.Lock()
+	defer deliveryMu.Unlock()
+	var obj cmdb.Object
+	if err := db.Get(context.Background(), "Notifier", name, "", storage.GetOptions{}, &obj); err != nil {
+		return err
+	}
+	n := obj.(*cmdb.Notifier)
+	deliveries := append([]cmdb.NotifierDelivery{delivery}, n.Status.Deliveries...)
+	n.Status.Deliveries = deliveries[:min(len(deliveries), notifierDeliveryLimit)]
+	return db.Update(context.Background(), n, nil)
+}
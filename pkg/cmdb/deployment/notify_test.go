@@ -0,0 +1,89 @@
+package deployment
+
+import (
+	"gcmdb/pkg/cmdb"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNotifierMatches(t *testing.T) {
+	event := &DeployEvent{Type: DeployEventFailed, Namespace: "test", Labels: map[string]string{"team": "devops"}}
+	n := cmdb.NewNotifier()
+	assert.True(t, notifierMatches(n, event))
+	n.Spec.Filter = cmdb.NotifierFilter{Namespaces: []string{"test"}, Events: []string{"failed"}, Selector: map[string]string{"team": "devops"}}
+	assert.True(t, notifierMatches(n, event))
+	n.Spec.Filter.Selector["team"] = "web"
+	assert.False(t, notifierMatches(n, event))
+	n.Spec.Filter.Selector = nil
+	n.Spec.Filter.Events = []string{"succeeded"}
+	assert.False(t, notifierMatches(n, event))
+	n.Spec.Filter.Events = nil
+	n.Spec.Filter.Namespaces = []string{"prod"}
+	assert.False(t, notifierMatches(n, event))
+	n.Spec.Filter.Namespaces = nil
+	n.Spec.Suspend = true
+	assert.False(t, notifierMatches(n, event))
+}
+
+func TestRenderNotifierBody(t *testing.T) {
+	event := &DeployEvent{
+		Type: DeployEventStarted, AppDeployment: "go-app", Namespace: "test", Action: DeployRelease,
+		Status: cmdb.AppDeploymentDeploying, Revision: 3, Time: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+	n := cmdb.NewNotifier()
+	body, err := renderNotifierBody(n, event)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"type": "started", "appDeployment": "go-app", "namespace": "test", "action": "release",
+		"status": "deploying", "revision": 3, "labels": null, "time": "2024-01-01T00:00:00Z"}`, body)
+	n.Spec.Template = "${ namespace }/${ appDeployment } ${ action } ${ type } r${ revision }"
+	body, err = renderNotifierBody(n, event)
+	assert.NoError(t, err)
+	assert.Equal(t, "test/go-app release started r3", body)
+}
+
+func TestNotifierRetries(t *testing.T) {
+	n := cmdb.NewNotifier()
+	assert.Equal(t, defaultNotifierRetries, notifierRetries(n))
+	retries := 0
+	n.Spec.Retries = &retries
+	assert.Equal(t, 0, notifierRetries(n))
+}
+
+func TestPostWebhook(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/redirect":
+			http.Redirect(w, r, "/ok", http.StatusFound)
+		case "/error":
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(strings.Repeat("x", 10*webhookResponseLimit)))
+		}
+	}))
+	defer ts.Close()
+
+	code, err := postWebhook(&cmdb.NotifierWebhook{Url: ts.URL + "/ok", Method: "PUT"}, "{}")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, code)
+
+	// 响应体截断
+	_, err = postWebhook(&cmdb.NotifierWebhook{Url: ts.URL + "/error"}, "{}")
+	assert.Error(t, err)
+	assert.Less(t, len(err.Error()), 2*webhookResponseLimit)
+	assert.Contains(t, err.Error(), "truncated")
+
+	// 不跟随重定向
+	code, err = postWebhook(&cmdb.NotifierWebhook{Url: ts.URL + "/redirect"}, "{}")
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusFound, code)
+
+	// 限制协议及请求方法
+	_, err = postWebhook(&cmdb.NotifierWebhook{Url: "file:///etc/passwd"}, "{}")
+	assert.ErrorContains(t, err, "not allowed")
+	_, err = postWebhook(&cmdb.NotifierWebhook{Url: ts.URL + "/ok", Method: "DELETE"}, "{}")
+	assert.ErrorContains(t, err, "not allowed")
+}
@@ -0,0 +1,88 @@
+package deployment
+
+import (
+	"context"
+	"gcmdb/pkg/cmdb"
+	"gcmdb/pkg/cmdb/runtime"
+	"gcmdb/pkg/cmdb/server/storage"
+)
+
+// 由服务端设置或具有特殊用途的参数，不参与 schema 校验
+var reservedParams = []string{"spec", "metadata", "host_nodes", "hostnode", "host_node_name", "host_node_ip", "app_instance_name"}
+
+// 部署参数不符合 schema
+type ParamsValidationError struct {
+	Errors []runtime.SchemaError
+}
+
+func (e ParamsValidationError) Error() string {
+	return "invalid params: " + runtime.FormatSchemaErrors(e.Errors)
+}
+
+// AppDeployment 的 Orchestration 与 DeployTemplate 声明的参数 schema，未声明的不返回
+func ParameterSchemas(db *storage.Store, name, namespace string) (map[string]*cmdb.ParameterSchema, error) {
+	appDeploy, err := getAppDeployment(db, name, namespace)
+	if err != nil {
+		return nil, err
+	}
+	return parameterSchemas(db, appDeploy)
+}
+
+func parameterSchemas(db *storage.Store, appDeploy *cmdb.AppDeployment) (map[string]*cmdb.ParameterSchema, error) {
+	schemas := map[string]*cmdb.ParameterSchema{}
+	var obj cmdb.Object
+	if err := db.Get(context.Background(), "Orchestration", appDeploy.Spec.Orchestration, "", storage.GetOptions{}, &obj); err != nil {
+		return nil, err
+	}
+	if schema := obj.(*cmdb.Orchestration).Spec.ParameterSchema; schema != nil {
+		schemas["orchestration"] = schema
+	}
+	templateName := ""
+	if t := appDeploy.Spec.Template.DeployTemplate; t != nil {
+		templateName = t.Name
+	}
+	if templateName == "" {
+		if err := db.Get(context.Background(), "ResourceRange", appDeploy.Spec.ResourceRange, appDeploy.Metadata.Namespace, storage.GetOptions{}, &obj); err != nil {
+			return nil, err
+		}
+		templateName = obj.(*cmdb.ResourceRange).DeployTemplate.Name
+	}
+	// 未指定时使用内置模板，无参数 schema
+	if templateName == "" {
+		return schemas, nil
+	}
+	if err := db.Get(context.Background(), "DeployTemplate", templateName, appDeploy.Metadata.Namespace, storage.GetOptions{}, &obj); err != nil {
+		return nil, err
+	}
+	if schema := obj.(*cmdb.DeployTemplate).Spec.ParameterSchema; schema != nil {
+		schemas["deployTemplate"] = schema
+	}
+	return schemas, nil
+}
+
+// 渲染前按 schema 设置参数默认值并校验，部署时由 preCheck 校验，渲染时不再重复校验
+func ValidateDeployParams(db *storage.Store, name, namespace string, params map[string]any) error {
+	appDeploy, err := getAppDeployment(db, name, namespace)
+	if err != nil {
+		return err
+	}
+	return validateDeployParams(db, appDeploy, params)
+}
+
+// 按 Orchestration 与 DeployTemplate 的 schema 设置参数默认值并校验
+func validateDeployParams(db *storage.Store, appDeploy *cmdb.AppDeployment, params map[string]any) error {
+	schemas, err := parameterSchemas(db, appDeploy)
+	if err != nil {
+		return err
+	}
+	var errs []runtime.SchemaError
+	for _, key := range []string{"orchestration", "deployTemplate"} {
+		if schema := schemas[key]; schema != nil {
+			errs = append(errs, runtime.ValidateParams(schema, params, reservedParams...)...)
+		}
+	}
+	if len(errs) > 0 {
+		return ParamsValidationError{Errors: errs}
+	}
+	return nil
+}
@@ -0,0 +1,28 @@
+package deployment
+
+import (
+	"gcmdb/pkg/cmdb"
+	"gcmdb/pkg/cmdb/runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReservedParams(t *testing.T) {
+	additional := false
+	schema := &cmdb.ParameterSchema{
+		Type:                 "object",
+		Properties:           map[string]*cmdb.ParameterSchema{"image_tag": {Type: "string"}},
+		AdditionalProperties: &additional,
+	}
+	// 服务端为每个节点设置的参数不参与校验
+	params := map[string]any{
+		"image_tag":         "v1",
+		"host_node_name":    "node1",
+		"host_node_ip":      "10.0.0.1",
+		"app_instance_name": "go-app--node1--abcde",
+	}
+	assert.Empty(t, runtime.ValidateParams(schema, params, reservedParams...))
+	params["unknown"] = 1
+	assert.Len(t, runtime.ValidateParams(schema, params, reservedParams...), 1)
+}
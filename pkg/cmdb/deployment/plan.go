@@ -0,0 +1,307 @@
+package deployment
+
+import (
+	"context"
+	"fmt"
+	"gcmdb/pkg/cmdb"
+	"gcmdb/pkg/cmdb/server/storage"
+	"log"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+)
+
+// 推进部署计划锁的有效期(秒)，避免并发推进重复部署
+const deployPlanLockTTL int64 = 60
+
+// 部署计划正被推进时的重试间隔及次数
+var DeployPlanRetryInterval = time.Second
+
+const deployPlanRetries = 10
+
+// AppDeployment 间的依赖存在环
+type DependencyCycleError struct {
+	Cycle []string
+}
+
+func (e DependencyCycleError) Error() string {
+	return fmt.Sprintf("appDeployment dependency cycle: %s.", strings.Join(e.Cycle, " -> "))
+}
+
+// 按 dependsOn 对 AppDeployment 拓扑排序，卸载时依赖方先卸载；仅考虑集合内的依赖
+func SortAppDeployments(appDeploys []cmdb.AppDeployment, action DeployAction) ([]cmdb.DeployPlanItem, error) {
+	after := map[string][]string{}
+	for _, d := range appDeploys {
+		after[d.Metadata.Name] = []string{}
+	}
+	for _, d := range appDeploys {
+		for _, dep := range d.Spec.DependsOn {
+			if _, ok := after[dep]; !ok {
+				continue
+			}
+			if action == DeployUninstall {
+				after[dep] = append(after[dep], d.Metadata.Name)
+			} else {
+				after[d.Metadata.Name] = append(after[d.Metadata.Name], dep)
+			}
+		}
+	}
+	names := slices.Sorted(maps.Keys(after))
+	items := []cmdb.DeployPlanItem{}
+	done := map[string]bool{}
+	// 每轮按名称顺序取出依赖均已排序的 AppDeployment
+	for len(items) < len(names) {
+		progressed := false
+		for _, name := range names {
+			if done[name] || slices.ContainsFunc(after[name], func(dep string) bool { return !done[dep] }) {
+				continue
+			}
+			done[name] = true
+			progressed = true
+			deps := slices.Clone(after[name])
+			slices.Sort(deps)
+			items = append(items, cmdb.DeployPlanItem{
+				AppDeployment: name,
+				After:         slices.Compact(deps),
+				Phase:         cmdb.DeployPlanItemPending,
+			})
+		}
+		if !progressed {
+			return nil, DependencyCycleError{Cycle: findCycle(names, after, done)}
+		}
+	}
+	return items, nil
+}
+
+// 在未排序的节点中查找一个环
+func findCycle(names []string, after map[string][]string, done map[string]bool) []string {
+	visiting := map[string]int{}
+	var path []string
+	var visit func(name string) []string
+	visit = func(name string) []string {
+		if i, ok := visiting[name]; ok {
+			if i < 0 {
+				return nil
+			}
+			return append(slices.Clone(path[i:]), name)
+		}
+		visiting[name] = len(path)
+		path = append(path, name)
+		for _, dep := range after[name] {
+			if done[dep] {
+				continue
+			}
+			if cycle := visit(dep); cycle != nil {
+				return cycle
+			}
+		}
+		path = path[:len(path)-1]
+		visiting[name] = -1
+		return nil
+	}
+	for _, name := range names {
+		if !done[name] {
+			if cycle := visit(name); cycle != nil {
+				return cycle
+			}
+		}
+	}
+	return nil
+}
+
+// 创建部署计划并开始部署没有依赖的 AppDeployment，dryRun 时仅返回部署顺序
+func RunDeployPlan(db *storage.Store, namespace string, selector map[string]string, action DeployAction, params map[string]any, requester string, dryRun bool) (*cmdb.DeployPlan, error) {
+	if !slices.Contains([]DeployAction{DeployRelease, DeployRestart, DeployUninstall}, action) {
+		errMsg := fmt.Sprintf("deployPlan action %s no support.", action)
+		return nil, fmt.Errorf("%s", errMsg)
+	}
+	var objs []cmdb.Object
+	if err := db.GetList(context.Background(), "AppDeployment", namespace, storage.ListOptions{LabelSelector: selector}, &objs); err != nil {
+		return nil, err
+	}
+	appDeploys := []cmdb.AppDeployment{}
+	for _, o := range objs {
+		if d, ok := o.(*cmdb.AppDeployment); ok {
+			appDeploys = append(appDeploys, *d)
+		}
+	}
+	if len(appDeploys) == 0 {
+		errMsg := fmt.Sprintf("no appDeployment in namespace %s matches selector %v.", namespace, selector)
+		return nil, fmt.Errorf("%s", errMsg)
+	}
+	items, err := SortAppDeployments(appDeploys, action)
+	if err != nil {
+		return nil, err
+	}
+	plan := cmdb.NewDeployPlan()
+	plan.Metadata.Name = truncNameLeft63(fmt.Sprintf("%s-%s", action, randomString(5)))
+	plan.Metadata.Namespace = namespace
+	plan.Metadata.Annotations = map[string]string{"requester": requester}
+	plan.Spec = cmdb.DeployPlanSpec{Selector: selector, Action: string(action), Params: params}
+	plan.Status = cmdb.DeployPlanStatus{Phase: cmdb.DeployPlanRunning, Items: items}
+	if dryRun {
+		return plan, nil
+	}
+	var out cmdb.Object
+	if err = db.Create(context.Background(), plan, &out); err != nil {
+		return nil, err
+	}
+	return advanceDeployPlan(db, out.(*cmdb.DeployPlan))
+}
+
+func getDeployPlan(db *storage.Store, name, namespace string) (*cmdb.DeployPlan, error) {
+	var obj cmdb.Object
+	if err := db.Get(context.Background(), "DeployPlan", name, namespace, storage.GetOptions{}, &obj); err != nil {
+		return nil, err
+	}
+	return obj.(*cmdb.DeployPlan), nil
+}
+
+// AppDeployment 部署结束或其 DeploymentRequest 审批结束后推进包含它的部署计划
+func advanceDeployPlans(db *storage.Store, name, namespace string) {
+	var objs []cmdb.Object
+	if err := db.GetList(context.Background(), "DeployPlan", namespace, storage.ListOptions{}, &objs); err != nil {
+		log.Printf("deployPlan: list deployPlans: %s", err)
+		return
+	}
+	for _, o := range objs {
+		plan, ok := o.(*cmdb.DeployPlan)
+		if !ok || plan.Status.Phase != cmdb.DeployPlanRunning {
+			continue
+		}
+		if !slices.ContainsFunc(plan.Status.Items, func(item cmdb.DeployPlanItem) bool { return item.AppDeployment == name }) {
+			continue
+		}
+		if _, err := advanceDeployPlan(db, plan); storage.IsLocked(err) {
+			// 正在推进的计划可能已错过本次结果，稍后重试
+			go retryAdvanceDeployPlan(db, plan)
+		} else if err != nil {
+			log.Printf("deployPlan %s/%s: %s", namespace, plan.Metadata.Name, err)
+		}
+	}
+}
+
+func retryAdvanceDeployPlan(db *storage.Store, plan *cmdb.DeployPlan) {
+	var err error
+	for range deployPlanRetries {
+		time.Sleep(DeployPlanRetryInterval)
+		if _, err = advanceDeployPlan(db, plan); !storage.IsLocked(err) {
+			break
+		}
+	}
+	if err != nil {
+		log.Printf("deployPlan %s/%s: %s", plan.Metadata.Namespace, plan.Metadata.Name, err)
+	}
+}
+
+// 同步部署中 AppDeployment 的结果，部署依赖均已成功的 AppDeployment，依赖失败的不再部署
+func advanceDeployPlan(db *storage.Store, plan *cmdb.DeployPlan) (*cmdb.DeployPlan, error) {
+	name, namespace := plan.Metadata.Name, plan.Metadata.Namespace
+	lockName := fmt.Sprintf("deployplans/%s/%s", namespace, name)
+	if _, err := db.AcquireLock(context.Background(), lockName, LockOwner, deployPlanLockTTL); err != nil {
+		return nil, err
+	}
+	defer db.ReleaseLock(context.Background(), lockName)
+	// 加锁后重新读取，避免重复部署
+	plan, err := getDeployPlan(db, name, namespace)
+	if err != nil {
+		return nil, err
+	}
+	phases := map[string]cmdb.DeployPlanItemPhase{}
+	for i := range plan.Status.Items {
+		item := &plan.Status.Items[i]
+		switch item.Phase {
+		case cmdb.DeployPlanItemDeploying:
+			syncDeployPlanItem(db, item, namespace)
+		case cmdb.DeployPlanItemWaitingApproval:
+			syncApprovalPlanItem(db, item, namespace)
+		case cmdb.DeployPlanItemPending:
+			startDeployPlanItem(db, plan, item, phases)
+		}
+		phases[item.AppDeployment] = item.Phase
+	}
+	plan.Status.Phase = deployPlanPhase(plan.Status.Items)
+	if err = db.Update(context.Background(), plan, nil); err != nil {
+		return nil, err
+	}
+	return plan, nil
+}
+
+// 根据 AppDeployment 的状态更新部署中的计划项
+func syncDeployPlanItem(db *storage.Store, item *cmdb.DeployPlanItem, namespace string) {
+	appDeploy, err := getAppDeployment(db, item.AppDeployment, namespace)
+	if err != nil {
+		item.Phase, item.Message = cmdb.DeployPlanItemFailed, err.Error()
+		return
+	}
+	switch appDeploy.Status {
+	case cmdb.AppDeploymentDeployed, cmdb.AppDeploymentUninstalled:
+		item.Phase, item.Message = cmdb.DeployPlanItemSucceeded, fmt.Sprintf("appDeployment is %s.", appDeploy.Status)
+	case cmdb.AppDeploymentFailed:
+		item.Phase, item.Message = cmdb.DeployPlanItemFailed, "appDeployment is failed."
+		if appDeploy.Rollout != nil && appDeploy.Rollout.Message != "" {
+			item.Message = appDeploy.Rollout.Message
+		}
+	}
+}
+
+// 根据 DeploymentRequest 的结果更新等待审批的计划项，审批通过并开始部署后转为部署中
+func syncApprovalPlanItem(db *storage.Store, item *cmdb.DeployPlanItem, namespace string) {
+	request, err := getDeploymentRequest(db, item.Request, namespace)
+	if err != nil {
+		item.Phase, item.Message = cmdb.DeployPlanItemFailed, err.Error()
+		return
+	}
+	switch request.Status.Phase {
+	case cmdb.DeploymentRequestDeployed:
+		item.Phase, item.Message = cmdb.DeployPlanItemDeploying, ""
+		syncDeployPlanItem(db, item, namespace)
+	case cmdb.DeploymentRequestRejected, cmdb.DeploymentRequestFailed:
+		item.Phase, item.Message = cmdb.DeployPlanItemFailed, request.Status.Message
+	}
+}
+
+// 依赖均已成功时开始部署，依赖失败或跳过时跳过；phases 为已处理的计划项状态
+func startDeployPlanItem(db *storage.Store, plan *cmdb.DeployPlan, item *cmdb.DeployPlanItem, phases map[string]cmdb.DeployPlanItemPhase) {
+	for _, dep := range item.After {
+		switch phases[dep] {
+		case cmdb.DeployPlanItemFailed, cmdb.DeployPlanItemSkipped:
+			item.Phase, item.Message = cmdb.DeployPlanItemSkipped, fmt.Sprintf("appDeployment %s %s.", dep, phases[dep])
+			return
+		case cmdb.DeployPlanItemSucceeded:
+		default:
+			return
+		}
+	}
+	params := maps.Clone(plan.Spec.Params)
+	if params == nil {
+		params = map[string]any{}
+	}
+	requester := plan.Metadata.Annotations["requester"]
+	c := NewDeployController(db, DeployAction(plan.Spec.Action), item.AppDeployment, plan.Metadata.Namespace, params)
+	if _, err := c.WithRequester(requester).Run(); err != nil {
+		// 需要审批时等待审批，审批结果由 decideDeploymentRequest 推进
+		if e, ok := err.(ApprovalRequiredError); ok {
+			item.Phase, item.Message, item.Request = cmdb.DeployPlanItemWaitingApproval, err.Error(), e.Request.Metadata.Name
+			return
+		}
+		item.Phase, item.Message = cmdb.DeployPlanItemFailed, err.Error()
+		return
+	}
+	item.Phase, item.Message = cmdb.DeployPlanItemDeploying, ""
+	syncDeployPlanItem(db, item, plan.Metadata.Namespace)
+}
+
+func deployPlanPhase(items []cmdb.DeployPlanItem) cmdb.DeployPlanPhase {
+	phase := cmdb.DeployPlanSucceeded
+	for _, item := range items {
+		switch item.Phase {
+		case cmdb.DeployPlanItemPending, cmdb.DeployPlanItemDeploying, cmdb.DeployPlanItemWaitingApproval:
+			return cmdb.DeployPlanRunning
+		case cmdb.DeployPlanItemFailed, cmdb.DeployPlanItemSkipped:
+			phase = cmdb.DeployPlanFailed
+		}
+	}
+	return phase
+}
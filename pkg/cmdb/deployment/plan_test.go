@@ -0,0 +1,67 @@
+package deployment
+
+import (
+	"gcmdb/pkg/cmdb"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newPlanAppDeployment(name string, dependsOn ...string) cmdb.AppDeployment {
+	appDeploy := cmdb.NewAppDeployment()
+	appDeploy.Metadata.Name = name
+	appDeploy.Spec.DependsOn = dependsOn
+	return *appDeploy
+}
+
+func TestSortAppDeployments(t *testing.T) {
+	appDeploys := []cmdb.AppDeployment{
+		newPlanAppDeployment("web", "api", "config"),
+		newPlanAppDeployment("api", "config", "config"),
+		newPlanAppDeployment("worker", "not-selected"),
+		newPlanAppDeployment("config"),
+	}
+	order := func(items []cmdb.DeployPlanItem) []string {
+		names := []string{}
+		for _, item := range items {
+			names = append(names, item.AppDeployment)
+		}
+		return names
+	}
+
+	items, err := SortAppDeployments(appDeploys, DeployRelease)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"config", "worker", "api", "web"}, order(items))
+	assert.Equal(t, []string{"config"}, items[2].After)
+	assert.Equal(t, []string{"api", "config"}, items[3].After)
+	assert.Empty(t, items[1].After)
+	for _, item := range items {
+		assert.Equal(t, cmdb.DeployPlanItemPending, item.Phase)
+	}
+
+	// 卸载时依赖方先卸载
+	items, err = SortAppDeployments(appDeploys, DeployUninstall)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"web", "worker", "api", "config"}, order(items))
+	assert.Equal(t, []string{"api", "web"}, items[3].After)
+
+	appDeploys[3].Spec.DependsOn = []string{"web"}
+	_, err = SortAppDeployments(appDeploys, DeployRelease)
+	assert.Equal(t, DependencyCycleError{Cycle: []string{"api", "config", "web", "api"}}, err)
+	_, err = SortAppDeployments([]cmdb.AppDeployment{newPlanAppDeployment("self", "self")}, DeployRelease)
+	assert.Equal(t, DependencyCycleError{Cycle: []string{"self", "self"}}, err)
+}
+
+func TestDeployPlanPhase(t *testing.T) {
+	items := []cmdb.DeployPlanItem{
+		{AppDeployment: "config", Phase: cmdb.DeployPlanItemSucceeded},
+		{AppDeployment: "api", Phase: cmdb.DeployPlanItemFailed},
+		{AppDeployment: "web", Phase: cmdb.DeployPlanItemPending},
+	}
+	assert.Equal(t, cmdb.DeployPlanRunning, deployPlanPhase(items))
+	items[2].Phase = cmdb.DeployPlanItemWaitingApproval
+	assert.Equal(t, cmdb.DeployPlanRunning, deployPlanPhase(items))
+	items[2].Phase = cmdb.DeployPlanItemSkipped
+	assert.Equal(t, cmdb.DeployPlanFailed, deployPlanPhase(items))
+	assert.Equal(t, cmdb.DeployPlanSucceeded, deployPlanPhase(items[:1]))
+}
@@ -0,0 +1,263 @@
+package deployment
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"gcmdb/pkg/cmdb"
+	"gcmdb/pkg/cmdb/conversion"
+	"gcmdb/pkg/cmdb/runtime"
+	"gcmdb/pkg/cmdb/server/storage"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/goccy/go-yaml"
+)
+
+type PromoteAction string
+
+const (
+	PromoteCreate    PromoteAction = "create"
+	PromoteUpdate    PromoteAction = "update"
+	PromoteUnchanged PromoteAction = "unchanged"
+)
+
+// 随 AppDeployment 复制的资源，按写入顺序排列
+var promoteKinds = []string{"TemplateLibrary", "DeployTemplate", "ResourceRange", "AppDeployment"}
+
+// 复制到目标命名空间的资源，current 为目标命名空间已有的资源
+type PromotedObject struct {
+	Kind     string         `json:"kind"`
+	Name     string         `json:"name"`
+	Action   PromoteAction  `json:"action"`
+	Current  map[string]any `json:"current,omitempty"`
+	Promoted map[string]any `json:"promoted"`
+}
+
+type PromoteResult struct {
+	AppDeployment string           `json:"appDeployment"`
+	From          string           `json:"from"`
+	To            string           `json:"to"`
+	Objects       []PromotedObject `json:"objects"`
+}
+
+// 将 AppDeployment 及其引用的 ResourceRange、DeployTemplate、TemplateLibrary 复制到目标命名空间，
+// 依次应用目标命名空间的 promotionOverrides 和 overrides，dryRun 时仅返回变更
+func PromoteAppDeployment(db *storage.Store, name, from, to string, overrides map[string]any, dryRun bool) (*PromoteResult, error) {
+	if from == to {
+		errMsg := fmt.Sprintf("appDeployment %s can't be promoted to the same namespace %s.", name, to)
+		return nil, storage.NewInvalidObjError(name, errMsg)
+	}
+	var obj cmdb.Object
+	if err := db.Get(context.Background(), "Namespace", to, "", storage.GetOptions{}, &obj); err != nil {
+		return nil, err
+	}
+	allOverrides := maps.Clone(obj.(*cmdb.Namespace).Spec.PromotionOverrides)
+	if allOverrides == nil {
+		allOverrides = map[string]any{}
+	}
+	maps.Copy(allOverrides, overrides)
+	kindOverrides, err := parsePromoteOverrides(allOverrides)
+	if err != nil {
+		return nil, err
+	}
+	sources, err := promoteSources(db, name, from)
+	if err != nil {
+		return nil, err
+	}
+	result := &PromoteResult{AppDeployment: name, From: from, To: to, Objects: []PromotedObject{}}
+	promotedObjs := []cmdb.Object{}
+	for _, source := range sources {
+		promoted, o, err := promoteObject(source, to, kindOverrides[strings.ToLower(source.GetKind())])
+		if err != nil {
+			return nil, err
+		}
+		item := PromotedObject{Kind: source.GetKind(), Name: source.GetMeta().Name, Action: PromoteCreate, Promoted: promoted}
+		var current cmdb.Object
+		err = db.Get(context.Background(), source.GetKind(), source.GetMeta().Name, to, storage.GetOptions{}, &current)
+		if err != nil && !storage.IsNotFound(err) {
+			return nil, err
+		}
+		if current != nil {
+			if item.Current, err = promoteMap(current); err != nil {
+				return nil, err
+			}
+			keepPromoteStatus(o, current)
+			item.Action = PromoteUpdate
+			if promotedEqual(item.Current, item.Promoted) {
+				item.Action = PromoteUnchanged
+			}
+		}
+		result.Objects = append(result.Objects, item)
+		promotedObjs = append(promotedObjs, o)
+	}
+	if dryRun {
+		return result, nil
+	}
+	for i, o := range promotedObjs {
+		switch result.Objects[i].Action {
+		case PromoteCreate:
+			err = db.Create(context.Background(), o, nil)
+		case PromoteUpdate:
+			err = db.Update(context.Background(), o, nil)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// 按资源类型拆分覆盖项，键为 <kind>.<字段路径>，如 resourcerange.spec.env.ENV
+func parsePromoteOverrides(overrides map[string]any) (map[string]map[string]any, error) {
+	kindOverrides := map[string]map[string]any{}
+	for _, key := range slices.Sorted(maps.Keys(overrides)) {
+		kind, path, _ := strings.Cut(key, ".")
+		kind = strings.ToLower(kind)
+		if !slices.ContainsFunc(promoteKinds, func(k string) bool { return strings.ToLower(k) == kind }) || path == "" {
+			errMsg := fmt.Sprintf("invalid promote override %q, must be <appdeployment|resourcerange|deploytemplate|templatelibrary>.<path>.", key)
+			return nil, storage.NewInvalidObjError(key, errMsg)
+		}
+		if path == "metadata.name" || path == "metadata.namespace" || path == "kind" || path == "apiVersion" {
+			errMsg := fmt.Sprintf("promote override %q is not allowed.", key)
+			return nil, storage.NewInvalidObjError(key, errMsg)
+		}
+		if kindOverrides[kind] == nil {
+			kindOverrides[kind] = map[string]any{}
+		}
+		kindOverrides[kind][path] = overrides[key]
+	}
+	return kindOverrides, nil
+}
+
+// 获取 AppDeployment 及其引用的命名空间内资源，按写入顺序排列
+func promoteSources(db *storage.Store, name, namespace string) ([]cmdb.Object, error) {
+	appDeploy, err := getAppDeployment(db, name, namespace)
+	if err != nil {
+		return nil, err
+	}
+	var rangeObj cmdb.Object
+	if err = db.Get(context.Background(), "ResourceRange", appDeploy.Spec.ResourceRange, namespace, storage.GetOptions{}, &rangeObj); err != nil {
+		return nil, err
+	}
+	resourceRange := rangeObj.(*cmdb.ResourceRange)
+	templateNames := []string{resourceRange.DeployTemplate.Name}
+	if t := appDeploy.Spec.Template.DeployTemplate; t != nil {
+		templateNames = append(templateNames, t.Name)
+	}
+	sources := []cmdb.Object{}
+	for _, templateName := range templateNames {
+		if templateName == "" || slices.ContainsFunc(sources, func(o cmdb.Object) bool { return o.GetMeta().Name == templateName }) {
+			continue
+		}
+		var templateObj cmdb.Object
+		if err = db.Get(context.Background(), "DeployTemplate", templateName, namespace, storage.GetOptions{}, &templateObj); err != nil {
+			return nil, err
+		}
+		sources = append(sources, templateObj)
+	}
+	libraries := []cmdb.Object{}
+	for _, templateObj := range sources {
+		for _, libName := range templateObj.(*cmdb.DeployTemplate).Spec.Libraries {
+			if libraries, err = promoteLibraries(db, libName, namespace, libraries, nil); err != nil {
+				return nil, err
+			}
+		}
+	}
+	sources = append(libraries, sources...)
+	return append(sources, resourceRange, appDeploy), nil
+}
+
+// 获取 TemplateLibrary 及其引用的 TemplateLibrary，被引用的排在前面，visiting 为当前的引用链
+func promoteLibraries(db *storage.Store, name, namespace string, libraries []cmdb.Object, visiting []string) ([]cmdb.Object, error) {
+	if slices.ContainsFunc(libraries, func(o cmdb.Object) bool { return o.GetMeta().Name == name }) {
+		return libraries, nil
+	}
+	if slices.Contains(visiting, name) {
+		return nil, runtime.TemplateCycleError{Cycle: append(slices.Clone(visiting), name)}
+	}
+	var obj cmdb.Object
+	if err := db.Get(context.Background(), "TemplateLibrary", name, namespace, storage.GetOptions{}, &obj); err != nil {
+		return nil, err
+	}
+	var err error
+	for _, libName := range obj.(*cmdb.TemplateLibrary).Spec.Libraries {
+		if libraries, err = promoteLibraries(db, libName, namespace, libraries, append(slices.Clone(visiting), name)); err != nil {
+			return nil, err
+		}
+	}
+	return append(libraries, obj), nil
+}
+
+// 生成目标命名空间的资源，返回用于比较的 map 及对象
+func promoteObject(source cmdb.Object, to string, overrides map[string]any) (map[string]any, cmdb.Object, error) {
+	m, err := promoteMap(source)
+	if err != nil {
+		return nil, nil, err
+	}
+	conversion.SetMapValueByPath(m, "metadata.namespace", to)
+	for _, path := range slices.Sorted(maps.Keys(overrides)) {
+		runtime.RecSetItem(m, path, overrides[path])
+	}
+	o, err := cmdb.NewResourceWithKind(source.GetKind())
+	if err != nil {
+		return nil, nil, err
+	}
+	key := fmt.Sprintf("%s/%s/%s", source.GetKind(), to, source.GetMeta().Name)
+	// 与解析资源文件一致，覆盖项的字段路径错误时报错
+	byts, err := json.Marshal(m)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err = yaml.UnmarshalWithOptions(byts, o, yaml.DisallowUnknownField()); err != nil {
+		return nil, nil, storage.NewInvalidObjError(key, err.Error())
+	}
+	if err = runtime.ValidateObject(o); err != nil {
+		return nil, nil, storage.NewInvalidObjError(key, err.Error())
+	}
+	// 以解析后的对象为准，便于与目标命名空间的资源比较
+	if m, err = promoteMap(o); err != nil {
+		return nil, nil, err
+	}
+	return m, o, nil
+}
+
+// 转为 map 并去除系统管理字段和部署状态
+func promoteMap(o cmdb.Object) (map[string]any, error) {
+	m := map[string]any{}
+	if err := conversion.StructToMap(o, &m); err != nil {
+		return nil, err
+	}
+	if metadata, ok := m["metadata"].(map[string]any); ok {
+		for _, field := range cmdb.ManagedMetaFields {
+			delete(metadata, field)
+		}
+	}
+	if o.GetKind() == "AppDeployment" {
+		for _, field := range cmdb.AppDeploymentStatusFields {
+			delete(m, field)
+		}
+	}
+	return m, nil
+}
+
+// 更新目标命名空间的 AppDeployment 时保留其部署状态
+func keepPromoteStatus(o, current cmdb.Object) {
+	appDeploy, ok := o.(*cmdb.AppDeployment)
+	if !ok {
+		return
+	}
+	target := current.(*cmdb.AppDeployment)
+	appDeploy.FlowRunId = target.FlowRunId
+	appDeploy.Status = target.Status
+	appDeploy.DeployRevision = target.DeployRevision
+	appDeploy.Rollout = target.Rollout
+}
+
+func promotedEqual(current, promoted map[string]any) bool {
+	a, _ := json.Marshal(current)
+	b, _ := json.Marshal(promoted)
+	return bytes.Equal(a, b)
+}
@@ -0,0 +1,51 @@
+package deployment
+
+import (
+	"gcmdb/pkg/cmdb"
+	"gcmdb/pkg/cmdb/conversion"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParsePromoteOverrides(t *testing.T) {
+	overrides, err := parsePromoteOverrides(map[string]any{
+		"ResourceRange.spec.env.ENV":             "prod",
+		"appdeployment.spec.template.spec.env.A": "1",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]map[string]any{
+		"resourcerange": {"spec.env.ENV": "prod"},
+		"appdeployment": {"spec.template.spec.env.A": "1"},
+	}, overrides)
+
+	for _, key := range []string{"app.spec.project", "resourcerange", "deploytemplate.metadata.namespace", "appdeployment.kind"} {
+		_, err = parsePromoteOverrides(map[string]any{key: "x"})
+		assert.Error(t, err, key)
+	}
+}
+
+func TestPromoteObject(t *testing.T) {
+	byts, err := os.ReadFile("../example/files/resource_range.yaml")
+	assert.NoError(t, err)
+	source, err := conversion.DecodeObject(byts)
+	assert.NoError(t, err)
+
+	m, o, err := promoteObject(source, "prod", map[string]any{"spec.env.ENV": "prod", "spec.resources.limit.cpu": 2})
+	assert.NoError(t, err)
+	assert.Equal(t, "prod", o.GetMeta().Namespace)
+	assert.Equal(t, "prod", o.(*cmdb.ResourceRange).Spec.Env["ENV"])
+	assert.Equal(t, "prod", conversion.GetMapValueByPath(m, "metadata.namespace"))
+	assert.Nil(t, conversion.GetMapValueByPath(m, "metadata.managedFields"))
+	// 源对象不变
+	assert.Equal(t, "test", source.GetMeta().Namespace)
+
+	assert.Equal(t, "2", o.(*cmdb.ResourceRange).Spec.Resources.Limit.Cpu)
+
+	// 字段路径或类型错误
+	_, _, err = promoteObject(source, "prod", map[string]any{"spec.notExist": "x"})
+	assert.Error(t, err)
+	_, _, err = promoteObject(source, "prod", map[string]any{"spec.env": []any{"ENV"}})
+	assert.Error(t, err)
+}
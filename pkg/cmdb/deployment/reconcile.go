@@ -0,0 +1,251 @@
+package deployment
+
+import (
+	"context"
+	"fmt"
+	"gcmdb/pkg/cmdb"
+	"gcmdb/pkg/cmdb/server/storage"
+	"strconv"
+	"time"
+)
+
+// AppInstance 所属 AppDeployment 部署版本号的标签
+const revisionLabel = "appDeploymentRevision"
+
+// 更新 AppInstance 的 flow run 状态(由编排器回调)，并同步所属 AppDeployment 的状态
+func UpdateAppInstanceStatus(db *storage.Store, name, namespace string, status cmdb.FlowRunStatus) (*cmdb.AppInstance, error) {
+	var obj cmdb.Object
+	if err := db.Get(context.Background(), "AppInstance", name, namespace, storage.GetOptions{}, &obj); err != nil {
+		return nil, err
+	}
+	inst := obj.(*cmdb.AppInstance)
+	inst.Status.FlowRunStatus = status
+	if err := db.Update(context.Background(), inst, nil); err != nil {
+		return nil, err
+	}
+	if appDeployName := inst.Metadata.Labels["appDeployment"]; appDeployName != "" {
+		if _, err := ReconcileAppDeployment(db, appDeployName, namespace); err != nil {
+			return nil, err
+		}
+	}
+	return inst, nil
+}
+
+// 根据 AppInstance 的运行状态同步 AppDeployment 的状态，并回收已卸载及超出历史版本数的 AppInstance，
+// 部署结束时通知 Notifier 并推进所在的部署计划
+func ReconcileAppDeployment(db *storage.Store, name, namespace string) (*cmdb.AppDeployment, error) {
+	prev, err := getAppDeployment(db, name, namespace)
+	if err != nil {
+		return nil, err
+	}
+	appDeploy, err := reconcileAppDeployment(db, name, namespace)
+	if err != nil {
+		return nil, err
+	}
+	notifyDeployFinished(db, prev.Status, appDeploy)
+	if appDeploy.Status != prev.Status {
+		advanceDeployPlans(db, name, namespace)
+	}
+	if err = syncDeployLock(db, appDeploy); err != nil {
+		return nil, err
+	}
+	// 部署结束后异步回收超出历史版本数的 AppInstance
+	if appDeploy.Status != cmdb.AppDeploymentDeploying && appDeploy.Status != cmdb.AppDeploymentUninstalling {
+		gcAppInstancesAsync(db, name, namespace)
+	}
+	return appDeploy, nil
+}
+
+func reconcileAppDeployment(db *storage.Store, name, namespace string) (*cmdb.AppDeployment, error) {
+	var appDeploy *cmdb.AppDeployment
+	var insts []cmdb.AppInstance
+	var err error
+	if appDeploy, err = getAppDeployment(db, name, namespace); err != nil {
+		return nil, err
+	}
+	if insts, err = liveAppInstances(db, name, namespace); err != nil {
+		return nil, err
+	}
+	if insts, err = gcTerminatedAppInstances(db, name, namespace, insts); err != nil {
+		return nil, err
+	}
+	status := appDeploy.Status
+	switch appDeploy.Status {
+	case cmdb.AppDeploymentDeploying:
+		current := currentAppInstances(appDeploy, insts)
+		completed, failed := countFlowRuns(current)
+		rollout := appDeploy.Rollout
+		if failed > 0 && rollout != nil && !rollout.Aborted {
+			errMsg := fmt.Sprintf("batch %d/%d failed, rollout halted", rollout.Batch, rollout.Batches)
+			return haltRollout(db, name, namespace, errMsg)
+		} else if failed > 0 {
+			status = cmdb.AppDeploymentFailed
+		} else if len(insts) == 0 {
+			// 中止首次金丝雀发布后已无存活的 AppInstance
+			status = cmdb.AppDeploymentUninstalled
+		} else if completed == len(current) {
+			status = cmdb.AppDeploymentDeployed
+		} else if rollout != nil && !rollout.Paused && !rollout.Aborted && batchCompleted(current, rollout.Batch) {
+			if !rollout.Canary {
+				if err = advanceRollout(db, appDeploy, time.Now()); err != nil {
+					return nil, err
+				}
+			} else if rollout.Batch == 1 {
+				if err = scheduleCanaryAnalysis(db, appDeploy, time.Now()); err != nil {
+					return nil, err
+				}
+			}
+		}
+	case cmdb.AppDeploymentUninstalling:
+		if _, failed := countFlowRuns(insts); failed > 0 {
+			status = cmdb.AppDeploymentFailed
+		} else if len(insts) == 0 {
+			status = cmdb.AppDeploymentUninstalled
+		}
+	}
+	if status == appDeploy.Status {
+		return appDeploy, nil
+	}
+	return setAppDeploymentStatus(db, name, namespace, status)
+}
+
+// 回收编排器已确认卸载完成的部署目标，返回剩余存活的 AppInstance
+func gcTerminatedAppInstances(db *storage.Store, name, namespace string, insts []cmdb.AppInstance) ([]cmdb.AppInstance, error) {
+	remain := []cmdb.AppInstance{}
+	for _, inst := range insts {
+		if inst.Status.Phase == cmdb.AppInstanceTerminating && inst.Status.FlowRunStatus == cmdb.FlowRunCompleted {
+			if err := deleteTargetAppInstances(db, name, namespace, appInstanceTarget(&inst)); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		remain = append(remain, inst)
+	}
+	return remain, nil
+}
+
+// 获取 AppDeployment 关联的所有 AppInstance，按创建顺序排列
+func listAppInstances(db *storage.Store, name, namespace string) ([]cmdb.AppInstance, error) {
+	var objs []cmdb.Object
+	listOpts := storage.ListOptions{LabelSelector: map[string]string{"appDeployment": name}}
+	if err := db.GetList(context.Background(), "AppInstance", namespace, listOpts, &objs); err != nil {
+		return nil, err
+	}
+	insts := []cmdb.AppInstance{}
+	for _, o := range objs {
+		if o, ok := o.(*cmdb.AppInstance); ok {
+			insts = append(insts, *o)
+		}
+	}
+	return insts, nil
+}
+
+// 获取存活的 AppInstance，即每个部署目标最新创建的 AppInstance
+func liveAppInstances(db *storage.Store, name, namespace string) ([]cmdb.AppInstance, error) {
+	insts, err := listAppInstances(db, name, namespace)
+	if err != nil {
+		return nil, err
+	}
+	latest := map[string]cmdb.AppInstance{}
+	var targets []string
+	for _, inst := range insts {
+		target := appInstanceTarget(&inst)
+		if _, ok := latest[target]; !ok {
+			targets = append(targets, target)
+		}
+		latest[target] = inst
+	}
+	live := []cmdb.AppInstance{}
+	for _, target := range targets {
+		live = append(live, latest[target])
+	}
+	return live, nil
+}
+
+// 获取本次部署版本创建的 AppInstance
+func currentAppInstances(appDeploy *cmdb.AppDeployment, insts []cmdb.AppInstance) []cmdb.AppInstance {
+	if appDeploy.DeployRevision == 0 {
+		return insts
+	}
+	current := []cmdb.AppInstance{}
+	for _, inst := range insts {
+		if appInstanceRevision(&inst) == appDeploy.DeployRevision {
+			current = append(current, inst)
+		}
+	}
+	return current
+}
+
+// AppInstance 所属的部署版本号，未设置时为 0
+func appInstanceRevision(inst *cmdb.AppInstance) int64 {
+	revision, _ := strconv.ParseInt(inst.Metadata.Labels[revisionLabel], 10, 64)
+	return revision
+}
+
+// 删除部署目标下的所有 AppInstance
+func deleteTargetAppInstances(db *storage.Store, name, namespace, target string) error {
+	insts, err := listAppInstances(db, name, namespace)
+	if err != nil {
+		return err
+	}
+	for _, inst := range insts {
+		if appInstanceTarget(&inst) != target {
+			continue
+		}
+		if err = db.Delete(context.Background(), "AppInstance", inst.Metadata.Name, namespace); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AppInstance 的部署目标，Docker 为 HostNode 名称，Kubernetes 为集群/命名空间/helm release
+func appInstanceTarget(inst *cmdb.AppInstance) string {
+	dp := inst.Spec.DeployPlatform
+	switch {
+	case dp == nil:
+		return ""
+	case dp.Kubernetes != nil:
+		target := fmt.Sprintf("%s/%s", dp.Kubernetes.Name, dp.Kubernetes.Namespace)
+		if dp.Kubernetes.Helm != nil {
+			target += "/" + dp.Kubernetes.Helm.Release
+		}
+		return target
+	case dp.Docker != nil:
+		return dp.Docker.NodeName
+	}
+	return ""
+}
+
+// 统计 flow run 已完成和已失败的数量
+func countFlowRuns(insts []cmdb.AppInstance) (completed, failed int) {
+	for _, inst := range insts {
+		switch inst.Status.FlowRunStatus {
+		case cmdb.FlowRunCompleted:
+			completed++
+		case cmdb.FlowRunFailed, cmdb.FlowRunCrashed, cmdb.FlowRunCancelled:
+			failed++
+		}
+	}
+	return completed, failed
+}
+
+func getAppDeployment(db *storage.Store, name, namespace string) (*cmdb.AppDeployment, error) {
+	var obj cmdb.Object
+	if err := db.Get(context.Background(), "AppDeployment", name, namespace, storage.GetOptions{}, &obj); err != nil {
+		return nil, err
+	}
+	return obj.(*cmdb.AppDeployment), nil
+}
+
+func setAppDeploymentStatus(db *storage.Store, name, namespace string, status cmdb.AppDeploymentStuatus) (*cmdb.AppDeployment, error) {
+	appDeploy, err := getAppDeployment(db, name, namespace)
+	if err != nil {
+		return nil, err
+	}
+	appDeploy.Status = status
+	if err = db.Update(context.Background(), appDeploy, nil); err != nil {
+		return nil, err
+	}
+	return appDeploy, nil
+}
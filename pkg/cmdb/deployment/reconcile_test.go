@@ -0,0 +1,42 @@
+package deployment
+
+import (
+	"gcmdb/pkg/cmdb"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAppInstanceTarget(t *testing.T) {
+	inst := cmdb.NewAppInstance()
+	assert.Equal(t, "", appInstanceTarget(inst))
+
+	inst.Spec.DeployPlatform = &cmdb.ResourceRangeDeployPlatform{
+		Docker: &cmdb.DeployPlatformDocker{NodeName: "node-1"},
+	}
+	assert.Equal(t, "node-1", appInstanceTarget(inst))
+
+	inst.Spec.DeployPlatform = &cmdb.ResourceRangeDeployPlatform{
+		Kubernetes: &cmdb.DPKubernetes{Name: "k8s", Namespace: "prod", Helm: &cmdb.DPHelm{Release: "go-app"}},
+	}
+	assert.Equal(t, "k8s/prod/go-app", appInstanceTarget(inst))
+}
+
+func TestCountFlowRuns(t *testing.T) {
+	statuses := []cmdb.FlowRunStatus{
+		cmdb.FlowRunCompleted,
+		cmdb.FlowRunCompleted,
+		cmdb.FlowRunRunning,
+		cmdb.FlowRunFailed,
+		cmdb.FlowRunCrashed,
+	}
+	var insts []cmdb.AppInstance
+	for _, s := range statuses {
+		inst := cmdb.NewAppInstance()
+		inst.Status.FlowRunStatus = s
+		insts = append(insts, *inst)
+	}
+	completed, failed := countFlowRuns(insts)
+	assert.Equal(t, 2, completed)
+	assert.Equal(t, 2, failed)
+}
@@ -3,6 +3,7 @@ package deployment
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"gcmdb/pkg/cmdb"
 	"gcmdb/pkg/cmdb/conversion"
 	"gcmdb/pkg/cmdb/runtime"
@@ -14,8 +15,11 @@ import (
 	"github.com/goccy/go-yaml"
 )
 
+// 服务端严格渲染模式，引用未定义的变量时报错；DeployTemplate 可单独开启
+var StrictTemplate bool
+
 // 将 AppDeployment 引用的所有对象详情，合并至 AppDeployment 中
-func resolveAppDeploymentDetail(db *storage.Store, appdeploy *cmdb.AppDeployment, appdeployDict map[string]any) (map[string]any, error) {
+func resolveAppDeploymentDetail(db *storage.Store, appdeploy *cmdb.AppDeployment, appdeployDict map[string]any, tracer *renderTracer) (map[string]any, error) {
 	var result map[string]any
 	appdeployDictDp := map[string]any{}
 	namespace := appdeploy.GetMeta().Namespace
@@ -49,6 +53,7 @@ func resolveAppDeploymentDetail(db *storage.Store, appdeploy *cmdb.AppDeployment
 			setValue := objMap["spec"]
 			// 递归设置 map 的值
 			runtime.RecSetItem(appdeployDict, setPath, setValue)
+			tracer.set(setPath, setValue, FieldSource{objectSource(kind, refMeta.Namespace, name), "spec"})
 		}
 	}
 	result = runtime.Merge2Dict(
@@ -61,6 +66,20 @@ func resolveAppDeploymentDetail(db *storage.Store, appdeploy *cmdb.AppDeployment
 
 // 将 resourceRange 与 AppDeployment 合并，获取渲染后的 AppDeployment
 func ResolveAppDeployment(db *storage.Store, name, namespace string, params map[string]any) (*cmdb.AppDeployment, error) {
+	return resolveAppDeployment(db, name, namespace, params, nil)
+}
+
+// 同 ResolveAppDeployment，并返回合并 ResourceRange 时的冲突
+func ResolveAppDeploymentWithConflicts(db *storage.Store, name, namespace string, params map[string]any) (*cmdb.AppDeployment, []runtime.MergeConflict, error) {
+	tracer := newRenderTracer()
+	appDeploy, err := resolveAppDeployment(db, name, namespace, params, tracer)
+	if err != nil {
+		return nil, nil, err
+	}
+	return appDeploy, tracer.conflicts, nil
+}
+
+func resolveAppDeployment(db *storage.Store, name, namespace string, params map[string]any, tracer *renderTracer) (*cmdb.AppDeployment, error) {
 	// TODO: go-yaml 对于数组空元素存在 bug: https://github.com/goccy/go-yaml/issues/766
 	var err error
 	var appDeploy, resourceRange cmdb.Object
@@ -91,49 +110,69 @@ func ResolveAppDeployment(db *storage.Store, name, namespace string, params map[
 	if err = conversion.StructToMap(resourceRange, &resourceRangeDict); err != nil {
 		return nil, err
 	}
+	// 字段的合并策略，AppDeployment 的注解优先
+	annotations := maps.Clone(resourceRange.GetMeta().Annotations)
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	maps.Copy(annotations, appDeploy.GetMeta().Annotations)
+	strategies, err := runtime.ParseMergeStrategies(annotations)
+	if err != nil {
+		return nil, storage.NewInvalidObjError(fmt.Sprintf("AppDeployment/%s/%s", namespace, name), err.Error())
+	}
+	tracer.set("", appdeployDict, FieldSource{Source: objectSource("AppDeployment", namespace, name)})
+	rrSource := objectSource("ResourceRange", namespace, rrName)
 	appdeployDetailDict := map[string]any{}
 	maps.Copy(appdeployDetailDict, appdeployDict)
 	// 将 ResourceRange 的 spec 合并至 AppDeployment.spec.template.spec
-	runtime.RecSetItem(
-		appdeployDetailDict,
-		"spec.template.spec",
-		runtime.Merge2Dict(
-			appdeployDetailDict["spec"].(map[string]any)["template"].(map[string]any)["spec"].(map[string]any),
-			resourceRangeDict["spec"].(map[string]any),
-			nil,
-		),
+	mergedSpec, conflicts := runtime.Merge2DictWithConflicts(
+		appdeployDetailDict["spec"].(map[string]any)["template"].(map[string]any)["spec"].(map[string]any),
+		resourceRangeDict["spec"].(map[string]any),
+		[]string{"spec"},
+		strategies,
 	)
+	runtime.RecSetItem(appdeployDetailDict, "spec.template.spec", mergedSpec)
+	tracer.merge("spec.template.spec", mergedSpec, FieldSource{rrSource, "spec"}, conflicts)
 	// 将 ResourceRange 的 deployTemplate 合并至 AppDeployment.template.deployTemplate
 	if deployTemplateSeted {
-		runtime.RecSetItem(
-			appdeployDetailDict,
-			"spec.template.deployTemplate",
-			runtime.Merge2Dict(
-				appdeployDetailDict["spec"].(map[string]any)["template"].(map[string]any)["deployTemplate"].(map[string]any),
-				resourceRangeDict["deployTemplate"].(map[string]any),
-				nil,
-			),
+		mergedTpl, conflicts := runtime.Merge2DictWithConflicts(
+			appdeployDetailDict["spec"].(map[string]any)["template"].(map[string]any)["deployTemplate"].(map[string]any),
+			resourceRangeDict["deployTemplate"].(map[string]any),
+			[]string{"deployTemplate"},
+			strategies,
 		)
+		runtime.RecSetItem(appdeployDetailDict, "spec.template.deployTemplate", mergedTpl)
+		tracer.merge("spec.template.deployTemplate", mergedTpl, FieldSource{rrSource, "deployTemplate"}, conflicts)
 	} else {
 		runtime.RecSetItem(
 			appdeployDetailDict,
 			"spec.template.deployTemplate",
 			resourceRangeDict["deployTemplate"].(map[string]any),
 		)
+		tracer.set("spec.template.deployTemplate", resourceRangeDict["deployTemplate"], FieldSource{rrSource, "deployTemplate"})
 	}
 	// 将 AppDeployment 引用的所有对象详情，合并至 AppDeployment 中
 	if appDeployYaml, err = yaml.MarshalWithOptions(appdeployDetailDict, yaml.AutoInt()); err != nil {
 		return nil, err
 	}
-	if appdeployDetailSpec, err = resolveAppDeploymentDetail(db, appDeploy.(*cmdb.AppDeployment), appdeployDetailDict); err != nil {
+	if appdeployDetailSpec, err = resolveAppDeploymentDetail(db, appDeploy.(*cmdb.AppDeployment), appdeployDetailDict, tracer); err != nil {
 		return nil, err
 	}
 	appdeployDetailDict["spec"].(map[string]any)["template"].(map[string]any)["spec"] = appdeployDetailSpec
 	params["spec"] = appdeployDetailSpec
 	params["metadata"] = appdeployDetailDict["metadata"]
-	if appDeployRendered, err = runtime.RenderTemplate(string(appDeployYaml), params); err != nil {
+	funcs, err := newTemplateFuncs(db, namespace)
+	if err != nil {
 		return nil, err
 	}
+	renderOpts := runtime.RenderOptions{
+		Name:      fmt.Sprintf("appdeployment/%s/%s", namespace, name),
+		Strict:    StrictTemplate,
+		Functions: funcs.functions(),
+	}
+	if appDeployRendered, err = runtime.RenderTemplateWithOptions(string(appDeployYaml), params, renderOpts); err != nil {
+		return nil, funcs.renderErr(err)
+	}
 	// 将 AppDeployment 引用的所有对象详情，合并至第一次渲染完成的 AppDeployment 中
 	if err = yaml.UnmarshalWithOptions([]byte(appDeployRendered), &appDeployRenderedDict); err != nil {
 		return nil, err
@@ -146,8 +185,8 @@ func ResolveAppDeployment(db *storage.Store, name, namespace string, params map[
 	// 支持双重引用，即链式引用，例如在 ResourceRange 对象中`spec.env.APPNAME` 值为 `${ spec.app }`，
 	// 当`deployTemplate.values.env`的值写作`${ spec.env }`时，也会正常解析
 	params["spec"] = appdeployRenderDetailDict
-	if appDeploySecRendered, err = runtime.RenderTemplate(appDeployRendered, params); err != nil {
-		return nil, err
+	if appDeploySecRendered, err = runtime.RenderTemplateWithOptions(appDeployRendered, params, renderOpts); err != nil {
+		return nil, funcs.renderErr(err)
 	}
 	if appDeploy, err = stringToObject(appDeploySecRendered); err != nil {
 		return nil, err
@@ -157,13 +196,27 @@ func ResolveAppDeployment(db *storage.Store, name, namespace string, params map[
 
 // 获取渲染后的 DeployTemplate
 func ResolveDeployTemplate(db *storage.Store, name, namespace string, params map[string]any) (*cmdb.DeployTemplate, error) {
+	return resolveDeployTemplate(db, name, namespace, params, nil)
+}
+
+// 同 ResolveDeployTemplate，并返回 AppDeployment 合并 ResourceRange 时的冲突
+func ResolveDeployTemplateWithConflicts(db *storage.Store, name, namespace string, params map[string]any) (*cmdb.DeployTemplate, []runtime.MergeConflict, error) {
+	tracer := newRenderTracer()
+	deployTpl, err := resolveDeployTemplate(db, name, namespace, params, tracer)
+	if err != nil {
+		return nil, nil, err
+	}
+	return deployTpl, tracer.conflicts, nil
+}
+
+func resolveDeployTemplate(db *storage.Store, name, namespace string, params map[string]any, tracer *renderTracer) (*cmdb.DeployTemplate, error) {
 	var appDeploy *cmdb.AppDeployment
 	var deployTpl cmdb.Object
 	var hostNodes []cmdb.Object
 	var deployTplBytes []byte
 	var deployTplRedered, deployTplDeployArgs string
 	var err error
-	if appDeploy, err = ResolveAppDeployment(db, name, namespace, params); err != nil {
+	if appDeploy, err = resolveAppDeployment(db, name, namespace, params, tracer); err != nil {
 		return nil, err
 	}
 	if appDeploy.Spec.Template.Spec.DeployPlatform.Docker != nil {
@@ -183,6 +236,14 @@ func ResolveDeployTemplate(db *storage.Store, name, namespace string, params map
 
 		params["host_nodes"] = hostNodesArray
 	}
+	// 未指定 DeployTemplate 时，Docker 部署使用内置的 docker-compose 模板
+	if tpl := appDeploy.Spec.Template.DeployTemplate; tpl == nil || tpl.Name == "" {
+		if appDeploy.Spec.Template.Spec.DeployPlatform.Docker != nil {
+			return defaultDeployTemplate(db, appDeploy, params)
+		}
+		errMsg := fmt.Sprintf("appDeployment %s/%s spec.template.deployTemplate.name not set.", namespace, name)
+		return nil, fmt.Errorf("%s", errMsg)
+	}
 	deployTplName := appDeploy.Spec.Template.DeployTemplate.Name
 	if err = db.Get(context.Background(), "DeployTemplate", deployTplName, namespace, storage.GetOptions{}, &deployTpl); err != nil {
 		return nil, err
@@ -195,14 +256,33 @@ func ResolveDeployTemplate(db *storage.Store, name, namespace string, params map
 	maps.Copy(params, values)
 	deployTplStr := string(deployTplBytes)
 	// fmt.Printf("deployTplStr: %s", deployTplStr)
-	deployTplRedered, err = runtime.RenderTemplate(deployTplStr, params)
+	funcs, err := newTemplateFuncs(db, namespace)
+	if err != nil {
+		return nil, err
+	}
+	rootName := fmt.Sprintf("deploytemplate/%s/%s", namespace, deployTplName)
+	libs := newTemplateLibraries(db, namespace, rootName, deployTpl.(*cmdb.DeployTemplate).Spec.Libraries)
+	renderOpts := runtime.RenderOptions{
+		Name:      rootName,
+		Strict:    StrictTemplate || deployTpl.(*cmdb.DeployTemplate).Spec.Strict,
+		Functions: funcs.functions(),
+		Reader:    libs.read,
+	}
+	if deployTplRedered, err = runtime.RenderTemplateWithOptions(deployTplStr, params, renderOpts); err != nil {
+		return nil, funcs.renderErr(err)
+	}
 	deployArgs := map[string]any{}
 	for k, v := range appDeploy.Spec.Template.DeployTemplate.DeployArgs {
 		deployArgs[k] = v
 	}
-	deployTplDeployArgs, err = runtime.RenderTemplate(
-		string(deployTpl.(*cmdb.DeployTemplate).Spec.DeployArgs), deployArgs,
+	renderOpts.Name += "/deployArgs"
+	libs.root = renderOpts.Name
+	deployTplDeployArgs, err = runtime.RenderTemplateWithOptions(
+		string(deployTpl.(*cmdb.DeployTemplate).Spec.DeployArgs), deployArgs, renderOpts,
 	)
+	if err != nil {
+		return nil, funcs.renderErr(err)
+	}
 	if deployTpl, err = stringToObject(deployTplRedered); err != nil {
 		return nil, err
 	}
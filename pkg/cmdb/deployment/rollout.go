@@ -0,0 +1,400 @@
+package deployment
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"gcmdb/pkg/cmdb"
+	"gcmdb/pkg/cmdb/server/storage"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/imroc/req/v3"
+)
+
+// 健康探测的默认超时时间
+const defaultHealthCheckTimeout = 60 * time.Second
+
+// 健康探测间隔
+var probeInterval = 2 * time.Second
+
+// 获取滚动发布策略，未启用时返回 nil
+func rollingStrategy(appDeploy *cmdb.AppDeployment) *cmdb.RollingUpdateStrategy {
+	strategy := appDeploy.Spec.Strategy
+	if strategy == nil || strategy.Type != cmdb.DeployStrategyRolling {
+		return nil
+	}
+	if strategy.RollingUpdate == nil {
+		return &cmdb.RollingUpdateStrategy{}
+	}
+	return strategy.RollingUpdate
+}
+
+// 每批次发布的 AppInstance 数量，不超过 maxUnavailable
+func rolloutBatchSize(strategy *cmdb.RollingUpdateStrategy) int {
+	size := strategy.BatchSize
+	if strategy.MaxUnavailable > 0 && (size == 0 || strategy.MaxUnavailable < size) {
+		size = strategy.MaxUnavailable
+	}
+	return max(size, 1)
+}
+
+// 为 AppInstance 分配发布批次，返回批次总数
+func assignBatches(insts []cmdb.AppInstance, size int) int {
+	for i := range insts {
+		insts[i].Status.Batch = i/size + 1
+	}
+	return (len(insts) + size - 1) / size
+}
+
+// 判断批次内的 AppInstance 是否都已完成
+func batchCompleted(insts []cmdb.AppInstance, batch int) bool {
+	for _, inst := range insts {
+		if inst.Status.Batch == batch && inst.Status.FlowRunStatus != cmdb.FlowRunCompleted {
+			return false
+		}
+	}
+	return true
+}
+
+// 规划分批发布，滚动发布仅支持 Docker 部署平台
+func (c *DeployController) planRollout(insts *[]cmdb.AppInstance) error {
+	if canaryStrategy(c.appDeploy) != nil {
+		return c.planCanary(insts)
+	}
+	strategy := rollingStrategy(c.appDeploy)
+	if strategy == nil {
+		return nil
+	}
+	typ, err := c.platformType()
+	if err != nil {
+		return err
+	}
+	if typ != DPDocker {
+		errMsg := fmt.Sprintf("appDeployment %s/%s rolling strategy only support %s.", c.namespace, c.name, DPDocker)
+		return fmt.Errorf("%s", errMsg)
+	}
+	if strategy.HealthCheck {
+		if probe := c.appDeploy.Spec.Template.Spec.Monitoring; probe == nil || probe.Probe == nil {
+			errMsg := fmt.Sprintf("appDeployment %s/%s health check require spec.monitoring.probe.", c.namespace, c.name)
+			return fmt.Errorf("%s", errMsg)
+		}
+	}
+	batches := assignBatches(*insts, rolloutBatchSize(strategy))
+	c.rollout = &cmdb.AppDeploymentRollout{Batch: 1, Batches: batches}
+	return nil
+}
+
+// 分批发布的推进间隔
+var RolloutInterval = 5 * time.Second
+
+// 分批发布推进锁的有效期(秒)，避免多个服务端重复推进
+const rolloutLockTTL int64 = 60
+
+func rolloutLockName(name, namespace string) string {
+	return fmt.Sprintf("rollouts/%s/%s", namespace, name)
+}
+
+// 当前批次完成后记录下一批次的发布时间及健康探测截止时间，由 RunRollouts 推进
+func advanceRollout(db *storage.Store, appDeploy *cmdb.AppDeployment, now time.Time) error {
+	rollout := appDeploy.Rollout
+	if rollout.NextBatchTime != nil {
+		return nil
+	}
+	strategy := rollingStrategy(appDeploy)
+	if strategy == nil {
+		strategy = &cmdb.RollingUpdateStrategy{}
+	}
+	next := now.Add(time.Duration(strategy.PauseSeconds) * time.Second)
+	rollout.NextBatchTime = &next
+	if strategy.HealthCheck {
+		timeout := defaultHealthCheckTimeout
+		if strategy.HealthCheckTimeout > 0 {
+			timeout = time.Duration(strategy.HealthCheckTimeout) * time.Second
+		}
+		deadline := now.Add(timeout)
+		rollout.HealthCheckDeadline = &deadline
+	}
+	rollout.Message = fmt.Sprintf("batch %d/%d completed, next batch at %s", rollout.Batch, rollout.Batches, next.Format(time.RFC3339))
+	return db.Update(context.Background(), appDeploy, nil)
+}
+
+// 按推进间隔发布到期的下一批次，直到 ctx 结束
+func RunRollouts(ctx context.Context, db *storage.Store, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := AdvanceDueRollouts(db, time.Now()); err != nil {
+			log.Printf("rollout: %s", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// 推进所有在 now 之前到期的分批发布及金丝雀分析，并续期等待中或 flow run 运行中的部署持有的部署锁
+func AdvanceDueRollouts(db *storage.Store, now time.Time) error {
+	var objs []cmdb.Object
+	if err := db.GetList(context.Background(), "AppDeployment", "", storage.ListOptions{All: true}, &objs); err != nil {
+		return err
+	}
+	var errs []error
+	for _, o := range objs {
+		appDeploy, ok := o.(*cmdb.AppDeployment)
+		if !ok {
+			continue
+		}
+		keep := rolloutWaiting(appDeploy)
+		var err error
+		if !keep {
+			keep, err = flowRunsActive(db, appDeploy)
+		}
+		if err == nil && keep {
+			err = syncDeployLock(db, appDeploy)
+		}
+		if err == nil && rolloutDue(appDeploy, now) {
+			err = advanceDueRollout(db, appDeploy, now)
+		} else if err == nil && canaryAnalysisDue(appDeploy, now) {
+			err = analyzeDueCanary(db, appDeploy, now)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("appDeployment %s/%s: %w", appDeploy.Metadata.Namespace, appDeploy.Metadata.Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// 判断分批发布是否在等待下一批次、金丝雀分析或 promote，等待期间没有状态回调续期部署锁
+func rolloutWaiting(appDeploy *cmdb.AppDeployment) bool {
+	rollout := appDeploy.Rollout
+	if appDeploy.Status != cmdb.AppDeploymentDeploying || rollout == nil || rollout.Aborted {
+		return false
+	}
+	return rollout.NextBatchTime != nil || rollout.AnalysisTime != nil || rollout.Paused
+}
+
+// 判断分批发布的下一批次是否到期
+func rolloutDue(appDeploy *cmdb.AppDeployment, now time.Time) bool {
+	rollout := appDeploy.Rollout
+	if appDeploy.Status != cmdb.AppDeploymentDeploying || rollout == nil || rollout.Canary || rollout.Aborted {
+		return false
+	}
+	return rollout.NextBatchTime != nil && !rollout.NextBatchTime.After(now)
+}
+
+// 健康探测当前批次，健康后发布下一批次，超过探测截止时间仍未健康则中止发布
+func advanceDueRollout(db *storage.Store, appDeploy *cmdb.AppDeployment, now time.Time) error {
+	name := appDeploy.Metadata.Name
+	namespace := appDeploy.Metadata.Namespace
+	lockName := rolloutLockName(name, namespace)
+	if _, err := db.AcquireLock(context.Background(), lockName, LockOwner, rolloutLockTTL); err != nil {
+		// 其他服务端正在推进
+		if storage.IsLocked(err) {
+			return nil
+		}
+		return err
+	}
+	defer db.ReleaseLock(context.Background(), lockName)
+	// 加锁后重新读取，确认下一批次未被推进，且期间未被中止或重新部署
+	latest, err := getAppDeployment(db, name, namespace)
+	if err != nil {
+		return err
+	}
+	if !rolloutDue(latest, now) || latest.DeployRevision != appDeploy.DeployRevision {
+		return nil
+	}
+	insts, err := liveAppInstances(db, name, namespace)
+	if err != nil {
+		return err
+	}
+	current := currentAppInstances(latest, insts)
+	if err = checkBatchHealthy(latest, current); err != nil {
+		deadline := latest.Rollout.HealthCheckDeadline
+		if deadline == nil || now.After(*deadline) {
+			_, err = haltRollout(db, name, namespace, err.Error())
+			return err
+		}
+		// 未到截止时间，下次推进时重新探测
+		return nil
+	}
+	if err = startNextBatch(db, latest, current); err != nil {
+		_, haltErr := haltRollout(db, name, namespace, err.Error())
+		return errors.Join(err, haltErr)
+	}
+	return nil
+}
+
+// 探测当前批次的 AppInstance，未启用健康检查时直接通过
+func checkBatchHealthy(appDeploy *cmdb.AppDeployment, insts []cmdb.AppInstance) error {
+	strategy := rollingStrategy(appDeploy)
+	if strategy == nil || !strategy.HealthCheck {
+		return nil
+	}
+	for _, inst := range insts {
+		if inst.Status.Batch != appDeploy.Rollout.Batch {
+			continue
+		}
+		if err := probeAppInstance(&inst); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 发布下一批次
+func startNextBatch(db *storage.Store, appDeploy *cmdb.AppDeployment, insts []cmdb.AppInstance) error {
+	batch := appDeploy.Rollout.Batch
+	next := []cmdb.AppInstance{}
+	for _, inst := range insts {
+		if inst.Status.Batch == batch+1 {
+			next = append(next, inst)
+		}
+	}
+	c := &DeployController{
+		store:           db,
+		name:            appDeploy.Metadata.Name,
+		namespace:       appDeploy.Metadata.Namespace,
+		action:          DeployRelease,
+		appDeploy:       appDeploy,
+		newAppInstances: &next,
+		flowRunId:       appDeploy.FlowRunId,
+		rollout:         &cmdb.AppDeploymentRollout{Batch: batch + 1, Batches: appDeploy.Rollout.Batches},
+	}
+	if err := c.runPrefectDeployment(); err != nil {
+		return err
+	}
+	if err := c.setAppInstanceStatus(); err != nil {
+		return err
+	}
+	appDeploy.Rollout = c.rollout
+	return db.Update(context.Background(), appDeploy, nil)
+}
+
+// 中止分批发布，取消未发布的 AppInstance 并标记 AppDeployment 失败
+func haltRollout(db *storage.Store, name, namespace, message string) (*cmdb.AppDeployment, error) {
+	appDeploy, err := getAppDeployment(db, name, namespace)
+	if err != nil {
+		return nil, err
+	}
+	insts, err := liveAppInstances(db, name, namespace)
+	if err != nil {
+		return nil, err
+	}
+	for _, inst := range currentAppInstances(appDeploy, insts) {
+		if inst.Status.FlowRunStatus != cmdb.FlowRunPending {
+			continue
+		}
+		inst.Status.FlowRunStatus = cmdb.FlowRunCancelled
+		if err = db.Update(context.Background(), &inst, nil); err != nil {
+			return nil, err
+		}
+	}
+	appDeploy.Status = cmdb.AppDeploymentFailed
+	if appDeploy.Rollout != nil {
+		appDeploy.Rollout.Message = message
+	}
+	if err = db.Update(context.Background(), appDeploy, nil); err != nil {
+		return nil, err
+	}
+	if err = syncDeployLock(db, appDeploy); err != nil {
+		return nil, err
+	}
+	return appDeploy, nil
+}
+
+// 取消进行中的部署，运行中的 flow run 请求取消，其余处理同 haltRollout
+func cancelDeployment(db *storage.Store, name, namespace, message string) (*cmdb.AppDeployment, error) {
+	appDeploy, err := getAppDeployment(db, name, namespace)
+	if err != nil {
+		return nil, err
+	}
+	insts, err := liveAppInstances(db, name, namespace)
+	if err != nil {
+		return nil, err
+	}
+	cancelled := []string{}
+	for _, inst := range currentAppInstances(appDeploy, insts) {
+		if inst.Status.FlowRunStatus != cmdb.FlowRunRunning && inst.Status.FlowRunStatus != cmdb.FlowRunPaused {
+			continue
+		}
+		if inst.FlowRunId != "" && !slices.Contains(cancelled, inst.FlowRunId) {
+			if err = cancelFlowRun(inst.FlowRunId); err != nil {
+				return nil, err
+			}
+			cancelled = append(cancelled, inst.FlowRunId)
+		}
+		inst.Status.FlowRunStatus = cmdb.FlowRunCancelling
+		if err = db.Update(context.Background(), &inst, nil); err != nil {
+			return nil, err
+		}
+	}
+	return haltRollout(db, name, namespace, message)
+}
+
+// 请求 Prefect 取消 flow run，未配置 Prefect API 时跳过
+func cancelFlowRun(flowRunId string) error {
+	if PrefectApiUrl == "" {
+		return nil
+	}
+	url := strings.TrimSuffix(PrefectApiUrl, "/") + "/flow_runs/" + flowRunId + "/set_state"
+	body := map[string]any{"state": map[string]any{"type": "CANCELLING"}}
+	resp, err := req.C().SetTimeout(10 * time.Second).R().SetBody(body).Post(url)
+	if err != nil {
+		return err
+	}
+	// flow run 已不存在时无需取消
+	if resp.IsErrorState() && resp.StatusCode != http.StatusNotFound {
+		errMsg := fmt.Sprintf("cancel flow run %s failed, orchestrator response code %d: %s", flowRunId, resp.StatusCode, resp.String())
+		return fmt.Errorf("%s", errMsg)
+	}
+	return nil
+}
+
+// 通过 monitoring.probe.httpGet 探测 AppInstance，直至健康或超时
+func waitAppInstanceHealthy(inst *cmdb.AppInstance, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		err := probeAppInstance(inst)
+		if err == nil || time.Now().After(deadline) {
+			return err
+		}
+		time.Sleep(probeInterval)
+	}
+}
+
+// 通过 monitoring.probe.httpGet 探测一次 AppInstance
+func probeAppInstance(inst *cmdb.AppInstance) error {
+	url, err := appInstanceProbeUrl(inst)
+	if err != nil {
+		return err
+	}
+	client := http.Client{Timeout: probeInterval}
+	resp, err := client.Get(url)
+	if err == nil {
+		resp.Body.Close()
+		if resp.StatusCode >= 200 && resp.StatusCode < 400 {
+			return nil
+		}
+		err = fmt.Errorf("status code %d", resp.StatusCode)
+	}
+	return fmt.Errorf("appInstance %s health check %s failed: %s", inst.Metadata.Name, url, err.Error())
+}
+
+func appInstanceProbeUrl(inst *cmdb.AppInstance) (string, error) {
+	spec := inst.Spec
+	if spec.Monitoring == nil || spec.Monitoring.Probe == nil {
+		return "", fmt.Errorf("appInstance %s spec.monitoring.probe not set", inst.Metadata.Name)
+	}
+	if spec.DeployPlatform == nil || spec.DeployPlatform.Docker == nil || spec.DeployPlatform.Docker.NodeIP == "" {
+		return "", fmt.Errorf("appInstance %s spec.deployPlatform.docker.nodeIP not set", inst.Metadata.Name)
+	}
+	httpGet := spec.Monitoring.Probe.HttpGet
+	path := strings.TrimPrefix(httpGet.Path, "/")
+	return fmt.Sprintf("http://%s:%s/%s", spec.DeployPlatform.Docker.NodeIP, httpGet.Port, path), nil
+}
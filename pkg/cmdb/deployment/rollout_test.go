@@ -0,0 +1,126 @@
+package deployment
+
+import (
+	"gcmdb/pkg/cmdb"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRolloutBatchSize(t *testing.T) {
+	tests := []struct {
+		strategy cmdb.RollingUpdateStrategy
+		want     int
+	}{
+		{cmdb.RollingUpdateStrategy{}, 1},
+		{cmdb.RollingUpdateStrategy{BatchSize: 3}, 3},
+		{cmdb.RollingUpdateStrategy{MaxUnavailable: 2}, 2},
+		{cmdb.RollingUpdateStrategy{BatchSize: 3, MaxUnavailable: 2}, 2},
+		{cmdb.RollingUpdateStrategy{BatchSize: 2, MaxUnavailable: 4}, 2},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, rolloutBatchSize(&tt.strategy))
+	}
+}
+
+func TestAssignBatches(t *testing.T) {
+	insts := make([]cmdb.AppInstance, 5)
+	batches := assignBatches(insts, 2)
+	assert.Equal(t, 3, batches)
+	var got []int
+	for _, inst := range insts {
+		got = append(got, inst.Status.Batch)
+	}
+	assert.Equal(t, []int{1, 1, 2, 2, 3}, got)
+
+	insts[0].Status.FlowRunStatus = cmdb.FlowRunCompleted
+	assert.False(t, batchCompleted(insts, 1))
+	insts[1].Status.FlowRunStatus = cmdb.FlowRunCompleted
+	assert.True(t, batchCompleted(insts, 1))
+}
+
+func TestRollingStrategy(t *testing.T) {
+	appDeploy := cmdb.NewAppDeployment()
+	assert.Nil(t, rollingStrategy(appDeploy))
+	appDeploy.Spec.Strategy = &cmdb.DeployStrategy{Type: cmdb.DeployStrategyAll}
+	assert.Nil(t, rollingStrategy(appDeploy))
+	appDeploy.Spec.Strategy = &cmdb.DeployStrategy{Type: cmdb.DeployStrategyRolling}
+	assert.Equal(t, &cmdb.RollingUpdateStrategy{}, rollingStrategy(appDeploy))
+}
+
+func TestWaitAppInstanceHealthy(t *testing.T) {
+	probeInterval = 10 * time.Millisecond
+	healthy := false
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/actuator/health", r.URL.Path)
+		if !healthy {
+			healthy = true
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+	host, port, _ := net.SplitHostPort(ts.Listener.Addr().String())
+
+	inst := cmdb.NewAppInstance()
+	inst.Metadata.Name = "go-app--test--abcde"
+	assert.Error(t, waitAppInstanceHealthy(inst, time.Second))
+
+	inst.Spec.Monitoring = &cmdb.ResourceRangeMonitoring{
+		Probe: &cmdb.MonitoringProbe{HttpGet: cmdb.MonitoringProbeHttpGet{Path: "actuator/health", Port: port}},
+	}
+	assert.Error(t, waitAppInstanceHealthy(inst, time.Second))
+
+	inst.Spec.DeployPlatform = &cmdb.ResourceRangeDeployPlatform{Docker: &cmdb.DeployPlatformDocker{NodeIP: host}}
+	assert.NoError(t, waitAppInstanceHealthy(inst, time.Second))
+
+	ts.Close()
+	assert.Error(t, waitAppInstanceHealthy(inst, 50*time.Millisecond))
+}
+
+func TestRolloutDue(t *testing.T) {
+	now := time.Now()
+	appDeploy := cmdb.NewAppDeployment()
+	assert.False(t, rolloutDue(appDeploy, now))
+	appDeploy.Status = cmdb.AppDeploymentDeploying
+	appDeploy.Rollout = &cmdb.AppDeploymentRollout{Batch: 1, Batches: 2}
+	assert.False(t, rolloutDue(appDeploy, now))
+
+	next := now.Add(time.Minute)
+	appDeploy.Rollout.NextBatchTime = &next
+	assert.False(t, rolloutDue(appDeploy, now))
+	assert.True(t, rolloutDue(appDeploy, next))
+
+	appDeploy.Rollout.Aborted = true
+	assert.False(t, rolloutDue(appDeploy, next))
+	appDeploy.Rollout.Aborted = false
+	appDeploy.Status = cmdb.AppDeploymentFailed
+	assert.False(t, rolloutDue(appDeploy, next))
+}
+
+func TestCanaryAnalysisDue(t *testing.T) {
+	now := time.Now()
+	appDeploy := cmdb.NewAppDeployment()
+	assert.False(t, canaryAnalysisDue(appDeploy, now))
+	appDeploy.Status = cmdb.AppDeploymentDeploying
+	appDeploy.Rollout = &cmdb.AppDeploymentRollout{Batch: 1, Batches: 2, Canary: true}
+	assert.False(t, canaryAnalysisDue(appDeploy, now))
+
+	analysisTime := now.Add(time.Minute)
+	appDeploy.Rollout.AnalysisTime = &analysisTime
+	assert.False(t, canaryAnalysisDue(appDeploy, now))
+	assert.True(t, canaryAnalysisDue(appDeploy, analysisTime))
+	assert.True(t, rolloutWaiting(appDeploy))
+	assert.False(t, rolloutDue(appDeploy, analysisTime))
+
+	appDeploy.Rollout.Analysis = canaryAnalysisPassed
+	assert.False(t, canaryAnalysisDue(appDeploy, analysisTime))
+	appDeploy.Rollout.Analysis = ""
+	appDeploy.Rollout.Aborted = true
+	assert.False(t, canaryAnalysisDue(appDeploy, analysisTime))
+}
@@ -0,0 +1,184 @@
+package deployment
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"gcmdb/pkg/cmdb"
+	"gcmdb/pkg/cmdb/runtime"
+	"gcmdb/pkg/cmdb/server/storage"
+	"log"
+	"maps"
+	"time"
+)
+
+// 定时部署的检查间隔
+var ScheduleInterval = 30 * time.Second
+
+// 定时部署调度锁的有效期(秒)，避免多个服务端重复触发
+const scheduleLockTTL int64 = 60
+
+func scheduleLockName(name, namespace string) string {
+	return fmt.Sprintf("schedules/%s/%s", namespace, name)
+}
+
+// 按检查间隔触发到期的 Schedule，直到 ctx 结束
+func RunScheduler(ctx context.Context, db *storage.Store, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := RunDueSchedules(db, time.Now()); err != nil {
+			log.Printf("scheduler: %s", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// 触发所有在 now 之前到期的 Schedule，并记录上次及下次运行时间
+func RunDueSchedules(db *storage.Store, now time.Time) error {
+	var objs []cmdb.Object
+	if err := db.GetList(context.Background(), "Schedule", "", storage.ListOptions{All: true}, &objs); err != nil {
+		return err
+	}
+	var errs []error
+	for _, o := range objs {
+		if s, ok := o.(*cmdb.Schedule); ok {
+			if err := runSchedule(db, s, now); err != nil {
+				errs = append(errs, fmt.Errorf("schedule %s/%s: %w", s.Metadata.Namespace, s.Metadata.Name, err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func runSchedule(db *storage.Store, s *cmdb.Schedule, now time.Time) error {
+	cron, loc, err := parseSchedule(&s.Spec)
+	if err != nil {
+		return err
+	}
+	now = now.In(loc)
+	status := s.Status
+	if s.Spec.Suspend {
+		status.NextScheduleTime = nil
+		return updateScheduleStatus(db, s, status)
+	}
+	// 错过的多次运行只补一次
+	if due := lastDueTime(cron, scheduleBaseTime(s).In(loc), now); !due.IsZero() {
+		lockName := scheduleLockName(s.Metadata.Name, s.Metadata.Namespace)
+		if _, err = db.AcquireLock(context.Background(), lockName, LockOwner, scheduleLockTTL); err != nil {
+			// 其他服务端正在处理
+			if storage.IsLocked(err) {
+				return nil
+			}
+			return err
+		}
+		defer db.ReleaseLock(context.Background(), lockName)
+		// 加锁后重新读取，确认本次运行未被触发
+		if s, err = getSchedule(db, s.Metadata.Name, s.Metadata.Namespace); err != nil {
+			return err
+		}
+		status = s.Status
+		if status.LastScheduleTime == nil || status.LastScheduleTime.Before(due) {
+			status.LastResult, status.Message = triggerSchedule(db, s)
+			status.LastScheduleTime = &due
+		}
+	}
+	next := cron.Next(now)
+	status.NextScheduleTime = nil
+	if !next.IsZero() {
+		status.NextScheduleTime = &next
+	}
+	return updateScheduleStatus(db, s, status)
+}
+
+func parseSchedule(spec *cmdb.ScheduleSpec) (*runtime.CronSchedule, *time.Location, error) {
+	cron, err := runtime.ParseCron(spec.Schedule)
+	if err != nil {
+		return nil, nil, err
+	}
+	loc := time.Local
+	if spec.TimeZone != "" {
+		if loc, err = time.LoadLocation(spec.TimeZone); err != nil {
+			return nil, nil, err
+		}
+	}
+	return cron, loc, nil
+}
+
+// 计算到期时间的起点：上次运行时间与最近一次变更时间中较晚者，变更前错过的运行不再补
+func scheduleBaseTime(s *cmdb.Schedule) time.Time {
+	var base time.Time
+	for _, t := range []*time.Time{s.Metadata.CreationTimeStamp, s.Metadata.ManagedFields.Time, s.Status.LastScheduleTime} {
+		if t != nil && t.After(base) {
+			base = *t
+		}
+	}
+	return base
+}
+
+// base 之后、now 之前(含)最近的运行时间，无则返回零值
+func lastDueTime(cron *runtime.CronSchedule, base, now time.Time) time.Time {
+	var due time.Time
+	for t := cron.Next(base); !t.IsZero() && !t.After(now); t = cron.Next(t) {
+		due = t
+	}
+	return due
+}
+
+// 按并发策略触发部署
+func triggerSchedule(db *storage.Store, s *cmdb.Schedule) (cmdb.ScheduleResult, string) {
+	name, namespace := s.Spec.AppDeployment, s.Metadata.Namespace
+	appDeploy, err := getAppDeployment(db, name, namespace)
+	if err != nil {
+		return cmdb.ScheduleFailed, err.Error()
+	}
+	deploying := appDeploy.Status == cmdb.AppDeploymentDeploying || appDeploy.Status == cmdb.AppDeploymentUninstalling
+	switch s.Spec.ConcurrencyPolicy {
+	case cmdb.ScheduleConcurrencyForbid:
+		if deploying {
+			return cmdb.ScheduleSkipped, fmt.Sprintf("appDeployment %s/%s is %s.", namespace, name, appDeploy.Status)
+		}
+	case cmdb.ScheduleConcurrencyReplace:
+		// 先取消进行中的部署并释放部署锁，再以本次运行替代
+		if deploying {
+			message := fmt.Sprintf("replaced by schedule %s.", s.Metadata.Name)
+			if _, err = cancelDeployment(db, name, namespace, message); err != nil {
+				return cmdb.ScheduleFailed, err.Error()
+			}
+		}
+	}
+	params := maps.Clone(s.Spec.Params)
+	if params == nil {
+		params = map[string]any{}
+	}
+	requester := fmt.Sprintf("schedule/%s", s.Metadata.Name)
+	if _, err = NewDeployController(db, DeployAction(s.Spec.Action), name, namespace, params).WithRequester(requester).Run(); err != nil {
+		if _, ok := err.(ApprovalRequiredError); ok {
+			return cmdb.SchedulePendingApproval, err.Error()
+		}
+		return cmdb.ScheduleFailed, err.Error()
+	}
+	return cmdb.ScheduleStarted, fmt.Sprintf("appDeployment %s/%s %s started.", namespace, name, s.Spec.Action)
+}
+
+func getSchedule(db *storage.Store, name, namespace string) (*cmdb.Schedule, error) {
+	var obj cmdb.Object
+	if err := db.Get(context.Background(), "Schedule", name, namespace, storage.GetOptions{}, &obj); err != nil {
+		return nil, err
+	}
+	return obj.(*cmdb.Schedule), nil
+}
+
+// 仅更新 status，避免覆盖期间对 spec 的修改
+func updateScheduleStatus(db *storage.Store, s *cmdb.Schedule, status cmdb.ScheduleStatus) error {
+	latest, err := getSchedule(db, s.Metadata.Name, s.Metadata.Namespace)
+	if err != nil {
+		return err
+	}
+	latest.Status = status
+	return db.Update(context.Background(), latest, nil)
+}
@@ -0,0 +1,40 @@
+package deployment
+
+import (
+	"gcmdb/pkg/cmdb"
+	"gcmdb/pkg/cmdb/runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLastDueTime(t *testing.T) {
+	cron, _ := runtime.ParseCron("0 * * * *")
+	base := time.Date(2024, 1, 1, 10, 30, 0, 0, time.UTC)
+	assert.True(t, lastDueTime(cron, base, base.Add(20*time.Minute)).IsZero())
+	// 错过多次运行时只返回最近一次
+	due := lastDueTime(cron, base, base.Add(3*time.Hour))
+	assert.Equal(t, time.Date(2024, 1, 1, 13, 0, 0, 0, time.UTC), due)
+}
+
+func TestScheduleBaseTime(t *testing.T) {
+	s := cmdb.NewSchedule()
+	created := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	updated := created.Add(time.Hour)
+	last := created.Add(2 * time.Hour)
+	s.Metadata.CreationTimeStamp = &created
+	assert.Equal(t, created, scheduleBaseTime(s))
+	s.Metadata.ManagedFields.Time = &updated
+	assert.Equal(t, updated, scheduleBaseTime(s))
+	s.Status.LastScheduleTime = &last
+	assert.Equal(t, last, scheduleBaseTime(s))
+}
+
+func TestParseScheduleTimeZone(t *testing.T) {
+	_, loc, err := parseSchedule(&cmdb.ScheduleSpec{Schedule: "@daily", TimeZone: "Asia/Shanghai"})
+	assert.NoError(t, err)
+	assert.Equal(t, "Asia/Shanghai", loc.String())
+	_, _, err = parseSchedule(&cmdb.ScheduleSpec{Schedule: "@daily", TimeZone: "Mars/Base"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,88 @@
+package deployment
+
+import (
+	"fmt"
+	"gcmdb/pkg/cmdb"
+	"gcmdb/pkg/cmdb/server/storage"
+	"strings"
+	"time"
+)
+
+// AppInstance 的部署状态
+type AppInstanceState struct {
+	Name string `json:"name"`
+	// 部署目标，Docker 为 HostNode 名称，Kubernetes 为集群/命名空间/helm release
+	Target        string                `json:"target"`
+	Revision      int64                 `json:"revision"`
+	Batch         int                   `json:"batch,omitempty"`
+	FlowRunId     string                `json:"flow_run_id,omitempty"`
+	FlowRunStatus cmdb.FlowRunStatus    `json:"flowRunStatus"`
+	Phase         cmdb.AppInstancePhase `json:"phase,omitempty"`
+	CreatedAt     *time.Time            `json:"createdAt,omitempty"`
+	// 最近一次运行(状态更新)的时间
+	LastRunAt *time.Time `json:"lastRunAt,omitempty"`
+}
+
+// AppDeployment 及其当前版本所有 AppInstance 的部署状态
+type AppDeploymentState struct {
+	Name           string                     `json:"name"`
+	Namespace      string                     `json:"namespace"`
+	Status         cmdb.AppDeploymentStuatus  `json:"status"`
+	DeployRevision int64                      `json:"deployRevision"`
+	FlowRunId      string                     `json:"flow_run_id,omitempty"`
+	Rollout        *cmdb.AppDeploymentRollout `json:"rollout,omitempty"`
+	// 各 flow run 状态的 AppInstance 数量
+	Counts    map[cmdb.FlowRunStatus]int `json:"counts"`
+	Instances []AppInstanceState         `json:"instances"`
+	LastError string                     `json:"lastError,omitempty"`
+	// 部署或卸载已结束
+	Done bool `json:"done"`
+}
+
+// 查询 AppDeployment 的部署状态
+func GetAppDeploymentStatus(db *storage.Store, name, namespace string) (*AppDeploymentState, error) {
+	appDeploy, err := getAppDeployment(db, name, namespace)
+	if err != nil {
+		return nil, err
+	}
+	insts, err := liveAppInstances(db, name, namespace)
+	if err != nil {
+		return nil, err
+	}
+	state := &AppDeploymentState{
+		Name:           name,
+		Namespace:      namespace,
+		Status:         appDeploy.Status,
+		DeployRevision: appDeploy.DeployRevision,
+		FlowRunId:      appDeploy.FlowRunId,
+		Rollout:        appDeploy.Rollout,
+		Counts:         map[cmdb.FlowRunStatus]int{},
+		Instances:      []AppInstanceState{},
+		Done:           appDeploy.Status != cmdb.AppDeploymentDeploying && appDeploy.Status != cmdb.AppDeploymentUninstalling,
+	}
+	var failed []string
+	for _, inst := range currentAppInstances(appDeploy, insts) {
+		state.Counts[inst.Status.FlowRunStatus]++
+		state.Instances = append(state.Instances, AppInstanceState{
+			Name:          inst.Metadata.Name,
+			Target:        appInstanceTarget(&inst),
+			Revision:      appInstanceRevision(&inst),
+			Batch:         inst.Status.Batch,
+			FlowRunId:     inst.FlowRunId,
+			FlowRunStatus: inst.Status.FlowRunStatus,
+			Phase:         inst.Status.Phase,
+			CreatedAt:     inst.Metadata.CreationTimeStamp,
+			LastRunAt:     inst.Metadata.ManagedFields.Time,
+		})
+		switch inst.Status.FlowRunStatus {
+		case cmdb.FlowRunFailed, cmdb.FlowRunCrashed:
+			failed = append(failed, fmt.Sprintf("appInstance %s(%s) flow run %s", inst.Metadata.Name, appInstanceTarget(&inst), inst.Status.FlowRunStatus))
+		}
+	}
+	// 分批发布中止的原因在前，其后为失败的 AppInstance
+	if appDeploy.Status == cmdb.AppDeploymentFailed && appDeploy.Rollout != nil && appDeploy.Rollout.Message != "" {
+		failed = append([]string{appDeploy.Rollout.Message}, failed...)
+	}
+	state.LastError = strings.Join(failed, "; ")
+	return state, nil
+}
@@ -0,0 +1,401 @@
+package deployment
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"gcmdb/pkg/cmdb"
+	"gcmdb/pkg/cmdb/server/storage"
+	"net/url"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/imroc/req/v3"
+)
+
+const (
+	TagSortSemver = "semver"
+	TagSortDate   = "date"
+)
+
+const (
+	// 镜像仓库每次返回的标签数
+	registryPageSize = 100
+	// 未指定每页数量时返回的标签数
+	DefaultTagPageSize = 20
+	// 并发查询标签创建时间的请求数
+	tagCreatedConcurrency = 8
+	// 镜像仓库单次请求的超时时间
+	registryRequestTimeout = 10 * time.Second
+	// 创建时间缓存的最大条目数，超出后清空
+	imageCreatedCacheSize = 10000
+)
+
+// 镜像配置的创建时间，配置按 digest 寻址不可变，以仓库地址及 digest 为键缓存
+var imageCreatedCache = struct {
+	sync.Mutex
+	m map[string]*time.Time
+}{m: map[string]*time.Time{}}
+
+var manifestMediaTypes = []string{
+	"application/vnd.docker.distribution.manifest.v2+json",
+	"application/vnd.docker.distribution.manifest.list.v2+json",
+	"application/vnd.oci.image.manifest.v1+json",
+	"application/vnd.oci.image.index.v1+json",
+}
+
+type ImageTag struct {
+	Name    string     `json:"name"`
+	Created *time.Time `json:"created,omitempty"`
+}
+
+type ImageTagList struct {
+	Image string     `json:"image"`
+	Total int        `json:"total"`
+	Tags  []ImageTag `json:"tags"`
+}
+
+type ImageTagOptions struct {
+	// semver | date，均为倒序，默认 semver。
+	// date 需要查询每个标签的 manifest，标签较多(数百个以上)的仓库可能超过请求超时时间，建议使用 semver
+	Sort string
+	// 从 1 开始
+	Page int
+	// 0 表示不分页
+	PageSize int
+}
+
+// 查询 AppDeployment 应用镜像在镜像仓库中的标签，ctx 结束时停止查询
+func ListImageTags(ctx context.Context, db *storage.Store, name, namespace string, opts ImageTagOptions) (*ImageTagList, error) {
+	appDeploy, err := ResolveAppDeployment(db, name, namespace, map[string]any{})
+	if err != nil {
+		return nil, err
+	}
+	spec := appDeploy.Spec.Template.Spec
+	var dpRegistry *cmdb.DPContainerRegistry
+	if spec.DeployPlatform != nil && spec.DeployPlatform.Kubernetes != nil {
+		dpRegistry = spec.DeployPlatform.Kubernetes.ContainerRegistry
+	} else if spec.DeployPlatform != nil && spec.DeployPlatform.Docker != nil {
+		dpRegistry = spec.DeployPlatform.Docker.ContainerRegistry
+	}
+	if dpRegistry == nil || dpRegistry.Name == "" {
+		errMsg := fmt.Sprintf("appDeployment %s/%s has no container registry.", namespace, name)
+		return nil, fmt.Errorf("%s", errMsg)
+	}
+	var obj cmdb.Object
+	if err = db.Get(context.Background(), "ContainerRegistry", dpRegistry.Name, "", storage.GetOptions{}, &obj); err != nil {
+		return nil, err
+	}
+	registry := obj.(*cmdb.ContainerRegistry)
+	rc, err := newRegistryClient(&registry.Spec)
+	if err != nil {
+		return nil, err
+	}
+	repository := path.Join(dpRegistry.Project, spec.App)
+
+	names, err := rc.listTags(ctx, repository)
+	if err != nil {
+		return nil, err
+	}
+	tags := make([]ImageTag, len(names))
+	for i, name := range names {
+		tags[i].Name = name
+	}
+	// 按日期排序时需要查询所有标签的创建时间，否则仅查询当前页
+	if opts.Sort == TagSortDate {
+		if err = rc.fillCreated(ctx, repository, tags); err != nil {
+			return nil, err
+		}
+		slices.SortStableFunc(tags, compareTagDate)
+	} else {
+		slices.SortStableFunc(tags, func(a, b ImageTag) int {
+			return compareSemverTag(b.Name, a.Name)
+		})
+	}
+	result := &ImageTagList{
+		Image: path.Join(registry.Spec.Registry, repository),
+		Total: len(tags),
+		Tags:  pageImageTags(tags, opts.Page, opts.PageSize),
+	}
+	if opts.Sort != TagSortDate {
+		if err = rc.fillCreated(ctx, repository, result.Tags); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+func pageImageTags(tags []ImageTag, page, pageSize int) []ImageTag {
+	if pageSize <= 0 {
+		return tags
+	}
+	page = max(page, 1)
+	start := min((page-1)*pageSize, len(tags))
+	end := min(start+pageSize, len(tags))
+	return tags[start:end]
+}
+
+// 创建时间倒序，无创建时间的排在最后
+func compareTagDate(a, b ImageTag) int {
+	switch {
+	case a.Created == nil && b.Created == nil:
+		return strings.Compare(b.Name, a.Name)
+	case a.Created == nil:
+		return 1
+	case b.Created == nil:
+		return -1
+	}
+	if c := b.Created.Compare(*a.Created); c != 0 {
+		return c
+	}
+	return strings.Compare(b.Name, a.Name)
+}
+
+var semverRegexp = regexp.MustCompile(`^v?(\d+)(?:\.(\d+))?(?:\.(\d+))?(?:-([0-9A-Za-z.-]+))?(?:\+[0-9A-Za-z.-]+)?$`)
+
+// 比较两个标签，非 semver 标签小于 semver 标签，且按名称比较
+func compareSemverTag(a, b string) int {
+	ma, mb := semverRegexp.FindStringSubmatch(a), semverRegexp.FindStringSubmatch(b)
+	switch {
+	case ma == nil && mb == nil:
+		return strings.Compare(a, b)
+	case ma == nil:
+		return -1
+	case mb == nil:
+		return 1
+	}
+	for i := 1; i <= 3; i++ {
+		na, _ := strconv.Atoi(ma[i])
+		nb, _ := strconv.Atoi(mb[i])
+		if na != nb {
+			return na - nb
+		}
+	}
+	// 预发布版本小于正式版本
+	switch {
+	case ma[4] == "" && mb[4] == "":
+		return strings.Compare(a, b)
+	case ma[4] == "":
+		return 1
+	case mb[4] == "":
+		return -1
+	}
+	pa, pb := strings.Split(ma[4], "."), strings.Split(mb[4], ".")
+	for i := 0; i < len(pa) && i < len(pb); i++ {
+		na, errA := strconv.Atoi(pa[i])
+		nb, errB := strconv.Atoi(pb[i])
+		var c int
+		switch {
+		case errA == nil && errB == nil:
+			c = na - nb
+		case errA == nil:
+			c = -1
+		case errB == nil:
+			c = 1
+		default:
+			c = strings.Compare(pa[i], pb[i])
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return len(pa) - len(pb)
+}
+
+// Docker Registry HTTP API V2 客户端，支持 Basic 和 Bearer Token 认证
+type registryClient struct {
+	url      string
+	username string
+	password string
+	// 并发查询时共用 Bearer Token
+	mu    sync.Mutex
+	token string
+}
+
+func newRegistryClient(spec *cmdb.ContainerRegistrySpec) (*registryClient, error) {
+	password, err := base64.StdEncoding.DecodeString(spec.Auth.Password)
+	if err != nil {
+		return nil, err
+	}
+	return &registryClient{
+		url:      strings.TrimSuffix(spec.Url, "/"),
+		username: spec.Auth.Username,
+		password: strings.TrimSpace(string(password)),
+	}, nil
+}
+
+// 请求镜像仓库，返回 401 时按 WWW-Authenticate 获取 Bearer Token 后重试
+func (rc *registryClient) get(ctx context.Context, uri string, headers map[string]string, result any) (*req.Response, error) {
+	for retry := 0; ; retry++ {
+		r := req.C().SetTimeout(registryRequestTimeout).R().SetContext(ctx).SetHeaders(headers).SetSuccessResult(result)
+		if token := rc.bearerToken(); token != "" {
+			r.SetBearerAuthToken(token)
+		} else {
+			r.SetBasicAuth(rc.username, rc.password)
+		}
+		resp, err := r.Get(rc.url + uri)
+		if err != nil {
+			return nil, err
+		}
+		challenge := resp.Header.Get("WWW-Authenticate")
+		if resp.StatusCode == 401 && retry == 0 && strings.HasPrefix(strings.ToLower(challenge), "bearer ") {
+			if err = rc.fetchToken(ctx, challenge); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if resp.IsErrorState() {
+			errMsg := fmt.Sprintf("container registry %s response code %d: %s", rc.url+uri, resp.StatusCode, resp.String())
+			return nil, fmt.Errorf("%s", errMsg)
+		}
+		return resp, nil
+	}
+}
+
+var challengeParamRegexp = regexp.MustCompile(`(\w+)="([^"]*)"`)
+
+func (rc *registryClient) bearerToken() string {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	return rc.token
+}
+
+func (rc *registryClient) fetchToken(ctx context.Context, challenge string) error {
+	params := map[string]string{}
+	for _, m := range challengeParamRegexp.FindAllStringSubmatch(challenge, -1) {
+		params[m[1]] = m[2]
+	}
+	realm := params["realm"]
+	delete(params, "realm")
+	var result struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	resp, err := req.C().SetTimeout(registryRequestTimeout).R().SetContext(ctx).
+		SetBasicAuth(rc.username, rc.password).SetQueryParams(params).SetSuccessResult(&result).Get(realm)
+	if err != nil {
+		return err
+	}
+	if resp.IsErrorState() {
+		errMsg := fmt.Sprintf("container registry auth %s response code %d: %s", realm, resp.StatusCode, resp.String())
+		return fmt.Errorf("%s", errMsg)
+	}
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.token = result.Token
+	if rc.token == "" {
+		rc.token = result.AccessToken
+	}
+	return nil
+}
+
+var nextLinkRegexp = regexp.MustCompile(`<([^>]+)>;\s*rel="?next"?`)
+
+// 查询仓库的所有标签，按 Link 头分页
+func (rc *registryClient) listTags(ctx context.Context, repository string) ([]string, error) {
+	tags := []string{}
+	uri := fmt.Sprintf("/v2/%s/tags/list?n=%d", repository, registryPageSize)
+	for uri != "" {
+		var page struct {
+			Tags []string `json:"tags"`
+		}
+		resp, err := rc.get(ctx, uri, nil, &page)
+		if err != nil {
+			return nil, err
+		}
+		tags = append(tags, page.Tags...)
+		uri = ""
+		if m := nextLinkRegexp.FindStringSubmatch(resp.Header.Get("Link")); m != nil {
+			next, err := url.Parse(m[1])
+			if err != nil {
+				return nil, err
+			}
+			uri = next.RequestURI()
+		}
+	}
+	return tags, nil
+}
+
+// 从镜像配置中读取创建时间，限制并发请求数，任一请求失败或 ctx 结束时返回
+func (rc *registryClient) fillCreated(ctx context.Context, repository string, tags []ImageTag) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	sem := make(chan struct{}, tagCreatedConcurrency)
+	var (
+		wg       sync.WaitGroup
+		errOnce  sync.Once
+		firstErr error
+	)
+	for i := range tags {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			created, err := rc.imageCreated(ctx, repository, tags[i].Name)
+			if err != nil {
+				// 只返回首个错误，其余请求随 cancel 失败
+				errOnce.Do(func() { firstErr = err; cancel() })
+				return
+			}
+			tags[i].Created = created
+		}(i)
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return firstErr
+	}
+	return ctx.Err()
+}
+
+func (rc *registryClient) imageCreated(ctx context.Context, repository, reference string) (*time.Time, error) {
+	type descriptor struct {
+		Digest string `json:"digest"`
+	}
+	var manifest struct {
+		Config    *descriptor  `json:"config"`
+		Manifests []descriptor `json:"manifests"`
+	}
+	headers := map[string]string{"Accept": strings.Join(manifestMediaTypes, ", ")}
+	if _, err := rc.get(ctx, fmt.Sprintf("/v2/%s/manifests/%s", repository, reference), headers, &manifest); err != nil {
+		return nil, err
+	}
+	// 多架构镜像取第一个平台的镜像
+	if manifest.Config == nil && len(manifest.Manifests) > 0 {
+		return rc.imageCreated(ctx, repository, manifest.Manifests[0].Digest)
+	}
+	if manifest.Config == nil {
+		return nil, nil
+	}
+	cacheKey := rc.url + "/" + repository + "@" + manifest.Config.Digest
+	imageCreatedCache.Lock()
+	created, ok := imageCreatedCache.m[cacheKey]
+	imageCreatedCache.Unlock()
+	if ok {
+		return created, nil
+	}
+	var config struct {
+		Created *time.Time `json:"created"`
+	}
+	if _, err := rc.get(ctx, fmt.Sprintf("/v2/%s/blobs/%s", repository, manifest.Config.Digest), nil, &config); err != nil {
+		return nil, err
+	}
+	imageCreatedCache.Lock()
+	if len(imageCreatedCache.m) >= imageCreatedCacheSize {
+		imageCreatedCache.m = map[string]*time.Time{}
+	}
+	imageCreatedCache.m[cacheKey] = config.Created
+	imageCreatedCache.Unlock()
+	return config.Created, nil
+}
@@ -0,0 +1,147 @@
+package deployment
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"gcmdb/pkg/cmdb"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompareSemverTag(t *testing.T) {
+	tags := []string{"latest", "v1.2.0", "1.10.0", "v1.2.0-rc.1", "v1.2.0-rc.10", "v1.2.0-beta", "v2", "abc"}
+	slices.SortFunc(tags, func(a, b string) int { return compareSemverTag(b, a) })
+	assert.Equal(t, []string{"v2", "1.10.0", "v1.2.0", "v1.2.0-rc.10", "v1.2.0-rc.1", "v1.2.0-beta", "latest", "abc"}, tags)
+}
+
+func TestCompareTagDate(t *testing.T) {
+	t1 := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	t2 := t1.Add(time.Hour)
+	tags := []ImageTag{{Name: "a"}, {Name: "b", Created: &t1}, {Name: "c", Created: &t2}, {Name: "d"}}
+	slices.SortFunc(tags, compareTagDate)
+	var names []string
+	for _, tag := range tags {
+		names = append(names, tag.Name)
+	}
+	assert.Equal(t, []string{"c", "b", "d", "a"}, names)
+}
+
+func TestPageImageTags(t *testing.T) {
+	tags := []ImageTag{{Name: "a"}, {Name: "b"}, {Name: "c"}}
+	assert.Equal(t, tags, pageImageTags(tags, 1, 0))
+	assert.Equal(t, tags[:2], pageImageTags(tags, 0, 2))
+	assert.Equal(t, tags[2:], pageImageTags(tags, 2, 2))
+	assert.Equal(t, []ImageTag{}, pageImageTags(tags, 3, 2))
+}
+
+// 模拟需要 Bearer Token 认证的镜像仓库
+func testRegistryServer(t *testing.T, tags []string, created map[string]string) *httptest.Server {
+	var ts *httptest.Server
+	repo := "/v2/go-devops/go-app"
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			user, pass, _ := r.BasicAuth()
+			if user != "readonly" || pass != "readonly123" || r.URL.Query().Get("scope") != "repository:go-devops/go-app:pull" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]string{"token": "test-token"})
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer test-token" {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="registry",scope="repository:go-devops/go-app:pull"`, ts.URL))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch {
+		case r.URL.Path == repo+"/tags/list":
+			n, _ := strconv.Atoi(r.URL.Query().Get("n"))
+			start := 0
+			if last := r.URL.Query().Get("last"); last != "" {
+				start = slices.Index(tags, last) + 1
+			}
+			end := min(start+n, len(tags))
+			if end < len(tags) {
+				w.Header().Set("Link", fmt.Sprintf(`<%s/tags/list?last=%s&n=%d>; rel="next"`, repo, tags[end-1], n))
+			}
+			json.NewEncoder(w).Encode(map[string]any{"name": "go-devops/go-app", "tags": tags[start:end]})
+		case strings.HasPrefix(r.URL.Path, repo+"/manifests/"):
+			ref := strings.TrimPrefix(r.URL.Path, repo+"/manifests/")
+			if !strings.Contains(r.Header.Get("Accept"), "application/vnd.oci.image.index.v1+json") {
+				w.WriteHeader(http.StatusNotAcceptable)
+				return
+			}
+			if ref == "multi-arch" {
+				json.NewEncoder(w).Encode(map[string]any{"manifests": []any{map[string]any{"digest": "sha256-multi-arch-amd64"}}})
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]any{"config": map[string]any{"digest": "config-" + strings.TrimPrefix(ref, "sha256-")}})
+		case strings.HasPrefix(r.URL.Path, repo+"/blobs/config-"):
+			ref := strings.TrimPrefix(r.URL.Path, repo+"/blobs/config-")
+			w.Header().Set("Content-Type", "application/octet-stream")
+			config := map[string]any{}
+			if value, ok := created[ref]; ok {
+				config["created"] = value
+			}
+			json.NewEncoder(w).Encode(config)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	return ts
+}
+
+func TestRegistryClient(t *testing.T) {
+	tags := []string{"latest", "multi-arch"}
+	created := map[string]string{"latest": "2026-01-02T00:00:00Z", "multi-arch-amd64": "2026-01-01T00:00:00Z"}
+	for i := 0; i < 150; i++ {
+		tags = append(tags, fmt.Sprintf("v1.0.%d", i))
+	}
+	ts := testRegistryServer(t, tags, created)
+	defer ts.Close()
+
+	spec := &cmdb.ContainerRegistrySpec{
+		Auth: cmdb.BasicAuth{Username: "readonly", Password: base64.StdEncoding.EncodeToString([]byte("readonly123\n"))},
+		Url:  ts.URL + "/",
+	}
+	rc, err := newRegistryClient(spec)
+	assert.NoError(t, err)
+	result, err := rc.listTags(context.Background(), "go-devops/go-app")
+	assert.NoError(t, err)
+	assert.Equal(t, tags, result)
+
+	imageTags := []ImageTag{{Name: "latest"}, {Name: "multi-arch"}}
+	assert.NoError(t, rc.fillCreated(context.Background(), "go-devops/go-app", imageTags))
+	assert.Equal(t, "2026-01-02T00:00:00Z", imageTags[0].Created.Format(time.RFC3339))
+	assert.Equal(t, "2026-01-01T00:00:00Z", imageTags[1].Created.Format(time.RFC3339))
+	imageCreatedCache.Lock()
+	assert.Equal(t, imageTags[1].Created, imageCreatedCache.m[ts.URL+"/go-devops/go-app@config-multi-arch-amd64"])
+	imageCreatedCache.Unlock()
+
+	imageTags = make([]ImageTag, len(tags))
+	for i, tag := range tags {
+		imageTags[i].Name = tag
+	}
+	assert.NoError(t, rc.fillCreated(context.Background(), "go-devops/go-app", imageTags))
+	assert.Equal(t, "2026-01-02T00:00:00Z", imageTags[0].Created.Format(time.RFC3339))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Error(t, rc.fillCreated(ctx, "go-devops/go-app", []ImageTag{{Name: "v1.0.0"}}))
+	_, err = rc.listTags(context.Background(), "not-exist")
+	assert.Error(t, err)
+
+	spec.Auth.Password = base64.StdEncoding.EncodeToString([]byte("invalid"))
+	rc, err = newRegistryClient(spec)
+	assert.NoError(t, err)
+	_, err = rc.listTags(context.Background(), "go-devops/go-app")
+	assert.Error(t, err)
+}
@@ -0,0 +1,71 @@
+package deployment
+
+import (
+	"context"
+	"fmt"
+	"gcmdb/pkg/cmdb"
+	"gcmdb/pkg/cmdb/runtime"
+	"gcmdb/pkg/cmdb/server/storage"
+	"slices"
+	"strings"
+)
+
+// 从命名空间的 TemplateLibrary 读取 include/import 的模板，名称为 <TemplateLibrary>/<data 键>
+type templateLibraries struct {
+	db        *storage.Store
+	namespace string
+	root      string
+	// 根模板声明的 TemplateLibrary
+	libraries []string
+	cache     map[string]*cmdb.TemplateLibrary
+}
+
+func newTemplateLibraries(db *storage.Store, namespace, root string, libraries []string) *templateLibraries {
+	return &templateLibraries{
+		db: db, namespace: namespace, root: root, libraries: libraries, cache: map[string]*cmdb.TemplateLibrary{},
+	}
+}
+
+// 仅可引用发起引用的模板已声明的 TemplateLibrary，TemplateLibrary 可引用自身的片段
+func (l *templateLibraries) read(from, name string) (string, error) {
+	libName, entry, _ := strings.Cut(name, "/")
+	allowed := l.libraries
+	if from != l.root {
+		fromLib, _, _ := strings.Cut(from, "/")
+		lib, err := l.get(fromLib)
+		if err != nil {
+			return "", err
+		}
+		allowed = append(slices.Clone(lib.Spec.Libraries), fromLib)
+	}
+	if !slices.Contains(allowed, libName) {
+		return "", runtime.TemplateError{
+			Template: from,
+			Message:  fmt.Sprintf("%s: templateLibrary %s is not declared in spec.libraries", name, libName),
+		}
+	}
+	lib, err := l.get(libName)
+	if err != nil {
+		return "", err
+	}
+	content, ok := lib.Data[entry]
+	if !ok {
+		return "", runtime.TemplateError{
+			Template: from,
+			Message:  fmt.Sprintf("%s: templateLibrary %s has no data %s", name, libName, entry),
+		}
+	}
+	return content, nil
+}
+
+func (l *templateLibraries) get(name string) (*cmdb.TemplateLibrary, error) {
+	if lib, ok := l.cache[name]; ok {
+		return lib, nil
+	}
+	var obj cmdb.Object
+	if err := l.db.Get(context.Background(), "TemplateLibrary", name, l.namespace, storage.GetOptions{}, &obj); err != nil {
+		return nil, err
+	}
+	l.cache[name] = obj.(*cmdb.TemplateLibrary)
+	return l.cache[name], nil
+}
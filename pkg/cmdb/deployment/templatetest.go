@@ -0,0 +1,218 @@
+package deployment
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"gcmdb/pkg/cmdb"
+	"gcmdb/pkg/cmdb/conversion"
+	"gcmdb/pkg/cmdb/server/storage"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/goccy/go-yaml"
+	"github.com/pmezard/go-difflib/difflib"
+)
+
+// 模板测试目录中 golden 文件所在的子目录，及默认参数文件
+const (
+	TemplateTestGoldenDir  = "golden"
+	TemplateTestParamsFile = "params.yaml"
+)
+
+// 模板测试用例的结果
+const (
+	TemplateTestPass    = "pass"
+	TemplateTestFail    = "fail"
+	TemplateTestUpdated = "updated"
+	TemplateTestError   = "error"
+)
+
+type TemplateTestResult struct {
+	// 渲染结果的类型，AppDeployment 或 DeployTemplate
+	Kind string `json:"kind"`
+	// 渲染的 AppDeployment，<namespace>/<name>
+	Name   string `json:"name"`
+	Golden string `json:"golden"`
+	Status string `json:"status"`
+	Diff   string `json:"diff,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// 将 dir 下的对象加载至进程内的存储，渲染其中每个 AppDeployment 及其 DeployTemplate，
+// 与 golden/<namespace>/<name>.<kind>.yaml 比较；params 覆盖 dir 下 params.yaml 中的参数，
+// update 为 true 时以渲染结果覆盖 golden 文件
+func RunTemplateTests(dir string, params map[string]any, update bool) ([]TemplateTestResult, error) {
+	objs, err := loadTemplateTestObjects(dir)
+	if err != nil {
+		return nil, err
+	}
+	testParams, err := templateTestParams(dir)
+	if err != nil {
+		return nil, err
+	}
+	maps.Copy(testParams, params)
+
+	db, closeDb, err := storage.NewEmbeddedStore()
+	if err != nil {
+		return nil, err
+	}
+	defer closeDb()
+	var appDeploys []*cmdb.AppDeployment
+	for _, obj := range objs {
+		if err = db.Load(context.Background(), obj); err != nil {
+			return nil, err
+		}
+		if appDeploy, ok := obj.(*cmdb.AppDeployment); ok {
+			appDeploys = append(appDeploys, appDeploy)
+		}
+	}
+	slices.SortFunc(appDeploys, func(a, b *cmdb.AppDeployment) int {
+		return strings.Compare(a.Metadata.Namespace+"/"+a.Metadata.Name, b.Metadata.Namespace+"/"+b.Metadata.Name)
+	})
+
+	results := []TemplateTestResult{}
+	for _, appDeploy := range appDeploys {
+		name, namespace := appDeploy.Metadata.Name, appDeploy.Metadata.Namespace
+		goldenPrefix := filepath.Join(dir, TemplateTestGoldenDir, namespace, name)
+		rendered, renderErr := ResolveAppDeployment(db, name, namespace, maps.Clone(testParams))
+		results = append(results, templateTestCase("AppDeployment", namespace+"/"+name, goldenPrefix+".appdeployment.yaml", rendered, renderErr, update))
+		// 未指定 DeployTemplate 且非 Docker 部署时没有可渲染的 DeployTemplate
+		spec := appDeploy.Spec.Template
+		if (spec.DeployTemplate == nil || spec.DeployTemplate.Name == "") && spec.Spec.DeployPlatform.Docker == nil {
+			continue
+		}
+		deployTpl, renderErr := ResolveDeployTemplate(db, name, namespace, maps.Clone(testParams))
+		results = append(results, templateTestCase("DeployTemplate", namespace+"/"+name, goldenPrefix+".deploytemplate.yaml", deployTpl, renderErr, update))
+	}
+	return results, nil
+}
+
+// dir 下除 golden 目录和参数文件外的 yaml、yml、json 文件
+func loadTemplateTestObjects(dir string) ([]cmdb.Object, error) {
+	var objs []cmdb.Object
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if path == filepath.Join(dir, TemplateTestGoldenDir) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		ext := strings.ToLower(filepath.Ext(path))
+		if path == filepath.Join(dir, TemplateTestParamsFile) || !slices.Contains([]string{".yaml", ".yml", ".json"}, ext) {
+			return nil
+		}
+		byts, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		var m map[string]any
+		if err = yaml.Unmarshal(byts, &m); err != nil {
+			return fmt.Errorf("%s: %s", path, err.Error())
+		}
+		if kind, ok := m["kind"].(string); !ok || kind == "" {
+			return fmt.Errorf("%s: kind is required", path)
+		}
+		obj, err := conversion.DecodeObject(byts)
+		if err != nil {
+			return fmt.Errorf("%s: %s", path, err.Error())
+		}
+		objs = append(objs, obj)
+		return nil
+	})
+	return objs, err
+}
+
+func templateTestParams(dir string) (map[string]any, error) {
+	params := map[string]any{}
+	byts, err := os.ReadFile(filepath.Join(dir, TemplateTestParamsFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return params, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = yaml.Unmarshal(byts, &params); err != nil {
+		return nil, fmt.Errorf("%s: %s", TemplateTestParamsFile, err.Error())
+	}
+	if params == nil {
+		params = map[string]any{}
+	}
+	return params, nil
+}
+
+// 比较渲染结果与 golden 文件，不一致时返回 unified diff
+func templateTestCase(kind, name, golden string, obj cmdb.Object, renderErr error, update bool) TemplateTestResult {
+	result := TemplateTestResult{Kind: kind, Name: name, Golden: golden}
+	if renderErr != nil {
+		result.Status, result.Error = TemplateTestError, renderErr.Error()
+		return result
+	}
+	actual, err := templateTestOutput(obj)
+	if err != nil {
+		result.Status, result.Error = TemplateTestError, err.Error()
+		return result
+	}
+	expected, err := os.ReadFile(golden)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		result.Status, result.Error = TemplateTestError, err.Error()
+		return result
+	}
+	if err == nil && string(expected) == actual {
+		result.Status = TemplateTestPass
+		return result
+	}
+	if update {
+		if err = os.MkdirAll(filepath.Dir(golden), 0755); err == nil {
+			err = os.WriteFile(golden, []byte(actual), 0644)
+		}
+		if err != nil {
+			result.Status, result.Error = TemplateTestError, err.Error()
+			return result
+		}
+		result.Status = TemplateTestUpdated
+		return result
+	}
+	result.Status = TemplateTestFail
+	if expected == nil {
+		result.Error = "golden file not found, run with --update to create it"
+		return result
+	}
+	result.Diff, _ = difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(string(expected)),
+		B:        difflib.SplitLines(actual),
+		FromFile: golden,
+		ToFile:   "rendered",
+		Context:  3,
+	})
+	return result
+}
+
+// 去掉系统管理字段和部署状态等与模板无关的字段后的 yaml
+func templateTestOutput(obj cmdb.Object) (string, error) {
+	var objMap map[string]any
+	if err := conversion.StructToMap(obj, &objMap); err != nil {
+		return "", err
+	}
+	if metadata, ok := objMap["metadata"].(map[string]any); ok {
+		for _, field := range cmdb.ManagedMetaFields {
+			delete(metadata, field)
+		}
+	}
+	if obj.GetKind() == "AppDeployment" {
+		for _, field := range cmdb.AppDeploymentStatusFields {
+			delete(objMap, field)
+		}
+	}
+	byts, err := yaml.MarshalWithOptions(objMap, yaml.AutoInt(), yaml.UseLiteralStyleIfMultiline(true))
+	if err != nil {
+		return "", err
+	}
+	return string(byts), nil
+}
@@ -0,0 +1,92 @@
+package deployment
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// 复制示例对象至临时目录，作为模板测试目录
+func templateTestDir(t *testing.T) string {
+	dir := t.TempDir()
+	files, err := filepath.Glob("../example/files/*.yaml")
+	assert.NoError(t, err)
+	for _, file := range files {
+		byts, err := os.ReadFile(file)
+		assert.NoError(t, err)
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, filepath.Base(file)), byts, 0o644))
+	}
+	return dir
+}
+
+func templateTestStatuses(results []TemplateTestResult) []string {
+	statuses := []string{}
+	for _, r := range results {
+		statuses = append(statuses, r.Kind+" "+r.Status)
+	}
+	return statuses
+}
+
+func TestRunTemplateTests(t *testing.T) {
+	dir := templateTestDir(t)
+	goldenTpl := filepath.Join(dir, "golden", "test", "go-app.deploytemplate.yaml")
+
+	results, err := RunTemplateTests(dir, nil, false)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"AppDeployment fail", "DeployTemplate fail"}, templateTestStatuses(results))
+	assert.Contains(t, results[0].Error, "golden file not found")
+	assert.Equal(t, goldenTpl, results[1].Golden)
+
+	results, err = RunTemplateTests(dir, nil, true)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"AppDeployment updated", "DeployTemplate updated"}, templateTestStatuses(results))
+	golden, err := os.ReadFile(goldenTpl)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(golden), "creationTimestamp")
+
+	results, err = RunTemplateTests(dir, nil, false)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"AppDeployment pass", "DeployTemplate pass"}, templateTestStatuses(results))
+
+	// params.yaml 中的参数被 params 覆盖
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, TemplateTestParamsFile), []byte("app_instance_name: from-file\n"), 0o644))
+	results, err = RunTemplateTests(dir, nil, false)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"AppDeployment pass", "DeployTemplate fail"}, templateTestStatuses(results))
+	assert.Contains(t, results[1].Diff, "+      app.cmdb/instance: from-file")
+	results, err = RunTemplateTests(dir, map[string]any{"app_instance_name": "from-flag"}, false)
+	assert.NoError(t, err)
+	assert.Contains(t, results[1].Diff, "+      app.cmdb/instance: from-flag")
+
+	// golden 文件未变化
+	byts, err := os.ReadFile(goldenTpl)
+	assert.NoError(t, err)
+	assert.Equal(t, golden, byts)
+}
+
+func TestRunTemplateTestsRenderError(t *testing.T) {
+	dir := templateTestDir(t)
+	assert.NoError(t, os.Remove(filepath.Join(dir, "resource_range.yaml")))
+	results, err := RunTemplateTests(dir, nil, true)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"AppDeployment error", "DeployTemplate error"}, templateTestStatuses(results))
+	_, err = os.Stat(filepath.Join(dir, "golden"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestRunTemplateTestsInvalidDir(t *testing.T) {
+	_, err := RunTemplateTests("notExist", nil, false)
+	assert.Error(t, err)
+
+	dir := templateTestDir(t)
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "bad.yaml"), []byte("metadata: {}\n"), 0o644))
+	_, err = RunTemplateTests(dir, nil, false)
+	assert.ErrorContains(t, err, "kind is required")
+
+	dir = templateTestDir(t)
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, TemplateTestParamsFile), []byte("a: ["), 0o644))
+	_, err = RunTemplateTests(dir, nil, false)
+	assert.ErrorContains(t, err, TemplateTestParamsFile)
+}
@@ -0,0 +1,257 @@
+package deployment
+
+import (
+	"fmt"
+	"gcmdb/pkg/cmdb"
+	"gcmdb/pkg/cmdb/conversion"
+	"gcmdb/pkg/cmdb/runtime"
+	"gcmdb/pkg/cmdb/server/storage"
+	"maps"
+	"reflect"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// 字段的来源，source 为 <Kind>/[<namespace>/]<name>、params 或 default（对象的默认值）
+type FieldSource struct {
+	Source     string `json:"source"`
+	SourcePath string `json:"sourcePath,omitempty"`
+}
+
+// 合并时因冲突被丢弃的值
+type DroppedValue struct {
+	FieldSource
+	Value any `json:"value"`
+}
+
+// 模板表达式引用的变量及其来源
+type TemplateInput struct {
+	Variable string `json:"variable"`
+	FieldSource
+}
+
+// 渲染结果中叶子字段的来源
+type FieldTrace struct {
+	Path  string `json:"path"`
+	Value any    `json:"value"`
+	FieldSource
+	// 渲染前的模板表达式
+	Expression string          `json:"expression,omitempty"`
+	Inputs     []TemplateInput `json:"inputs,omitempty"`
+	Dropped    []DroppedValue  `json:"dropped,omitempty"`
+}
+
+type AppDeploymentTrace struct {
+	AppDeployment *cmdb.AppDeployment     `json:"appDeployment"`
+	Fields        []FieldTrace            `json:"fields"`
+	Conflicts     []runtime.MergeConflict `json:"conflicts"`
+}
+
+// 模板变量中的下标，如 ['APP']
+var variableIndexRe = regexp.MustCompile(`\['([^']*)'\]`)
+
+// 记录渲染前 AppDeployment 各叶子字段的来源及合并冲突，为 nil 时不记录
+type renderTracer struct {
+	fields    map[string]*FieldTrace
+	dropped   map[string][]DroppedValue
+	conflicts []runtime.MergeConflict
+}
+
+func newRenderTracer() *renderTracer {
+	return &renderTracer{fields: map[string]*FieldTrace{}, dropped: map[string][]DroppedValue{}, conflicts: []runtime.MergeConflict{}}
+}
+
+// 渲染 AppDeployment，并返回结果中每个叶子字段的来源
+func TraceAppDeployment(db *storage.Store, name, namespace string, params map[string]any) (*AppDeploymentTrace, error) {
+	tracer := newRenderTracer()
+	appDeploy, err := resolveAppDeployment(db, name, namespace, params, tracer)
+	if err != nil {
+		return nil, err
+	}
+	fields, err := tracer.result(appDeploy, params)
+	if err != nil {
+		return nil, err
+	}
+	return &AppDeploymentTrace{AppDeployment: appDeploy, Fields: fields, Conflicts: tracer.conflicts}, nil
+}
+
+func objectSource(kind, namespace, name string) string {
+	if namespace == "" {
+		return fmt.Sprintf("%s/%s", kind, name)
+	}
+	return fmt.Sprintf("%s/%s/%s", kind, namespace, name)
+}
+
+// prefix 下的字段均来自 src
+func (t *renderTracer) set(prefix string, value any, src FieldSource) {
+	if t == nil {
+		return
+	}
+	t.remove(prefix)
+	walkLeaves(prefix, value, func(path string, v any) {
+		sourcePath := joinPath(src.SourcePath, strings.TrimPrefix(path, prefix))
+		t.fields[path] = &FieldTrace{Path: path, Value: v, FieldSource: FieldSource{src.Source, sourcePath}}
+	})
+}
+
+// 将 src 合并至 prefix 后，值未变化的字段保留原来源，其余字段来自 src；
+// 冲突的路径为 src 中的路径，override 策略丢弃的是原来的值
+func (t *renderTracer) merge(prefix string, merged map[string]any, src FieldSource, conflicts []runtime.MergeConflict) {
+	if t == nil {
+		return
+	}
+	t.conflicts = append(t.conflicts, conflicts...)
+	old := t.remove(prefix)
+	walkLeaves(prefix, merged, func(path string, v any) {
+		if f, ok := old[path]; ok && reflect.DeepEqual(f.Value, v) {
+			t.fields[path] = f
+			return
+		}
+		sourcePath := joinPath(src.SourcePath, strings.TrimPrefix(path, prefix))
+		t.fields[path] = &FieldTrace{Path: path, Value: v, FieldSource: FieldSource{src.Source, sourcePath}}
+	})
+	for _, c := range conflicts {
+		path := prefix + strings.TrimPrefix(c.Path, src.SourcePath)
+		dropped := DroppedValue{FieldSource: FieldSource{src.Source, c.Path}, Value: c.Dropped}
+		if c.Strategy == runtime.MergeOverride {
+			dropped.FieldSource = droppedSource(old, path)
+		}
+		t.dropped[path] = append(t.dropped[path], dropped)
+	}
+}
+
+// 被覆盖的字段 path 原来的来源
+func droppedSource(old map[string]*FieldTrace, path string) FieldSource {
+	for _, p := range slices.Sorted(maps.Keys(old)) {
+		if isSubPath(p, path) {
+			f := old[p].FieldSource
+			return FieldSource{f.Source, strings.TrimSuffix(f.SourcePath, strings.TrimPrefix(p, path))}
+		}
+	}
+	return FieldSource{}
+}
+
+// 删除 prefix 及其下的字段，返回被删除的字段
+func (t *renderTracer) remove(prefix string) map[string]*FieldTrace {
+	removed := map[string]*FieldTrace{}
+	for path, f := range t.fields {
+		if isSubPath(path, prefix) {
+			removed[path] = f
+			delete(t.fields, path)
+		}
+	}
+	return removed
+}
+
+// 字段自身或最近的上级字段的来源，模板渲染出的字段来自上级字段的表达式
+func (t *renderTracer) lookup(path string) *FieldTrace {
+	for p := path; p != ""; p = parentPath(p) {
+		if f, ok := t.fields[p]; ok {
+			return f
+		}
+	}
+	return nil
+}
+
+// 按渲染结果的叶子字段输出来源，按路径排序
+func (t *renderTracer) result(appDeploy *cmdb.AppDeployment, params map[string]any) ([]FieldTrace, error) {
+	var objMap map[string]any
+	if err := conversion.StructToMap(appDeploy, &objMap); err != nil {
+		return nil, err
+	}
+	result := []FieldTrace{}
+	walkLeaves("", objMap, func(path string, v any) {
+		field := FieldTrace{Path: path, Value: v, FieldSource: FieldSource{Source: "default"}}
+		if f := t.lookup(path); f != nil {
+			field.FieldSource = f.FieldSource
+			if expr, ok := f.Value.(string); ok && (strings.Contains(expr, "${") || strings.Contains(expr, "{%")) {
+				field.Expression = expr
+				field.Inputs = t.inputs(expr, params)
+			}
+		}
+		for _, p := range slices.Sorted(maps.Keys(t.dropped)) {
+			if isSubPath(path, p) {
+				field.Dropped = append(field.Dropped, t.dropped[p]...)
+			}
+		}
+		result = append(result, field)
+	})
+	slices.SortFunc(result, func(a, b FieldTrace) int { return strings.Compare(a.Path, b.Path) })
+	return result, nil
+}
+
+// 模板表达式引用的 spec、metadata 字段及参数的来源
+func (t *renderTracer) inputs(expr string, params map[string]any) []TemplateInput {
+	inputs := []TemplateInput{}
+	for _, variable := range runtime.TemplateVariables(expr) {
+		variable = variableIndexRe.ReplaceAllString(variable, ".$1")
+		root, _, _ := strings.Cut(variable, ".")
+		var path string
+		switch root {
+		case "spec":
+			path = "spec.template." + variable
+		case "metadata":
+			path = variable
+		default:
+			if _, ok := params[root]; ok {
+				inputs = append(inputs, TemplateInput{Variable: variable, FieldSource: FieldSource{"params", variable}})
+			}
+			continue
+		}
+		if f := t.lookup(path); f != nil {
+			inputs = append(inputs, TemplateInput{Variable: variable, FieldSource: f.FieldSource})
+			continue
+		}
+		// 引用的是 map 或列表，返回其下所有字段的来源
+		for _, p := range slices.Sorted(maps.Keys(t.fields)) {
+			if isSubPath(p, path) {
+				input := TemplateInput{Variable: variable + strings.TrimPrefix(p, path), FieldSource: t.fields[p].FieldSource}
+				inputs = append(inputs, input)
+			}
+		}
+	}
+	return inputs
+}
+
+// 遍历叶子字段，空的 map 和列表也作为叶子字段
+func walkLeaves(path string, value any, fn func(path string, v any)) {
+	switch v := value.(type) {
+	case map[string]any:
+		if len(v) > 0 {
+			for key, item := range v {
+				walkLeaves(joinPath(path, "."+key), item, fn)
+			}
+			return
+		}
+	case []any:
+		if len(v) > 0 {
+			for i, item := range v {
+				walkLeaves(fmt.Sprintf("%s[%d]", path, i), item, fn)
+			}
+			return
+		}
+	}
+	fn(path, value)
+}
+
+// 拼接字段路径，sub 以 . 或 [ 开头
+func joinPath(path, sub string) string {
+	if path == "" {
+		return strings.TrimPrefix(sub, ".")
+	}
+	return path + sub
+}
+
+func parentPath(path string) string {
+	i := strings.LastIndexAny(path, ".[")
+	if i < 0 {
+		return ""
+	}
+	return path[:i]
+}
+
+// path 为 prefix 自身或其下的字段
+func isSubPath(path, prefix string) bool {
+	return prefix == "" || path == prefix || strings.HasPrefix(path, prefix+".") || strings.HasPrefix(path, prefix+"[")
+}
@@ -0,0 +1,55 @@
+package deployment
+
+import (
+	"gcmdb/pkg/cmdb/runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRenderTracerMerge(t *testing.T) {
+	tracer := newRenderTracer()
+	current := map[string]any{"app": "go-app", "env": map[string]any{}, "args": []any{"a"}, "port": nil}
+	tracer.set("spec", current, FieldSource{Source: "AppDeployment/test/go-app", SourcePath: "spec"})
+	assert.Equal(t, FieldSource{"AppDeployment/test/go-app", "spec.args[0]"}, tracer.fields["spec.args[0]"].FieldSource)
+
+	target := map[string]any{"app": "", "env": map[string]any{"ENV": "test"}, "port": 80, "args": []any{"b"}}
+	merged, conflicts := runtime.Merge2DictWithConflicts(current, target, []string{"spec"}, nil)
+	tracer.merge("spec", merged, FieldSource{Source: "ResourceRange/test/test", SourcePath: "spec"}, conflicts)
+	assert.Equal(t, FieldSource{"AppDeployment/test/go-app", "spec.app"}, tracer.fields["spec.app"].FieldSource)
+	assert.Equal(t, FieldSource{"ResourceRange/test/test", "spec.env.ENV"}, tracer.fields["spec.env.ENV"].FieldSource)
+	assert.Equal(t, FieldSource{"ResourceRange/test/test", "spec.port"}, tracer.fields["spec.port"].FieldSource)
+	assert.Nil(t, tracer.fields["spec.env"])
+	assert.Equal(t, []DroppedValue{{FieldSource{"ResourceRange/test/test", "spec.app"}, ""}}, tracer.dropped["spec.app"])
+	assert.Equal(t, []DroppedValue{{FieldSource{"ResourceRange/test/test", "spec.args"}, []any{"b"}}}, tracer.dropped["spec.args"])
+
+	assert.Equal(t, "spec.env.ENV", tracer.lookup("spec.env.ENV.x").Path)
+	assert.Nil(t, tracer.lookup("metadata.name"))
+}
+
+func TestRenderTracerInputs(t *testing.T) {
+	tracer := newRenderTracer()
+	tracer.set("spec.template.spec", map[string]any{"env": map[string]any{"A": "1", "B": "2"}, "app": "go-app"},
+		FieldSource{Source: "ResourceRange/test/test", SourcePath: "spec"})
+	inputs := tracer.inputs("${ spec.app }-${ spec.env } ${ tag } ${ spec.env['A'] } ${ unknown }", map[string]any{"tag": "v1"})
+	assert.Equal(t, []TemplateInput{
+		{"spec.app", FieldSource{"ResourceRange/test/test", "spec.app"}},
+		{"spec.env.A", FieldSource{"ResourceRange/test/test", "spec.env.A"}},
+		{"spec.env.B", FieldSource{"ResourceRange/test/test", "spec.env.B"}},
+		{"tag", FieldSource{"params", "tag"}},
+		{"spec.env.A", FieldSource{"ResourceRange/test/test", "spec.env.A"}},
+	}, inputs)
+}
+
+func TestRenderTracerOverride(t *testing.T) {
+	tracer := newRenderTracer()
+	current := map[string]any{"command": []any{"python"}}
+	tracer.set("spec.template.spec", current, FieldSource{Source: "AppDeployment/test/go-app", SourcePath: "spec.template.spec"})
+	target := map[string]any{"command": []any{"java"}}
+	merged, conflicts := runtime.Merge2DictWithConflicts(current, target, []string{"spec"}, map[string]string{"spec.command": "override"})
+	tracer.merge("spec.template.spec", merged, FieldSource{Source: "ResourceRange/test/test", SourcePath: "spec"}, conflicts)
+	assert.Equal(t, FieldSource{"ResourceRange/test/test", "spec.command[0]"}, tracer.fields["spec.template.spec.command[0]"].FieldSource)
+	assert.Equal(t, []DroppedValue{{FieldSource{"AppDeployment/test/go-app", "spec.template.spec.command"}, []any{"python"}}},
+		tracer.dropped["spec.template.spec.command"])
+	assert.Equal(t, conflicts, tracer.conflicts)
+}
@@ -0,0 +1,169 @@
+package runtime
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 5 段式 cron 表达式：分 时 日 月 周
+type CronSchedule struct {
+	minute, hour, dom, month, dow uint64
+	// 日、周字段为 * 时，两者取交集，否则取并集
+	domStar, dowStar bool
+}
+
+type cronBounds struct {
+	min, max int
+	names    map[string]int
+}
+
+var (
+	cronMinute = cronBounds{0, 59, nil}
+	cronHour   = cronBounds{0, 23, nil}
+	cronDom    = cronBounds{1, 31, nil}
+	cronMonth  = cronBounds{1, 12, map[string]int{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}}
+	// 7 与 0 均表示周日
+	cronDow = cronBounds{0, 7, map[string]int{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}}
+)
+
+var cronMacros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// 解析 cron 表达式，支持 * , - / 、月份和星期的英文缩写及 @daily 等宏
+func ParseCron(expr string) (*CronSchedule, error) {
+	spec := strings.TrimSpace(expr)
+	if macro, ok := cronMacros[strings.ToLower(spec)]; ok {
+		spec = macro
+	}
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		errMsg := fmt.Sprintf("cron expression %q must have 5 fields, got %d", expr, len(fields))
+		return nil, fmt.Errorf("%s", errMsg)
+	}
+	s := &CronSchedule{
+		domStar: strings.HasPrefix(fields[2], "*"),
+		dowStar: strings.HasPrefix(fields[4], "*"),
+	}
+	var err error
+	for i, f := range []struct {
+		bits   *uint64
+		bounds cronBounds
+	}{
+		{&s.minute, cronMinute}, {&s.hour, cronHour}, {&s.dom, cronDom}, {&s.month, cronMonth}, {&s.dow, cronDow},
+	} {
+		if *f.bits, err = parseCronField(fields[i], f.bounds); err != nil {
+			errMsg := fmt.Sprintf("cron expression %q: %s", expr, err)
+			return nil, fmt.Errorf("%s", errMsg)
+		}
+	}
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+	return s, nil
+}
+
+func parseCronField(field string, bounds cronBounds) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		rangePart, stepPart, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			n, err := strconv.Atoi(stepPart)
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("invalid step %q", part)
+			}
+			step = n
+		}
+		var start, end int
+		var err error
+		switch {
+		case rangePart == "*":
+			start, end = bounds.min, bounds.max
+		case strings.Contains(rangePart, "-"):
+			lo, hi, _ := strings.Cut(rangePart, "-")
+			if start, err = parseCronValue(lo, bounds); err != nil {
+				return 0, err
+			}
+			if end, err = parseCronValue(hi, bounds); err != nil {
+				return 0, err
+			}
+		default:
+			if start, err = parseCronValue(rangePart, bounds); err != nil {
+				return 0, err
+			}
+			// 5/10 表示从 5 开始每 10 个
+			end = start
+			if hasStep {
+				end = bounds.max
+			}
+		}
+		if start > end {
+			return 0, fmt.Errorf("invalid range %q", part)
+		}
+		for i := start; i <= end; i += step {
+			bits |= 1 << uint(i)
+		}
+	}
+	return bits, nil
+}
+
+func parseCronValue(value string, bounds cronBounds) (int, error) {
+	if n, ok := bounds.names[strings.ToLower(value)]; ok {
+		return n, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < bounds.min || n > bounds.max {
+		return 0, fmt.Errorf("value %q out of range [%d, %d]", value, bounds.min, bounds.max)
+	}
+	return n, nil
+}
+
+// 返回 t 之后的下一次运行时间，使用 t 的时区；5 年内无匹配时返回零值
+func (s *CronSchedule) Next(t time.Time) time.Time {
+	loc := t.Location()
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		if s.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if !s.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if s.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+			continue
+		}
+		if s.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Truncate(time.Minute).Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+func (s *CronSchedule) dayMatches(t time.Time) bool {
+	domMatch := s.dom&(1<<uint(t.Day())) != 0
+	dowMatch := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.domStar || s.dowStar {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
@@ -0,0 +1,39 @@
+package runtime
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseCronInvalid(t *testing.T) {
+	for _, expr := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "*/0 * * * *", "5-1 * * * *", "* * * foo *"} {
+		_, err := ParseCron(expr)
+		assert.Error(t, err, expr)
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	loc, _ := time.LoadLocation("Asia/Shanghai")
+	from := time.Date(2024, 1, 31, 10, 30, 20, 0, loc) // 周三
+	cases := map[string]time.Time{
+		"* * * * *":         time.Date(2024, 1, 31, 10, 31, 0, 0, loc),
+		"*/15 * * * *":      time.Date(2024, 1, 31, 10, 45, 0, 0, loc),
+		"0 3 * * *":         time.Date(2024, 2, 1, 3, 0, 0, 0, loc),
+		"0 9-17/4 * * *":    time.Date(2024, 1, 31, 13, 0, 0, 0, loc),
+		"0 0 29 2 *":        time.Date(2024, 2, 29, 0, 0, 0, 0, loc),
+		"0 0 * * mon,fri":   time.Date(2024, 2, 2, 0, 0, 0, 0, loc),
+		"0 0 * * 7":         time.Date(2024, 2, 4, 0, 0, 0, 0, loc),
+		"0 0 15 * 1":        time.Date(2024, 2, 5, 0, 0, 0, 0, loc),
+		"30 10 * JAN-MAR *": time.Date(2024, 2, 1, 10, 30, 0, 0, loc),
+		"@monthly":          time.Date(2024, 2, 1, 0, 0, 0, 0, loc),
+	}
+	for expr, want := range cases {
+		s, err := ParseCron(expr)
+		assert.NoError(t, err, expr)
+		assert.True(t, want.Equal(s.Next(from)), "%s: %s", expr, s.Next(from))
+	}
+	s, _ := ParseCron("0 0 30 2 *")
+	assert.True(t, s.Next(from).IsZero())
+}
@@ -0,0 +1,128 @@
+package runtime
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/nikolalohinski/gonja/v2/exec"
+)
+
+// now 未指定格式时的默认格式
+const defaultNowFormat = "20060102150405"
+
+// strftime 格式符对应的 Go 时间格式
+var strftimeLayouts = map[byte]string{
+	'Y': "2006", 'y': "06", 'm': "01", 'd': "02", 'H': "15", 'I': "03", 'M': "04", 'S': "05",
+	'f': "000000", 'p': "PM", 'b': "Jan", 'B': "January", 'a': "Mon", 'A': "Monday",
+	'z': "-0700", 'Z': "MST", 'j': "002", '%': "%",
+}
+
+// 当前时间，format 为 strftime 格式(如 %Y-%m-%d)或 Go 时间格式(如 2006-01-02)，为空时为 20060102150405
+func nowTime(format string) string {
+	return time.Now().Format(timeLayout(format))
+}
+
+func timeLayout(format string) string {
+	if format == "" {
+		return defaultNowFormat
+	}
+	if !strings.Contains(format, "%") {
+		return format
+	}
+	var b strings.Builder
+	for i := 0; i < len(format); i++ {
+		if format[i] == '%' && i+1 < len(format) {
+			if layout, ok := strftimeLayouts[format[i+1]]; ok {
+				b.WriteString(layout)
+				i++
+				continue
+			}
+		}
+		b.WriteByte(format[i])
+	}
+	return b.String()
+}
+
+func b64encode(e *exec.Evaluator, in *exec.Value, params *exec.VarArgs) *exec.Value {
+	if in.IsError() {
+		return in
+	}
+	return exec.AsValue(base64.StdEncoding.EncodeToString([]byte(in.String())))
+}
+
+func b64decode(e *exec.Evaluator, in *exec.Value, params *exec.VarArgs) *exec.Value {
+	if in.IsError() {
+		return in
+	}
+	byts, err := base64.StdEncoding.DecodeString(in.String())
+	if err != nil {
+		return exec.AsValue(exec.ErrInvalidCall(err))
+	}
+	return exec.AsValue(string(byts))
+}
+
+func sha256Sum(e *exec.Evaluator, in *exec.Value, params *exec.VarArgs) *exec.Value {
+	if in.IsError() {
+		return in
+	}
+	sum := sha256.Sum256([]byte(in.String()))
+	return exec.AsValue(hex.EncodeToString(sum[:]))
+}
+
+// 转为 JSON，可指定缩进空格数，如 to_json(2)
+func toJson(e *exec.Evaluator, in *exec.Value, params *exec.VarArgs) *exec.Value {
+	if in.IsError() {
+		return in
+	}
+	indent := 0
+	if len(params.Args) > 0 {
+		if !params.Args[0].IsInteger() {
+			return exec.AsValue(exec.ErrInvalidCall(fmt.Errorf("to_json indent must be an integer")))
+		}
+		indent = params.Args[0].Integer()
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if indent > 0 {
+		enc.SetIndent("", strings.Repeat(" ", indent))
+	}
+	if err := enc.Encode(in.ToGoSimpleType(true)); err != nil {
+		return exec.AsValue(exec.ErrInvalidCall(err))
+	}
+	return exec.AsValue(strings.TrimSuffix(buf.String(), "\n"))
+}
+
+// 与 Jinja 一致的 indent，不追加换行，非字符串转为字符串后缩进
+func indent(e *exec.Evaluator, in *exec.Value, params *exec.VarArgs) *exec.Value {
+	if in.IsError() {
+		return in
+	}
+	var (
+		width int
+		first bool
+		blank bool
+	)
+	if err := params.Take(
+		exec.KeywordArgument("width", exec.AsValue(4), exec.IntArgument(&width)),
+		exec.KeywordArgument("first", exec.AsValue(false), exec.BoolArgument(&first)),
+		exec.KeywordArgument("blank", exec.AsValue(false), exec.BoolArgument(&blank)),
+	); err != nil {
+		return exec.AsValue(exec.ErrInvalidCall(err))
+	}
+	prefix := strings.Repeat(" ", width)
+	lines := strings.Split(in.String(), "\n")
+	for i, line := range lines {
+		if (i == 0 && !first) || (strings.TrimSpace(line) == "" && !blank) {
+			continue
+		}
+		lines[i] = prefix + line
+	}
+	return exec.AsValue(strings.Join(lines, "\n"))
+}
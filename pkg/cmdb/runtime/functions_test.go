@@ -0,0 +1,65 @@
+package runtime
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTimeLayout(t *testing.T) {
+	assert.Equal(t, "20060102150405", timeLayout(""))
+	assert.Equal(t, "2006-01-02", timeLayout("2006-01-02"))
+	assert.Equal(t, "2006-01-02T15:04:05 %q 100%", timeLayout("%Y-%m-%dT%H:%M:%S %q 100%%"))
+	assert.Equal(t, time.Now().Format("2006"), nowTime("%Y"))
+}
+
+func TestTemplateFilters(t *testing.T) {
+	context := map[string]any{
+		"data": map[string]any{"url": "http://a?b=1&c=<2>", "list": []any{1, 2}},
+		"text": "line1\nline2",
+	}
+	tests := []struct {
+		template string
+		want     string
+	}{
+		{"${ 'hello' | b64encode }", "aGVsbG8="},
+		{"${ 'aGVsbG8=' | b64decode }", "hello"},
+		{"${ 'hello' | sha256 }", "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"},
+		{"${ data | to_json }", `{"list":[1,2],"url":"http://a?b=1&c=<2>"}`},
+		{"${ data.list | to_json(2) }", "[\n  1,\n  2\n]"},
+		{"a:\n  ${ text | indent(2) }", "a:\n  line1\n  line2"},
+		{"${ 12 | indent(2, first=True) }", "  12"},
+		{"${ missing | default('none') }", "none"},
+		{"${ now('%Y') }", time.Now().Format("2006")},
+	}
+	for _, tt := range tests {
+		got, err := RenderTemplate(tt.template, context)
+		assert.NoError(t, err, tt.template)
+		assert.Equal(t, tt.want, got, tt.template)
+	}
+	_, err := RenderTemplate("${ 'not base64' | b64decode }", context)
+	assert.Error(t, err)
+}
+
+func TestRenderTemplateFunctions(t *testing.T) {
+	context := map[string]any{"name": "db"}
+	opts := RenderOptions{Functions: map[string]any{
+		"upper_name": func(name string) (string, error) {
+			if name == "" {
+				return "", fmt.Errorf("name is empty")
+			}
+			return "DB", nil
+		},
+	}}
+	got, err := RenderTemplateWithOptions("${ upper_name(name) }", context, opts)
+	assert.NoError(t, err)
+	assert.Equal(t, "DB", got)
+	_, err = RenderTemplateWithOptions("${ upper_name('') }", context, opts)
+	assert.ErrorContains(t, err, "name is empty")
+	// 函数不写入渲染参数
+	assert.Equal(t, map[string]any{"name": "db"}, context)
+	_, err = RenderTemplateWithOptions("${ upper_name(name) }", context, RenderOptions{Strict: true})
+	assert.Error(t, err)
+}
@@ -116,31 +116,7 @@ func RecSetItem(obj map[string]any, path string, value any) {
 }
 
 func Merge2Dict(current, target map[string]any, path []string) map[string]any {
-	if path == nil {
-		path = []string{}
-	}
-
-	for key, targetVal := range target {
-		if currentVal, exists := current[key]; exists {
-			// 如果两个都是 map[string]any，递归合并
-			currentMap, okCur := currentVal.(map[string]any)
-			targetMap, okTar := targetVal.(map[string]any)
-			if okCur && okTar {
-				Merge2Dict(currentMap, targetMap, append(path, key))
-			} else if currentVal == nil && isAllowedType(targetVal) {
-				// 如果 current[key] 是 nil 并且 target[key] 是允许类型，则替换
-				current[key] = targetVal
-			} else if !reflect.DeepEqual(currentVal, targetVal) {
-				// 值不同且 current[key] 不为空，跳过冲突处理
-				// 如果需要，可以在这里抛错或打印冲突信息
-				// fmt.Printf("Conflict at %s\n", strings.Join(append(path, key), "."))
-			}
-		} else {
-			// current中不存在该key，直接赋值
-			current[key] = targetVal
-		}
-	}
-	return current
+	return merge2Dict(current, target, path, nil, nil)
 }
 
 // 判定 target 的值是否是允许替换 current nil 的类型
@@ -0,0 +1,545 @@
+package runtime
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nikolalohinski/gonja/v2/builtins/control_structures"
+	"github.com/nikolalohinski/gonja/v2/config"
+	"github.com/nikolalohinski/gonja/v2/exec"
+	"github.com/nikolalohinski/gonja/v2/nodes"
+	"github.com/nikolalohinski/gonja/v2/parser"
+	"github.com/nikolalohinski/gonja/v2/tokens"
+)
+
+// 单次渲染的资源限制，避免模板耗尽服务端的 CPU 和内存
+type RenderLimits struct {
+	// 渲染输出的最大字节数，包括宏和 set 块的中间输出，字符串乘法及过滤器产生的字符串同样受限
+	MaxOutputSize int
+	// 所有 for 循环的总迭代次数上限，range() 生成的序列长度同样受限
+	MaxLoopIterations int
+	// 宏调用的最大嵌套深度
+	MaxRecursionDepth int
+	// 渲染的最长时间，超时后立即返回错误，渲染在下次输出、循环、宏或过滤器调用时中止
+	Timeout time.Duration
+}
+
+var DefaultRenderLimits = RenderLimits{
+	MaxOutputSize:     4 << 20,
+	MaxLoopIterations: 100000,
+	MaxRecursionDepth: 64,
+	Timeout:           2 * time.Second,
+}
+
+// 渲染超出的限制
+const (
+	RenderLimitOutputSize     = "outputSize"
+	RenderLimitLoopIterations = "loopIterations"
+	RenderLimitRecursionDepth = "recursionDepth"
+	RenderLimitTimeout        = "timeout"
+)
+
+// 渲染超出资源限制
+type RenderLimitError struct {
+	Template string `json:"template"`
+	Limit    string `json:"limit"`
+	Message  string `json:"message"`
+}
+
+func (e RenderLimitError) Error() string {
+	return fmt.Sprintf("template %s: %s", e.Template, e.Message)
+}
+
+// 保存 for 循环迭代对象求值结果的变量
+const loopItemsVariable = "__loop_items__"
+
+// 单次渲染的资源计数，超出限制后记录首个错误，之后的检查均返回该错误
+type renderLimiter struct {
+	name       string
+	limits     RenderLimits
+	deadline   time.Time
+	written    int
+	iterations int
+	depth      int
+	// 超时由渲染外的 goroutine 记录，err 需加锁访问
+	mu         sync.Mutex
+	err        error
+	structures *exec.ControlStructureSet
+}
+
+func newRenderLimiter(name string, limits RenderLimits) *renderLimiter {
+	return &renderLimiter{name: name, limits: limits, deadline: time.Now().Add(limits.Timeout)}
+}
+
+func (l *renderLimiter) fail(limit, format string, args ...any) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.err == nil {
+		l.err = RenderLimitError{Template: l.name, Limit: limit, Message: fmt.Sprintf(format, args...)}
+	}
+	return l.err
+}
+
+func (l *renderLimiter) failed() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.err
+}
+
+func (l *renderLimiter) timeout() error {
+	return l.fail(RenderLimitTimeout, "rendering exceeds the timeout of %s", l.limits.Timeout)
+}
+
+func (l *renderLimiter) check() error {
+	if err := l.failed(); err != nil {
+		return err
+	}
+	if time.Now().After(l.deadline) {
+		return l.timeout()
+	}
+	return nil
+}
+
+// 检查求值得到的中间结果，字符串按输出大小、列表和字典按迭代次数限制
+func (l *renderLimiter) value(v *exec.Value) error {
+	switch {
+	case v.IsString() && v.Len() > l.limits.MaxOutputSize:
+		return l.fail(RenderLimitOutputSize, "string of %d bytes exceeds the output size limit of %d bytes", v.Len(), l.limits.MaxOutputSize)
+	case (v.IsList() || v.IsDict()) && v.Len() > l.limits.MaxLoopIterations:
+		return l.fail(RenderLimitLoopIterations, "collection of %d items exceeds the loop iterations limit of %d", v.Len(), l.limits.MaxLoopIterations)
+	}
+	return nil
+}
+
+// 包装过滤器，调用前后检查超时及输入、结果的大小
+func (l *renderLimiter) filter(filter exec.FilterFunction) exec.FilterFunction {
+	return func(e *exec.Evaluator, in *exec.Value, params *exec.VarArgs) *exec.Value {
+		if err := l.check(); err != nil {
+			return exec.AsValue(err)
+		}
+		if err := l.value(in); err != nil {
+			return exec.AsValue(err)
+		}
+		out := filter(e, in, params)
+		if out.IsError() {
+			return out
+		}
+		if err := l.value(out); err != nil {
+			return exec.AsValue(err)
+		}
+		return out
+	}
+}
+
+// 在 goroutine 中执行渲染，超时后不再等待，渲染在下次检查时中止
+func (l *renderLimiter) execute(render func() error) error {
+	done := make(chan error, 1)
+	go func() { done <- render() }()
+	timer := time.NewTimer(time.Until(l.deadline))
+	defer timer.Stop()
+	select {
+	case err := <-done:
+		return err
+	case <-timer.C:
+		return l.timeout()
+	}
+}
+
+func (l *renderLimiter) write(n int) error {
+	if err := l.check(); err != nil {
+		return err
+	}
+	if l.written += n; l.written > l.limits.MaxOutputSize {
+		return l.fail(RenderLimitOutputSize, "rendered output exceeds the limit of %d bytes", l.limits.MaxOutputSize)
+	}
+	return nil
+}
+
+func (l *renderLimiter) iterate(n int) error {
+	if err := l.check(); err != nil {
+		return err
+	}
+	if l.iterations += n; l.iterations > l.limits.MaxLoopIterations {
+		return l.fail(RenderLimitLoopIterations, "loop iterations exceed the limit of %d", l.limits.MaxLoopIterations)
+	}
+	return nil
+}
+
+// 包装宏，调用时检查嵌套深度
+func (l *renderLimiter) macro(macro exec.Macro) exec.Macro {
+	return func(params *exec.VarArgs) *exec.Value {
+		if err := l.check(); err != nil {
+			return exec.AsValue(err)
+		}
+		if l.depth >= l.limits.MaxRecursionDepth {
+			return exec.AsValue(l.fail(RenderLimitRecursionDepth, "macro recursion depth exceeds the limit of %d", l.limits.MaxRecursionDepth))
+		}
+		l.depth++
+		defer func() { l.depth-- }()
+		return macro(params)
+	}
+}
+
+type limitedWriter struct {
+	w       io.Writer
+	limiter *renderLimiter
+}
+
+func (w *limitedWriter) Write(p []byte) (int, error) {
+	if err := w.limiter.write(len(p)); err != nil {
+		return 0, err
+	}
+	return w.w.Write(p)
+}
+
+func (l *renderLimiter) writer(w io.Writer) io.Writer {
+	if lw, ok := w.(*limitedWriter); ok && lw.limiter == l {
+		return w
+	}
+	return &limitedWriter{w: w, limiter: l}
+}
+
+// 替换 gonja 的 range()，其返回的 channel 没有长度，无法在循环前检查迭代次数
+func (l *renderLimiter) rangeFunction(_ *exec.Evaluator, params *exec.VarArgs) ([]int, error) {
+	start, stop, step := 0, 0, 1
+	args := params.Args
+	for _, arg := range args {
+		if !arg.IsInteger() {
+			return nil, exec.ErrInvalidCall(errors.New("expected signature is [start, ]stop[, step] where all arguments are integers"))
+		}
+	}
+	switch len(args) {
+	case 1:
+		stop = args[0].Integer()
+	case 2:
+		start, stop = args[0].Integer(), args[1].Integer()
+	case 3:
+		start, stop, step = args[0].Integer(), args[1].Integer(), args[2].Integer()
+	default:
+		return nil, exec.ErrInvalidCall(errors.New("expected signature is [start, ]stop[, step] where all arguments are integers"))
+	}
+	if step == 0 {
+		return nil, exec.ErrInvalidCall(errors.New("step cannot be 0"))
+	}
+	count := 0
+	if step > 0 && stop > start {
+		count = (stop - start + step - 1) / step
+	} else if step < 0 && stop < start {
+		count = (start - stop - step - 1) / -step
+	}
+	if count > l.limits.MaxLoopIterations {
+		return nil, l.fail(RenderLimitLoopIterations, "range of %d items exceeds the loop iterations limit of %d", count, l.limits.MaxLoopIterations)
+	}
+	items := make([]int, count)
+	for i := range items {
+		items[i] = start + i*step
+	}
+	return items, nil
+}
+
+// 模板中的乘法改写为调用的函数
+const multiplyFunction = "__mul__"
+
+// 乘除运算符，同一优先级的运算从左至右结合
+var multiplicativeTokens = []tokens.Type{tokens.Multiply, tokens.Division, tokens.FloorDivision, tokens.Modulo}
+
+// 按 gonja 的乘法语义计算 a * b，gonja 直接以 strings.Repeat 计算字符串乘法，
+// 因此在重复前检查结果大小
+func (l *renderLimiter) multiply(params *exec.VarArgs) (*exec.Value, error) {
+	if len(params.Args) != 2 {
+		return nil, exec.ErrInvalidCall(errors.New("expected signature is (left, right)"))
+	}
+	if err := l.check(); err != nil {
+		return nil, err
+	}
+	left, right := params.Args[0], params.Args[1]
+	switch {
+	case left.IsFloat() || right.IsFloat():
+		return exec.AsValue(left.Float() * right.Float()), nil
+	case left.IsString():
+		s, count := left.String(), max(right.Integer(), 0)
+		if len(s) > 0 && count > l.limits.MaxOutputSize/len(s) {
+			return nil, l.fail(RenderLimitOutputSize, "repeating a string of %d bytes %d times exceeds the output size limit of %d bytes", len(s), count, l.limits.MaxOutputSize)
+		}
+		return exec.AsValue(strings.Repeat(s, count)), nil
+	}
+	return exec.AsValue(left.Integer() * right.Integer()), nil
+}
+
+// 解析前将模板中的 a * b 改写为 __mul__(a, b)，运算数的范围由 gonja 的解析器确定，
+// 无法确定范围的乘法保持不变，由 gonja 报告语法错误
+func (l *renderLimiter) rewriteMultiply(source string, cfg *config.Config) string {
+	skipped := 0
+	for {
+		toks := lexTokens(source, cfg)
+		op, n := -1, 0
+		for i, tok := range toks {
+			if tok.Type == tokens.Multiply {
+				if n == skipped {
+					op = i
+					break
+				}
+				n++
+			}
+		}
+		if op < 0 {
+			return source
+		}
+		start, end := l.leftOperandStart(toks, op, cfg), l.rightOperandEnd(toks, op, cfg)
+		if start < 0 || end < 0 {
+			skipped++
+			continue
+		}
+		left := strings.TrimSpace(source[toks[start].Pos:toks[op].Pos])
+		right := source[toks[op+1].Pos:toks[end].Pos]
+		// 保留右侧运算数后的空白，不改变其后 token 的位置
+		space := right[len(strings.TrimRight(right, " \t\r\n")):]
+		source = fmt.Sprintf("%s%s(%s, %s)%s%s", source[:toks[start].Pos], multiplyFunction, left, strings.TrimRight(right, " \t\r\n"), space, source[toks[end].Pos:])
+	}
+}
+
+func lexTokens(source string, cfg *config.Config) []*tokens.Token {
+	toks := []*tokens.Token{}
+	stream := tokens.Lex(source, cfg)
+	for ; !stream.End(); stream.Next() {
+		toks = append(toks, stream.Current())
+	}
+	return append(toks, stream.Current())
+}
+
+// 从 toks[start] 开始解析一个幂运算表达式，返回其后第一个 token 的位置，解析失败时返回 -1
+func (l *renderLimiter) parsePower(toks []*tokens.Token, start int, cfg *config.Config) int {
+	p := parser.NewParser(l.name, tokens.NewStream(toks[start:]), cfg, nil, l.structures)
+	if expr, err := p.ParsePower(); err != nil || expr == nil {
+		return -1
+	}
+	return slices.Index(toks, p.Current())
+}
+
+// 乘号右侧的一元表达式，返回其后第一个 token 的位置
+func (l *renderLimiter) rightOperandEnd(toks []*tokens.Token, op int, cfg *config.Config) int {
+	start := op + 1
+	if start < len(toks) && (toks[start].Type == tokens.Addition || toks[start].Type == tokens.Subtraction) {
+		start++
+	}
+	if start >= len(toks) {
+		return -1
+	}
+	return l.parsePower(toks, start, cfg)
+}
+
+// 乘号左侧的运算数，包括通过 / // % 相连的前序运算数，如 a / b * c 的 a / b，
+// 每个运算数取恰好结束于运算符前的最长表达式
+func (l *renderLimiter) leftOperandStart(toks []*tokens.Token, op int, cfg *config.Config) int {
+	// 表达式所在的 ${ } 或 {% %} 的起点
+	begin := 0
+	for i := op - 1; i >= 0; i-- {
+		if toks[i].Type == tokens.VariableBegin || toks[i].Type == tokens.BlockBegin {
+			begin = i + 1
+			break
+		}
+	}
+	end := op
+	for {
+		start := -1
+		for i := begin; i < end; i++ {
+			if l.parsePower(toks, i, cfg) == end {
+				start = i
+				break
+			}
+		}
+		if start < 0 {
+			return -1
+		}
+		// 乘除运算符后的正负号属于该运算数
+		if start-2 >= begin && (toks[start-1].Type == tokens.Addition || toks[start-1].Type == tokens.Subtraction) &&
+			slices.Contains(multiplicativeTokens, toks[start-2].Type) {
+			start--
+		}
+		if start-1 < begin || !slices.Contains(multiplicativeTokens, toks[start-1].Type) {
+			return start
+		}
+		end = start - 1
+	}
+}
+
+// 渲染使用的环境，for、macro、import、from 及全部过滤器替换为检查限制的实现
+func (l *renderLimiter) environment(base *exec.Environment) *exec.Environment {
+	// FilterSet 不能遍历，借助 Update 将过滤器复制到 filters 中
+	filters := map[string]exec.FilterFunction{}
+	exec.NewFilterSet(filters).Update(base.Filters)
+	for name, filter := range filters {
+		filters[name] = l.filter(filter)
+	}
+	l.structures = exec.NewControlStructureSet(map[string]parser.ControlStructureParser{}).Update(base.ControlStructures)
+	wrappers := map[string]func(parser.ControlStructureParser) parser.ControlStructureParser{
+		"for":    l.forParser,
+		"macro":  l.macroParser,
+		"import": l.importParser,
+		"from":   l.fromParser,
+	}
+	for name, wrap := range wrappers {
+		if original, ok := base.ControlStructures.Get(name); ok {
+			l.structures.Replace(name, wrap(original))
+		}
+	}
+	return &exec.Environment{
+		Filters:           exec.NewFilterSet(filters),
+		Tests:             base.Tests,
+		ControlStructures: l.structures,
+		Context:           base.Context.Inherit().Update(exec.NewContext(map[string]any{multiplyFunction: l.multiply})),
+		Methods:           base.Methods,
+	}
+}
+
+// 取出控制结构参数的全部 token，以便重新解析
+func drainArgs(args *parser.Parser) []*tokens.Token {
+	toks := []*tokens.Token{}
+	for !args.End() {
+		toks = append(toks, args.Next())
+	}
+	return toks
+}
+
+func (l *renderLimiter) argsParser(p *parser.Parser, toks []*tokens.Token) *parser.Parser {
+	return parser.NewParser(l.name, tokens.NewStream(slices.Clone(toks)), p.Config, p.Loader, l.structures)
+}
+
+type limitedFor struct {
+	exec.ControlStructure
+	limiter *renderLimiter
+	items   nodes.Expression
+}
+
+// 解析 for 时单独解析被迭代的表达式，并在原实现中替换为保存其求值结果的变量，
+// 执行时先求值并检查迭代次数，避免对表达式求值两次
+func (l *renderLimiter) forParser(original parser.ControlStructureParser) parser.ControlStructureParser {
+	return func(p *parser.Parser, args *parser.Parser) (nodes.ControlStructure, error) {
+		toks := drainArgs(args)
+		in := slices.IndexFunc(toks, func(tok *tokens.Token) bool { return tok.Type == tokens.In })
+		if in < 0 {
+			return original(p, l.argsParser(p, toks))
+		}
+		itemsParser := l.argsParser(p, toks[in+1:])
+		items, err := itemsParser.ParseExpression()
+		if err != nil {
+			return original(p, l.argsParser(p, toks))
+		}
+		rest := slices.Index(toks, itemsParser.Current())
+		if rest < 0 {
+			rest = len(toks)
+		}
+		variable := &tokens.Token{Type: tokens.Name, Val: loopItemsVariable, Pos: toks[in].Pos, Line: toks[in].Line, Col: toks[in].Col}
+		rewritten := slices.Concat(toks[:in+1], []*tokens.Token{variable}, toks[rest:])
+		cs, err := original(p, l.argsParser(p, rewritten))
+		if err != nil {
+			return nil, err
+		}
+		return &limitedFor{ControlStructure: cs.(exec.ControlStructure), limiter: l, items: items}, nil
+	}
+}
+
+func (f *limitedFor) Execute(r *exec.Renderer, tag *nodes.ControlStructureBlock) error {
+	if err := f.limiter.check(); err != nil {
+		return err
+	}
+	items := r.Eval(f.items)
+	if items.IsError() {
+		return items
+	}
+	if items.IsList() || items.IsDict() || items.IsString() {
+		if err := f.limiter.iterate(items.Len()); err != nil {
+			return err
+		}
+	}
+	sub := r.Inherit()
+	sub.Environment.Context.Set(loopItemsVariable, items)
+	// 宏和 set 块的输出不经过渲染的 writer，需单独计数
+	sub.Output = f.limiter.writer(sub.Output)
+	return f.ControlStructure.Execute(sub, tag)
+}
+
+// 执行后将定义或导入的宏替换为检查嵌套深度的宏
+type limitedMacros struct {
+	exec.ControlStructure
+	limiter *renderLimiter
+	// 宏的变量名
+	macros []string
+	// import 导入的宏集合的变量名
+	module string
+}
+
+func (m *limitedMacros) Execute(r *exec.Renderer, tag *nodes.ControlStructureBlock) error {
+	if err := m.limiter.check(); err != nil {
+		return err
+	}
+	if err := m.ControlStructure.Execute(r, tag); err != nil {
+		return err
+	}
+	ctx := r.Environment.Context
+	for _, name := range m.macros {
+		if macro, ok := ctx.Get(name); ok {
+			if fn, ok := macro.(exec.Macro); ok {
+				ctx.Set(name, m.limiter.macro(fn))
+			}
+		}
+	}
+	if module, ok := ctx.Get(m.module); ok && m.module != "" {
+		if macros, ok := module.(map[string]exec.Macro); ok {
+			limited := map[string]exec.Macro{}
+			for name, fn := range macros {
+				limited[name] = m.limiter.macro(fn)
+			}
+			ctx.Set(m.module, limited)
+		}
+	}
+	return nil
+}
+
+func (l *renderLimiter) macroParser(original parser.ControlStructureParser) parser.ControlStructureParser {
+	return func(p *parser.Parser, args *parser.Parser) (nodes.ControlStructure, error) {
+		cs, err := original(p, args)
+		if err != nil {
+			return nil, err
+		}
+		name := cs.(*controlStructures.MacroControlStructure).Name
+		return &limitedMacros{ControlStructure: cs.(exec.ControlStructure), limiter: l, macros: []string{name}}, nil
+	}
+}
+
+// import 的别名为 as 后的变量名
+func (l *renderLimiter) importParser(original parser.ControlStructureParser) parser.ControlStructureParser {
+	return func(p *parser.Parser, args *parser.Parser) (nodes.ControlStructure, error) {
+		toks := drainArgs(args)
+		cs, err := original(p, l.argsParser(p, toks))
+		if err != nil {
+			return nil, err
+		}
+		module := ""
+		if as := slices.IndexFunc(toks, func(tok *tokens.Token) bool {
+			return tok.Type == tokens.Name && tok.Val == "as"
+		}); as >= 0 && as+1 < len(toks) {
+			module = toks[as+1].Val
+		}
+		return &limitedMacros{ControlStructure: cs.(exec.ControlStructure), limiter: l, module: module}, nil
+	}
+}
+
+func (l *renderLimiter) fromParser(original parser.ControlStructureParser) parser.ControlStructureParser {
+	return func(p *parser.Parser, args *parser.Parser) (nodes.ControlStructure, error) {
+		cs, err := original(p, args)
+		if err != nil {
+			return nil, err
+		}
+		macros := []string{}
+		for alias := range cs.(*controlStructures.FromImportControlStructure).As {
+			macros = append(macros, alias)
+		}
+		return &limitedMacros{ControlStructure: cs.(exec.ControlStructure), limiter: l, macros: macros}, nil
+	}
+}
@@ -0,0 +1,180 @@
+package runtime
+
+import (
+	"errors"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestRenderLimits(t *testing.T) {
+	defaultLimits := DefaultRenderLimits
+	defer func() { DefaultRenderLimits = defaultLimits }()
+	DefaultRenderLimits = RenderLimits{
+		MaxOutputSize:     1024,
+		MaxLoopIterations: 100,
+		MaxRecursionDepth: 8,
+		Timeout:           200 * time.Millisecond,
+	}
+
+	libs := map[string]string{
+		"lib/m":   "{% macro f(n) %}${ n }{% endmacro %}",
+		"lib/mul": "{% macro twice(s) %}${ s * 2 }{% endmacro %}",
+	}
+	reader := func(from, name string) (string, error) { return libs[name], nil }
+
+	tests := []struct {
+		name      string
+		template  string
+		want      string
+		wantLimit string
+	}{
+		{
+			name:     "loop",
+			template: "{% for i in items %}${ i }{% else %}empty{% endfor %}",
+			want:     "12",
+		},
+		{
+			name:     "loop over dict",
+			template: "{% for k, v in d %}${ k }=${ v }{% endfor %}",
+			want:     "a=1",
+		},
+		{
+			name:     "range",
+			template: "{% for i in range(3) %}${ i }{% endfor %}",
+			want:     "012",
+		},
+		{
+			name:     "recursive macro",
+			template: "{% macro f(n) %}{% if n > 0 %}${ n }${ f(n - 1) }{% endif %}{% endmacro %}${ f(3) }",
+			want:     "321",
+		},
+		{
+			name:     "imported macro",
+			template: "{% from 'lib/m' import f as h %}{% import 'lib/m' as m %}${ h(1) }${ m.f(2) }",
+			want:     "12",
+		},
+		{
+			name:     "multiplication",
+			template: "${ 'ab' * 3 }|${ 2 * 3 + 1 }|${ 7 // 2 * 3 }|${ (1 + 2) * -3 }|${ [1, 2][1] * 2 * 2 }|${ 1.5 * 2 }",
+			want:     "ababab|7|9|-9|8|3.0",
+		},
+		{
+			name:     "multiplication in blocks",
+			template: "{% from 'lib/mul' import twice %}{% set x = 'a' * 2 %}{% if x * 2 == 'aaaa' %}${ twice(x) }{% endif %}{% raw %}${ a * b }{% endraw %}",
+			want:     "aaaa${ a * b }",
+		},
+		{
+			name:      "output size",
+			template:  "{% for i in range(100) %}${ s }{% endfor %}",
+			wantLimit: RenderLimitOutputSize,
+		},
+		{
+			name:      "range length",
+			template:  "{% for i in range(1000000) %}{% endfor %}",
+			wantLimit: RenderLimitLoopIterations,
+		},
+		{
+			name:      "nested loops",
+			template:  "{% for i in range(20) %}{% for j in range(20) %}{% endfor %}{% endfor %}",
+			wantLimit: RenderLimitLoopIterations,
+		},
+		{
+			name:      "infinite recursion",
+			template:  "{% macro f() %}${ f() }{% endmacro %}${ f() }",
+			wantLimit: RenderLimitRecursionDepth,
+		},
+		{
+			name:      "string multiplication",
+			template:  "{% set n = ('x' * 300000000) | length %}${ n }",
+			wantLimit: RenderLimitOutputSize,
+		},
+		{
+			name:      "filter chain",
+			template:  "{% set n = ('x' * 1000 ~ 'x' * 1000) | upper | length %}${ n }",
+			wantLimit: RenderLimitOutputSize,
+		},
+		{
+			name:      "list filter",
+			template:  "{% set n = ('x' * 1000) | list | length %}${ n }",
+			wantLimit: RenderLimitLoopIterations,
+		},
+		{
+			name:      "filter result",
+			template:  "{% set x = 'x' | center(100000) %}",
+			wantLimit: RenderLimitOutputSize,
+		},
+		{
+			name:     "filter",
+			template: "{% set n = ('x' * 10) | upper | length %}${ n }",
+			want:     "10",
+		},
+		{
+			name:      "timeout",
+			template:  "{% macro f(n) %}{% if n > 0 %}${ f(n - 1) }${ f(n - 1) }{% endif %}{% endmacro %}${ f(7) }",
+			wantLimit: RenderLimitTimeout,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.wantLimit == RenderLimitTimeout {
+				DefaultRenderLimits.Timeout = time.Nanosecond
+				defer func() { DefaultRenderLimits.Timeout = 200 * time.Millisecond }()
+			}
+			ctx := map[string]any{"items": []any{1, 2}, "d": map[string]any{"a": 1}, "s": string(make([]byte, 100))}
+			got, err := RenderTemplateWithOptions(tt.template, ctx, RenderOptions{Reader: reader})
+			if tt.wantLimit == "" {
+				if err != nil {
+					t.Fatalf("RenderTemplateWithOptions() error = %v", err)
+				}
+				if got != tt.want {
+					t.Errorf("RenderTemplateWithOptions() = %q, want %q", got, tt.want)
+				}
+				return
+			}
+			var limitErr RenderLimitError
+			if !errors.As(err, &limitErr) {
+				t.Fatalf("RenderTemplateWithOptions() error = %v, want RenderLimitError", err)
+			}
+			if limitErr.Limit != tt.wantLimit {
+				t.Errorf("RenderLimitError.Limit = %s, want %s", limitErr.Limit, tt.wantLimit)
+			}
+		})
+	}
+}
+
+func TestRenderDeadline(t *testing.T) {
+	defaultLimits := DefaultRenderLimits
+	defer func() { DefaultRenderLimits = defaultLimits }()
+	DefaultRenderLimits.Timeout = 100 * time.Millisecond
+
+	// 表达式求值期间没有检查点，渲染到期后仍需立即返回
+	slow := func() string {
+		time.Sleep(2 * time.Second)
+		return "done"
+	}
+	start := time.Now()
+	_, err := RenderTemplateWithOptions("{% set x = slow() %}${ x }", nil, RenderOptions{Functions: map[string]any{"slow": slow}})
+	var limitErr RenderLimitError
+	if !errors.As(err, &limitErr) || limitErr.Limit != RenderLimitTimeout {
+		t.Fatalf("RenderTemplateWithOptions() error = %v, want timeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("RenderTemplateWithOptions() returned after %s", elapsed)
+	}
+}
+
+func TestRenderStringRepetition(t *testing.T) {
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	_, err := RenderTemplate("${ ('x' * 1000000000) | length }", nil)
+	runtime.ReadMemStats(&after)
+	var limitErr RenderLimitError
+	if !errors.As(err, &limitErr) || limitErr.Limit != RenderLimitOutputSize {
+		t.Fatalf("RenderTemplate() error = %v, want outputSize", err)
+	}
+	// 超出限制的字符串乘法在重复前拒绝
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 64<<20 {
+		t.Errorf("RenderTemplate() allocated %d bytes", allocated)
+	}
+}
@@ -0,0 +1,209 @@
+package runtime
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode"
+
+	"github.com/nikolalohinski/gonja/v2"
+	"github.com/nikolalohinski/gonja/v2/loaders"
+)
+
+// 按名称读取 include/import 的模板，from 为发起引用的模板名称
+type TemplateReader func(from, name string) (string, error)
+
+// include/import 存在循环引用
+type TemplateCycleError struct {
+	Cycle []string
+}
+
+func (e TemplateCycleError) Error() string {
+	return fmt.Sprintf("template include cycle: %s.", strings.Join(e.Cycle, " -> "))
+}
+
+var templateIncludeRe = regexp.MustCompile(`\{%-?\s*(?:include|import|from)\s+(?:'([^']*)'|"([^"]*)")`)
+
+// 模板中以字符串常量 include/import 的模板名称
+func TemplateIncludes(template string) []string {
+	names := []string{}
+	for _, m := range templateIncludeRe.FindAllStringSubmatch(template, -1) {
+		name := m[1] + m[2]
+		if !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// 渲染期间共享的加载状态，gonja 不会返回 Inherit 的错误，需单独记录
+type loaderState struct {
+	root    string
+	content []byte
+	reader  TemplateReader
+	// 解析前改写模板内容，如将乘法替换为检查限制的函数调用
+	rewrite func(string) string
+	err     error
+}
+
+// include/import 时由 reader 读取模板，stack 为当前的引用链，用于检测循环引用
+type templateLoader struct {
+	state *loaderState
+	stack []string
+}
+
+func newTemplateLoader(root, content string, reader TemplateReader) *templateLoader {
+	state := &loaderState{root: root, content: []byte(content), reader: reader}
+	return &templateLoader{state: state, stack: []string{root}}
+}
+
+func (l *templateLoader) Resolve(name string) (string, error) {
+	return strings.TrimSpace(name), nil
+}
+
+func (l *templateLoader) Read(name string) (io.Reader, error) {
+	name, _ = l.Resolve(name)
+	if name == l.state.root && len(l.stack) == 1 {
+		if err := checkUnclosed(name, string(l.state.content)); err != nil {
+			return nil, l.fail(err)
+		}
+		if l.state.rewrite != nil {
+			return strings.NewReader(l.state.rewrite(string(l.state.content))), nil
+		}
+		return bytes.NewReader(l.state.content), nil
+	}
+	if l.state.reader == nil {
+		return nil, l.fail(fmt.Errorf("template %s: include/import is not supported here", name))
+	}
+	// Inherit 后 stack 末尾即为 name，发起引用的为其上一级
+	from := l.stack[len(l.stack)-1]
+	if len(l.stack) > 1 && l.stack[len(l.stack)-1] == name {
+		from = l.stack[len(l.stack)-2]
+	}
+	content, err := l.state.reader(from, name)
+	if err != nil {
+		return nil, l.fail(err)
+	}
+	if err = checkUnclosed(name, content); err != nil {
+		return nil, l.fail(err)
+	}
+	if l.state.rewrite != nil {
+		content = l.state.rewrite(content)
+	}
+	return strings.NewReader(content), nil
+}
+
+func (l *templateLoader) Inherit(from string) (loaders.Loader, error) {
+	from, _ = l.Resolve(from)
+	if i := slices.Index(l.stack, from); i >= 0 {
+		return nil, l.fail(TemplateCycleError{Cycle: append(slices.Clone(l.stack[i:]), from)})
+	}
+	return &templateLoader{state: l.state, stack: append(slices.Clone(l.stack), from)}, nil
+}
+
+// 记录首个错误，渲染失败时代替 gonja 的错误返回
+func (l *templateLoader) fail(err error) error {
+	if l.state.err == nil {
+		l.state.err = err
+	}
+	return err
+}
+
+// gonja 的词法分析在模板结束于未闭合的表达式时会陷入死循环，读取模板时按其规则提前检查；
+// 其余的语法错误由 gonja 报告
+func checkUnclosed(name, template string) error {
+	cfg := gonja.DefaultConfig
+	rawEnds := map[string]*regexp.Regexp{
+		"raw":     regexp.MustCompile(regexp.QuoteMeta(cfg.BlockStartString) + `-?\s*endraw`),
+		"comment": regexp.MustCompile(regexp.QuoteMeta(cfg.BlockStartString) + `-?\s*endcomment`),
+	}
+	for pos := 0; pos < len(template); {
+		rest := template[pos:]
+		var begin string
+		switch {
+		case strings.HasPrefix(rest, cfg.CommentStartString):
+			i := strings.Index(rest[len(cfg.CommentStartString):], cfg.CommentEndString)
+			if i < 0 {
+				return nil
+			}
+			pos += len(cfg.CommentStartString) + i + len(cfg.CommentEndString)
+			continue
+		case strings.HasPrefix(rest, cfg.VariableStartString):
+			begin = cfg.VariableStartString
+		case strings.HasPrefix(rest, cfg.BlockStartString):
+			begin = cfg.BlockStartString
+		default:
+			pos++
+			continue
+		}
+		start := pos
+		pos += len(begin)
+		var rawEnd *regexp.Regexp
+		if begin == cfg.BlockStartString {
+			ident := strings.TrimLeft(template[pos:], "-+ \t")
+			ident = ident[:len(ident)-len(strings.TrimLeftFunc(ident, func(r rune) bool {
+				return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+			}))]
+			rawEnd = rawEnds[ident]
+		}
+		end, ok := expressionEnd(template, pos, cfg.VariableEndString, cfg.BlockEndString)
+		if !ok {
+			return nil
+		}
+		if end < 0 {
+			line := strings.Count(template[:start], "\n") + 1
+			col := start - strings.LastIndex(template[:start], "\n")
+			return TemplateError{Template: name, Line: line, Col: col, Message: fmt.Sprintf("unclosed %s", begin)}
+		}
+		pos = end
+		if rawEnd != nil {
+			loc := rawEnd.FindStringIndex(template[pos:])
+			if loc == nil {
+				return nil
+			}
+			pos += loc[0]
+		}
+	}
+	return nil
+}
+
+// 表达式结束的位置，未闭合时为 -1；括号不匹配或字符串未闭合时 gonja 会报错，ok 为 false
+func expressionEnd(template string, pos int, ends ...string) (end int, ok bool) {
+	var delimiters []byte
+	for pos < len(template) {
+		rest := template[pos:]
+		if len(delimiters) == 0 || rest[0] != delimiters[len(delimiters)-1] {
+			for _, e := range ends {
+				if strings.HasPrefix(rest, e) || strings.HasPrefix(rest, "-"+e) || strings.HasPrefix(rest, "+"+e) {
+					return pos + strings.Index(rest, e) + len(e), true
+				}
+			}
+		}
+		switch c := rest[0]; c {
+		case '\'', '"':
+			i := 1
+			for ; i < len(rest) && (rest[i] != c || rest[i-1] == '\\'); i++ {
+			}
+			if i == len(rest) {
+				return 0, false
+			}
+			pos += i
+		case '(':
+			delimiters = append(delimiters, ')')
+		case '[':
+			delimiters = append(delimiters, ']')
+		case '{':
+			delimiters = append(delimiters, '}')
+		case ')', ']', '}':
+			if len(delimiters) == 0 || delimiters[len(delimiters)-1] != c {
+				return 0, false
+			}
+			delimiters = delimiters[:len(delimiters)-1]
+		}
+		pos++
+	}
+	return -1, true
+}
@@ -0,0 +1,73 @@
+package runtime
+
+import (
+	"fmt"
+	"gcmdb/pkg/cmdb"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTemplateIncludes(t *testing.T) {
+	template := `{% include 'lib/a' %}{%- import "lib/b" as b %}{% from 'other/c' import x %}{% include 'lib/a' %}`
+	assert.Equal(t, []string{"lib/a", "lib/b", "other/c"}, TemplateIncludes(template))
+	assert.Equal(t, []string{}, TemplateIncludes("${ a }"))
+}
+
+func TestRenderTemplateWithReader(t *testing.T) {
+	templates := map[string]string{
+		"lib/a":      "A${ name }{% include 'lib/b' %}",
+		"lib/b":      "B",
+		"lib/macros": "{% macro hello(n) %}hello ${ n }{% endmacro %}",
+		"lib/loop":   "{% include 'lib/loop2' %}",
+		"lib/loop2":  "{% include 'lib/loop' %}",
+	}
+	froms := []string{}
+	reader := func(from, name string) (string, error) {
+		froms = append(froms, from+">"+name)
+		if tpl, ok := templates[name]; ok {
+			return tpl, nil
+		}
+		return "", fmt.Errorf("%s not found", name)
+	}
+	opts := RenderOptions{Name: "root", Reader: reader}
+	out, err := RenderTemplateWithOptions("{% include 'lib/a' %}", map[string]any{"name": "x"}, opts)
+	assert.NoError(t, err)
+	assert.Equal(t, "AxB", out)
+	assert.Equal(t, []string{"root>lib/a", "lib/a>lib/b"}, froms)
+
+	out, err = RenderTemplateWithOptions("{% import 'lib/macros' as m %}${ m.hello('y') }", nil, opts)
+	assert.NoError(t, err)
+	assert.Equal(t, "hello y", out)
+
+	_, err = RenderTemplateWithOptions("{% include 'lib/loop' %}", nil, opts)
+	assert.Equal(t, TemplateCycleError{Cycle: []string{"lib/loop", "lib/loop2", "lib/loop"}}, err)
+
+	_, err = RenderTemplateWithOptions("{% include 'lib/none' %}", nil, opts)
+	assert.EqualError(t, err, "lib/none not found")
+
+	// 未设置 reader 时不支持 include
+	_, err = RenderTemplateWithOptions("{% include 'lib/a' %}", nil, RenderOptions{})
+	assert.Error(t, err)
+}
+
+func TestTemplateIncludesValidation(t *testing.T) {
+	tpl := cmdb.NewDeployTemplate()
+	tpl.Metadata.Name = "test"
+	tpl.Metadata.Namespace = "test"
+	tpl.Spec.Command = []string{"docker-compose"}
+	tpl.Data = map[string]string{"a": "{% include 'lib/a' %}"}
+	assert.Error(t, ValidateObject(tpl))
+	tpl.Spec.Libraries = []string{"lib"}
+	assert.NoError(t, ValidateObject(tpl))
+	tpl.Data["a"] = "{% include 'a' %}"
+	assert.Error(t, ValidateObject(tpl))
+
+	lib := cmdb.NewTemplateLibrary()
+	lib.Metadata.Name = "lib"
+	lib.Metadata.Namespace = "test"
+	lib.Data = map[string]string{"a": "{% include 'lib/b' %}", "b": "B"}
+	assert.NoError(t, ValidateObject(lib))
+	lib.Spec.Libraries = []string{"lib"}
+	assert.Error(t, ValidateObject(lib))
+}
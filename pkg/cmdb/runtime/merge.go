@@ -0,0 +1,189 @@
+package runtime
+
+import (
+	"fmt"
+	"maps"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// 字段合并策略，在 ResourceRange、AppDeployment 的注解中以 merge.cmdb/<字段路径> 指定。
+// current 中显式为 null 的字段始终继承 target 的值，删除继承的字段需指定 delete 策略
+const (
+	// 保留 current 的值，默认策略
+	MergeKeep = "keep"
+	// 使用 target 的值
+	MergeOverride = "override"
+	// 列表追加 target 中 current 不存在的元素
+	MergeAppend = "append"
+	// 列表元素按 merge:<键> 的值合并，键相同的元素递归合并
+	MergeByKey = "merge"
+	// 删除该字段，即显式置空继承的值
+	MergeDelete = "delete"
+)
+
+const MergeAnnotationPrefix = "merge.cmdb/"
+
+// 合并时的冲突，kept 为保留的值，dropped 为丢弃的值
+type MergeConflict struct {
+	Path     string `json:"path"`
+	Strategy string `json:"strategy"`
+	Kept     any    `json:"kept"`
+	Dropped  any    `json:"dropped"`
+}
+
+// 从注解中解析字段的合并策略，键为字段路径
+func ParseMergeStrategies(annotations map[string]string) (map[string]string, error) {
+	strategies := map[string]string{}
+	for key, strategy := range annotations {
+		path, ok := strings.CutPrefix(key, MergeAnnotationPrefix)
+		if !ok {
+			continue
+		}
+		if path == "" {
+			errMsg := fmt.Sprintf("annotation %s: field path is required.", key)
+			return nil, fmt.Errorf("%s", errMsg)
+		}
+		name, byKey, _ := strings.Cut(strategy, ":")
+		switch {
+		case name == MergeByKey && byKey != "":
+		case byKey == "" && slices.Contains([]string{MergeKeep, MergeOverride, MergeAppend, MergeDelete}, name):
+		default:
+			errMsg := fmt.Sprintf("annotation %s: invalid merge strategy %q, must be keep, override, append, merge:<key> or delete.", key, strategy)
+			return nil, fmt.Errorf("%s", errMsg)
+		}
+		strategies[path] = strategy
+	}
+	return strategies, nil
+}
+
+// 同 Merge2Dict，按 strategies 中字段路径的策略合并，并返回冲突，path 为字段路径的前缀
+func Merge2DictWithConflicts(current, target map[string]any, path []string, strategies map[string]string) (map[string]any, []MergeConflict) {
+	conflicts := []MergeConflict{}
+	// target 中不存在上级字段时不会遍历到，需先删除
+	prefix := strings.Join(path, ".")
+	for fieldPath, strategy := range strategies {
+		rest, ok := fieldPath, true
+		if prefix != "" {
+			rest, ok = strings.CutPrefix(fieldPath, prefix+".")
+		}
+		if ok && strategy == MergeDelete {
+			deletePath(current, strings.Split(rest, "."))
+		}
+	}
+	result := merge2Dict(current, target, path, strategies, &conflicts)
+	slices.SortFunc(conflicts, func(a, b MergeConflict) int { return strings.Compare(a.Path, b.Path) })
+	return result, conflicts
+}
+
+func merge2Dict(current, target map[string]any, path []string, strategies map[string]string, conflicts *[]MergeConflict) map[string]any {
+	if path == nil {
+		path = []string{}
+	}
+	keys := slices.Sorted(maps.Keys(target))
+	for key := range current {
+		if _, ok := target[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	for _, key := range keys {
+		fieldPath := strings.Join(append(slices.Clone(path), key), ".")
+		strategy, byKey, _ := strings.Cut(strategies[fieldPath], ":")
+		if strategy == MergeDelete {
+			delete(current, key)
+			continue
+		}
+		targetVal, inTarget := target[key]
+		currentVal, exists := current[key]
+		if !inTarget {
+			continue
+		}
+		if !exists {
+			// current中不存在该key，直接赋值
+			current[key] = targetVal
+			continue
+		}
+		if strategy == MergeOverride {
+			if !reflect.DeepEqual(currentVal, targetVal) {
+				current[key] = targetVal
+				addConflict(conflicts, fieldPath, MergeOverride, targetVal, currentVal)
+			}
+			continue
+		}
+		currentList, okCurList := currentVal.([]any)
+		targetList, okTarList := targetVal.([]any)
+		if okCurList && okTarList && strategy == MergeAppend {
+			current[key] = appendList(currentList, targetList)
+			continue
+		}
+		if okCurList && okTarList && strategy == MergeByKey {
+			current[key] = mergeListByKey(currentList, targetList, fieldPath, byKey, strategies, conflicts)
+			continue
+		}
+		// 如果两个都是 map[string]any，递归合并
+		currentMap, okCur := currentVal.(map[string]any)
+		targetMap, okTar := targetVal.(map[string]any)
+		if okCur && okTar {
+			merge2Dict(currentMap, targetMap, append(slices.Clone(path), key), strategies, conflicts)
+		} else if currentVal == nil && isAllowedType(targetVal) {
+			// 未指定合并策略时，如果 current[key] 是 nil 并且 target[key] 是允许类型，则替换
+			current[key] = targetVal
+		} else if !reflect.DeepEqual(currentVal, targetVal) {
+			// 值不同且 current[key] 不为空，保留 current 的值并记录冲突
+			addConflict(conflicts, fieldPath, MergeKeep, currentVal, targetVal)
+		}
+	}
+	return current
+}
+
+func deletePath(m map[string]any, keys []string) {
+	if len(keys) == 1 {
+		delete(m, keys[0])
+		return
+	}
+	if next, ok := m[keys[0]].(map[string]any); ok {
+		deletePath(next, keys[1:])
+	}
+}
+
+func addConflict(conflicts *[]MergeConflict, path, strategy string, kept, dropped any) {
+	if conflicts != nil {
+		*conflicts = append(*conflicts, MergeConflict{Path: path, Strategy: strategy, Kept: kept, Dropped: dropped})
+	}
+}
+
+// 追加 target 中 current 不存在的元素
+func appendList(current, target []any) []any {
+	result := slices.Clone(current)
+	for _, item := range target {
+		if !slices.ContainsFunc(result, func(v any) bool { return reflect.DeepEqual(v, item) }) {
+			result = append(result, item)
+		}
+	}
+	return result
+}
+
+// 按元素中 key 的值合并列表，值相同的元素递归合并，其余元素追加
+func mergeListByKey(current, target []any, path, key string, strategies map[string]string, conflicts *[]MergeConflict) []any {
+	result := slices.Clone(current)
+	for _, item := range target {
+		targetItem, ok := item.(map[string]any)
+		i := -1
+		if ok && targetItem[key] != nil {
+			i = slices.IndexFunc(result, func(v any) bool {
+				m, ok := v.(map[string]any)
+				return ok && reflect.DeepEqual(m[key], targetItem[key])
+			})
+		}
+		if i < 0 {
+			if !slices.ContainsFunc(result, func(v any) bool { return reflect.DeepEqual(v, item) }) {
+				result = append(result, item)
+			}
+			continue
+		}
+		itemPath := fmt.Sprintf("%s[%s=%v]", path, key, targetItem[key])
+		merge2Dict(result[i].(map[string]any), targetItem, []string{itemPath}, strategies, conflicts)
+	}
+	return result
+}
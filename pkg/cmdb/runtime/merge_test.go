@@ -0,0 +1,113 @@
+package runtime
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseMergeStrategies(t *testing.T) {
+	strategies, err := ParseMergeStrategies(map[string]string{
+		"merge.cmdb/spec.args":          "append",
+		"merge.cmdb/spec.volumes":       "merge:name",
+		"merge.cmdb/spec.env.JAVA_OPTS": "delete",
+		"other":                         "value",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"spec.args": "append", "spec.volumes": "merge:name", "spec.env.JAVA_OPTS": "delete"}, strategies)
+
+	for _, annotations := range []map[string]string{
+		{"merge.cmdb/spec.args": "invalid"},
+		{"merge.cmdb/spec.args": "merge"},
+		{"merge.cmdb/spec.args": "append:name"},
+		{"merge.cmdb/": "keep"},
+	} {
+		_, err = ParseMergeStrategies(annotations)
+		assert.Error(t, err, annotations)
+	}
+}
+
+func TestMerge2DictWithConflicts(t *testing.T) {
+	current := map[string]any{"a": 1, "b": map[string]any{"c": "x", "d": nil}, "e": map[string]any{"f": 1}}
+	target := map[string]any{"a": 2, "b": map[string]any{"c": "y", "d": "z", "g": 3}, "e": "h"}
+	got, conflicts := Merge2DictWithConflicts(current, target, []string{"spec"}, nil)
+	assert.Equal(t, map[string]any{"a": 1, "b": map[string]any{"c": "x", "d": "z", "g": 3}, "e": map[string]any{"f": 1}}, got)
+	assert.Equal(t, []MergeConflict{
+		{Path: "spec.a", Strategy: "keep", Kept: 1, Dropped: 2},
+		{Path: "spec.b.c", Strategy: "keep", Kept: "x", Dropped: "y"},
+		{Path: "spec.e", Strategy: "keep", Kept: map[string]any{"f": 1}, Dropped: "h"},
+	}, conflicts)
+}
+
+func TestMerge2DictStrategies(t *testing.T) {
+	current := map[string]any{
+		"command": []any{"python"},
+		"args":    []any{"a", "b"},
+		"env":     map[string]any{"ENV": "test", "JAVA_OPTS": ""},
+		"volumes": []any{
+			map[string]any{"name": "data", "path": "/data"},
+			"raw",
+		},
+		"image": "app",
+	}
+	target := map[string]any{
+		"command": []any{"java"},
+		"args":    []any{"b", "c"},
+		"env":     map[string]any{"JAVA_OPTS": "-Xmx1g", "TZ": "UTC"},
+		"volumes": []any{
+			map[string]any{"name": "data", "path": "/mnt/data", "readOnly": true},
+			map[string]any{"name": "logs", "path": "/logs"},
+			"raw",
+		},
+		"image": "base",
+	}
+	strategies := map[string]string{
+		"spec.command":       "override",
+		"spec.args":          "append",
+		"spec.env.JAVA_OPTS": "delete",
+		"spec.volumes":       "merge:name",
+		"spec.image":         "keep",
+	}
+	got, conflicts := Merge2DictWithConflicts(current, target, []string{"spec"}, strategies)
+	assert.Equal(t, map[string]any{
+		"command": []any{"java"},
+		"args":    []any{"a", "b", "c"},
+		"env":     map[string]any{"ENV": "test", "TZ": "UTC"},
+		"volumes": []any{
+			map[string]any{"name": "data", "path": "/data", "readOnly": true},
+			"raw",
+			map[string]any{"name": "logs", "path": "/logs"},
+		},
+		"image": "app",
+	}, got)
+	assert.Equal(t, []MergeConflict{
+		{Path: "spec.command", Strategy: "override", Kept: []any{"java"}, Dropped: []any{"python"}},
+		{Path: "spec.image", Strategy: "keep", Kept: "app", Dropped: "base"},
+		{Path: "spec.volumes[name=data].path", Strategy: "keep", Kept: "/data", Dropped: "/mnt/data"},
+	}, conflicts)
+}
+
+func TestMerge2DictDeleteWithoutTarget(t *testing.T) {
+	current := map[string]any{"env": map[string]any{"A": "1", "B": "2"}, "image": "app"}
+	got, conflicts := Merge2DictWithConflicts(current, map[string]any{}, nil, map[string]string{"env.A": "delete", "image": "delete"})
+	assert.Equal(t, map[string]any{"env": map[string]any{"B": "2"}}, got)
+	assert.Empty(t, conflicts)
+}
+
+func TestMerge2DictNullInherit(t *testing.T) {
+	current := func() map[string]any {
+		return map[string]any{"env": map[string]any{"A": nil, "B": "2"}, "image": nil, "port": nil}
+	}
+	target := map[string]any{"env": map[string]any{"A": "1", "C": "3"}, "image": "base"}
+	// null 继承 target 的值，与是否指定其他字段的合并策略无关
+	want := map[string]any{"env": map[string]any{"A": "1", "B": "2", "C": "3"}, "image": "base", "port": nil}
+	got, _ := Merge2DictWithConflicts(current(), target, nil, nil)
+	assert.Equal(t, want, got)
+	got, conflicts := Merge2DictWithConflicts(current(), target, nil, map[string]string{"env.C": "keep"})
+	assert.Equal(t, want, got)
+	assert.Empty(t, conflicts)
+
+	// 删除继承的字段需指定 delete 策略
+	got, _ = Merge2DictWithConflicts(current(), target, nil, map[string]string{"env.A": "delete", "image": "delete"})
+	assert.Equal(t, map[string]any{"env": map[string]any{"B": "2", "C": "3"}, "port": nil}, got)
+}
@@ -0,0 +1,257 @@
+package runtime
+
+import (
+	"encoding/json"
+	"fmt"
+	"gcmdb/pkg/cmdb"
+	"math"
+	"reflect"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+)
+
+var schemaTypes = []string{"string", "integer", "number", "boolean", "object", "array"}
+
+// 参数校验失败的字段及原因
+type SchemaError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// 检查 JSON Schema 本身是否合法
+func CheckSchema(schema *cmdb.ParameterSchema) error {
+	return checkSchema(schema, "")
+}
+
+func checkSchema(schema *cmdb.ParameterSchema, path string) error {
+	if schema == nil {
+		return nil
+	}
+	at := path
+	if at == "" {
+		at = "<root>"
+	}
+	if schema.Type != "" && !slices.Contains(schemaTypes, schema.Type) {
+		return fmt.Errorf("schema %s: unknown type %q", at, schema.Type)
+	}
+	if schema.Pattern != "" {
+		if _, err := regexp.Compile(schema.Pattern); err != nil {
+			return fmt.Errorf("schema %s: invalid pattern: %s", at, err)
+		}
+	}
+	for _, name := range schema.Required {
+		if schema.Properties[name] == nil && schema.AdditionalProperties != nil && !*schema.AdditionalProperties {
+			return fmt.Errorf("schema %s: required property %q is not declared", at, name)
+		}
+	}
+	if schema.Default != nil {
+		if errs := validateSchema(schema, schema.Default, path); len(errs) > 0 {
+			return fmt.Errorf("schema %s: invalid default: %s", at, errs[0].Message)
+		}
+	}
+	for name, prop := range schema.Properties {
+		if err := checkSchema(prop, joinSchemaPath(path, name)); err != nil {
+			return err
+		}
+	}
+	return checkSchema(schema.Items, path+"[]")
+}
+
+// 设置默认值后按 schema 校验参数，返回所有不合法的字段，ignore 中的顶层参数不校验
+func ValidateParams(schema *cmdb.ParameterSchema, params map[string]any, ignore ...string) []SchemaError {
+	if schema == nil {
+		return nil
+	}
+	if params == nil {
+		params = map[string]any{}
+	}
+	applySchemaDefaults(schema, params)
+	value := map[string]any{}
+	for k, v := range params {
+		if !slices.Contains(ignore, k) {
+			value[k] = v
+		}
+	}
+	return validateSchema(schema, value, "")
+}
+
+// 为缺失的属性设置默认值，对象类型的属性未设置时按其子属性的默认值生成
+func applySchemaDefaults(schema *cmdb.ParameterSchema, obj map[string]any) {
+	for name, prop := range schema.Properties {
+		if prop == nil {
+			continue
+		}
+		if _, ok := obj[name]; !ok {
+			switch {
+			case prop.Default != nil:
+				obj[name] = cloneDefault(prop.Default)
+			case len(prop.Properties) > 0 && (prop.Type == "" || prop.Type == "object"):
+				child := map[string]any{}
+				applySchemaDefaults(prop, child)
+				if len(child) > 0 {
+					obj[name] = child
+				}
+			}
+			continue
+		}
+		if child, ok := obj[name].(map[string]any); ok {
+			applySchemaDefaults(prop, child)
+		}
+	}
+}
+
+// 默认值可能为 map 或数组，避免多次部署共用
+func cloneDefault(v any) any {
+	byts, err := json.Marshal(v)
+	if err != nil {
+		return v
+	}
+	var out any
+	if err = json.Unmarshal(byts, &out); err != nil {
+		return v
+	}
+	return out
+}
+
+func validateSchema(schema *cmdb.ParameterSchema, value any, path string) []SchemaError {
+	var errs []SchemaError
+	field := path
+	if field == "" {
+		field = "params"
+	}
+	fail := func(format string, args ...any) {
+		errs = append(errs, SchemaError{Field: field, Message: fmt.Sprintf(format, args...)})
+	}
+	if schema.Type != "" && !schemaTypeMatches(schema.Type, value) {
+		fail("must be %s, got %s", schema.Type, schemaTypeOf(value))
+		return errs
+	}
+	if len(schema.Enum) > 0 && !slices.ContainsFunc(schema.Enum, func(e any) bool { return schemaEqual(e, value) }) {
+		fail("must be one of %v", schema.Enum)
+	}
+	if n, ok := toFloat(value); ok {
+		if schema.Minimum != nil && n < *schema.Minimum {
+			fail("must be >= %v", *schema.Minimum)
+		}
+		if schema.Maximum != nil && n > *schema.Maximum {
+			fail("must be <= %v", *schema.Maximum)
+		}
+	}
+	if s, ok := value.(string); ok {
+		length := len([]rune(s))
+		if schema.MinLength != nil && length < *schema.MinLength {
+			fail("length must be >= %d", *schema.MinLength)
+		}
+		if schema.MaxLength != nil && length > *schema.MaxLength {
+			fail("length must be <= %d", *schema.MaxLength)
+		}
+		if schema.Pattern != "" {
+			if re, err := regexp.Compile(schema.Pattern); err == nil && !re.MatchString(s) {
+				fail("must match pattern %q", schema.Pattern)
+			}
+		}
+	}
+	if items, ok := value.([]any); ok {
+		if schema.MinItems != nil && len(items) < *schema.MinItems {
+			fail("must have at least %d items", *schema.MinItems)
+		}
+		if schema.MaxItems != nil && len(items) > *schema.MaxItems {
+			fail("must have at most %d items", *schema.MaxItems)
+		}
+		if schema.Items != nil {
+			for i, item := range items {
+				errs = append(errs, validateSchema(schema.Items, item, fmt.Sprintf("%s[%d]", path, i))...)
+			}
+		}
+	}
+	if obj, ok := value.(map[string]any); ok {
+		for _, name := range schema.Required {
+			if _, ok := obj[name]; !ok {
+				errs = append(errs, SchemaError{Field: joinSchemaPath(path, name), Message: "is required"})
+			}
+		}
+		names := make([]string, 0, len(obj))
+		for name := range obj {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if prop := schema.Properties[name]; prop != nil {
+				errs = append(errs, validateSchema(prop, obj[name], joinSchemaPath(path, name))...)
+			} else if schema.AdditionalProperties != nil && !*schema.AdditionalProperties {
+				errs = append(errs, SchemaError{Field: joinSchemaPath(path, name), Message: "is not allowed"})
+			}
+		}
+	}
+	return errs
+}
+
+func joinSchemaPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+func schemaTypeMatches(schemaType string, value any) bool {
+	switch schemaType {
+	case "integer":
+		n, ok := toFloat(value)
+		return ok && n == math.Trunc(n)
+	case "number":
+		_, ok := toFloat(value)
+		return ok
+	}
+	return schemaTypeOf(value) == schemaType
+}
+
+func schemaTypeOf(value any) string {
+	if value == nil {
+		return "null"
+	}
+	if _, ok := toFloat(value); ok {
+		return "number"
+	}
+	switch value.(type) {
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case map[string]any:
+		return "object"
+	case []any:
+		return "array"
+	}
+	return reflect.TypeOf(value).String()
+}
+
+// 参数可能来自 JSON 或 YAML，数值类型不一
+func toFloat(value any) (float64, bool) {
+	switch v := value.(type) {
+	case json.Number:
+		n, err := v.Float64()
+		return n, err == nil
+	case float64, float32, int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		return reflect.ValueOf(v).Convert(reflect.TypeOf(float64(0))).Float(), true
+	}
+	return 0, false
+}
+
+func schemaEqual(a, b any) bool {
+	if x, ok := toFloat(a); ok {
+		y, ok := toFloat(b)
+		return ok && x == y
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// 格式化为一行错误信息
+func FormatSchemaErrors(errs []SchemaError) string {
+	msgs := make([]string, 0, len(errs))
+	for _, e := range errs {
+		msgs = append(msgs, fmt.Sprintf("%s %s", e.Field, e.Message))
+	}
+	return strings.Join(msgs, "; ")
+}
@@ -0,0 +1,77 @@
+package runtime
+
+import (
+	"encoding/json"
+	"gcmdb/pkg/cmdb"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func parseSchema(t *testing.T, s string) *cmdb.ParameterSchema {
+	schema := &cmdb.ParameterSchema{}
+	assert.NoError(t, json.Unmarshal([]byte(s), schema))
+	return schema
+}
+
+func TestCheckSchema(t *testing.T) {
+	assert.NoError(t, CheckSchema(nil))
+	assert.NoError(t, CheckSchema(parseSchema(t, `{"type":"object","properties":{"a":{"type":"integer","default":1}}}`)))
+	for _, s := range []string{
+		`{"type":"map"}`,
+		`{"properties":{"a":{"pattern":"("}}}`,
+		`{"properties":{"a":{"type":"integer","default":"x"}}}`,
+		`{"properties":{"a":{"items":{"type":"str"}}}}`,
+		`{"required":["b"],"additionalProperties":false}`,
+	} {
+		assert.Error(t, CheckSchema(parseSchema(t, s)), s)
+	}
+}
+
+func TestValidateParams(t *testing.T) {
+	schema := parseSchema(t, `{
+		"type": "object",
+		"required": ["image_tag"],
+		"additionalProperties": false,
+		"properties": {
+			"image_tag": {"type": "string", "pattern": "^v\\d+"},
+			"replicas": {"type": "integer", "minimum": 1, "maximum": 10, "default": 2},
+			"env": {"type": "string", "enum": ["test", "prod"]},
+			"resources": {"type": "object", "properties": {"cpu": {"type": "number", "default": 0.5}}},
+			"hosts": {"type": "array", "maxItems": 2, "items": {"type": "string", "minLength": 1}}
+		}
+	}`)
+
+	// 设置默认值，保留参数不校验
+	params := map[string]any{"image_tag": "v1", "spec": map[string]any{}}
+	assert.Empty(t, ValidateParams(schema, params, "spec"))
+	assert.Equal(t, float64(2), params["replicas"])
+	assert.Equal(t, map[string]any{"cpu": 0.5}, params["resources"])
+	// YAML 解析的整数
+	assert.Empty(t, ValidateParams(schema, map[string]any{"image_tag": "v1", "replicas": uint64(3)}))
+
+	errs := ValidateParams(schema, map[string]any{
+		"replicas":  1.5,
+		"env":       "dev",
+		"hosts":     []any{"a", "", "c"},
+		"resources": map[string]any{"cpu": "1"},
+		"unknown":   true,
+	})
+	assert.Equal(t, []SchemaError{
+		{Field: "image_tag", Message: "is required"},
+		{Field: "env", Message: "must be one of [test prod]"},
+		{Field: "hosts", Message: "must have at most 2 items"},
+		{Field: "hosts[1]", Message: "length must be >= 1"},
+		{Field: "replicas", Message: "must be integer, got number"},
+		{Field: "resources.cpu", Message: "must be number, got string"},
+		{Field: "unknown", Message: "is not allowed"},
+	}, errs)
+	assert.Equal(t, "image_tag is required; env must be one of [test prod]", FormatSchemaErrors(errs[:2]))
+
+	errs = ValidateParams(schema, map[string]any{"image_tag": "latest", "replicas": 11})
+	assert.Equal(t, []SchemaError{
+		{Field: "image_tag", Message: `must match pattern "^v\\d+"`},
+		{Field: "replicas", Message: "must be <= 10"},
+	}, errs)
+	assert.Nil(t, ValidateParams(nil, nil))
+}
@@ -1,26 +1,196 @@
 package runtime
 
 import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
 	"strings"
-	"time"
 
 	"github.com/goccy/go-yaml"
 	"github.com/nikolalohinski/gonja/v2"
 	"github.com/nikolalohinski/gonja/v2/exec"
+	"github.com/nikolalohinski/gonja/v2/tokens"
+)
+
+type RenderOptions struct {
+	// 模板名称，用于错误信息
+	Name string
+	// 严格模式，引用未定义的变量时报错
+	Strict bool
+	// 仅本次渲染可用的函数，如读取 Secret 的 secret()
+	Functions map[string]any
+	// 读取 include/import 的模板，未设置时不支持 include/import
+	Reader TemplateReader
+}
+
+// 严格模式下模板引用了未定义的变量
+type TemplateError struct {
+	Template string `json:"template"`
+	Line     int    `json:"line"`
+	Col      int    `json:"col"`
+	Path     string `json:"path"`
+	Message  string `json:"message"`
+}
+
+func (e TemplateError) Error() string {
+	return fmt.Sprintf("template %s line %d col %d: %s", e.Template, e.Line, e.Col, e.Message)
+}
+
+var (
+	undefinedAttrRe = regexp.MustCompile(`(?i)unable to evaluate (\S+): (?:attribute|item) .* not found`)
+	undefinedNameRe = regexp.MustCompile(`(?i)unable to evaluate name "([^"]+)"`)
+	errLineRe       = regexp.MustCompile(`at line (\d+)`)
 )
 
 func RenderTemplate(template string, context map[string]any) (string, error) {
-	// Create a new template from the string
-	tpl, err := gonja.FromString(template)
+	return RenderTemplateWithOptions(template, context, RenderOptions{})
+}
+
+// 按选项渲染模板，context 不会被修改
+func RenderTemplateWithOptions(template string, context map[string]any, opts RenderOptions) (string, error) {
+	cfg := gonja.DefaultConfig
+	if opts.Strict {
+		cfg = cfg.Inherit()
+		cfg.StrictUndefined = true
+	}
+	name := opts.Name
+	if name == "" {
+		name = "template"
+	}
+	loader := newTemplateLoader(name, template, opts.Reader)
+	limiter := newRenderLimiter(name, DefaultRenderLimits)
+	loader.state.rewrite = func(source string) string { return limiter.rewriteMultiply(source, cfg) }
+	tpl, err := exec.NewTemplate(name, cfg, loader, limiter.environment(gonja.DefaultEnvironment))
 	if err != nil {
+		if loader.state.err != nil {
+			return "", loader.state.err
+		}
+		return "", err
+	}
+	ctx := exec.EmptyContext().Update(exec.NewContext(context)).Update(exec.NewContext(opts.Functions))
+	ctx.Set("range", limiter.rangeFunction)
+	var out strings.Builder
+	if err = limiter.execute(func() error { return tpl.Execute(limiter.writer(&out), ctx) }); err != nil {
+		if limiter.failed() != nil {
+			return "", limiter.failed()
+		}
+		if loader.state.err != nil {
+			return "", loader.state.err
+		}
+		if tplErr, ok := undefinedError(name, template, err); ok && opts.Strict {
+			return "", tplErr
+		}
 		return "", err
 	}
+	return out.String(), nil
+}
 
-	// Create a new execution context with the provided context
-	ctx := exec.NewContext(context)
+// 仅解析模板，检查语法错误
+func ParseTemplate(name, template string) error {
+	cfg := gonja.DefaultConfig.Inherit()
+	loader := newTemplateLoader(name, template, nil)
+	if _, err := exec.NewTemplate(name, cfg, loader, gonja.DefaultEnvironment); err != nil {
+		if loader.state.err != nil {
+			return loader.state.err
+		}
+		return err
+	}
+	return nil
+}
 
-	// Render the template with the context
-	return tpl.ExecuteToString(ctx)
+// 从 gonja 的错误信息中解析未定义的变量，并在模板中定位其行列
+func undefinedError(name, template string, err error) (TemplateError, bool) {
+	msg := err.Error()
+	path := ""
+	if m := undefinedAttrRe.FindAllStringSubmatch(msg, -1); m != nil {
+		path = m[len(m)-1][1]
+	} else if m := undefinedNameRe.FindAllStringSubmatch(msg, -1); m != nil {
+		path = m[len(m)-1][1]
+	} else {
+		return TemplateError{}, false
+	}
+	line := 0
+	if m := errLineRe.FindAllStringSubmatch(msg, -1); m != nil {
+		line, _ = strconv.Atoi(m[len(m)-1][1])
+	}
+	tplErr := TemplateError{Template: name, Line: line, Path: path, Message: fmt.Sprintf("undefined variable %s", path)}
+	if tok := findVariable(template, path, line); tok != nil {
+		tplErr.Line, tplErr.Col = tok.Line, tok.Col
+	}
+	return tplErr, true
+}
+
+// 查找 line 行起首个以 path 开头的变量引用，返回其首个 token
+func findVariable(template, path string, line int) *tokens.Token {
+	toks := templateTokens(template)
+	for i, tok := range toks {
+		if tok.Type != tokens.Name || tok.Line < line || (i > 0 && toks[i-1].Type == tokens.Dot) {
+			continue
+		}
+		chain := variableChain(toks[i:])
+		if chain == path || strings.HasPrefix(chain, path+".") || strings.HasPrefix(chain, path+"[") {
+			return tok
+		}
+	}
+	return nil
+}
+
+// 模板中引用的变量路径，如 spec.env['APP']，不含过滤器名称
+func TemplateVariables(template string) []string {
+	toks := templateTokens(template)
+	chains := []string{}
+	for i, tok := range toks {
+		if tok.Type != tokens.Name || (i > 0 && (toks[i-1].Type == tokens.Dot || toks[i-1].Type == tokens.Pipe)) {
+			continue
+		}
+		if chain := variableChain(toks[i:]); !slices.Contains(chains, chain) {
+			chains = append(chains, chain)
+		}
+	}
+	return chains
+}
+
+// 模板的词法 token，不含空白
+func templateTokens(template string) []*tokens.Token {
+	if checkUnclosed("", template) != nil {
+		return []*tokens.Token{}
+	}
+	cfg := gonja.DefaultConfig.Inherit()
+	lexer := tokens.NewLexer(template, cfg)
+	go lexer.Run()
+	toks := []*tokens.Token{}
+	for tok := range lexer.Tokens {
+		if tok.Type != tokens.Whitespace {
+			toks = append(toks, tok)
+		}
+	}
+	return toks
+}
+
+// 将 token 序列还原为与 gonja 错误信息一致的变量路径，如 spec.env['APP']
+func variableChain(toks []*tokens.Token) string {
+	chain := toks[0].Val
+	for i := 1; i < len(toks); i++ {
+		switch {
+		case toks[i].Type == tokens.Dot && i+1 < len(toks) && (toks[i+1].Type == tokens.Name || toks[i+1].Type == tokens.Integer):
+			chain += "." + toks[i+1].Val
+			i++
+		case toks[i].Type == tokens.LeftBracket && i+2 < len(toks) && toks[i+2].Type == tokens.RightBracket:
+			switch toks[i+1].Type {
+			case tokens.String:
+				chain += fmt.Sprintf("['%s']", toks[i+1].Val)
+			case tokens.Integer, tokens.Name:
+				chain += fmt.Sprintf("[%s]", toks[i+1].Val)
+			default:
+				return chain
+			}
+			i += 2
+		default:
+			return chain
+		}
+	}
+	return chain
 }
 
 func toYaml(e *exec.Evaluator, in *exec.Value, params *exec.VarArgs) *exec.Value {
@@ -38,10 +208,6 @@ func toYaml(e *exec.Evaluator, in *exec.Value, params *exec.VarArgs) *exec.Value
 	return exec.AsValue(v)
 }
 
-func nowTime(format string) string {
-	return time.Now().Format("20060102150405")
-}
-
 func init() {
 	gonja.DefaultConfig.VariableStartString = "${"
 	gonja.DefaultConfig.VariableEndString = "}"
@@ -49,4 +215,9 @@ func init() {
 
 	gonja.DefaultContext.Update(exec.NewContext(map[string]any{"now": nowTime}))
 	gonja.DefaultEnvironment.Filters.Register("to_yaml", toYaml)
+	gonja.DefaultEnvironment.Filters.Register("to_json", toJson)
+	gonja.DefaultEnvironment.Filters.Register("b64encode", b64encode)
+	gonja.DefaultEnvironment.Filters.Register("b64decode", b64decode)
+	gonja.DefaultEnvironment.Filters.Register("sha256", sha256Sum)
+	gonja.DefaultEnvironment.Filters.Replace("indent", indent)
 }
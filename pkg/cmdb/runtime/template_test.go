@@ -1,6 +1,7 @@
 package runtime
 
 import (
+	"reflect"
 	"testing"
 )
 
@@ -79,3 +80,121 @@ func TestRenderTemplate(t *testing.T) {
 		})
 	}
 }
+
+func TestTemplateVariables(t *testing.T) {
+	template := "${ spec.env['APP'] | default(name) } {% for h in hosts %}${ h.ip }{% endfor %}${ spec.app }${ spec.app }"
+	want := []string{"spec.env['APP']", "name", "for", "h", "hosts", "h.ip", "endfor", "spec.app"}
+	if got := TemplateVariables(template); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+	if got := TemplateVariables("no variables"); len(got) != 0 {
+		t.Errorf("expected no variables, got %v", got)
+	}
+}
+
+func TestRenderTemplateStrict(t *testing.T) {
+	context := map[string]any{
+		"spec": map[string]any{
+			"env":   map[string]any{"APP": "go-app"},
+			"hosts": []any{map[string]any{"name": "host1"}},
+		},
+	}
+	tests := []struct {
+		name     string
+		template string
+		want     string
+		wantErr  *TemplateError
+	}{
+		{
+			name:     "defined variable",
+			template: "app: ${ spec.env.APP }",
+			want:     "app: go-app",
+		},
+		{
+			name:     "default filter",
+			template: "app: ${ spec.env.NAME | default('none') }",
+			want:     "app: none",
+		},
+		{
+			name:     "undefined attribute",
+			template: "kind: Test\napp: ${ spec.evn.APP }",
+			wantErr:  &TemplateError{Template: "test", Line: 2, Col: 9, Path: "spec.evn", Message: "undefined variable spec.evn"},
+		},
+		{
+			name:     "undefined name",
+			template: "tag: ${ image_tag }",
+			wantErr:  &TemplateError{Template: "test", Line: 1, Col: 9, Path: "image_tag", Message: "undefined variable image_tag"},
+		},
+		{
+			name:     "undefined item",
+			template: "app: ${ spec.env.APP }\nenv: ${ spec.env[\"ENV\"] }",
+			wantErr:  &TemplateError{Template: "test", Line: 2, Col: 9, Path: "spec.env['ENV']", Message: "undefined variable spec.env['ENV']"},
+		},
+		{
+			name:     "undefined in loop",
+			template: "{% for h in spec.hosts %}\n- ${ h.name }:${ h.port }\n{% endfor %}",
+			wantErr:  &TemplateError{Template: "test", Line: 2, Col: 18, Path: "h.port", Message: "undefined variable h.port"},
+		},
+		{
+			name:     "undefined in condition",
+			template: "{% if spec.debug %}debug{% endif %}",
+			wantErr:  &TemplateError{Template: "test", Line: 1, Col: 7, Path: "spec.debug", Message: "undefined variable spec.debug"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := RenderTemplateWithOptions(tt.template, context, RenderOptions{Name: "test", Strict: true})
+			if tt.wantErr != nil {
+				tplErr, ok := err.(TemplateError)
+				if !ok {
+					t.Fatalf("RenderTemplateWithOptions() error = %v, want TemplateError", err)
+				}
+				if tplErr != *tt.wantErr {
+					t.Errorf("RenderTemplateWithOptions() error = %+v, want %+v", tplErr, *tt.wantErr)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("RenderTemplateWithOptions() = %v, %v, want %v", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseTemplate(t *testing.T) {
+	for _, template := range []string{
+		"${ a } {% for i in b %}${ i }{% endfor %}{% include 'lib/a' %}",
+		"{% raw %}${ {% endraw %}",
+		"{# ${ #}",
+	} {
+		if err := ParseTemplate("ok", template); err != nil {
+			t.Errorf("expected no error for %q, got %v", template, err)
+		}
+	}
+	for _, template := range []string{"{% if a %}", "{% endfor %}", "${ (a }"} {
+		if err := ParseTemplate("bad", template); err == nil {
+			t.Errorf("expected error for %q", template)
+		}
+	}
+}
+
+func TestUnclosedTemplate(t *testing.T) {
+	tests := []struct {
+		template string
+		want     TemplateError
+	}{
+		{"${ a ", TemplateError{Template: "bad", Line: 1, Col: 1, Message: "unclosed ${"}},
+		{"a: ${ b }\nc: ${ 'd}' ", TemplateError{Template: "bad", Line: 2, Col: 4, Message: "unclosed ${"}},
+		{"{% if a ", TemplateError{Template: "bad", Line: 1, Col: 1, Message: "unclosed {%"}},
+	}
+	for _, tt := range tests {
+		if err := ParseTemplate("bad", tt.template); !reflect.DeepEqual(err, tt.want) {
+			t.Errorf("ParseTemplate(%q) = %v, want %v", tt.template, err, tt.want)
+		}
+		_, err := RenderTemplateWithOptions(tt.template, map[string]any{"a": 1, "b": 2}, RenderOptions{Name: "bad"})
+		if !reflect.DeepEqual(err, tt.want) {
+			t.Errorf("RenderTemplateWithOptions(%q) = %v, want %v", tt.template, err, tt.want)
+		}
+	}
+}
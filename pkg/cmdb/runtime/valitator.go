@@ -3,6 +3,8 @@ package runtime
 import (
 	"encoding/base64"
 	"gcmdb/pkg/cmdb"
+	"slices"
+	"strings"
 
 	"github.com/go-playground/validator/v10"
 )
@@ -11,6 +13,9 @@ import (
 func ValidateObject(r cmdb.Object) error {
 	validate := validator.New(validator.WithRequiredStructEnabled())
 	validate.RegisterValidation("base64map", base64MapValidation)
+	validate.RegisterValidation("cron", cronValidation)
+	validate.RegisterValidation("jsonschema", jsonSchemaValidation)
+	validate.RegisterValidation("templateincludes", templateIncludesValidation)
 	return validate.Struct(r)
 }
 
@@ -25,3 +30,52 @@ func base64MapValidation(fl validator.FieldLevel) bool {
 
 	return true
 }
+
+// string 必须为合法的 cron 表达式
+func cronValidation(fl validator.FieldLevel) bool {
+	_, err := ParseCron(fl.Field().String())
+	return err == nil
+}
+
+// 必须为合法的参数 JSON Schema
+func jsonSchemaValidation(fl validator.FieldLevel) bool {
+	schema, ok := fl.Field().Interface().(cmdb.ParameterSchema)
+	if !ok {
+		return false
+	}
+	return CheckSchema(&schema) == nil
+}
+
+// data 中 include/import 的模板须为 <TemplateLibrary>/<键>，且库已在 spec.libraries 中声明
+func templateIncludesValidation(fl validator.FieldLevel) bool {
+	data, ok := fl.Field().Interface().(map[string]string)
+	if !ok {
+		return false
+	}
+	var self string
+	var libraries []string
+	switch obj := fl.Parent().Interface().(type) {
+	case cmdb.DeployTemplate:
+		libraries = obj.Spec.Libraries
+	case cmdb.TemplateLibrary:
+		self = obj.Metadata.Name
+		libraries = obj.Spec.Libraries
+		if slices.Contains(libraries, self) {
+			return false
+		}
+	default:
+		return false
+	}
+	for _, value := range data {
+		for _, name := range TemplateIncludes(value) {
+			lib, entry, found := strings.Cut(name, "/")
+			if !found || entry == "" {
+				return false
+			}
+			if lib != self && !slices.Contains(libraries, lib) {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,64 @@
+package v1
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"gcmdb/pkg/setting"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/render"
+)
+
+// 访问令牌与用户的对应关系，由 InstallApi 从服务端配置加载
+var UserTokens = map[string]string{}
+
+type userContextKey struct{}
+
+func loadUserTokens(users []setting.UserTokenS) {
+	tokens := map[string]string{}
+	for _, u := range users {
+		if u.NAME != "" && u.TOKEN != "" {
+			tokens[u.TOKEN] = u.NAME
+		}
+	}
+	UserTokens = tokens
+}
+
+// 按 Authorization: Bearer <token> 认证用户，未携带令牌的请求为匿名用户，令牌无效时拒绝请求
+func authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		if auth == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		token, ok := strings.CutPrefix(auth, "Bearer ")
+		user := ""
+		if ok {
+			user = lookupUser(token)
+		}
+		if user == "" {
+			render.Render(w, r, ErrUnauthorized(fmt.Errorf("%s", "invalid access token.")))
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), userContextKey{}, user)))
+	})
+}
+
+func lookupUser(token string) string {
+	user := ""
+	for t, name := range UserTokens {
+		if subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+			user = name
+		}
+	}
+	return user
+}
+
+// 请求认证的用户，匿名请求返回空
+func requestUser(r *http.Request) string {
+	user, _ := r.Context().Value(userContextKey{}).(string)
+	return user
+}
@@ -0,0 +1,42 @@
+package v1
+
+import (
+	"gcmdb/pkg/setting"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuthenticate(t *testing.T) {
+	loadUserTokens([]setting.UserTokenS{{NAME: "bob", TOKEN: "bob-token"}, {NAME: "carol"}})
+	defer func() { UserTokens = map[string]string{} }()
+	assert.Equal(t, map[string]string{"bob-token": "bob"}, UserTokens)
+
+	var user string
+	handler := authenticate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user = requestUser(r)
+	}))
+	tests := []struct {
+		auth string
+		code int
+		user string
+	}{
+		{"", http.StatusOK, ""},
+		{"Bearer bob-token", http.StatusOK, "bob"},
+		{"Bearer carol", http.StatusUnauthorized, ""},
+		{"Basic Ym9iOmJvYi10b2tlbg==", http.StatusUnauthorized, ""},
+	}
+	for _, tt := range tests {
+		user = ""
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", PathPrefix+"/health", nil)
+		if tt.auth != "" {
+			req.Header.Set("Authorization", tt.auth)
+		}
+		handler.ServeHTTP(rr, req)
+		assert.Equal(t, tt.code, rr.Code, tt.auth)
+		assert.Equal(t, tt.user, user, tt.auth)
+	}
+}
@@ -1,10 +1,14 @@
 package v1
 
 import (
+	"encoding/json"
 	"fmt"
 	"gcmdb/pkg/cmdb"
 	"gcmdb/pkg/cmdb/deployment"
+	"gcmdb/pkg/cmdb/server/storage"
 	"net/http"
+	"reflect"
+	"strconv"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/render"
@@ -12,13 +16,32 @@ import (
 
 type RenderParams struct {
 	Params map[string]any `json:"params"`
+	// 返回每个字段的来源
+	Trace bool `json:"trace"`
+	// 同时返回合并 ResourceRange 时的冲突
+	Conflicts bool `json:"conflicts"`
 }
 
-// TODO: read appdeployment status
-// TODO: read logs
-// TODO: list appdeployment image tags
+type ApprovalParams struct {
+	Comment string `json:"comment"`
+}
+
+type DeployPlanParams struct {
+	Selector map[string]string `json:"selector"`
+	Params   map[string]any    `json:"params"`
+}
+
+type PromoteParams struct {
+	To        string         `json:"to"`
+	Overrides map[string]any `json:"overrides"`
+	DryRun    bool           `json:"dryRun"`
+}
+
+type AppInstanceStatusParams struct {
+	FlowRunStatus cmdb.FlowRunStatus `json:"flowRunStatus"`
+}
 
-func addAppRenderApi(r *chi.Mux) {
+func addAppRenderApi(r chi.Router) {
 	r.Post(
 		fmt.Sprintf("%s/appdeployments/{namespace}/{name}/render", PathPrefix),
 		renderAppDeploymentFunc(),
@@ -27,10 +50,73 @@ func addAppRenderApi(r *chi.Mux) {
 		fmt.Sprintf("%s/appdeployments/{namespace}/{name}/deploytemplate/render", PathPrefix),
 		renderDeployTemplateFunc(),
 	)
+	r.Post(
+		fmt.Sprintf("%s/appdeployments/{namespace}/{name}/helmvalues/render", PathPrefix),
+		renderHelmValuesFunc(),
+	)
+	r.Post(
+		fmt.Sprintf("%s/appdeployments/{namespace}/{name}/manifests/render", PathPrefix),
+		renderManifestsFunc(),
+	)
+	r.Post(
+		fmt.Sprintf("%s/appdeployments/{namespace}/{name}/compose/render", PathPrefix),
+		renderDockerComposeFunc(),
+	)
 	r.Post(
 		fmt.Sprintf("%s/appdeployments/{namespace}/{name}/run/{action}", PathPrefix),
 		runAppDeploymentFunc(),
 	)
+	r.Post(
+		fmt.Sprintf("%s/appdeployments/{namespace}/{name}/promote", PathPrefix),
+		promoteAppDeploymentFunc(),
+	)
+	r.Get(
+		fmt.Sprintf("%s/appdeployments/{namespace}/{name}/lock", PathPrefix),
+		getDeployLockFunc(),
+	)
+	r.Delete(
+		fmt.Sprintf("%s/appdeployments/{namespace}/{name}/lock", PathPrefix),
+		releaseDeployLockFunc(),
+	)
+	r.Get(
+		fmt.Sprintf("%s/appdeployments/{namespace}/{name}/status", PathPrefix),
+		readAppDeploymentStatusFunc(),
+	)
+	r.Get(
+		fmt.Sprintf("%s/appdeployments/{namespace}/{name}/parameters", PathPrefix),
+		readParameterSchemasFunc(),
+	)
+	r.Get(
+		fmt.Sprintf("%s/appdeployments/{namespace}/{name}/tags", PathPrefix),
+		listImageTagsFunc(),
+	)
+	r.Post(
+		fmt.Sprintf("%s/appinstances/{namespace}/{name}/status", PathPrefix),
+		updateAppInstanceStatusFunc(),
+	)
+	r.Post(
+		fmt.Sprintf("%s/deployplans/{namespace}/run/{action}", PathPrefix),
+		runDeployPlanFunc(),
+	)
+	r.Post(
+		fmt.Sprintf("%s/deploymentrequests/{namespace}/{name}/approve", PathPrefix),
+		decideDeploymentRequestFunc(deployment.ApproveDeploymentRequest),
+	)
+	r.Post(
+		fmt.Sprintf("%s/deploymentrequests/{namespace}/{name}/reject", PathPrefix),
+		decideDeploymentRequestFunc(deployment.RejectDeploymentRequest),
+	)
+}
+
+func addStreamApi(r chi.Router) {
+	r.Get(
+		fmt.Sprintf("%s/appdeployments/{namespace}/{name}/logs", PathPrefix),
+		readLogsFunc("AppDeployment"),
+	)
+	r.Get(
+		fmt.Sprintf("%s/appinstances/{namespace}/{name}/logs", PathPrefix),
+		readLogsFunc("AppInstance"),
+	)
 }
 
 // render appdeployment
@@ -40,8 +126,27 @@ func renderAppDeploymentFunc() http.HandlerFunc {
 		name := chi.URLParam(r, "name")
 		namespace := chi.URLParam(r, "namespace")
 		var params RenderParams
-		if err = render.Decode(r, &params); err != nil {
-			render.Render(w, r, ErrInvalidRequest(err))
+		if !decodeRenderParams(w, r, &params) {
+			return
+		}
+		if params.Trace {
+			var trace *deployment.AppDeploymentTrace
+			if trace, err = deployment.TraceAppDeployment(db, name, namespace, params.Params); err != nil {
+				handleStorageErr(w, r, err)
+				return
+			}
+			render.Status(r, http.StatusOK)
+			render.Respond(w, r, trace)
+			return
+		}
+		if params.Conflicts {
+			appDeploy, conflicts, err := deployment.ResolveAppDeploymentWithConflicts(db, name, namespace, params.Params)
+			if err != nil {
+				handleStorageErr(w, r, err)
+				return
+			}
+			render.Status(r, http.StatusOK)
+			render.Respond(w, r, map[string]any{"appDeployment": appDeploy, "conflicts": conflicts})
 			return
 		}
 		var appDeploy *cmdb.AppDeployment
@@ -61,8 +166,17 @@ func renderDeployTemplateFunc() http.HandlerFunc {
 		name := chi.URLParam(r, "name")
 		namespace := chi.URLParam(r, "namespace")
 		var params RenderParams
-		if err = render.Decode(r, &params); err != nil {
-			render.Render(w, r, ErrInvalidRequest(err))
+		if !decodeRenderParams(w, r, &params) {
+			return
+		}
+		if params.Conflicts {
+			deployTemplate, conflicts, err := deployment.ResolveDeployTemplateWithConflicts(db, name, namespace, params.Params)
+			if err != nil {
+				handleStorageErr(w, r, err)
+				return
+			}
+			render.Status(r, http.StatusOK)
+			render.Respond(w, r, map[string]any{"deployTemplate": deployTemplate, "conflicts": conflicts})
 			return
 		}
 		var deployTemplate *cmdb.DeployTemplate
@@ -75,6 +189,84 @@ func renderDeployTemplateFunc() http.HandlerFunc {
 	}
 }
 
+// render helm values of kubernetes appdeployment
+func renderHelmValuesFunc() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var err error
+		name := chi.URLParam(r, "name")
+		namespace := chi.URLParam(r, "namespace")
+		var params RenderParams
+		if !decodeRenderParams(w, r, &params) {
+			return
+		}
+		var values map[string]any
+		if values, err = deployment.RenderHelmValues(db, name, namespace, params.Params); err != nil {
+			handleStorageErr(w, r, err)
+			return
+		}
+		render.Status(r, http.StatusOK)
+		render.Respond(w, r, values)
+	}
+}
+
+// render kubernetes manifests of appdeployment
+func renderManifestsFunc() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var err error
+		name := chi.URLParam(r, "name")
+		namespace := chi.URLParam(r, "namespace")
+		var params RenderParams
+		if !decodeRenderParams(w, r, &params) {
+			return
+		}
+		var manifests []map[string]any
+		if manifests, err = deployment.RenderKubernetesManifests(db, name, namespace, params.Params); err != nil {
+			handleStorageErr(w, r, err)
+			return
+		}
+		render.Status(r, http.StatusOK)
+		render.Respond(w, r, manifests)
+	}
+}
+
+// render docker-compose of appdeployment for each hostnode
+func renderDockerComposeFunc() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var err error
+		name := chi.URLParam(r, "name")
+		namespace := chi.URLParam(r, "namespace")
+		var params RenderParams
+		if !decodeRenderParams(w, r, &params) {
+			return
+		}
+		var composes map[string]map[string]any
+		if composes, err = deployment.RenderDockerCompose(db, name, namespace, params.Params); err != nil {
+			handleStorageErr(w, r, err)
+			return
+		}
+		render.Status(r, http.StatusOK)
+		render.Respond(w, r, composes)
+	}
+}
+
+// 解析渲染参数，按 schema 设置默认值并校验，失败时返回错误响应
+func decodeRenderParams(w http.ResponseWriter, r *http.Request, params *RenderParams) bool {
+	if err := render.Decode(r, params); err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return false
+	}
+	if params.Params == nil {
+		params.Params = map[string]any{}
+	}
+	name := chi.URLParam(r, "name")
+	namespace := chi.URLParam(r, "namespace")
+	if err := deployment.ValidateDeployParams(db, name, namespace, params.Params); err != nil {
+		handleStorageErr(w, r, err)
+		return false
+	}
+	return true
+}
+
 // TODO: run appdeployment
 func runAppDeploymentFunc() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -87,15 +279,25 @@ func runAppDeploymentFunc() http.HandlerFunc {
 			render.Render(w, r, ErrInvalidRequest(err))
 			return
 		}
+		if errResp := requireApprovalUser(r, namespace, action); errResp != nil {
+			render.Render(w, r, errResp)
+			return
+		}
 		deployCtl := deployment.NewDeployController(
 			db,
 			deployment.DeployAction(action),
 			name,
 			namespace,
 			params.Params,
-		)
+		).WithRequester(requestUser(r))
 		var appDeploy *cmdb.AppDeployment
 		if appDeploy, err = deployCtl.Run(); err != nil {
+			// 受保护的命名空间返回待审批的 DeploymentRequest
+			if e, ok := err.(deployment.ApprovalRequiredError); ok {
+				render.Status(r, http.StatusAccepted)
+				render.Respond(w, r, e.Request)
+				return
+			}
 			handleStorageErr(w, r, err)
 			return
 		}
@@ -103,3 +305,333 @@ func runAppDeploymentFunc() http.HandlerFunc {
 		render.Respond(w, r, appDeploy)
 	}
 }
+
+// promote appdeployment with its resourcerange and deploytemplate to another namespace
+func promoteAppDeploymentFunc() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := chi.URLParam(r, "name")
+		namespace := chi.URLParam(r, "namespace")
+		var params PromoteParams
+		if err := render.Decode(r, &params); err != nil {
+			render.Render(w, r, ErrInvalidRequest(err))
+			return
+		}
+		result, err := deployment.PromoteAppDeployment(db, name, namespace, params.To, params.Overrides, params.DryRun)
+		if err != nil {
+			handleStorageErr(w, r, err)
+			return
+		}
+		render.Status(r, http.StatusOK)
+		render.Respond(w, r, result)
+	}
+}
+
+// run appdeployments matched by selector in dependency order
+func runDeployPlanFunc() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		namespace := chi.URLParam(r, "namespace")
+		action := chi.URLParam(r, "action")
+		dryRun, _ := strconv.ParseBool(r.URL.Query().Get("dryRun"))
+		var params DeployPlanParams
+		if err := render.Decode(r, &params); err != nil {
+			render.Render(w, r, ErrInvalidRequest(err))
+			return
+		}
+		if params.Params == nil {
+			params.Params = map[string]any{}
+		}
+		if errResp := requireApprovalUser(r, namespace, action); errResp != nil && !dryRun {
+			render.Render(w, r, errResp)
+			return
+		}
+		plan, err := deployment.RunDeployPlan(
+			db, namespace, params.Selector, deployment.DeployAction(action), params.Params, requestUser(r), dryRun,
+		)
+		if err != nil {
+			if _, ok := err.(deployment.DependencyCycleError); ok {
+				render.Render(w, r, ErrUnprocessableEntity(err))
+				return
+			}
+			handleStorageErr(w, r, err)
+			return
+		}
+		render.Status(r, http.StatusOK)
+		if !dryRun {
+			render.Status(r, http.StatusCreated)
+		}
+		render.Respond(w, r, plan)
+	}
+}
+
+// read parameter schemas declared by orchestration and deploytemplate of appdeployment
+func readParameterSchemasFunc() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := chi.URLParam(r, "name")
+		namespace := chi.URLParam(r, "namespace")
+		schemas, err := deployment.ParameterSchemas(db, name, namespace)
+		if err != nil {
+			handleStorageErr(w, r, err)
+			return
+		}
+		render.Status(r, http.StatusOK)
+		render.Respond(w, r, schemas)
+	}
+}
+
+// read appdeployment deploy lock
+func getDeployLockFunc() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := chi.URLParam(r, "name")
+		namespace := chi.URLParam(r, "namespace")
+		lock, err := deployment.GetDeployLock(db, name, namespace)
+		if err != nil {
+			handleStorageErr(w, r, err)
+			return
+		}
+		render.Status(r, http.StatusOK)
+		render.Respond(w, r, lock)
+	}
+}
+
+// force release appdeployment deploy lock
+func releaseDeployLockFunc() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := chi.URLParam(r, "name")
+		namespace := chi.URLParam(r, "namespace")
+		lock, err := deployment.ReleaseDeployLock(db, name, namespace)
+		if err != nil {
+			handleStorageErr(w, r, err)
+			return
+		}
+		render.Status(r, http.StatusOK)
+		render.Respond(w, r, lock)
+	}
+}
+
+// update appinstance flow run status, callback by orchestrator
+func updateAppInstanceStatusFunc() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var err error
+		name := chi.URLParam(r, "name")
+		namespace := chi.URLParam(r, "namespace")
+		var params AppInstanceStatusParams
+		if err = render.Decode(r, &params); err != nil {
+			render.Render(w, r, ErrInvalidRequest(err))
+			return
+		}
+		var appInst *cmdb.AppInstance
+		if appInst, err = deployment.UpdateAppInstanceStatus(db, name, namespace, params.FlowRunStatus); err != nil {
+			handleStorageErr(w, r, err)
+			return
+		}
+		render.Status(r, http.StatusOK)
+		render.Respond(w, r, appInst)
+	}
+}
+
+// read appdeployment status with its current appinstances
+func readAppDeploymentStatusFunc() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := chi.URLParam(r, "name")
+		namespace := chi.URLParam(r, "namespace")
+		state, err := deployment.GetAppDeploymentStatus(db, name, namespace)
+		if err != nil {
+			handleStorageErr(w, r, err)
+			return
+		}
+		render.Status(r, http.StatusOK)
+		render.Respond(w, r, state)
+	}
+}
+
+// list image tags of appdeployment from container registry
+func listImageTagsFunc() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var err error
+		name := chi.URLParam(r, "name")
+		namespace := chi.URLParam(r, "namespace")
+		query := r.URL.Query()
+		opts := deployment.ImageTagOptions{Sort: query.Get("sort"), PageSize: deployment.DefaultTagPageSize}
+		switch opts.Sort {
+		case "", deployment.TagSortSemver, deployment.TagSortDate:
+		default:
+			render.Render(w, r, ErrInvalidRequest(fmt.Errorf("sort %s no support, must be %s or %s", opts.Sort, deployment.TagSortSemver, deployment.TagSortDate)))
+			return
+		}
+		for key, value := range map[string]*int{"page": &opts.Page, "pageSize": &opts.PageSize} {
+			if query.Get(key) == "" {
+				continue
+			}
+			if *value, err = strconv.Atoi(query.Get(key)); err != nil {
+				render.Render(w, r, ErrInvalidRequest(err))
+				return
+			}
+		}
+		var tags *deployment.ImageTagList
+		if tags, err = deployment.ListImageTags(r.Context(), db, name, namespace, opts); err != nil {
+			handleStorageErr(w, r, err)
+			return
+		}
+		render.Status(r, http.StatusOK)
+		render.Respond(w, r, tags)
+	}
+}
+
+// read flow run logs of appdeployment or appinstance, stream ndjson lines with follow=true
+func readLogsFunc(kind string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := chi.URLParam(r, "name")
+		namespace := chi.URLParam(r, "namespace")
+		opts := deployment.LogOptions{
+			Instance: r.URL.Query().Get("instance"),
+			Follow:   r.URL.Query().Get("follow") == "true",
+		}
+		if kind == "AppInstance" {
+			opts.Instance, name = name, ""
+		}
+		streaming := false
+		write := func(logs []deployment.FlowRunLog) error {
+			if !opts.Follow {
+				render.Status(r, http.StatusOK)
+				render.Respond(w, r, logs)
+				return nil
+			}
+			if !streaming {
+				w.Header().Set("Content-Type", "application/x-ndjson")
+				w.WriteHeader(http.StatusOK)
+				streaming = true
+			}
+			encoder := json.NewEncoder(w)
+			for _, log := range logs {
+				if err := encoder.Encode(log); err != nil {
+					return err
+				}
+			}
+			if flusher, ok := w.(http.Flusher); ok {
+				flusher.Flush()
+			}
+			return nil
+		}
+		// 开始输出日志后无法再返回错误状态码
+		if err := deployment.StreamLogs(r.Context(), db, name, namespace, opts, write); err != nil && !streaming {
+			handleStorageErr(w, r, err)
+		}
+	}
+}
+
+// 通用接口写入前的检查：审批状态只能通过 approve/reject 接口变更，
+// 创建后申请内容不可修改，命名空间的审批策略只能由认证的用户修改
+func checkResourceWrite(r *http.Request, data cmdb.Object, create bool) render.Renderer {
+	switch obj := data.(type) {
+	case *cmdb.DeploymentRequest:
+		return checkDeploymentRequestWrite(r, obj, create)
+	case *cmdb.Namespace:
+		return checkNamespaceWrite(r, obj, create)
+	}
+	return nil
+}
+
+func checkDeploymentRequestWrite(r *http.Request, request *cmdb.DeploymentRequest, create bool) render.Renderer {
+	if create {
+		status := request.Status
+		if (status.Phase != "" && status.Phase != cmdb.DeploymentRequestPending) || len(status.Approvals) > 0 || status.Message != "" {
+			return ErrForbidden(fmt.Errorf("%s", "deploymentRequest status can't be set on create, use approve or reject."))
+		}
+		if errResp := requireApprovalUser(r, request.Metadata.Namespace, request.Spec.Action); errResp != nil {
+			return errResp
+		}
+		if request.Spec.Requester != requestUser(r) {
+			errMsg := fmt.Sprintf("deploymentRequest requester must be the authenticated user %q.", requestUser(r))
+			return ErrForbidden(fmt.Errorf("%s", errMsg))
+		}
+		return nil
+	}
+	var obj cmdb.Object
+	if err := db.Get(r.Context(), "DeploymentRequest", request.Metadata.Name, request.Metadata.Namespace, storage.GetOptions{}, &obj); err != nil {
+		// 不存在时由更新返回错误
+		return nil
+	}
+	stored := obj.(*cmdb.DeploymentRequest)
+	// 审批针对创建时的申请内容，之后修改参数或操作会使审批失效
+	if !reflect.DeepEqual(request.Spec, stored.Spec) {
+		return ErrForbidden(fmt.Errorf("%s", "deploymentRequest spec can't be changed after create."))
+	}
+	if request.Status.Phase == "" {
+		request.Status.Phase = stored.Status.Phase
+	}
+	if !reflect.DeepEqual(request.Status, stored.Status) {
+		return ErrForbidden(fmt.Errorf("%s", "deploymentRequest status can't be changed, use approve or reject."))
+	}
+	return nil
+}
+
+// 匿名请求不可修改审批策略，包括改变 bizEnv 使生效的策略变化
+func checkNamespaceWrite(r *http.Request, ns *cmdb.Namespace, create bool) render.Renderer {
+	if requestUser(r) != "" {
+		return nil
+	}
+	errResp := ErrUnauthorized(fmt.Errorf("%s", "changing namespace approval requires an access token."))
+	if create {
+		if ns.Spec.Approval != nil {
+			return errResp
+		}
+		return nil
+	}
+	var obj cmdb.Object
+	if err := db.Get(r.Context(), "Namespace", ns.Metadata.Name, "", storage.GetOptions{}, &obj); err != nil {
+		return nil
+	}
+	stored := obj.(*cmdb.Namespace)
+	if !reflect.DeepEqual(ns.Spec.Approval, stored.Spec.Approval) ||
+		!reflect.DeepEqual(deployment.NamespaceApprovalPolicy(ns), deployment.NamespaceApprovalPolicy(stored)) {
+		return errResp
+	}
+	return nil
+}
+
+// 需要审批的操作须由认证的用户发起，匿名申请无法与审批人区分
+func requireApprovalUser(r *http.Request, namespace, action string) render.Renderer {
+	if requestUser(r) != "" {
+		return nil
+	}
+	required, err := deployment.ApprovalRequired(db, namespace, action)
+	if err != nil {
+		return ErrInternal(err)
+	}
+	if required {
+		errMsg := fmt.Sprintf("%s in namespace %s requires an access token.", action, namespace)
+		return ErrUnauthorized(fmt.Errorf("%s", errMsg))
+	}
+	return nil
+}
+
+// approve or reject deploymentrequest
+func decideDeploymentRequestFunc(
+	decide func(db *storage.Store, name, namespace, user, comment string) (*cmdb.DeploymentRequest, error),
+) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := chi.URLParam(r, "name")
+		namespace := chi.URLParam(r, "namespace")
+		var params ApprovalParams
+		if err := render.Decode(r, &params); err != nil {
+			render.Render(w, r, ErrInvalidRequest(err))
+			return
+		}
+		user := requestUser(r)
+		if user == "" {
+			render.Render(w, r, ErrUnauthorized(fmt.Errorf("%s", "approval requires an access token.")))
+			return
+		}
+		request, err := decide(db, name, namespace, user, params.Comment)
+		if err != nil {
+			if _, ok := err.(deployment.ApprovalDeniedError); ok {
+				render.Render(w, r, ErrForbidden(err))
+				return
+			}
+			handleStorageErr(w, r, err)
+			return
+		}
+		render.Status(r, http.StatusOK)
+		render.Respond(w, r, request)
+	}
+}
@@ -71,3 +71,25 @@ func TestRunAppDeploymentFuncInvalid(t *testing.T) {
 	runAppDeploymentFunc()(rr, req)
 	assert.Equal(t, rr.Code, 400)
 }
+
+func TestUpdateAppInstanceStatusFuncInvalid(t *testing.T) {
+	route := chi.NewRouter()
+	InstallApi(route, nil)
+
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("name", "a-not-exist-instance")
+	rctx.URLParams.Add("namespace", "test")
+
+	rr := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/", nil)
+	req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+	updateAppInstanceStatusFunc()(rr, req)
+	assert.Equal(t, rr.Code, 400)
+
+	rr = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/", bytes.NewBuffer([]byte(`{"flowRunStatus":"completed"}`)))
+	req.Header = http.Header{"Content-Type": {"application/json"}}
+	req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+	updateAppInstanceStatusFunc()(rr, req)
+	assert.Equal(t, rr.Code, 404)
+}
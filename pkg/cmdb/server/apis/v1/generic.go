@@ -1,17 +1,23 @@
 package v1
 
 import (
+	"context"
 	"fmt"
 	"gcmdb/global"
 	"gcmdb/pkg/cmdb"
 	"gcmdb/pkg/cmdb/conversion"
+	"gcmdb/pkg/cmdb/deployment"
+	"gcmdb/pkg/cmdb/runtime"
 	"gcmdb/pkg/cmdb/server/storage"
+	"gcmdb/pkg/setting"
 	"net/http"
 	"path"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/render"
 	clientv3 "go.etcd.io/etcd/client/v3"
 )
@@ -20,23 +26,70 @@ const PathPrefix = "/api/v1"
 
 var db *storage.Store
 
-func InstallApi(r *chi.Mux, s *storage.Store) {
+func InstallApi(r chi.Router, s *storage.Store) {
 	if s == nil {
 		db = newStorage()
 	} else {
 		db = s
 	}
+	if global.ServerSetting != nil && global.ServerSetting.DEPLOY_LOCK_TTL > 0 {
+		deployment.LockTTL = global.ServerSetting.DEPLOY_LOCK_TTL
+	}
+	if global.ServerSetting != nil && global.ServerSetting.PREFECT_API_URL != "" {
+		deployment.PrefectApiUrl = global.ServerSetting.PREFECT_API_URL
+	}
+	if global.ServerSetting != nil && global.ServerSetting.SCHEDULE_INTERVAL > 0 {
+		deployment.ScheduleInterval = time.Duration(global.ServerSetting.SCHEDULE_INTERVAL) * time.Second
+	}
+	if global.ServerSetting != nil && global.ServerSetting.STRICT_TEMPLATE {
+		deployment.StrictTemplate = true
+	}
+	if global.ServerSetting != nil {
+		setRenderLimits(global.ServerSetting)
+	}
+	if global.ServerSetting != nil && len(global.ServerSetting.USERS) > 0 {
+		loadUserTokens(global.ServerSetting.USERS)
+	}
+
+	r.Group(func(r chi.Router) {
+		r.Use(middleware.Timeout(RequestTimeout))
 
-	r.Get(path.Join(PathPrefix, "health"), healthFunc())
+		r.Get(path.Join(PathPrefix, "health"), healthFunc())
 
-	for _, kind := range global.ResourceOrder {
-		addGenericApi(r, kind)
+		for _, kind := range global.ResourceOrder {
+			addGenericApi(r, kind)
+		}
+
+		addAppRenderApi(r)
+	})
+
+	// 持续输出的接口不设置超时，由客户端断开连接结束
+	addStreamApi(r)
+}
+
+// 配置中大于 0 的渲染限制覆盖默认值
+func setRenderLimits(s *setting.ServerSettingS) {
+	if s.RENDER_MAX_OUTPUT_SIZE > 0 {
+		runtime.DefaultRenderLimits.MaxOutputSize = s.RENDER_MAX_OUTPUT_SIZE
+	}
+	if s.RENDER_MAX_LOOP_ITERATIONS > 0 {
+		runtime.DefaultRenderLimits.MaxLoopIterations = s.RENDER_MAX_LOOP_ITERATIONS
 	}
+	if s.RENDER_MAX_RECURSION_DEPTH > 0 {
+		runtime.DefaultRenderLimits.MaxRecursionDepth = s.RENDER_MAX_RECURSION_DEPTH
+	}
+	if s.RENDER_TIMEOUT > 0 {
+		runtime.DefaultRenderLimits.Timeout = time.Duration(s.RENDER_TIMEOUT) * time.Millisecond
+	}
+}
 
-	addAppRenderApi(r)
+// 启动定时部署调度及分批发布推进，需在 InstallApi 之后调用
+func StartScheduler(ctx context.Context) {
+	go deployment.RunScheduler(ctx, db, deployment.ScheduleInterval)
+	go deployment.RunRollouts(ctx, db, deployment.RolloutInterval)
 }
 
-func addGenericApi(r *chi.Mux, kind string) {
+func addGenericApi(r chi.Router, kind string) {
 	kind = strings.ToLower(kind)
 	obj, err := cmdb.NewResourceWithKind(kind)
 	if err != nil {
@@ -161,6 +214,10 @@ func createFunc(kind string) http.HandlerFunc {
 			render.Render(w, r, ErrInvalidRequest(err))
 			return
 		}
+		if errResp := checkResourceWrite(r, data, true); errResp != nil {
+			render.Render(w, r, errResp)
+			return
+		}
 		var out cmdb.Object
 		if err := db.Create(r.Context(), data, &out); err != nil {
 			handleStorageErr(w, r, err)
@@ -182,6 +239,10 @@ func updateFunc(kind string) http.HandlerFunc {
 			render.Render(w, r, ErrInvalidRequest(err))
 			return
 		}
+		if errResp := checkResourceWrite(r, data, false); errResp != nil {
+			render.Render(w, r, errResp)
+			return
+		}
 		var out cmdb.Object
 		if err := db.Update(r.Context(), data, &out); err != nil {
 			handleStorageErr(w, r, err)
@@ -218,6 +279,9 @@ type ErrResponse struct {
 	StatusText string `json:"status"`          // user-level status message
 	AppCode    int64  `json:"code,omitempty"`  // application-specific error code
 	ErrorText  string `json:"error,omitempty"` // application-level error message, for debugging
+
+	Fields   []runtime.SchemaError  `json:"fields,omitempty"`   // field-level validation errors
+	Template *runtime.TemplateError `json:"template,omitempty"` // template rendering error
 }
 
 func (e *ErrResponse) Render(w http.ResponseWriter, r *http.Request) error {
@@ -252,6 +316,53 @@ func ErrUnprocessableEntity(err error) render.Renderer {
 	}
 }
 
+func ErrInvalidParams(err deployment.ParamsValidationError) render.Renderer {
+	return &ErrResponse{
+		Err:            err,
+		HTTPStatusCode: 422,
+		StatusText:     "Unprocessable Entity.",
+		ErrorText:      err.Error(),
+		Fields:         err.Errors,
+	}
+}
+
+func ErrTemplate(err runtime.TemplateError) render.Renderer {
+	return &ErrResponse{
+		Err:            err,
+		HTTPStatusCode: 422,
+		StatusText:     "Unprocessable Entity.",
+		ErrorText:      err.Error(),
+		Template:       &err,
+	}
+}
+
+func ErrUnauthorized(err error) render.Renderer {
+	return &ErrResponse{
+		Err:            err,
+		HTTPStatusCode: 401,
+		StatusText:     "Unauthorized.",
+		ErrorText:      err.Error(),
+	}
+}
+
+func ErrForbidden(err error) render.Renderer {
+	return &ErrResponse{
+		Err:            err,
+		HTTPStatusCode: 403,
+		StatusText:     "Forbidden.",
+		ErrorText:      err.Error(),
+	}
+}
+
+func ErrConflict(err error) render.Renderer {
+	return &ErrResponse{
+		Err:            err,
+		HTTPStatusCode: 409,
+		StatusText:     "Conflict.",
+		ErrorText:      err.Error(),
+	}
+}
+
 func ErrInternal(err error) render.Renderer {
 	return &ErrResponse{
 		Err:            err,
@@ -269,9 +380,23 @@ func handleStorageErr(w http.ResponseWriter, r *http.Request, err error) {
 			render.Render(w, r, ErrNotFound(err))
 		case storage.ErrCodeInvalidObj:
 			render.Render(w, r, ErrUnprocessableEntity(err))
+		case storage.ErrCodeLocked:
+			render.Render(w, r, ErrConflict(err))
 		default:
 			render.Render(w, r, ErrInvalidRequest(err))
 		}
+	case deployment.ParamsValidationError:
+		render.Render(w, r, ErrInvalidParams(err))
+	case runtime.TemplateError:
+		render.Render(w, r, ErrTemplate(err))
+	case runtime.TemplateCycleError:
+		render.Render(w, r, ErrUnprocessableEntity(err))
+	case runtime.RenderLimitError:
+		render.Render(w, r, ErrUnprocessableEntity(err))
+	case deployment.TemplateAccessError:
+		render.Render(w, r, ErrForbidden(err))
+	case deployment.ApprovalDeniedError:
+		render.Render(w, r, ErrForbidden(err))
 	default:
 		render.Render(w, r, ErrInternal(err))
 	}
@@ -9,6 +9,9 @@ import (
 	"github.com/go-chi/render"
 )
 
+// 请求的超时时间，流式输出的接口(如 logs -f)不受限制
+var RequestTimeout = 3 * time.Second
+
 func NewRouter(s *storage.Store) chi.Router {
 	r := chi.NewRouter()
 
@@ -16,7 +19,7 @@ func NewRouter(s *storage.Store) chi.Router {
 	r.Use(middleware.Logger)
 	r.Use(middleware.Recoverer)
 	r.Use(middleware.URLFormat)
-	r.Use(middleware.Timeout(3 * time.Second))
+	r.Use(authenticate)
 	r.Use(render.SetContentType(render.ContentTypeJSON))
 
 	InstallApi(r, s)
@@ -0,0 +1,114 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"gcmdb/pkg/cmdb"
+	"gcmdb/pkg/cmdb/runtime"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/mcuadros/go-defaults"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/server/v3/embed"
+)
+
+// 进程内嵌入式 etcd 的存储前缀
+const embeddedPathPrefix = "/gcmdb"
+
+// 启动进程内的嵌入式 etcd 并返回其存储，数据目录为临时目录，close 时删除
+func NewEmbeddedStore() (store *Store, close func(), err error) {
+	dir, err := os.MkdirTemp("", "gcmdb-etcd-")
+	if err != nil {
+		return nil, nil, err
+	}
+	cfg := embed.NewConfig()
+	cfg.Dir = dir
+	cfg.UnsafeNoFsync = true
+	cfg.LogLevel = "error"
+	cfg.LogOutputs = []string{os.DevNull}
+	ports, err := freePorts(2)
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, nil, err
+	}
+	clientURL := url.URL{Scheme: "http", Host: net.JoinHostPort("127.0.0.1", strconv.Itoa(ports[0]))}
+	peerURL := url.URL{Scheme: "http", Host: net.JoinHostPort("127.0.0.1", strconv.Itoa(ports[1]))}
+	cfg.ListenClientUrls = []url.URL{clientURL}
+	cfg.AdvertiseClientUrls = []url.URL{clientURL}
+	cfg.ListenPeerUrls = []url.URL{peerURL}
+	cfg.AdvertisePeerUrls = []url.URL{peerURL}
+	cfg.InitialCluster = cfg.InitialClusterFromName(cfg.Name)
+	server, err := embed.StartEtcd(cfg)
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, nil, err
+	}
+	select {
+	case <-server.Server.ReadyNotify():
+	case <-time.After(30 * time.Second):
+		server.Close()
+		os.RemoveAll(dir)
+		return nil, nil, fmt.Errorf("embedded etcd not ready after 30s")
+	}
+	client, err := clientv3.New(clientv3.Config{Endpoints: []string{clientURL.Host}, DialTimeout: 5 * time.Second})
+	if err != nil {
+		server.Close()
+		os.RemoveAll(dir)
+		return nil, nil, err
+	}
+	close = func() {
+		client.Close()
+		server.Close()
+		os.RemoveAll(dir)
+	}
+	return New(client, embeddedPathPrefix), close, nil
+}
+
+// 由系统分配的空闲端口
+func freePorts(count int) ([]int, error) {
+	ports := []int{}
+	for i := 0; i < count; i++ {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			return nil, err
+		}
+		defer l.Close()
+		ports = append(ports, l.Addr().(*net.TCPAddr).Port)
+	}
+	return ports, nil
+}
+
+// 写入本地对象，与 Create 一样校验字段并设置默认值，但不检查、不记录引用关系，
+// 仅用于加载测试对象，引用的对象可以不在存储中
+func (s *Store) Load(ctx context.Context, obj cmdb.Object) error {
+	key := s.getStoragePath(obj)
+	if err := runtime.ValidateObject(obj); err != nil {
+		return NewInvalidObjError(key, err.Error())
+	}
+	meta := obj.GetMeta()
+	now := time.Now()
+	meta.CreationTimeStamp = &now
+	meta.ManagedFields.Time = &now
+	defaults.SetDefaults(obj)
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return NewInternalError(err.Error())
+	}
+	txnResp, err := s.client.KV.Txn(ctx).If(
+		notFound(key),
+	).Then(
+		clientv3.OpPut(key, string(data)),
+	).Commit()
+	if err != nil {
+		return NewInternalError(err.Error())
+	}
+	if !txnResp.Succeeded {
+		return NewKeyExistsError(key, 0)
+	}
+	return nil
+}
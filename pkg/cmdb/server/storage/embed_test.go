@@ -0,0 +1,37 @@
+package storage
+
+import (
+	"context"
+	"gcmdb/pkg/cmdb"
+	"gcmdb/pkg/cmdb/conversion"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEmbeddedStoreLoad(t *testing.T) {
+	store, closeStore, err := NewEmbeddedStore()
+	assert.NoError(t, err)
+	defer closeStore()
+	ctx := context.Background()
+
+	byts, err := os.ReadFile("../../example/files/appdeployment.yaml")
+	assert.NoError(t, err)
+	obj, err := conversion.DecodeObject(byts)
+	assert.NoError(t, err)
+	// 引用的 Namespace、Orchestration 等不存在，Create 失败而 Load 成功
+	assert.True(t, IsReferencedNotExist(store.Create(ctx, obj, nil)))
+	obj, _ = conversion.DecodeObject(byts)
+	assert.NoError(t, store.Load(ctx, obj))
+	assert.True(t, IsExist(store.Load(ctx, obj)))
+
+	var out cmdb.Object
+	assert.NoError(t, store.Get(ctx, "AppDeployment", "go-app", "test", GetOptions{}, &out))
+	assert.Equal(t, "go-app", out.GetMeta().Name)
+	assert.NotNil(t, out.GetMeta().CreationTimeStamp)
+
+	obj, _ = conversion.DecodeObject(byts)
+	obj.GetMeta().Name = "Invalid_Name"
+	assert.True(t, IsInvalidObj(store.Load(ctx, obj)))
+}
@@ -10,6 +10,7 @@ const (
 	ErrCodeInvalidObj
 	ErrCodeResourceReferenced
 	ErrCodeReferencedNotExist
+	ErrCodeLocked
 )
 
 var errCodeToMessage = map[int]string{
@@ -18,6 +19,7 @@ var errCodeToMessage = map[int]string{
 	ErrCodeInvalidObj:         "invalid object",
 	ErrCodeResourceReferenced: "resource has been referenced",
 	ErrCodeReferencedNotExist: "resource reference targert not exist",
+	ErrCodeLocked:             "resource is locked",
 }
 
 func NewKeyNotFoundError(key string, rv int64) *StorageError {
@@ -60,6 +62,14 @@ func NewResourceReferencedError(key, msg string) *StorageError {
 	}
 }
 
+func NewLockedError(key, msg string) *StorageError {
+	return &StorageError{
+		Code:               ErrCodeLocked,
+		Key:                key,
+		AdditionalErrorMsg: msg,
+	}
+}
+
 type StorageError struct {
 	Code               int
 	Key                string
@@ -97,6 +107,11 @@ func IsReferencedNotExist(err error) bool {
 	return isErrCode(err, ErrCodeReferencedNotExist)
 }
 
+// IsLocked returns true if resource is locked by others
+func IsLocked(err error) bool {
+	return isErrCode(err, ErrCodeLocked)
+}
+
 func isErrCode(err error, code int) bool {
 	if err == nil {
 		return false
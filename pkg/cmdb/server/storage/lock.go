@@ -0,0 +1,137 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"time"
+
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// 基于 etcd lease 的锁，lease 过期后自动释放
+type Lock struct {
+	Name       string    `json:"name"`
+	Owner      string    `json:"owner"`
+	TTL        int64     `json:"ttl"`
+	LeaseID    int64     `json:"leaseId"`
+	AcquiredAt time.Time `json:"acquiredAt"`
+	// 剩余有效期(秒)
+	ExpiresIn int64 `json:"expiresIn"`
+}
+
+func (s *Store) getLockPath(name string) string {
+	return path.Join(s.pathPrefix, "locks", name)
+}
+
+// 获取锁，锁已被持有时返回 ErrCodeLocked
+func (s *Store) AcquireLock(ctx context.Context, name, owner string, ttl int64) (*Lock, error) {
+	return s.putLock(ctx, name, owner, ttl, notFound(s.getLockPath(name)))
+}
+
+// 接管仍由 leaseID 持有的锁，用于同一部署的后续操作，锁已被释放或被其他持有者获取时返回 ErrCodeLocked
+func (s *Store) TakeOverLock(ctx context.Context, name, owner string, leaseID, ttl int64) (*Lock, error) {
+	cmp := clientv3.Compare(clientv3.LeaseValue(s.getLockPath(name)), "=", clientv3.LeaseID(leaseID))
+	lock, err := s.putLock(ctx, name, owner, ttl, cmp)
+	if err != nil {
+		return nil, err
+	}
+	// 锁已绑定新的 lease，撤销原 lease
+	s.client.Lease.Revoke(ctx, clientv3.LeaseID(leaseID))
+	return lock, nil
+}
+
+// 满足 cmp 时以新的 lease 写入锁
+func (s *Store) putLock(ctx context.Context, name, owner string, ttl int64, cmp clientv3.Cmp) (*Lock, error) {
+	key := s.getLockPath(name)
+	lease, err := s.client.Lease.Grant(ctx, ttl)
+	if err != nil {
+		return nil, NewInternalError(err.Error())
+	}
+	lock := &Lock{
+		Name:       name,
+		Owner:      owner,
+		TTL:        ttl,
+		LeaseID:    int64(lease.ID),
+		AcquiredAt: time.Now(),
+		ExpiresIn:  ttl,
+	}
+	data, err := json.Marshal(lock)
+	if err != nil {
+		s.client.Lease.Revoke(ctx, lease.ID)
+		return nil, NewInternalError(err.Error())
+	}
+	txnResp, err := s.client.KV.Txn(ctx).If(
+		cmp,
+	).Then(
+		clientv3.OpPut(key, string(data), clientv3.WithLease(lease.ID)),
+	).Else(
+		clientv3.OpGet(key),
+	).Commit()
+	if err != nil {
+		s.client.Lease.Revoke(ctx, lease.ID)
+		return nil, NewInternalError(err.Error())
+	}
+	if !txnResp.Succeeded {
+		s.client.Lease.Revoke(ctx, lease.ID)
+		held := &Lock{}
+		if kvs := txnResp.Responses[0].GetResponseRange().Kvs; len(kvs) > 0 {
+			json.Unmarshal(kvs[0].Value, held)
+		}
+		msg := fmt.Sprintf("locked by %s since %s", held.Owner, held.AcquiredAt.Format(time.RFC3339))
+		return nil, NewLockedError(key, msg)
+	}
+	return lock, nil
+}
+
+// 查询锁及其剩余有效期
+func (s *Store) GetLock(ctx context.Context, name string) (*Lock, error) {
+	key := s.getLockPath(name)
+	getResp, err := s.client.KV.Get(ctx, key)
+	if err != nil {
+		return nil, NewInternalError(err.Error())
+	}
+	if len(getResp.Kvs) == 0 {
+		return nil, NewKeyNotFoundError(key, 0)
+	}
+	lock := &Lock{}
+	if err = json.Unmarshal(getResp.Kvs[0].Value, lock); err != nil {
+		return nil, NewInternalError(err.Error())
+	}
+	ttlResp, err := s.client.Lease.TimeToLive(ctx, clientv3.LeaseID(lock.LeaseID))
+	if err != nil {
+		return nil, NewInternalError(err.Error())
+	}
+	lock.ExpiresIn = ttlResp.TTL
+	return lock, nil
+}
+
+// 续期锁
+func (s *Store) RefreshLock(ctx context.Context, name string) error {
+	lock, err := s.GetLock(ctx, name)
+	if err != nil {
+		return err
+	}
+	if _, err = s.client.Lease.KeepAliveOnce(ctx, clientv3.LeaseID(lock.LeaseID)); err != nil {
+		if err == rpctypes.ErrLeaseNotFound {
+			return NewKeyNotFoundError(s.getLockPath(name), 0)
+		}
+		return NewInternalError(err.Error())
+	}
+	return nil
+}
+
+// 释放锁，不校验持有者
+func (s *Store) ReleaseLock(ctx context.Context, name string) (*Lock, error) {
+	lock, err := s.GetLock(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	// 撤销 lease 会同时删除锁，若期间锁已被重新获取则 lease 不同，不受影响
+	if _, err = s.client.Lease.Revoke(ctx, clientv3.LeaseID(lock.LeaseID)); err != nil && err != rpctypes.ErrLeaseNotFound {
+		return nil, NewInternalError(err.Error())
+	}
+	return lock, nil
+}
@@ -0,0 +1,74 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLock(t *testing.T) {
+	ctx, s, _ := testSetup(false)
+	name := "appdeployments/test/lock-test"
+
+	lock, err := s.AcquireLock(ctx, name, "owner-1", 60)
+	assert.NoError(t, err)
+	assert.Equal(t, "owner-1", lock.Owner)
+	_, err = s.AcquireLock(ctx, name, "owner-2", 60)
+	assert.True(t, IsLocked(err))
+
+	lock, err = s.GetLock(ctx, name)
+	assert.NoError(t, err)
+	assert.Equal(t, "owner-1", lock.Owner)
+	assert.Greater(t, lock.ExpiresIn, int64(0))
+	assert.NoError(t, s.RefreshLock(ctx, name))
+
+	_, err = s.ReleaseLock(ctx, name)
+	assert.NoError(t, err)
+	_, err = s.ReleaseLock(ctx, name)
+	assert.True(t, IsNotFound(err))
+	assert.True(t, IsNotFound(s.RefreshLock(ctx, name)))
+}
+
+func TestLockExpired(t *testing.T) {
+	ctx, s, _ := testSetup(false)
+	name := "appdeployments/test/lock-expired-test"
+
+	_, err := s.AcquireLock(ctx, name, "owner-1", 1)
+	assert.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		_, err := s.AcquireLock(ctx, name, "owner-2", 60)
+		return err == nil
+	}, 10*time.Second, 200*time.Millisecond)
+	lock, err := s.ReleaseLock(ctx, name)
+	assert.NoError(t, err)
+	assert.Equal(t, "owner-2", lock.Owner)
+}
+
+func TestTakeOverLock(t *testing.T) {
+	ctx, s, _ := testSetup(false)
+	name := "appdeployments/test/lock-take-over-test"
+
+	held, err := s.AcquireLock(ctx, name, "owner-1", 60)
+	assert.NoError(t, err)
+	lock, err := s.TakeOverLock(ctx, name, "owner-2", held.LeaseID, 60)
+	assert.NoError(t, err)
+	assert.Equal(t, "owner-2", lock.Owner)
+	// 原 lease 已被接管
+	_, err = s.TakeOverLock(ctx, name, "owner-3", held.LeaseID, 60)
+	assert.True(t, IsLocked(err))
+
+	lock, err = s.ReleaseLock(ctx, name)
+	assert.NoError(t, err)
+	assert.Equal(t, "owner-2", lock.Owner)
+	_, err = s.TakeOverLock(ctx, name, "owner-3", lock.LeaseID, 60)
+	assert.True(t, IsLocked(err))
+}
+
+func TestLockInvalidClient(t *testing.T) {
+	ctx, s, _ := testInvalidSetup()
+	_, err := s.AcquireLock(ctx, "appdeployments/test/lock-test", "owner-1", 60)
+	assert.True(t, IsInternalError(err))
+	_, err = s.GetLock(ctx, "appdeployments/test/lock-test")
+	assert.True(t, IsInternalError(err))
+}
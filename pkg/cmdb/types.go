@@ -38,6 +38,8 @@ func NewResourceWithKind(kind string) (Object, error) {
 		o = NewNamespace()
 	case "deploytemplate":
 		o = NewDeployTemplate()
+	case "templatelibrary":
+		o = NewTemplateLibrary()
 	case "project":
 		o = NewProject()
 	case "app":
@@ -50,6 +52,14 @@ func NewResourceWithKind(kind string) (Object, error) {
 		o = NewAppDeployment()
 	case "appinstance":
 		o = NewAppInstance()
+	case "schedule":
+		o = NewSchedule()
+	case "deploymentrequest":
+		o = NewDeploymentRequest()
+	case "notifier":
+		o = NewNotifier()
+	case "deployplan":
+		o = NewDeployPlan()
 	default:
 		return nil, ResourceTypeError{Kind: kind}
 	}
@@ -116,6 +126,12 @@ func NewDeployTemplate() *DeployTemplate {
 	}
 }
 
+func NewTemplateLibrary() *TemplateLibrary {
+	return &TemplateLibrary{
+		ResourceBase: *NewResourceBase("TemplateLibrary", true),
+	}
+}
+
 func NewSCM() *SCM {
 	return &SCM{
 		ResourceBase: *NewResourceBase("SCM", false),
@@ -169,6 +185,34 @@ func NewAppInstance() *AppInstance {
 	}
 }
 
+func NewSchedule() *Schedule {
+	return &Schedule{
+		ResourceBase: *NewResourceBase("Schedule", true),
+		Spec:         ScheduleSpec{Params: make(map[string]any)},
+	}
+}
+
+func NewDeploymentRequest() *DeploymentRequest {
+	return &DeploymentRequest{
+		ResourceBase: *NewResourceBase("DeploymentRequest", true),
+		Spec:         DeploymentRequestSpec{Params: make(map[string]any)},
+	}
+}
+
+func NewNotifier() *Notifier {
+	return &Notifier{
+		ResourceBase: *NewResourceBase("Notifier", false),
+		Spec:         NotifierSpec{Webhook: NotifierWebhook{Headers: make(map[string]string)}},
+	}
+}
+
+func NewDeployPlan() *DeployPlan {
+	return &DeployPlan{
+		ResourceBase: *NewResourceBase("DeployPlan", true),
+		Spec:         DeployPlanSpec{Selector: make(map[string]string), Params: make(map[string]any)},
+	}
+}
+
 type ManagedFields struct {
 	Manager   string     `json:"manager" default:"cmctl"`
 	Operation string     `json:"operation" default:"Updated"`
@@ -270,6 +314,30 @@ type NamespaceSpec struct {
 	BizEnv     string `json:"bizEnv" validate:"required"`
 	BizUnit    string `json:"bizUnit" validate:"required"`
 	Datacenter string `json:"datacenter" validate:"required,dns_rfc1035_label" reference:"Datacenter"`
+	// 部署审批策略
+	Approval *NamespaceApproval `json:"approval,omitempty"`
+	// promote 到本命名空间时覆盖的字段，键为 <kind>.<字段路径>，如 resourcerange.spec.env.ENV
+	PromotionOverrides map[string]any `json:"promotionOverrides,omitempty"`
+	// 本命名空间渲染模板时可读取的资源
+	TemplateAccess *NamespaceTemplateAccess `json:"templateAccess,omitempty"`
+}
+
+type NamespaceTemplateAccess struct {
+	// lookup() 可读取的资源类型，未设置时为默认类型，默认不含带认证信息的类型；Secret 只能通过 secret() 读取
+	Kinds []string `json:"kinds,omitempty" validate:"omitempty,dive,oneof=Project Datacenter Zone Namespace SCM HostNode HelmRepository ContainerRegistry App ConfigCenter DeployPlatform DeployTemplate ResourceRange Orchestration AppDeployment AppInstance"`
+	// secret() 可读取的 Secret，未设置时不可读取
+	Secrets []string `json:"secrets,omitempty" validate:"omitempty,dive,required"`
+}
+
+type NamespaceApproval struct {
+	// 是否需要审批，未设置时 bizEnv 为 prod/production 的命名空间需要审批
+	Required *bool `json:"required,omitempty"`
+	// 审批人，为空时除申请人外任何人均可审批
+	Approvers []string `json:"approvers,omitempty"`
+	// 需要的审批人数，默认 1
+	MinApprovals int `json:"minApprovals,omitempty" validate:"omitempty,min=1"`
+	// 需要审批的部署操作，默认 release、restart、uninstall
+	Actions []string `json:"actions,omitempty" validate:"omitempty,dive,oneof=release restart uninstall promote abort"`
 }
 
 type Namespace struct {
@@ -461,12 +529,18 @@ func (r *DeployPlatform) GetMeta() *ObjectMeta {
 type DeployTemplateSpec struct {
 	Command    []string `json:"command" validate:"required"`
 	DeployArgs string   `json:"deployArgs"`
+	// 部署参数(params)的 JSON Schema
+	ParameterSchema *ParameterSchema `json:"parameterSchema,omitempty" validate:"omitempty,jsonschema"`
+	// 严格渲染，引用未定义的变量时报错
+	Strict bool `json:"strict,omitempty"`
+	// data 中 include/import 的 TemplateLibrary
+	Libraries []string `json:"libraries,omitempty" validate:"omitempty,dive,dns_rfc1035_label" reference:"TemplateLibrary"`
 }
 
 type DeployTemplate struct {
 	ResourceBase `json:",inline"`
 	Spec         DeployTemplateSpec `json:"spec" validate:"required"`
-	Data         map[string]string  `json:"data" validate:"required"`
+	Data         map[string]string  `json:"data" validate:"required,templateincludes"`
 }
 
 func (r DeployTemplate) GetKind() string {
@@ -477,6 +551,27 @@ func (r *DeployTemplate) GetMeta() *ObjectMeta {
 	return &r.Metadata
 }
 
+// 可被 DeployTemplate include/import 的模板片段，以 <名称>/<data 键> 引用
+type TemplateLibrary struct {
+	ResourceBase `json:",inline"`
+	Spec         TemplateLibrarySpec `json:"spec"`
+	Data         map[string]string   `json:"data" validate:"required,templateincludes"`
+}
+
+type TemplateLibrarySpec struct {
+	Description string `json:"description,omitempty"`
+	// data 中 include/import 的其他 TemplateLibrary，引用本库的片段时无需声明
+	Libraries []string `json:"libraries,omitempty" validate:"omitempty,dive,dns_rfc1035_label" reference:"TemplateLibrary"`
+}
+
+func (r TemplateLibrary) GetKind() string {
+	return r.Kind
+}
+
+func (r *TemplateLibrary) GetMeta() *ObjectMeta {
+	return &r.Metadata
+}
+
 type ProjectSpec struct {
 	NameInChain string `json:"nameInChain" validate:"required"`
 }
@@ -592,6 +687,8 @@ type MonitoringProbeHttpGet struct {
 
 type MonitoringProbe struct {
 	HttpGet MonitoringProbeHttpGet `json:"httpGet"`
+	// 容器内执行的健康检查命令，用于 docker-compose healthcheck，如 ["curl", "-f", "http://localhost:8000/health"]
+	Command []string `json:"command,omitempty"`
 }
 
 type ResourceRangeMonitoring struct {
@@ -650,6 +747,28 @@ func (r *ResourceRange) GetMeta() *ObjectMeta {
 type OrchestrationSpec struct {
 	Name       string         `json:"name" validate:"required"`
 	Parameters map[string]any `json:"parameters"`
+	// 部署参数(params)的 JSON Schema
+	ParameterSchema *ParameterSchema `json:"parameterSchema,omitempty" validate:"omitempty,jsonschema"`
+}
+
+// JSON Schema 的子集，用于校验部署参数并设置默认值
+type ParameterSchema struct {
+	// string integer number boolean object array
+	Type                 string                      `json:"type,omitempty"`
+	Description          string                      `json:"description,omitempty"`
+	Default              any                         `json:"default,omitempty"`
+	Enum                 []any                       `json:"enum,omitempty"`
+	Properties           map[string]*ParameterSchema `json:"properties,omitempty"`
+	Required             []string                    `json:"required,omitempty"`
+	AdditionalProperties *bool                       `json:"additionalProperties,omitempty"`
+	Items                *ParameterSchema            `json:"items,omitempty"`
+	Minimum              *float64                    `json:"minimum,omitempty"`
+	Maximum              *float64                    `json:"maximum,omitempty"`
+	MinLength            *int                        `json:"minLength,omitempty"`
+	MaxLength            *int                        `json:"maxLength,omitempty"`
+	Pattern              string                      `json:"pattern,omitempty"`
+	MinItems             *int                        `json:"minItems,omitempty"`
+	MaxItems             *int                        `json:"maxItems,omitempty"`
 }
 
 type Orchestration struct {
@@ -675,10 +794,45 @@ type AppDeploymentSpecTemplate struct {
 	DeployTemplate *ResourceRangeDeployTemplate  `json:"deployTemplate,omitempty"`
 }
 
+type DeployStrategyType string
+
+const (
+	DeployStrategyAll     DeployStrategyType = "all"
+	DeployStrategyRolling DeployStrategyType = "rolling"
+	DeployStrategyCanary  DeployStrategyType = "canary"
+)
+
+type RollingUpdateStrategy struct {
+	BatchSize          int  `json:"batchSize,omitempty" validate:"omitempty,min=1"`
+	MaxUnavailable     int  `json:"maxUnavailable,omitempty" validate:"omitempty,min=1"`
+	PauseSeconds       int  `json:"pauseSeconds,omitempty" validate:"omitempty,min=0"`
+	HealthCheck        bool `json:"healthCheck,omitempty"`
+	HealthCheckTimeout int  `json:"healthCheckTimeout,omitempty" validate:"omitempty,min=1"`
+}
+
+type CanaryStrategy struct {
+	Nodes           int               `json:"nodes,omitempty" validate:"omitempty,min=1"`
+	Percentage      int               `json:"percentage,omitempty" validate:"omitempty,min=1,max=100"`
+	NodeSelector    map[string]string `json:"nodeSelector,omitempty"`
+	AnalysisSeconds int               `json:"analysisSeconds,omitempty" validate:"omitempty,min=0"`
+	HealthCheck     bool              `json:"healthCheck,omitempty"`
+}
+
+type DeployStrategy struct {
+	Type          DeployStrategyType     `json:"type" validate:"omitempty,oneof=all rolling canary"`
+	RollingUpdate *RollingUpdateStrategy `json:"rollingUpdate,omitempty"`
+	Canary        *CanaryStrategy        `json:"canary,omitempty"`
+}
+
 type AppDeploymentSpec struct {
 	Orchestration string                    `json:"orchestration" validate:"required,dns_rfc1035_label" reference:"Orchestration"`
 	ResourceRange string                    `json:"resourceRange" validate:"required,dns_rfc1035_label" reference:"ResourceRange"`
 	Template      AppDeploymentSpecTemplate `json:"template" validate:"required"`
+	Strategy      *DeployStrategy           `json:"strategy,omitempty"`
+	// 保留的历史版本数，超出的非存活 AppInstance 会被回收，默认 10
+	RevisionHistoryLimit *int `json:"revisionHistoryLimit,omitempty" validate:"omitempty,min=0"`
+	// 依赖的同命名空间 AppDeployment，批量部署时先部署依赖
+	DependsOn []string `json:"dependsOn,omitempty" validate:"omitempty,dive,dns_rfc1035_label"`
 }
 
 type AppDeploymentStuatus string
@@ -693,13 +847,37 @@ const (
 	AppDeploymentFailed       AppDeploymentStuatus = "failed"
 )
 
+type AppDeploymentRollout struct {
+	Batch    int    `json:"batch"`
+	Batches  int    `json:"batches"`
+	Message  string `json:"message,omitempty"`
+	Canary   bool   `json:"canary,omitempty"`
+	Paused   bool   `json:"paused,omitempty"`
+	Aborted  bool   `json:"aborted,omitempty"`
+	Analysis string `json:"analysis,omitempty"`
+	// 下一批次的最早发布时间，当前批次完成后记录，由 RunRollouts 推进
+	NextBatchTime *time.Time `json:"nextBatchTime,omitempty"`
+	// 当前批次健康探测的截止时间，超时未健康则中止发布
+	HealthCheckDeadline *time.Time `json:"healthCheckDeadline,omitempty"`
+	// 金丝雀分析时间，金丝雀批次完成后按分析窗口记录，由 RunRollouts 到期后分析
+	AnalysisTime *time.Time `json:"analysisTime,omitempty"`
+}
+
 type AppDeployment struct {
-	ResourceBase `json:",inline"`
-	Spec         AppDeploymentSpec    `json:"spec" validate:"required"`
-	FlowRunId    string               `json:"flow_run_id" validate:"omitempty,uuid4"`
-	Status       AppDeploymentStuatus `json:"status,omitempty" default:"none-deployed"`
+	ResourceBase   `json:",inline"`
+	Spec           AppDeploymentSpec     `json:"spec" validate:"required"`
+	FlowRunId      string                `json:"flow_run_id" validate:"omitempty,uuid4"`
+	Status         AppDeploymentStuatus  `json:"status,omitempty" default:"none-deployed"`
+	DeployRevision int64                 `json:"deployRevision,omitempty"`
+	Rollout        *AppDeploymentRollout `json:"rollout,omitempty"`
 }
 
+// 系统管理的元数据字段，与资源内容无关
+var ManagedMetaFields = []string{"create_revision", "creationTimestamp", "managedFields", "revision", "version"}
+
+// AppDeployment 的部署状态字段，由部署流程维护
+var AppDeploymentStatusFields = []string{"flow_run_id", "status", "deployRevision", "rollout"}
+
 func (r AppDeployment) GetKind() string {
 	return r.Kind
 }
@@ -726,8 +904,17 @@ const (
 	FlowRunCancelling FlowRunStatus = "cancelling"
 )
 
+type AppInstancePhase string
+
+const (
+	AppInstanceActive      AppInstancePhase = "active"
+	AppInstanceTerminating AppInstancePhase = "terminating"
+)
+
 type AppInstanceStatus struct {
-	FlowRunStatus FlowRunStatus `json:"flowRunStatus" default:"pending"`
+	FlowRunStatus FlowRunStatus    `json:"flowRunStatus" default:"pending" validate:"omitempty,oneof=pending running completed failed cancelled crashed paused cancelling"`
+	Phase         AppInstancePhase `json:"phase,omitempty" default:"active" validate:"omitempty,oneof=active terminating"`
+	Batch         int              `json:"batch,omitempty"`
 }
 
 type AppInstance struct {
@@ -745,3 +932,222 @@ func (r AppInstance) GetKind() string {
 func (r *AppInstance) GetMeta() *ObjectMeta {
 	return &r.Metadata
 }
+
+type ScheduleConcurrencyPolicy string
+
+const (
+	// 不检查部署状态，部署进行中时由部署锁拒绝本次运行
+	ScheduleConcurrencyAllow ScheduleConcurrencyPolicy = "Allow"
+	// 部署进行中时跳过本次运行
+	ScheduleConcurrencyForbid ScheduleConcurrencyPolicy = "Forbid"
+	// 取消进行中的部署并释放部署锁，以本次运行替代
+	ScheduleConcurrencyReplace ScheduleConcurrencyPolicy = "Replace"
+)
+
+type ScheduleResult string
+
+const (
+	ScheduleStarted ScheduleResult = "started"
+	ScheduleSkipped ScheduleResult = "skipped"
+	// 已创建 DeploymentRequest，等待审批
+	SchedulePendingApproval ScheduleResult = "pendingApproval"
+	ScheduleFailed          ScheduleResult = "failed"
+)
+
+type ScheduleSpec struct {
+	// cron 表达式：分 时 日 月 周
+	Schedule string `json:"schedule" validate:"required,cron"`
+	// IANA 时区，默认为服务端本地时区
+	TimeZone          string                    `json:"timeZone,omitempty" validate:"omitempty,timezone"`
+	AppDeployment     string                    `json:"appDeployment" validate:"required,dns_rfc1035_label" reference:"AppDeployment"`
+	Action            string                    `json:"action" default:"release" validate:"required,oneof=release restart uninstall"`
+	Params            map[string]any            `json:"params,omitempty"`
+	Suspend           bool                      `json:"suspend,omitempty"`
+	ConcurrencyPolicy ScheduleConcurrencyPolicy `json:"concurrencyPolicy,omitempty" default:"Forbid" validate:"omitempty,oneof=Allow Forbid Replace"`
+}
+
+type ScheduleStatus struct {
+	// 最近一次运行的计划时间
+	LastScheduleTime *time.Time     `json:"lastScheduleTime,omitempty"`
+	LastResult       ScheduleResult `json:"lastResult,omitempty"`
+	Message          string         `json:"message,omitempty"`
+	// 暂停时为空
+	NextScheduleTime *time.Time `json:"nextScheduleTime,omitempty"`
+}
+
+type Schedule struct {
+	ResourceBase `json:",inline"`
+	Spec         ScheduleSpec   `json:"spec" validate:"required"`
+	Status       ScheduleStatus `json:"status,omitempty"`
+}
+
+func (r Schedule) GetKind() string {
+	return r.Kind
+}
+
+func (r *Schedule) GetMeta() *ObjectMeta {
+	return &r.Metadata
+}
+
+type DeploymentRequestPhase string
+
+const (
+	DeploymentRequestPending  DeploymentRequestPhase = "pending"
+	DeploymentRequestRejected DeploymentRequestPhase = "rejected"
+	// 已达到审批人数，部署执行中
+	DeploymentRequestApproved DeploymentRequestPhase = "approved"
+	DeploymentRequestDeployed DeploymentRequestPhase = "deployed"
+	DeploymentRequestFailed   DeploymentRequestPhase = "failed"
+)
+
+type DeploymentRequestSpec struct {
+	AppDeployment string         `json:"appDeployment" validate:"required,dns_rfc1035_label"`
+	Action        string         `json:"action" validate:"required,oneof=release restart uninstall promote abort"`
+	Params        map[string]any `json:"params,omitempty"`
+	Requester     string         `json:"requester"`
+}
+
+type DeploymentApproval struct {
+	User     string     `json:"user"`
+	Approved bool       `json:"approved"`
+	Comment  string     `json:"comment,omitempty"`
+	Time     *time.Time `json:"time,omitempty"`
+}
+
+type DeploymentRequestStatus struct {
+	Phase     DeploymentRequestPhase `json:"phase" default:"pending" validate:"omitempty,oneof=pending rejected approved deployed failed"`
+	Approvals []DeploymentApproval   `json:"approvals,omitempty"`
+	Message   string                 `json:"message,omitempty"`
+}
+
+// 受保护命名空间的部署申请，审批通过后执行部署
+type DeploymentRequest struct {
+	ResourceBase `json:",inline"`
+	Spec         DeploymentRequestSpec   `json:"spec" validate:"required"`
+	Status       DeploymentRequestStatus `json:"status,omitempty"`
+}
+
+func (r DeploymentRequest) GetKind() string {
+	return r.Kind
+}
+
+func (r *DeploymentRequest) GetMeta() *ObjectMeta {
+	return &r.Metadata
+}
+
+type NotifierWebhook struct {
+	Url     string            `json:"url" validate:"required,http_url"`
+	Method  string            `json:"method,omitempty" default:"POST" validate:"omitempty,oneof=POST PUT"`
+	Headers map[string]string `json:"headers,omitempty"`
+	// 请求超时(秒)
+	Timeout int `json:"timeout,omitempty" default:"10" validate:"omitempty,min=1"`
+}
+
+// 事件过滤条件，为空表示不过滤
+type NotifierFilter struct {
+	Namespaces []string `json:"namespaces,omitempty"`
+	// AppDeployment 的标签
+	Selector map[string]string `json:"selector,omitempty"`
+	// 事件类型：started succeeded failed
+	Events []string `json:"events,omitempty" validate:"omitempty,dive,oneof=started succeeded failed"`
+}
+
+type NotifierSpec struct {
+	Webhook NotifierWebhook `json:"webhook" validate:"required"`
+	// 请求体模板，以事件字段为变量渲染，为空时发送事件 JSON
+	Template string         `json:"template,omitempty"`
+	Filter   NotifierFilter `json:"filter,omitempty"`
+	// 投递失败的重试次数，默认 3
+	Retries *int `json:"retries,omitempty" validate:"omitempty,min=0,max=10"`
+	Suspend bool `json:"suspend,omitempty"`
+}
+
+type NotifierDelivery struct {
+	Event         string     `json:"event"`
+	AppDeployment string     `json:"appDeployment"`
+	Namespace     string     `json:"namespace"`
+	Time          *time.Time `json:"time,omitempty"`
+	Attempts      int        `json:"attempts"`
+	StatusCode    int        `json:"statusCode,omitempty"`
+	Succeeded     bool       `json:"succeeded"`
+	Error         string     `json:"error,omitempty"`
+}
+
+type NotifierStatus struct {
+	// 最近的投递记录，新的在前
+	Deliveries []NotifierDelivery `json:"deliveries,omitempty"`
+}
+
+// 部署事件的 Webhook 通知
+type Notifier struct {
+	ResourceBase `json:",inline"`
+	Spec         NotifierSpec   `json:"spec" validate:"required"`
+	Status       NotifierStatus `json:"status,omitempty"`
+}
+
+func (r Notifier) GetKind() string {
+	return r.Kind
+}
+
+func (r *Notifier) GetMeta() *ObjectMeta {
+	return &r.Metadata
+}
+
+type DeployPlanPhase string
+
+const (
+	DeployPlanRunning   DeployPlanPhase = "running"
+	DeployPlanSucceeded DeployPlanPhase = "succeeded"
+	DeployPlanFailed    DeployPlanPhase = "failed"
+)
+
+type DeployPlanItemPhase string
+
+const (
+	DeployPlanItemPending   DeployPlanItemPhase = "pending"
+	DeployPlanItemDeploying DeployPlanItemPhase = "deploying"
+	DeployPlanItemSucceeded DeployPlanItemPhase = "succeeded"
+	DeployPlanItemFailed    DeployPlanItemPhase = "failed"
+	// 依赖部署失败，不再部署
+	DeployPlanItemSkipped DeployPlanItemPhase = "skipped"
+	// 受保护的命名空间等待 DeploymentRequest 审批，审批通过后继续部署
+	DeployPlanItemWaitingApproval DeployPlanItemPhase = "waitingApproval"
+)
+
+type DeployPlanSpec struct {
+	// 选择同命名空间的 AppDeployment
+	Selector map[string]string `json:"selector,omitempty"`
+	Action   string            `json:"action" validate:"required,oneof=release restart uninstall"`
+	Params   map[string]any    `json:"params,omitempty"`
+}
+
+type DeployPlanItem struct {
+	AppDeployment string `json:"appDeployment"`
+	// 计划内需先完成的 AppDeployment，卸载时为依赖它的 AppDeployment
+	After   []string            `json:"after,omitempty"`
+	Phase   DeployPlanItemPhase `json:"phase"`
+	Message string              `json:"message,omitempty"`
+	// 等待审批的 DeploymentRequest
+	Request string `json:"request,omitempty"`
+}
+
+type DeployPlanStatus struct {
+	Phase DeployPlanPhase `json:"phase,omitempty"`
+	// 按拓扑顺序排列
+	Items []DeployPlanItem `json:"items,omitempty"`
+}
+
+// 按依赖顺序批量部署 AppDeployment
+type DeployPlan struct {
+	ResourceBase `json:",inline"`
+	Spec         DeployPlanSpec   `json:"spec" validate:"required"`
+	Status       DeployPlanStatus `json:"status,omitempty"`
+}
+
+func (r DeployPlan) GetKind() string {
+	return r.Kind
+}
+
+func (r *DeployPlan) GetMeta() *ObjectMeta {
+	return &r.Metadata
+}
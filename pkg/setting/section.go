@@ -2,11 +2,34 @@ package setting
 
 type ClientSettingS struct {
 	CMDB_API_URL string
+	// 访问令牌，服务端据此认证部署发起人及审批人
+	CMDB_TOKEN string
+}
+
+type UserTokenS struct {
+	NAME  string
+	TOKEN string
 }
 
 type ServerSettingS struct {
 	ETCD_SERVER_HOST string
 	ETCD_SERVER_PORT string
+	// 用户访问令牌，请求通过 Authorization: Bearer <TOKEN> 认证为 NAME，部署发起人及审批人以认证的用户为准
+	USERS []UserTokenS
+	// 部署锁有效期(秒)
+	DEPLOY_LOCK_TTL int64
+	// 编排系统 Prefect API 地址，用于查询部署日志
+	PREFECT_API_URL string
+	// 定时部署检查间隔(秒)
+	SCHEDULE_INTERVAL int64
+	// 严格渲染模板，引用未定义的变量时报错
+	STRICT_TEMPLATE bool
+	// 模板渲染的资源限制，未设置时使用默认值：输出字节数、循环总迭代次数、宏嵌套深度
+	RENDER_MAX_OUTPUT_SIZE     int
+	RENDER_MAX_LOOP_ITERATIONS int
+	RENDER_MAX_RECURSION_DEPTH int
+	// 模板渲染超时(毫秒)
+	RENDER_TIMEOUT int64
 }
 
 func (s *Setting) ReadSection(k string, v interface{}) error {